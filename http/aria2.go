@@ -0,0 +1,514 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/leganck/fn-qb-proxy/qbapi"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	aria2RPCPath     = "/jsonrpc"
+	aria2Version     = "1.37.0"
+	aria2TokenPrefix = "token:"
+	// aria2GIDLength aria2 的 GID 为 16 位十六进制，取种子哈希的前 16 位
+	aria2GIDLength = 16
+)
+
+var (
+	errAria2Unauthorized  = errors.New("Unauthorized")
+	errAria2UnknownMethod = errors.New("Method not found")
+	errAria2NotFound      = errors.New("GID is not found")
+)
+
+// aria2Request JSON-RPC 2.0 请求
+type aria2Request struct {
+	JSONRPC string            `json:"jsonrpc"`
+	ID      any               `json:"id"`
+	Method  string            `json:"method"`
+	Params  []json.RawMessage `json:"params"`
+}
+
+// aria2Server 将 aria2 JSON-RPC（HTTP 与 WebSocket）转换为 qBittorrent Web API 调用
+type aria2Server struct {
+	client  *qbapi.Client
+	secret  string
+	origins []string // 允许跨域访问的来源，例如独立部署的 AriaNg
+}
+
+func newAria2Server(client *qbapi.Client, secret string, origins []string) *aria2Server {
+	return &aria2Server{client: client, secret: secret, origins: origins}
+}
+
+// allowOrigin 浏览器发起的请求只接受同源或配置的来源，没有 Origin 头的非浏览器客户端不受限制
+func (s *aria2Server) allowOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	if u, err := url.Parse(origin); err == nil && u.Host == r.Host {
+		return true
+	}
+	return slices.Contains(s.origins, origin)
+}
+
+func (s *aria2Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !s.allowOrigin(r) {
+		logrus.Debugf("aria2 request from origin %s refused", r.Header.Get("Origin"))
+		http.Error(w, "Origin not allowed", http.StatusForbidden)
+		return
+	}
+	if isWebsocketUpgrade(r) {
+		s.serveWebsocket(w, r)
+		return
+	}
+	if origin := r.Header.Get("Origin"); origin != "" {
+		w.Header().Set("Access-Control-Allow-Origin", origin)
+		w.Header().Add("Vary", "Origin")
+	}
+	if r.Method == http.MethodOptions {
+		w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+		w.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS")
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var raw json.RawMessage
+	if err := json.NewDecoder(r.Body).Decode(&raw); err != nil {
+		http.Error(w, "Invalid JSON request", http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json-rpc")
+	w.Write(s.handle(r.Context(), raw))
+}
+
+func (s *aria2Server) serveWebsocket(w http.ResponseWriter, r *http.Request) {
+	conn, err := upgradeWebsocket(w, r)
+	if err != nil {
+		logrus.Debugf("aria2 websocket upgrade failed: %v", err)
+		return
+	}
+	defer conn.Close()
+
+	// 连接被接管后请求的 context 不再随客户端断开而取消，改为跟随连接生命周期
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	for {
+		msg, err := conn.ReadMessage()
+		if err != nil {
+			return
+		}
+		if err := conn.WriteText(s.handle(ctx, msg)); err != nil {
+			return
+		}
+	}
+}
+
+// handle 处理单个或批量请求并返回编码后的响应
+func (s *aria2Server) handle(ctx context.Context, raw json.RawMessage) []byte {
+	trimmed := strings.TrimSpace(string(raw))
+	if strings.HasPrefix(trimmed, "[") {
+		var batch []aria2Request
+		if err := json.Unmarshal(raw, &batch); err != nil {
+			data, _ := json.Marshal(aria2Error(nil, -32700, "Parse error."))
+			return data
+		}
+		responses := make([]map[string]any, 0, len(batch))
+		for _, req := range batch {
+			responses = append(responses, s.dispatch(ctx, req))
+		}
+		data, _ := json.Marshal(responses)
+		return data
+	}
+
+	var req aria2Request
+	if err := json.Unmarshal(raw, &req); err != nil {
+		data, _ := json.Marshal(aria2Error(nil, -32700, "Parse error."))
+		return data
+	}
+	data, _ := json.Marshal(s.dispatch(ctx, req))
+	return data
+}
+
+func aria2Error(id any, code int, message string) map[string]any {
+	return map[string]any{
+		"id":      id,
+		"jsonrpc": "2.0",
+		"error":   map[string]any{"code": code, "message": message},
+	}
+}
+
+func (s *aria2Server) dispatch(ctx context.Context, req aria2Request) map[string]any {
+	logrus.Debugf("aria2 rpc: %s", req.Method)
+	result, err := s.call(ctx, req.Method, req.Params)
+	if err != nil {
+		if !errors.Is(err, errAria2Unauthorized) && !errors.Is(err, errAria2UnknownMethod) {
+			logrus.Warnf("aria2 rpc %s failed: %v", req.Method, err)
+		}
+		return aria2Error(req.ID, 1, err.Error())
+	}
+	return map[string]any{"id": req.ID, "jsonrpc": "2.0", "result": result}
+}
+
+// checkToken 校验并去掉第一个参数中的 token:secret
+func (s *aria2Server) checkToken(params []json.RawMessage) ([]json.RawMessage, error) {
+	var token string
+	if len(params) > 0 && json.Unmarshal(params[0], &token) == nil && strings.HasPrefix(token, aria2TokenPrefix) {
+		params = params[1:]
+	} else {
+		token = ""
+	}
+	got := strings.TrimPrefix(token, aria2TokenPrefix)
+	if s.secret != "" && subtle.ConstantTimeCompare([]byte(got), []byte(s.secret)) != 1 {
+		return nil, errAria2Unauthorized
+	}
+	return params, nil
+}
+
+func (s *aria2Server) call(ctx context.Context, method string, params []json.RawMessage) (any, error) {
+	switch method {
+	case "system.listMethods":
+		return aria2Methods, nil
+	case "system.listNotifications":
+		return []string{}, nil
+	case "system.multicall":
+		return s.multicall(ctx, params)
+	}
+
+	params, err := s.checkToken(params)
+	if err != nil {
+		return nil, err
+	}
+
+	switch method {
+	case "aria2.getVersion":
+		return map[string]any{"version": aria2Version, "enabledFeatures": []string{"BitTorrent", "Magnet"}}, nil
+	case "aria2.getGlobalStat":
+		return s.globalStat(ctx)
+	case "aria2.addUri":
+		var uris []string
+		var options map[string]any
+		if err := param(params, 0, &uris); err != nil {
+			return nil, err
+		}
+		if err := param(params, 1, &options); err != nil {
+			return nil, err
+		}
+		if len(uris) == 0 {
+			return nil, fmt.Errorf("no URI to download")
+		}
+		source, err := resolveSource(ctx, uris[0])
+		if err != nil {
+			return nil, err
+		}
+		return s.add(ctx, source, options)
+	case "aria2.addTorrent":
+		var torrent string
+		var options map[string]any
+		if err := param(params, 0, &torrent); err != nil {
+			return nil, err
+		}
+		if err := param(params, 2, &options); err != nil {
+			return nil, err
+		}
+		data, err := base64.StdEncoding.DecodeString(torrent)
+		if err != nil {
+			return nil, fmt.Errorf("invalid torrent data: %v", err)
+		}
+		source, err := sourceFromFile("upload.torrent", data)
+		if err != nil {
+			return nil, err
+		}
+		return s.add(ctx, source, options)
+	case "aria2.tellStatus":
+		var gid string
+		var keys []string
+		if err := param(params, 0, &gid); err != nil {
+			return nil, err
+		}
+		if err := param(params, 1, &keys); err != nil {
+			return nil, err
+		}
+		t, err := s.findTorrent(ctx, gid)
+		if err != nil {
+			return nil, err
+		}
+		return pickKeys(aria2Status(t), keys), nil
+	case "aria2.tellActive":
+		var keys []string
+		if err := param(params, 0, &keys); err != nil {
+			return nil, err
+		}
+		return s.tellList(ctx, "active", 0, -1, keys)
+	case "aria2.tellWaiting", "aria2.tellStopped":
+		var offset, num int
+		var keys []string
+		if err := param(params, 0, &offset); err != nil {
+			return nil, err
+		}
+		if err := param(params, 1, &num); err != nil {
+			return nil, err
+		}
+		if err := param(params, 2, &keys); err != nil {
+			return nil, err
+		}
+		group := "waiting"
+		if method == "aria2.tellStopped" {
+			group = "stopped"
+		}
+		return s.tellList(ctx, group, offset, num, keys)
+	case "aria2.remove", "aria2.forceRemove", "aria2.pause", "aria2.forcePause", "aria2.unpause":
+		var gid string
+		if err := param(params, 0, &gid); err != nil {
+			return nil, err
+		}
+		t, err := s.findTorrent(ctx, gid)
+		if err != nil {
+			return nil, err
+		}
+		switch method {
+		case "aria2.remove", "aria2.forceRemove":
+			err = s.client.Delete(ctx, []string{t.Hash}, false)
+		case "aria2.unpause":
+			err = s.client.Start(ctx, []string{t.Hash})
+		default:
+			err = s.client.Stop(ctx, []string{t.Hash})
+		}
+		if err != nil {
+			return nil, err
+		}
+		return gid, nil
+	case "aria2.pauseAll", "aria2.forcePauseAll", "aria2.unpauseAll":
+		if method == "aria2.unpauseAll" {
+			err = s.client.Start(ctx, []string{"all"})
+		} else {
+			err = s.client.Stop(ctx, []string{"all"})
+		}
+		if err != nil {
+			return nil, err
+		}
+		return "OK", nil
+	case "aria2.removeDownloadResult", "aria2.purgeDownloadResult", "aria2.saveSession",
+		"aria2.changeGlobalOption", "aria2.changeOption":
+		return "OK", nil
+	case "aria2.getGlobalOption", "aria2.getOption":
+		savePath, err := s.client.DefaultSavePath(ctx)
+		if err != nil {
+			return nil, err
+		}
+		return map[string]any{"dir": savePath}, nil
+	default:
+		return nil, errAria2UnknownMethod
+	}
+}
+
+var aria2Methods = []string{
+	"aria2.addUri", "aria2.addTorrent", "aria2.remove", "aria2.forceRemove",
+	"aria2.pause", "aria2.forcePause", "aria2.pauseAll", "aria2.forcePauseAll",
+	"aria2.unpause", "aria2.unpauseAll", "aria2.tellStatus", "aria2.tellActive",
+	"aria2.tellWaiting", "aria2.tellStopped", "aria2.getGlobalStat", "aria2.getVersion",
+	"aria2.getGlobalOption", "aria2.getOption", "aria2.changeGlobalOption", "aria2.changeOption",
+	"aria2.removeDownloadResult", "aria2.purgeDownloadResult", "aria2.saveSession",
+	"system.multicall", "system.listMethods", "system.listNotifications",
+}
+
+// multicall 依次执行多个调用，成功的结果包装为单元素数组，失败的为错误对象
+func (s *aria2Server) multicall(ctx context.Context, params []json.RawMessage) (any, error) {
+	var calls []struct {
+		MethodName string            `json:"methodName"`
+		Params     []json.RawMessage `json:"params"`
+	}
+	if err := param(params, 0, &calls); err != nil {
+		return nil, err
+	}
+	results := make([]any, 0, len(calls))
+	for _, c := range calls {
+		if c.MethodName == "system.multicall" {
+			results = append(results, map[string]any{"code": 1, "message": "Recursive system.multicall forbidden."})
+			continue
+		}
+		result, err := s.call(ctx, c.MethodName, c.Params)
+		if err != nil {
+			results = append(results, map[string]any{"code": 1, "message": err.Error()})
+			continue
+		}
+		results = append(results, []any{result})
+	}
+	return results, nil
+}
+
+func (s *aria2Server) add(ctx context.Context, source torrentSource, options map[string]any) (any, error) {
+	opts := qbapi.AddOptions{}
+	if dir, ok := options["dir"].(string); ok {
+		opts.SavePath = dir
+	}
+	if pause, ok := options["pause"].(string); ok {
+		opts.Paused = pause == "true"
+	}
+	source.apply(&opts)
+	if err := s.client.AddTorrent(ctx, opts); err != nil {
+		return nil, err
+	}
+	if len(source.hash) < aria2GIDLength {
+		// 无法预知哈希（例如 qBittorrent 自行下载的链接），返回占位 GID
+		return strings.Repeat("0", aria2GIDLength), nil
+	}
+	return source.hash[:aria2GIDLength], nil
+}
+
+func (s *aria2Server) findTorrent(ctx context.Context, gid string) (*qbapi.Torrent, error) {
+	gid = strings.ToLower(gid)
+	torrents, err := s.client.Torrents(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	for i := range torrents {
+		if strings.HasPrefix(torrents[i].Hash, gid) {
+			return &torrents[i], nil
+		}
+	}
+	return nil, errAria2NotFound
+}
+
+func (s *aria2Server) tellList(ctx context.Context, group string, offset, num int, keys []string) (any, error) {
+	torrents, err := s.client.Torrents(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	var selected []map[string]any
+	for i := range torrents {
+		status := aria2Status(&torrents[i])
+		if aria2Group(status["status"].(string)) == group {
+			selected = append(selected, pickKeys(status, keys))
+		}
+	}
+
+	// aria2 允许负数 offset 表示从末尾倒序
+	if offset < 0 {
+		for i, j := 0, len(selected)-1; i < j; i, j = i+1, j-1 {
+			selected[i], selected[j] = selected[j], selected[i]
+		}
+		offset = -offset - 1
+	}
+	if offset >= len(selected) {
+		return []map[string]any{}, nil
+	}
+	selected = selected[offset:]
+	if num >= 0 && num < len(selected) {
+		selected = selected[:num]
+	}
+	return selected, nil
+}
+
+func aria2Group(status string) string {
+	switch status {
+	case "active":
+		return "active"
+	case "waiting", "paused":
+		return "waiting"
+	default:
+		return "stopped"
+	}
+}
+
+func pickKeys(all map[string]any, keys []string) map[string]any {
+	if len(keys) == 0 {
+		return all
+	}
+	picked := make(map[string]any, len(keys))
+	for _, k := range keys {
+		if v, ok := all[k]; ok {
+			picked[k] = v
+		}
+	}
+	return picked
+}
+
+// aria2Status 将 qBittorrent 种子信息映射为 aria2 的下载状态，aria2 中所有数值均为字符串
+func aria2Status(t *qbapi.Torrent) map[string]any {
+	status := "active"
+	errorCode, errorMessage := "0", ""
+	switch {
+	case t.IsErrored():
+		status, errorCode, errorMessage = "error", "1", t.State
+	case t.IsPaused() && t.IsFinished():
+		status = "complete"
+	case t.IsPaused():
+		status = "paused"
+	case t.State == "queuedDL" || t.State == "queuedUP":
+		status = "waiting"
+	}
+
+	itoa := func(n int64) string { return strconv.FormatInt(n, 10) }
+	gid := t.Hash
+	if len(gid) > aria2GIDLength {
+		gid = gid[:aria2GIDLength]
+	}
+	filePath := t.ContentPath
+	if filePath == "" {
+		filePath = path.Join(t.SavePath, t.Name)
+	}
+
+	return map[string]any{
+		"gid":             gid,
+		"status":          status,
+		"totalLength":     itoa(t.Size),
+		"completedLength": itoa(t.Completed),
+		"uploadLength":    itoa(t.Uploaded),
+		"downloadSpeed":   itoa(t.DlSpeed),
+		"uploadSpeed":     itoa(t.UpSpeed),
+		"connections":     itoa(t.NumSeeds + t.NumLeechs),
+		"numSeeders":      itoa(t.NumSeeds),
+		"seeder":          strconv.FormatBool(t.IsFinished()),
+		"infoHash":        t.Hash,
+		"dir":             t.SavePath,
+		"errorCode":       errorCode,
+		"errorMessage":    errorMessage,
+		"bittorrent":      map[string]any{"info": map[string]any{"name": t.Name}},
+		"files": []map[string]any{{
+			"index":           "1",
+			"path":            filePath,
+			"length":          itoa(t.Size),
+			"completedLength": itoa(t.Completed),
+			"selected":        "true",
+			"uris":            []any{},
+		}},
+	}
+}
+
+func (s *aria2Server) globalStat(ctx context.Context) (any, error) {
+	torrents, err := s.client.Torrents(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	info, err := s.client.TransferInfo(ctx)
+	if err != nil {
+		return nil, err
+	}
+	counts := map[string]int{}
+	for i := range torrents {
+		counts[aria2Group(aria2Status(&torrents[i])["status"].(string))]++
+	}
+	return map[string]any{
+		"downloadSpeed":   strconv.FormatInt(info.DlInfoSpeed, 10),
+		"uploadSpeed":     strconv.FormatInt(info.UpInfoSpeed, 10),
+		"numActive":       strconv.Itoa(counts["active"]),
+		"numWaiting":      strconv.Itoa(counts["waiting"]),
+		"numStopped":      strconv.Itoa(counts["stopped"]),
+		"numStoppedTotal": strconv.Itoa(counts["stopped"]),
+	}, nil
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestAria2Origin(t *testing.T) {
+	s := newAria2Server(nil, "secret", []string{"http://ariang.lan:8080"})
+	tests := []struct {
+		origin    string
+		websocket bool
+		method    string
+		want      int
+		allowed   string
+	}{
+		{origin: "", method: http.MethodOptions, want: http.StatusOK},
+		{origin: "http://nas:18080", method: http.MethodOptions, want: http.StatusOK, allowed: "http://nas:18080"},
+		{origin: "http://ariang.lan:8080", method: http.MethodOptions, want: http.StatusOK, allowed: "http://ariang.lan:8080"},
+		{origin: "http://evil.example", method: http.MethodOptions, want: http.StatusForbidden},
+		{origin: "http://evil.example", method: http.MethodPost, want: http.StatusForbidden},
+		{origin: "null", method: http.MethodPost, want: http.StatusForbidden},
+		{origin: "http://evil.example", websocket: true, method: http.MethodGet, want: http.StatusForbidden},
+		// 通过 Origin 检查后才校验握手
+		{origin: "http://nas:18080", websocket: true, method: http.MethodGet, want: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(tt.method, "http://nas:18080"+aria2RPCPath, nil)
+		if tt.origin != "" {
+			r.Header.Set("Origin", tt.origin)
+		}
+		if tt.websocket {
+			r.Header.Set("Upgrade", "websocket")
+			r.Header.Set("Connection", "Upgrade")
+		}
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, r)
+		if w.Code != tt.want {
+			t.Errorf("%s origin %q websocket %v: status %d, want %d", tt.method, tt.origin, tt.websocket, w.Code, tt.want)
+		}
+		if got := w.Header().Get("Access-Control-Allow-Origin"); got != tt.allowed {
+			t.Errorf("%s origin %q: Access-Control-Allow-Origin = %q, want %q", tt.method, tt.origin, got, tt.allowed)
+		}
+	}
+}
+
+func TestAria2CheckToken(t *testing.T) {
+	s := newAria2Server(nil, "secret", nil)
+	tests := []struct {
+		params  string
+		wantErr bool
+		rest    int
+	}{
+		{params: `["token:secret", ["magnet:?x"]]`, rest: 1},
+		{params: `["token:wrong", ["magnet:?x"]]`, wantErr: true},
+		{params: `["token:secre"]`, wantErr: true},
+		{params: `[["magnet:?x"]]`, wantErr: true},
+		{params: `[]`, wantErr: true},
+	}
+	for _, tt := range tests {
+		var params []json.RawMessage
+		json.Unmarshal([]byte(tt.params), &params)
+		rest, err := s.checkToken(params)
+		if (err != nil) != tt.wantErr || (err == nil && len(rest) != tt.rest) {
+			t.Errorf("checkToken(%s) = %d params, %v", tt.params, len(rest), err)
+		}
+	}
+	// 未设置 secret 时不需要 token
+	if rest, err := newAria2Server(nil, "", nil).checkToken([]json.RawMessage{json.RawMessage(`["magnet:?x"]`)}); err != nil || len(rest) != 1 {
+		t.Errorf("no secret: %d params, %v", len(rest), err)
+	}
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"path"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/leganck/fn-qb-proxy/metainfo"
+	"github.com/leganck/fn-qb-proxy/qbapi"
+)
+
+// maxTorrentFileSize 通过链接下载 .torrent 文件时允许的最大大小
+const maxTorrentFileSize = 10 << 20
+
+// fetchClient 用于下载 .torrent 文件
+// 链接由客户端提供，只允许连接公网地址（包括重定向之后），避免借此访问本机或内网服务；
+// 不使用环境变量中的代理，否则无法检查实际连接的地址
+var fetchClient = &http.Client{
+	Timeout: 30 * time.Second,
+	Transport: &http.Transport{
+		DialContext: (&net.Dialer{
+			Timeout: 10 * time.Second,
+			Control: func(network, address string, c syscall.RawConn) error {
+				addr, err := netip.ParseAddrPort(address)
+				if err != nil {
+					return err
+				}
+				if !isPublicAddr(addr.Addr()) {
+					return fmt.Errorf("refusing to fetch from non-public address %s", addr.Addr())
+				}
+				return nil
+			},
+		}).DialContext,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ResponseHeaderTimeout: 10 * time.Second,
+	},
+}
+
+// cgnatPrefix 运营商级 NAT 使用的共享地址段
+var cgnatPrefix = netip.MustParsePrefix("100.64.0.0/10")
+
+// isPublicAddr 判断地址是否可以从公网访问
+func isPublicAddr(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	return addr.IsGlobalUnicast() && !addr.IsPrivate() && !cgnatPrefix.Contains(addr)
+}
+
+// torrentIDs 为种子分配稳定的整数 id，供只支持数字 id 的协议使用
+type torrentIDs struct {
+	mu     sync.Mutex
+	byHash map[string]int
+	byID   map[int]string
+	next   int
+}
+
+func newTorrentIDs() *torrentIDs {
+	return &torrentIDs{byHash: make(map[string]int), byID: make(map[int]string), next: 1}
+}
+
+// assign 为列表中尚未分配 id 的种子分配 id
+func (t *torrentIDs) assign(torrents []qbapi.Torrent) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, torrent := range torrents {
+		t.reserveLocked(torrent.Hash)
+	}
+}
+
+// reserve 为哈希分配 id（已存在时返回原 id）
+func (t *torrentIDs) reserve(hash string) int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.reserveLocked(hash)
+}
+
+func (t *torrentIDs) reserveLocked(hash string) int {
+	if id, ok := t.byHash[hash]; ok {
+		return id
+	}
+	id := t.next
+	t.next++
+	t.byHash[hash] = id
+	t.byID[id] = hash
+	return id
+}
+
+func (t *torrentIDs) id(hash string) int {
+	return t.reserve(hash)
+}
+
+func (t *torrentIDs) hash(id int) (string, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	hash, ok := t.byID[id]
+	return hash, ok
+}
+
+// torrentSource 待添加的种子来源：磁力链接/URL 或 .torrent 文件内容
+type torrentSource struct {
+	url  string
+	file *qbapi.TorrentFile
+	hash string
+	name string
+}
+
+// apply 将来源写入添加参数
+func (s torrentSource) apply(opts *qbapi.AddOptions) {
+	if s.file != nil {
+		opts.Files = append(opts.Files, *s.file)
+	} else {
+		opts.URLs = append(opts.URLs, s.url)
+	}
+}
+
+// sourceFromFile 解析 .torrent 文件内容
+func sourceFromFile(name string, data []byte) (torrentSource, error) {
+	t, err := metainfo.Parse(data)
+	if err != nil {
+		return torrentSource{}, fmt.Errorf("invalid torrent file: %v", err)
+	}
+	return torrentSource{
+		file: &qbapi.TorrentFile{Name: name, Data: data},
+		hash: t.Hash(),
+		name: t.Name,
+	}, nil
+}
+
+// resolveSource 解析磁力链接；http(s) 链接会先下载 .torrent 文件以便得到哈希
+// 下载失败（包括指向本机或内网地址）时不解析，交给 qBittorrent 自行下载
+func resolveSource(ctx context.Context, uri string) (torrentSource, error) {
+	uri = strings.TrimSpace(uri)
+	if strings.HasPrefix(uri, "magnet:") {
+		t, err := metainfo.ParseMagnet(uri)
+		if err != nil {
+			return torrentSource{}, err
+		}
+		return torrentSource{url: uri, hash: t.Hash(), name: t.Name}, nil
+	}
+
+	if !strings.HasPrefix(uri, "http://") && !strings.HasPrefix(uri, "https://") {
+		return torrentSource{}, fmt.Errorf("unsupported link: %q", uri)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, uri, nil)
+	if err != nil {
+		return torrentSource{}, err
+	}
+	resp, err := fetchClient.Do(req)
+	if err == nil {
+		defer resp.Body.Close()
+		if resp.StatusCode == http.StatusOK {
+			data, readErr := io.ReadAll(io.LimitReader(resp.Body, maxTorrentFileSize))
+			if readErr == nil {
+				if source, parseErr := sourceFromFile(path.Base(req.URL.Path), data); parseErr == nil {
+					return source, nil
+				}
+			}
+		}
+	}
+	return torrentSource{url: uri, name: uri}, nil
+}
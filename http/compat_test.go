@@ -0,0 +1,56 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+)
+
+func TestIsPublicAddr(t *testing.T) {
+	tests := []struct {
+		addr string
+		want bool
+	}{
+		{"93.184.216.34", true},
+		{"2606:2800:220:1:248:1893:25c8:1946", true},
+		{"127.0.0.1", false},
+		{"::1", false},
+		{"10.1.2.3", false},
+		{"172.16.0.1", false},
+		{"192.168.1.20", false},
+		{"169.254.169.254", false},
+		{"100.100.1.1", false},
+		{"0.0.0.0", false},
+		{"fe80::1", false},
+		{"fd00::1", false},
+		{"::ffff:127.0.0.1", false},
+		{"::ffff:192.168.1.1", false},
+	}
+	for _, tt := range tests {
+		if got := isPublicAddr(netip.MustParseAddr(tt.addr)); got != tt.want {
+			t.Errorf("isPublicAddr(%s) = %v, want %v", tt.addr, got, tt.want)
+		}
+	}
+}
+
+func TestResolveSourceSkipsLocalURLs(t *testing.T) {
+	fetched := false
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetched = true
+	}))
+	defer srv.Close()
+
+	uri := srv.URL + "/a.torrent"
+	source, err := resolveSource(context.Background(), uri)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fetched {
+		t.Error("local URL was fetched")
+	}
+	if source.url != uri || source.hash != "" || source.file != nil {
+		t.Errorf("resolveSource(%q) = %+v, want the URL passed through", uri, source)
+	}
+}
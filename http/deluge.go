@@ -0,0 +1,512 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/leganck/fn-qb-proxy/qbapi"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	delugeRPCPath       = "/json"
+	delugeSessionCookie = "_session_id"
+	delugeSessionTTL    = 24 * time.Hour
+	delugeVersion       = "2.1.1"
+)
+
+// Deluge Web 的错误码
+const (
+	delugeErrNotAuthenticated = 1
+	delugeErrUnknownMethod    = 2
+	delugeErrCall             = 3
+)
+
+var errDelugeUnknownMethod = errors.New("unknown method")
+
+// delugeRequest Deluge Web JSON-RPC 请求
+type delugeRequest struct {
+	Method string            `json:"method"`
+	Params []json.RawMessage `json:"params"`
+	ID     any               `json:"id"`
+}
+
+// delugeServer 将 Deluge Web JSON-RPC 转换为 qBittorrent Web API 调用
+// Deluge 的 Label 插件映射为 qBittorrent 的分类
+type delugeServer struct {
+	client   *qbapi.Client
+	password string
+
+	mu       sync.Mutex
+	sessions map[string]time.Time
+}
+
+func newDelugeServer(client *qbapi.Client, password string) *delugeServer {
+	return &delugeServer{
+		client:   client,
+		password: password,
+		sessions: make(map[string]time.Time),
+	}
+}
+
+func (s *delugeServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req delugeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON request", http.StatusBadRequest)
+		return
+	}
+	logrus.Debugf("deluge rpc: %s", req.Method)
+
+	resp := map[string]any{"id": req.ID, "result": nil, "error": nil}
+	switch req.Method {
+	case "auth.login":
+		var password string
+		if len(req.Params) > 0 {
+			json.Unmarshal(req.Params[0], &password)
+		}
+		ok := s.password == "" || subtle.ConstantTimeCompare([]byte(password), []byte(s.password)) == 1
+		if ok {
+			http.SetCookie(w, &http.Cookie{Name: delugeSessionCookie, Value: s.newSession(), Path: "/", HttpOnly: true})
+		}
+		resp["result"] = ok
+	case "auth.check_session":
+		resp["result"] = s.validSession(r)
+	default:
+		if !s.validSession(r) {
+			resp["error"] = map[string]any{"message": "Not authenticated", "code": delugeErrNotAuthenticated}
+			break
+		}
+		result, err := s.call(r.Context(), req.Method, req.Params)
+		if errors.Is(err, errDelugeUnknownMethod) {
+			resp["error"] = map[string]any{"message": "Unknown method", "code": delugeErrUnknownMethod}
+		} else if err != nil {
+			logrus.Warnf("deluge rpc %s failed: %v", req.Method, err)
+			resp["error"] = map[string]any{"message": err.Error(), "code": delugeErrCall}
+		} else {
+			resp["result"] = result
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+func (s *delugeServer) newSession() string {
+	buf := make([]byte, 16)
+	rand.Read(buf)
+	id := hex.EncodeToString(buf)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	for sid, expires := range s.sessions {
+		if now.After(expires) {
+			delete(s.sessions, sid)
+		}
+	}
+	s.sessions[id] = now.Add(delugeSessionTTL)
+	return id
+}
+
+func (s *delugeServer) validSession(r *http.Request) bool {
+	cookie, err := r.Cookie(delugeSessionCookie)
+	if err != nil {
+		return false
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	expires, ok := s.sessions[cookie.Value]
+	return ok && time.Now().Before(expires)
+}
+
+// param 将第 i 个参数解析到 v，参数缺失时保持零值
+func param(params []json.RawMessage, i int, v any) error {
+	if i >= len(params) || string(params[i]) == "null" {
+		return nil
+	}
+	if err := json.Unmarshal(params[i], v); err != nil {
+		return fmt.Errorf("invalid parameter %d: %v", i, err)
+	}
+	return nil
+}
+
+func (s *delugeServer) call(ctx context.Context, method string, params []json.RawMessage) (any, error) {
+	switch method {
+	case "web.connected":
+		return true, nil
+	case "web.get_hosts":
+		return [][]any{{"qbittorrent", "127.0.0.1", 58846, "qBittorrent"}}, nil
+	case "web.get_host_status":
+		return []any{"qbittorrent", "Connected", delugeVersion}, nil
+	case "web.connect", "web.disconnect":
+		return nil, nil
+	case "daemon.info", "daemon.get_version":
+		return delugeVersion, nil
+	case "core.get_enabled_plugins", "core.get_available_plugins", "web.get_plugins":
+		return []string{"Label"}, nil
+	case "core.enable_plugin":
+		return true, nil
+	case "core.get_config", "core.get_config_value":
+		return s.getConfig(ctx, method, params)
+	case "web.update_ui":
+		return s.updateUI(ctx, params)
+	case "core.get_torrents_status":
+		var filter map[string]any
+		var keys []string
+		if err := param(params, 0, &filter); err != nil {
+			return nil, err
+		}
+		if err := param(params, 1, &keys); err != nil {
+			return nil, err
+		}
+		return s.torrentsStatus(ctx, filter, keys)
+	case "core.get_torrent_status":
+		var hash string
+		var keys []string
+		if err := param(params, 0, &hash); err != nil {
+			return nil, err
+		}
+		if err := param(params, 1, &keys); err != nil {
+			return nil, err
+		}
+		status, err := s.torrentsStatus(ctx, map[string]any{"id": hash}, keys)
+		if err != nil {
+			return nil, err
+		}
+		if st, ok := status[strings.ToLower(hash)]; ok {
+			return st, nil
+		}
+		return map[string]any{}, nil
+	case "core.add_torrent_url", "core.add_torrent_magnet":
+		var uri string
+		var options map[string]any
+		if err := param(params, 0, &uri); err != nil {
+			return nil, err
+		}
+		if err := param(params, 1, &options); err != nil {
+			return nil, err
+		}
+		source, err := resolveSource(ctx, uri)
+		if err != nil {
+			return nil, err
+		}
+		return s.addTorrent(ctx, source, options)
+	case "core.add_torrent_file":
+		var filename, dump string
+		var options map[string]any
+		if err := param(params, 0, &filename); err != nil {
+			return nil, err
+		}
+		if err := param(params, 1, &dump); err != nil {
+			return nil, err
+		}
+		if err := param(params, 2, &options); err != nil {
+			return nil, err
+		}
+		data, err := base64.StdEncoding.DecodeString(dump)
+		if err != nil {
+			return nil, fmt.Errorf("invalid filedump: %v", err)
+		}
+		source, err := sourceFromFile(filename, data)
+		if err != nil {
+			return nil, err
+		}
+		return s.addTorrent(ctx, source, options)
+	case "core.remove_torrent":
+		var hash string
+		var removeData bool
+		if err := param(params, 0, &hash); err != nil {
+			return nil, err
+		}
+		if err := param(params, 1, &removeData); err != nil {
+			return nil, err
+		}
+		return true, s.client.Delete(ctx, []string{strings.ToLower(hash)}, removeData)
+	case "core.remove_torrents":
+		var hashes []string
+		var removeData bool
+		if err := param(params, 0, &hashes); err != nil {
+			return nil, err
+		}
+		if err := param(params, 1, &removeData); err != nil {
+			return nil, err
+		}
+		return []any{}, s.client.Delete(ctx, lowerAll(hashes), removeData)
+	case "core.pause_torrent", "core.pause_torrents", "core.resume_torrent", "core.resume_torrents":
+		var hashes []string
+		if err := param(params, 0, &hashes); err != nil {
+			var hash string
+			if err := param(params, 0, &hash); err != nil {
+				return nil, err
+			}
+			hashes = []string{hash}
+		}
+		if strings.HasPrefix(method, "core.pause") {
+			return nil, s.client.Stop(ctx, lowerAll(hashes))
+		}
+		return nil, s.client.Start(ctx, lowerAll(hashes))
+	case "label.get_labels":
+		return s.labels(ctx)
+	case "label.add":
+		var name string
+		if err := param(params, 0, &name); err != nil {
+			return nil, err
+		}
+		return nil, s.client.CreateCategory(ctx, strings.ToLower(name), "")
+	case "label.remove":
+		var name string
+		if err := param(params, 0, &name); err != nil {
+			return nil, err
+		}
+		return nil, s.client.RemoveCategories(ctx, []string{name})
+	case "label.set_torrent":
+		var hash, label string
+		if err := param(params, 0, &hash); err != nil {
+			return nil, err
+		}
+		if err := param(params, 1, &label); err != nil {
+			return nil, err
+		}
+		return nil, s.setLabel(ctx, []string{strings.ToLower(hash)}, label)
+	case "label.get_config":
+		return map[string]any{}, nil
+	case "label.get_options":
+		return map[string]any{"apply_move_completed": false, "move_completed": false}, nil
+	case "label.set_options", "label.set_config":
+		return nil, nil
+	default:
+		return nil, errDelugeUnknownMethod
+	}
+}
+
+func lowerAll(list []string) []string {
+	out := make([]string, len(list))
+	for i, s := range list {
+		out[i] = strings.ToLower(s)
+	}
+	return out
+}
+
+func (s *delugeServer) getConfig(ctx context.Context, method string, params []json.RawMessage) (any, error) {
+	savePath, err := s.client.DefaultSavePath(ctx)
+	if err != nil {
+		return nil, err
+	}
+	config := map[string]any{
+		"download_location":      savePath,
+		"move_completed":         false,
+		"move_completed_path":    savePath,
+		"add_paused":             false,
+		"max_download_speed":     -1,
+		"max_upload_speed":       -1,
+		"max_active_downloading": -1,
+	}
+	if method == "core.get_config_value" {
+		var key string
+		if err := param(params, 0, &key); err != nil {
+			return nil, err
+		}
+		return config[key], nil
+	}
+	return config, nil
+}
+
+func (s *delugeServer) labels(ctx context.Context) ([]string, error) {
+	categories, err := s.client.Categories(ctx)
+	if err != nil {
+		return nil, err
+	}
+	labels := make([]string, 0, len(categories))
+	for name := range categories {
+		labels = append(labels, name)
+	}
+	sort.Strings(labels)
+	return labels, nil
+}
+
+// setLabel 设置分类，分类不存在时先创建（Deluge 的 label 不区分大小写，统一为小写）
+func (s *delugeServer) setLabel(ctx context.Context, hashes []string, label string) error {
+	label = strings.ToLower(label)
+	if label != "" {
+		var se *qbapi.StatusError
+		if err := s.client.CreateCategory(ctx, label, ""); err != nil && !(errors.As(err, &se) && se.Code == http.StatusConflict) {
+			return err
+		}
+	}
+	return s.client.SetCategory(ctx, hashes, label)
+}
+
+func (s *delugeServer) addTorrent(ctx context.Context, source torrentSource, options map[string]any) (any, error) {
+	opts := qbapi.AddOptions{}
+	if dir, ok := options["download_location"].(string); ok {
+		opts.SavePath = dir
+	}
+	opts.Paused, _ = options["add_paused"].(bool)
+	source.apply(&opts)
+	if err := s.client.AddTorrent(ctx, opts); err != nil {
+		return nil, err
+	}
+	if source.hash == "" {
+		return nil, nil
+	}
+	return source.hash, nil
+}
+
+func (s *delugeServer) updateUI(ctx context.Context, params []json.RawMessage) (any, error) {
+	var keys []string
+	var filter map[string]any
+	if err := param(params, 0, &keys); err != nil {
+		return nil, err
+	}
+	if err := param(params, 1, &filter); err != nil {
+		return nil, err
+	}
+	torrents, err := s.torrentsStatus(ctx, filter, keys)
+	if err != nil {
+		return nil, err
+	}
+	info, err := s.client.TransferInfo(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]any{
+		"connected": true,
+		"torrents":  torrents,
+		"filters":   map[string]any{},
+		"stats": map[string]any{
+			"download_rate":          info.DlInfoSpeed,
+			"upload_rate":            info.UpInfoSpeed,
+			"max_download":           -1,
+			"max_upload":             -1,
+			"num_connections":        0,
+			"dht_nodes":              info.DHTNodes,
+			"download_protocol_rate": 0,
+			"upload_protocol_rate":   0,
+		},
+	}, nil
+}
+
+// torrentsStatus 按 Deluge 的过滤条件（id、state、label）返回种子状态
+func (s *delugeServer) torrentsStatus(ctx context.Context, filter map[string]any, keys []string) (map[string]map[string]any, error) {
+	query := url.Values{}
+	if ids, ok := filter["id"]; ok {
+		switch v := ids.(type) {
+		case string:
+			query.Set("hashes", strings.ToLower(v))
+		case []any:
+			var hashes []string
+			for _, id := range v {
+				if h, ok := id.(string); ok {
+					hashes = append(hashes, strings.ToLower(h))
+				}
+			}
+			query.Set("hashes", strings.Join(hashes, "|"))
+		}
+	}
+	if label, ok := filter["label"].(string); ok {
+		query.Set("category", label)
+	}
+
+	torrents, err := s.client.Torrents(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+
+	state, _ := filter["state"].(string)
+	result := make(map[string]map[string]any, len(torrents))
+	for i := range torrents {
+		all := delugeFields(&torrents[i])
+		if state != "" && state != "All" && all["state"] != state {
+			continue
+		}
+		if len(keys) == 0 {
+			result[torrents[i].Hash] = all
+			continue
+		}
+		picked := make(map[string]any, len(keys))
+		for _, k := range keys {
+			if v, ok := all[k]; ok {
+				picked[k] = v
+			}
+		}
+		result[torrents[i].Hash] = picked
+	}
+	return result, nil
+}
+
+// delugeFields 将 qBittorrent 种子信息映射为 Deluge 字段
+func delugeFields(t *qbapi.Torrent) map[string]any {
+	state, message := "Downloading", "OK"
+	switch t.State {
+	case "pausedDL", "pausedUP", "stoppedDL", "stoppedUP":
+		state = "Paused"
+	case "error", "missingFiles":
+		state, message = "Error", t.State
+	case "checkingDL", "checkingUP", "checkingResumeData":
+		state = "Checking"
+	case "moving":
+		state = "Moving"
+	case "queuedDL", "queuedUP":
+		state = "Queued"
+	case "uploading", "stalledUP", "forcedUP":
+		state = "Seeding"
+	}
+
+	eta := t.Eta
+	if eta >= 8640000 {
+		eta = 0
+	}
+	trackerHost := ""
+	if u, err := url.Parse(t.Tracker); err == nil {
+		trackerHost = u.Hostname()
+	}
+
+	return map[string]any{
+		"hash":                  t.Hash,
+		"name":                  t.Name,
+		"state":                 state,
+		"message":               message,
+		"progress":              t.Progress * 100,
+		"total_size":            t.Size,
+		"total_wanted":          t.Size,
+		"total_done":            t.Completed,
+		"total_uploaded":        t.Uploaded,
+		"all_time_download":     t.Downloaded,
+		"download_payload_rate": t.DlSpeed,
+		"upload_payload_rate":   t.UpSpeed,
+		"eta":                   eta,
+		"ratio":                 t.Ratio,
+		"save_path":             t.SavePath,
+		"download_location":     t.SavePath,
+		"time_added":            t.AddedOn,
+		"completed_time":        max(t.CompletionOn, 0),
+		"active_time":           t.TimeActive,
+		"seeding_time":          t.SeedingTime,
+		"is_finished":           t.IsFinished(),
+		"paused":                t.IsPaused(),
+		"label":                 t.Category,
+		"num_seeds":             t.NumSeeds,
+		"num_peers":             t.NumLeechs,
+		"tracker_host":          trackerHost,
+		"queue":                 t.Priority,
+		"stop_at_ratio":         t.RatioLimit > 0,
+		"stop_ratio":            t.RatioLimit,
+	}
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// delugeCall 发送 JSON-RPC 请求并解码响应
+func delugeCall(t *testing.T, s *delugeServer, cookie *http.Cookie, method string, params ...any) (map[string]any, *http.Response) {
+	t.Helper()
+	body, _ := json.Marshal(map[string]any{"method": method, "params": params, "id": 1})
+	r := httptest.NewRequest(http.MethodPost, delugeRPCPath, strings.NewReader(string(body)))
+	if cookie != nil {
+		r.AddCookie(cookie)
+	}
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, r)
+	var resp map[string]any
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("%s: %v: %s", method, err, w.Body)
+	}
+	return resp, w.Result()
+}
+
+func TestDelugeSession(t *testing.T) {
+	s := newDelugeServer(nil, "secret")
+
+	resp, _ := delugeCall(t, s, nil, "web.connected")
+	if e, _ := resp["error"].(map[string]any); e == nil || e["code"] != float64(delugeErrNotAuthenticated) {
+		t.Errorf("call without session: error = %v", resp["error"])
+	}
+
+	resp, res := delugeCall(t, s, nil, "auth.login", "wrong")
+	if resp["result"] != false || len(res.Cookies()) != 0 {
+		t.Errorf("wrong password: result = %v, cookies = %v", resp["result"], res.Cookies())
+	}
+
+	resp, res = delugeCall(t, s, nil, "auth.login", "secret")
+	if resp["result"] != true || len(res.Cookies()) != 1 || res.Cookies()[0].Name != delugeSessionCookie {
+		t.Fatalf("login: result = %v, cookies = %v", resp["result"], res.Cookies())
+	}
+	cookie := res.Cookies()[0]
+
+	if resp, _ := delugeCall(t, s, cookie, "auth.check_session"); resp["result"] != true {
+		t.Errorf("check_session = %v, want true", resp["result"])
+	}
+	if resp, _ := delugeCall(t, s, cookie, "web.connected"); resp["result"] != true || resp["error"] != nil {
+		t.Errorf("web.connected = %v, error %v", resp["result"], resp["error"])
+	}
+	if resp, _ := delugeCall(t, s, cookie, "core.no_such_method"); resp["error"].(map[string]any)["code"] != float64(delugeErrUnknownMethod) {
+		t.Errorf("unknown method: error = %v", resp["error"])
+	}
+	if resp, _ := delugeCall(t, s, &http.Cookie{Name: delugeSessionCookie, Value: "forged"}, "auth.check_session"); resp["result"] != false {
+		t.Errorf("forged session accepted")
+	}
+
+	// 过期的会话失效
+	s.mu.Lock()
+	s.sessions[cookie.Value] = time.Now().Add(-time.Second)
+	s.mu.Unlock()
+	if resp, _ := delugeCall(t, s, cookie, "auth.check_session"); resp["result"] != false {
+		t.Errorf("expired session accepted")
+	}
+}
+
+func TestDelugeTorrentsStatus(t *testing.T) {
+	var query string
+	client := newFakeQB(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query = r.URL.RawQuery
+		w.Write([]byte(`[
+			{"hash":"aaa","name":"Show","state":"stalledUP","progress":1,"category":"tv","eta":8640000,
+			 "tracker":"https://tracker.example.org:443/announce?passkey=x","size":100,"completed":100,"ratio_limit":2},
+			{"hash":"bbb","name":"Show 2","state":"pausedDL","progress":0.5,"category":"tv","eta":60,"size":200}
+		]`))
+	}))
+	s := newDelugeServer(client, "")
+	_, res := delugeCall(t, s, nil, "auth.login", "")
+	cookie := res.Cookies()[0]
+
+	keys := []string{"name", "state", "progress", "label", "eta", "tracker_host", "stop_ratio", "missing"}
+	resp, _ := delugeCall(t, s, cookie, "core.get_torrents_status", map[string]any{"label": "tv", "state": "Seeding"}, keys)
+	if resp["error"] != nil {
+		t.Fatalf("error = %v", resp["error"])
+	}
+	if query != "category=tv" {
+		t.Errorf("qBittorrent query = %q, want category=tv", query)
+	}
+	result := resp["result"].(map[string]any)
+	if len(result) != 1 {
+		t.Fatalf("result = %v, want only the seeding torrent", result)
+	}
+	got := result["aaa"].(map[string]any)
+	want := map[string]any{
+		"name": "Show", "state": "Seeding", "progress": 100.0, "label": "tv",
+		// 未知的 ETA 为 0
+		"eta": 0.0, "tracker_host": "tracker.example.org", "stop_ratio": 2.0,
+	}
+	if len(got) != len(want) {
+		t.Errorf("fields = %v, want %v", got, want)
+	}
+	for k, v := range want {
+		if got[k] != v {
+			t.Errorf("%s = %v, want %v", k, got[k], v)
+		}
+	}
+
+	resp, _ = delugeCall(t, s, cookie, "core.get_torrents_status", map[string]any{"id": []string{"BBB"}}, []string{"state", "paused"})
+	if query != "hashes=bbb" {
+		t.Errorf("qBittorrent query = %q, want hashes=bbb", query)
+	}
+	if st := resp["result"].(map[string]any)["bbb"].(map[string]any); st["state"] != "Paused" || st["paused"] != true {
+		t.Errorf("paused torrent = %v", st)
+	}
+}
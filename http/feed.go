@@ -0,0 +1,261 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/leganck/fn-qb-proxy/qbapi"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	feedPath         = "/feed/"
+	feedDefaultLimit = 50
+	feedMaxLimit     = 500
+)
+
+// feedServer 以 RSS 2.0 / Atom 输出最近完成的种子，供阅读器与 Kodi 订阅
+// 地址为 /feed/rss 与 /feed/atom，category 参数限定分类（为空表示未分类），limit 参数限定条数
+type feedServer struct {
+	client   *qbapi.Client
+	password string
+	title    string
+}
+
+func newFeedServer(client *qbapi.Client, password, title string) *feedServer {
+	return &feedServer{client: client, password: password, title: title}
+}
+
+func (s *feedServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	// 部分阅读器不支持 Basic 认证，也可以通过 token 参数传递密码
+	if s.password != "" {
+		_, pass, ok := r.BasicAuth()
+		if !ok {
+			pass = r.URL.Query().Get("token")
+		}
+		if subtle.ConstantTimeCompare([]byte(pass), []byte(s.password)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Basic realm="Feed"`)
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+	}
+
+	format := strings.TrimPrefix(r.URL.Path, feedPath)
+	if format != "rss" && format != "atom" {
+		http.NotFound(w, r)
+		return
+	}
+
+	limit := feedDefaultLimit
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			http.Error(w, "Invalid limit", http.StatusBadRequest)
+			return
+		}
+		limit = min(n, feedMaxLimit)
+	}
+
+	query := url.Values{
+		"filter":  {"completed"},
+		"sort":    {"completion_on"},
+		"reverse": {"true"},
+		"limit":   {strconv.Itoa(limit)},
+	}
+	title := s.title
+	if r.URL.Query().Has("category") {
+		category := r.URL.Query().Get("category")
+		query.Set("category", category)
+		if category != "" {
+			title += " - " + category
+		}
+	}
+	torrents, err := s.client.Torrents(r.Context(), query)
+	if err != nil {
+		logrus.Errorf("Feed: failed to get torrents: %v", err)
+		http.Error(w, "Failed to get torrents", http.StatusBadGateway)
+		return
+	}
+	// 完成时间未知的种子（如刚添加时已存在数据）不列出
+	var items []qbapi.Torrent
+	for _, t := range torrents {
+		if t.CompletionOn > 0 {
+			items = append(items, t)
+		}
+	}
+
+	self := feedURL(r)
+	var doc any
+	contentType := "application/rss+xml; charset=utf-8"
+	if format == "atom" {
+		doc = buildAtomFeed(title, self, items)
+		contentType = "application/atom+xml; charset=utf-8"
+	} else {
+		doc = buildRSSFeed(title, self, items)
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Write([]byte(xml.Header))
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(doc); err != nil {
+		logrus.Warnf("Feed: failed to write response: %v", err)
+	}
+}
+
+// feedURL 返回订阅地址（不含 token 参数），作为 Feed 的链接与 id
+func feedURL(r *http.Request) string {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	if v := r.Header.Get("X-Forwarded-Proto"); v != "" {
+		scheme = v
+	}
+	query := r.URL.Query()
+	query.Del("token")
+	u := url.URL{Scheme: scheme, Host: r.Host, Path: r.URL.Path, RawQuery: query.Encode()}
+	return u.String()
+}
+
+// feedSummary 条目的文字说明
+func feedSummary(t *qbapi.Torrent) string {
+	summary := fmt.Sprintf("Size: %s, Ratio: %.2f, Completed: %s",
+		humanSize(t.Size), t.Ratio, time.Unix(t.CompletionOn, 0).Format("2006-01-02 15:04:05"))
+	if t.Category != "" {
+		summary += ", Category: " + t.Category
+	}
+	return summary
+}
+
+func humanSize(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for v := n / unit; v >= unit; v /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+type rssFeed struct {
+	XMLName xml.Name   `xml:"rss"`
+	Version string     `xml:"version,attr"`
+	Channel rssChannel `xml:"channel"`
+}
+
+type rssChannel struct {
+	Title         string    `xml:"title"`
+	Link          string    `xml:"link"`
+	Description   string    `xml:"description"`
+	LastBuildDate string    `xml:"lastBuildDate"`
+	Items         []rssItem `xml:"item"`
+}
+
+type rssItem struct {
+	Title       string  `xml:"title"`
+	GUID        rssGUID `xml:"guid"`
+	PubDate     string  `xml:"pubDate"`
+	Category    string  `xml:"category,omitempty"`
+	Description string  `xml:"description"`
+}
+
+type rssGUID struct {
+	IsPermaLink bool   `xml:"isPermaLink,attr"`
+	Value       string `xml:",chardata"`
+}
+
+func buildRSSFeed(title, self string, torrents []qbapi.Torrent) *rssFeed {
+	feed := &rssFeed{
+		Version: "2.0",
+		Channel: rssChannel{
+			Title:         title,
+			Link:          self,
+			Description:   "Recently completed torrents",
+			LastBuildDate: time.Now().Format(time.RFC1123Z),
+		},
+	}
+	for i := range torrents {
+		t := &torrents[i]
+		feed.Channel.Items = append(feed.Channel.Items, rssItem{
+			Title:       t.Name,
+			GUID:        rssGUID{Value: "urn:btih:" + t.Hash},
+			PubDate:     time.Unix(t.CompletionOn, 0).Format(time.RFC1123Z),
+			Category:    t.Category,
+			Description: feedSummary(t),
+		})
+	}
+	return feed
+}
+
+type atomFeed struct {
+	XMLName xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	Title   string      `xml:"title"`
+	ID      string      `xml:"id"`
+	Updated string      `xml:"updated"`
+	Link    atomLink    `xml:"link"`
+	Author  atomAuthor  `xml:"author"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+type atomLink struct {
+	Rel  string `xml:"rel,attr"`
+	Href string `xml:"href,attr"`
+}
+
+type atomAuthor struct {
+	Name string `xml:"name"`
+}
+
+type atomEntry struct {
+	Title    string        `xml:"title"`
+	ID       string        `xml:"id"`
+	Updated  string        `xml:"updated"`
+	Category *atomCategory `xml:"category,omitempty"`
+	Summary  string        `xml:"summary"`
+}
+
+type atomCategory struct {
+	Term string `xml:"term,attr"`
+}
+
+func buildAtomFeed(title, self string, torrents []qbapi.Torrent) *atomFeed {
+	// Atom 要求 updated 为最近一次变化的时间，没有条目时使用当前时间
+	updated := time.Now()
+	if len(torrents) > 0 {
+		updated = time.Unix(torrents[0].CompletionOn, 0)
+	}
+	feed := &atomFeed{
+		Title:   title,
+		ID:      self,
+		Updated: updated.UTC().Format(time.RFC3339),
+		Link:    atomLink{Rel: "self", Href: self},
+		Author:  atomAuthor{Name: title},
+	}
+	for i := range torrents {
+		t := &torrents[i]
+		entry := atomEntry{
+			Title:   t.Name,
+			ID:      "urn:btih:" + t.Hash,
+			Updated: time.Unix(t.CompletionOn, 0).UTC().Format(time.RFC3339),
+			Summary: feedSummary(t),
+		}
+		if t.Category != "" {
+			entry.Category = &atomCategory{Term: t.Category}
+		}
+		feed.Entries = append(feed.Entries, entry)
+	}
+	return feed
+}
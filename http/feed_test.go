@@ -0,0 +1,106 @@
+package main
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/leganck/fn-qb-proxy/qbapi"
+)
+
+// newFakeQB 在临时 Unix Socket 上启动模拟的 qBittorrent，登录总是成功
+func newFakeQB(t *testing.T, handler http.Handler) *qbapi.Client {
+	t.Helper()
+	sock := filepath.Join(t.TempDir(), "qb.sock")
+	l, err := net.Listen("unix", sock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v2/auth/login", func(w http.ResponseWriter, r *http.Request) {
+		http.SetCookie(w, &http.Cookie{Name: "SID", Value: "test"})
+		w.Write([]byte("Ok."))
+	})
+	mux.Handle("/", handler)
+	srv := &http.Server{Handler: mux}
+	go srv.Serve(l)
+	t.Cleanup(func() { srv.Close() })
+	return qbapi.New(sock, "")
+}
+
+func TestFeedServer(t *testing.T) {
+	var query string
+	client := newFakeQB(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query = r.URL.RawQuery
+		category, filtered := r.URL.Query().Get("category"), r.URL.Query().Has("category")
+		list := []map[string]any{
+			{"hash": "aaa", "name": "Show.S01E02", "category": "tv", "completion_on": 1700000200, "size": 2048},
+			{"hash": "bbb", "name": "Movie", "category": "movies", "completion_on": 1700000100, "size": 1024},
+			// 完成时间未知的种子不列出
+			{"hash": "ccc", "name": "Unknown", "category": "tv", "completion_on": -1},
+		}
+		var out []map[string]any
+		for _, t := range list {
+			if !filtered || t["category"] == category {
+				out = append(out, t)
+			}
+		}
+		json.NewEncoder(w).Encode(out)
+	}))
+	s := newFeedServer(client, "secret", "alice")
+
+	get := func(target string, auth bool) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, "http://nas:18080"+target, nil)
+		if auth {
+			r.SetBasicAuth("admin", "secret")
+		}
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, r)
+		return w
+	}
+
+	for _, target := range []string{"/feed/rss", "/feed/rss?token=wrong"} {
+		if w := get(target, false); w.Code != http.StatusUnauthorized {
+			t.Errorf("%s: status %d, want %d", target, w.Code, http.StatusUnauthorized)
+		}
+	}
+
+	w := get("/feed/rss?token=secret", false)
+	var rss rssFeed
+	if err := xml.Unmarshal(w.Body.Bytes(), &rss); err != nil {
+		t.Fatalf("rss: %v\n%s", err, w.Body)
+	}
+	if len(rss.Channel.Items) != 2 || rss.Channel.Items[0].GUID.Value != "urn:btih:aaa" || rss.Channel.Items[1].Category != "movies" {
+		t.Errorf("rss items = %+v", rss.Channel.Items)
+	}
+	if rss.Channel.Link != "http://nas:18080/feed/rss" {
+		t.Errorf("rss link = %q, token must not be included", rss.Channel.Link)
+	}
+
+	w = get("/feed/atom?category=tv&limit=1000", true)
+	var atom atomFeed
+	if err := xml.Unmarshal(w.Body.Bytes(), &atom); err != nil {
+		t.Fatalf("atom: %v\n%s", err, w.Body)
+	}
+	if atom.Title != "alice - tv" || len(atom.Entries) != 1 || atom.Entries[0].Title != "Show.S01E02" ||
+		atom.Entries[0].Category == nil || atom.Entries[0].Category.Term != "tv" {
+		t.Errorf("atom = %+v", atom)
+	}
+	if atom.Updated != "2023-11-14T22:16:40Z" {
+		t.Errorf("atom updated = %q, want the latest completion time", atom.Updated)
+	}
+	if want := "category=tv&filter=completed&limit=500&reverse=true&sort=completion_on"; query != want {
+		t.Errorf("qBittorrent query = %q, want %q", query, want)
+	}
+
+	if w := get("/feed/json", true); w.Code != http.StatusNotFound {
+		t.Errorf("unknown format: status %d", w.Code)
+	}
+	if w := get("/feed/rss?limit=0", true); w.Code != http.StatusBadRequest {
+		t.Errorf("invalid limit: status %d", w.Code)
+	}
+}
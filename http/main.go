@@ -12,6 +12,8 @@ import (
 	"os"
 	"strings"
 
+	"github.com/leganck/fn-qb-proxy/qbapi"
+	"github.com/leganck/fn-qb-proxy/ratelimit"
 	"github.com/leganck/fn-qb-proxy/sigctx"
 	"github.com/sirupsen/logrus"
 	"github.com/urfave/cli/v2"
@@ -39,15 +41,61 @@ func httpCmd(cliCtx *cli.Context) error {
 	// 替换：使用logrus.Info输出服务启动信息
 	logrus.Infof("http running on port %d", port)
 
+	clientLimits, err := ratelimit.Parse(cliCtx.String("client-rate-limit"))
+	if err != nil {
+		return fmt.Errorf("client rate limit: %w", err)
+	}
+	upstreamLimits, err := ratelimit.Parse(cliCtx.String("upstream-rate-limit"))
+	if err != nil {
+		return fmt.Errorf("upstream rate limit: %w", err)
+	}
+
 	proxy := proxy(uds, authPassword)
+	mux := http.NewServeMux()
+	mux.Handle("/", &proxy)
+
+	// 可选的兼容协议端点，通过同一个 Unix Socket 调用 qBittorrent Web API
+	client := qbapi.New(uds, authPassword)
+	if cliCtx.Bool("transmission") {
+		logrus.Infof("Transmission RPC enabled on %s", transmissionRPCPath)
+		mux.Handle(transmissionRPCPath, newTransmissionServer(client, authPassword))
+	}
+	if cliCtx.Bool("deluge") {
+		logrus.Infof("Deluge JSON-RPC enabled on %s", delugeRPCPath)
+		mux.Handle(delugeRPCPath, newDelugeServer(client, authPassword))
+	}
+	if cliCtx.Bool("aria2") {
+		secret := cliCtx.String("aria2-secret")
+		if secret == "" {
+			secret = authPassword
+		}
+		var origins []string
+		for _, origin := range strings.Split(cliCtx.String("aria2-origins"), ",") {
+			if origin = strings.TrimSuffix(strings.TrimSpace(origin), "/"); origin != "" {
+				origins = append(origins, origin)
+			}
+		}
+		logrus.Infof("aria2 JSON-RPC enabled on %s", aria2RPCPath)
+		mux.Handle(aria2RPCPath, newAria2Server(client, secret, origins))
+	}
+	if cliCtx.Bool("feed") {
+		logrus.Infof("Completed torrents feed enabled on %srss and %satom", feedPath, feedPath)
+		mux.Handle(feedPath, newFeedServer(client, authPassword, cliCtx.String("feed-title")))
+	}
+
+	handler := ratelimit.Middleware(mux,
+		ratelimit.Scope{Name: "client", Limiter: ratelimit.New(clientLimits), Key: remoteIP},
+		ratelimit.Scope{Name: "upstream", Limiter: ratelimit.New(upstreamLimits), Key: func(*http.Request) string { return uds }},
+	)
+
 	server := &http.Server{
 		Addr:    fmt.Sprintf(":%d", port),
-		Handler: &proxy,
+		Handler: handler,
 		BaseContext: func(listener net.Listener) context.Context {
 			return ctx
 		},
 	}
-	err := server.ListenAndServe()
+	err = server.ListenAndServe()
 	if err != nil {
 		return fmt.Errorf("listen and serve: %w", err)
 	}
@@ -56,6 +104,15 @@ func httpCmd(cliCtx *cli.Context) error {
 	return nil
 }
 
+// remoteIP 以客户端 IP 作为限流身份
+func remoteIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
 func proxy(uds string, authPassword string) httputil.ReverseProxy {
 
 	proxy := httputil.ReverseProxy{
@@ -76,6 +133,8 @@ func proxy(uds string, authPassword string) httputil.ReverseProxy {
 		},
 		Rewrite: func(r *httputil.ProxyRequest) {
 			logrus.Debugf("request: %v", r.In.URL.Path)
+			// 转发客户端地址，fn-qb-proxy 据此按客户端限流与匹配规则
+			r.SetXForwarded()
 			r.Out.URL.Scheme = "http"
 			r.Out.URL.Host = fmt.Sprintf("unix://%s", uds)
 			r.Out.Host = fmt.Sprintf("unix://%s", uds)
@@ -159,6 +218,52 @@ func main() {
 				Value:   "",
 				EnvVars: []string{"PASSWORD"},
 			},
+			&cli.StringFlag{
+				Name:    "client-rate-limit",
+				Usage:   "per-client rate limits, format class=rate:burst:inflight,... (classes: sync,read,add,write,default)",
+				EnvVars: []string{"CLIENT_RATE_LIMIT"},
+			},
+			&cli.StringFlag{
+				Name:    "upstream-rate-limit",
+				Usage:   "rate limits towards the qBittorrent socket, same format as --client-rate-limit",
+				EnvVars: []string{"UPSTREAM_RATE_LIMIT"},
+			},
+			&cli.BoolFlag{
+				Name:    "transmission",
+				Usage:   "expose a Transmission RPC compatible endpoint on /transmission/rpc",
+				EnvVars: []string{"TRANSMISSION"},
+			},
+			&cli.BoolFlag{
+				Name:    "deluge",
+				Usage:   "expose a Deluge Web JSON-RPC compatible endpoint on /json",
+				EnvVars: []string{"DELUGE"},
+			},
+			&cli.BoolFlag{
+				Name:    "aria2",
+				Usage:   "expose an aria2 JSON-RPC compatible endpoint (HTTP and WebSocket) on /jsonrpc",
+				EnvVars: []string{"ARIA2"},
+			},
+			&cli.StringFlag{
+				Name:    "aria2-secret",
+				Usage:   "aria2 RPC secret token, defaults to --password",
+				EnvVars: []string{"ARIA2_SECRET"},
+			},
+			&cli.StringFlag{
+				Name:    "aria2-origins",
+				Usage:   "comma-separated browser origins allowed to call the aria2 endpoint besides its own, e.g. http://ariang.lan",
+				EnvVars: []string{"ARIA2_ORIGINS"},
+			},
+			&cli.BoolFlag{
+				Name:    "feed",
+				Usage:   "expose RSS/Atom feeds of completed torrents on /feed/rss and /feed/atom",
+				EnvVars: []string{"FEED"},
+			},
+			&cli.StringFlag{
+				Name:    "feed-title",
+				Usage:   "feed title, e.g. the user name of this instance",
+				Value:   "qBittorrent",
+				EnvVars: []string{"FEED_TITLE"},
+			},
 		},
 	}
 
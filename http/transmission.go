@@ -0,0 +1,417 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/leganck/fn-qb-proxy/qbapi"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	transmissionRPCPath   = "/transmission/rpc"
+	transmissionSessionID = "X-Transmission-Session-Id"
+	transmissionRPCVer    = 17
+)
+
+// Transmission 种子状态
+const (
+	trStopped = iota
+	trCheckWait
+	trCheck
+	trDownloadWait
+	trDownload
+	trSeedWait
+	trSeed
+)
+
+// transmissionRequest Transmission JSON-RPC 请求
+type transmissionRequest struct {
+	Method    string          `json:"method"`
+	Arguments json.RawMessage `json:"arguments"`
+	Tag       any             `json:"tag,omitempty"`
+}
+
+// transmissionServer 将 Transmission RPC 转换为 qBittorrent Web API 调用
+type transmissionServer struct {
+	client    *qbapi.Client
+	password  string
+	sessionID string
+	ids       *torrentIDs
+}
+
+func newTransmissionServer(client *qbapi.Client, password string) *transmissionServer {
+	buf := make([]byte, 24)
+	rand.Read(buf)
+	return &transmissionServer{
+		client:    client,
+		password:  password,
+		sessionID: hex.EncodeToString(buf),
+		ids:       newTorrentIDs(),
+	}
+}
+
+func (s *transmissionServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if s.password != "" {
+		if _, pass, ok := r.BasicAuth(); !ok || subtle.ConstantTimeCompare([]byte(pass), []byte(s.password)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Basic realm="Transmission"`)
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+	}
+
+	// 与 Transmission 一致：缺少或错误的 session id 返回 409 并下发正确的 id
+	if r.Header.Get(transmissionSessionID) != s.sessionID {
+		w.Header().Set(transmissionSessionID, s.sessionID)
+		http.Error(w, "Invalid session id", http.StatusConflict)
+		return
+	}
+
+	var req transmissionRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON request", http.StatusBadRequest)
+		return
+	}
+	logrus.Debugf("transmission rpc: %s", req.Method)
+
+	args, err := s.call(r.Context(), req.Method, req.Arguments)
+	resp := map[string]any{"result": "success", "arguments": args}
+	if err != nil {
+		logrus.Warnf("transmission rpc %s failed: %v", req.Method, err)
+		resp["result"] = err.Error()
+		resp["arguments"] = map[string]any{}
+	}
+	if req.Tag != nil {
+		resp["tag"] = req.Tag
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set(transmissionSessionID, s.sessionID)
+	json.NewEncoder(w).Encode(resp)
+}
+
+func (s *transmissionServer) call(ctx context.Context, method string, raw json.RawMessage) (map[string]any, error) {
+	var args map[string]any
+	if len(raw) > 0 {
+		if err := json.Unmarshal(raw, &args); err != nil {
+			return nil, fmt.Errorf("invalid arguments: %v", err)
+		}
+	}
+
+	switch method {
+	case "torrent-get":
+		return s.torrentGet(ctx, args)
+	case "torrent-add":
+		return s.torrentAdd(ctx, args)
+	case "torrent-remove":
+		hashes, err := s.selectHashes(ctx, args["ids"])
+		if err != nil || len(hashes) == 0 {
+			return map[string]any{}, err
+		}
+		deleteData, _ := args["delete-local-data"].(bool)
+		return map[string]any{}, s.client.Delete(ctx, hashes, deleteData)
+	case "torrent-start", "torrent-start-now":
+		hashes, err := s.selectHashes(ctx, args["ids"])
+		if err != nil || len(hashes) == 0 {
+			return map[string]any{}, err
+		}
+		return map[string]any{}, s.client.Start(ctx, hashes)
+	case "torrent-stop":
+		hashes, err := s.selectHashes(ctx, args["ids"])
+		if err != nil || len(hashes) == 0 {
+			return map[string]any{}, err
+		}
+		return map[string]any{}, s.client.Stop(ctx, hashes)
+	case "session-get":
+		return s.sessionGet(ctx)
+	case "session-stats":
+		return s.sessionStats(ctx)
+	default:
+		return nil, fmt.Errorf("method name not recognized")
+	}
+}
+
+// selectHashes 根据 Transmission 的 ids 参数筛选种子
+func (s *transmissionServer) selectHashes(ctx context.Context, ids any) ([]string, error) {
+	torrents, err := s.selectTorrents(ctx, ids)
+	if err != nil {
+		return nil, err
+	}
+	hashes := make([]string, 0, len(torrents))
+	for _, t := range torrents {
+		hashes = append(hashes, t.Hash)
+	}
+	return hashes, nil
+}
+
+// selectTorrents ids 可以为空（全部）、单个 id、哈希、"recently-active" 或它们组成的数组
+func (s *transmissionServer) selectTorrents(ctx context.Context, ids any) ([]qbapi.Torrent, error) {
+	all, err := s.client.Torrents(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	s.ids.assign(all)
+	if ids == nil {
+		return all, nil
+	}
+
+	if str, ok := ids.(string); ok && str == "recently-active" {
+		cutoff := time.Now().Add(-time.Minute).Unix()
+		var active []qbapi.Torrent
+		for _, t := range all {
+			if t.LastActivity >= cutoff || t.DlSpeed > 0 || t.UpSpeed > 0 {
+				active = append(active, t)
+			}
+		}
+		return active, nil
+	}
+
+	wanted := make(map[string]bool)
+	list, ok := ids.([]any)
+	if !ok {
+		list = []any{ids}
+	}
+	for _, id := range list {
+		switch v := id.(type) {
+		case float64:
+			if hash, ok := s.ids.hash(int(v)); ok {
+				wanted[hash] = true
+			}
+		case string:
+			wanted[strings.ToLower(v)] = true
+		}
+	}
+
+	var selected []qbapi.Torrent
+	for _, t := range all {
+		if wanted[t.Hash] {
+			selected = append(selected, t)
+		}
+	}
+	return selected, nil
+}
+
+func (s *transmissionServer) torrentGet(ctx context.Context, args map[string]any) (map[string]any, error) {
+	torrents, err := s.selectTorrents(ctx, args["ids"])
+	if err != nil {
+		return nil, err
+	}
+
+	var fields []string
+	if list, ok := args["fields"].([]any); ok {
+		for _, f := range list {
+			if name, ok := f.(string); ok {
+				fields = append(fields, name)
+			}
+		}
+	}
+
+	result := make([]map[string]any, 0, len(torrents))
+	for i := range torrents {
+		all := transmissionFields(&torrents[i], s.ids.id(torrents[i].Hash))
+		if len(fields) == 0 {
+			result = append(result, all)
+			continue
+		}
+		picked := make(map[string]any, len(fields))
+		for _, f := range fields {
+			if v, ok := all[f]; ok {
+				picked[f] = v
+			}
+		}
+		result = append(result, picked)
+	}
+	return map[string]any{"torrents": result}, nil
+}
+
+// transmissionFields 将 qBittorrent 种子信息映射为 Transmission 字段
+func transmissionFields(t *qbapi.Torrent, id int) map[string]any {
+	status := trDownload
+	errCode, errString := 0, ""
+	switch t.State {
+	case "pausedDL", "pausedUP", "stoppedDL", "stoppedUP":
+		status = trStopped
+	case "error", "missingFiles":
+		status = trStopped
+		errCode, errString = 3, t.State
+	case "checkingDL", "checkingUP", "checkingResumeData", "moving":
+		status = trCheck
+	case "queuedDL":
+		status = trDownloadWait
+	case "queuedUP":
+		status = trSeedWait
+	case "uploading", "stalledUP", "forcedUP":
+		status = trSeed
+	}
+
+	eta := t.Eta
+	if eta >= 8640000 {
+		eta = -2 // 未知
+	}
+
+	labels := []string{}
+	for _, tag := range strings.Split(t.Tags, ",") {
+		if tag = strings.TrimSpace(tag); tag != "" {
+			labels = append(labels, tag)
+		}
+	}
+
+	return map[string]any{
+		"id":             id,
+		"hashString":     t.Hash,
+		"name":           t.Name,
+		"status":         status,
+		"error":          errCode,
+		"errorString":    errString,
+		"totalSize":      t.TotalSize,
+		"sizeWhenDone":   t.Size,
+		"leftUntilDone":  t.AmountLeft,
+		"percentDone":    t.Progress,
+		"rateDownload":   t.DlSpeed,
+		"rateUpload":     t.UpSpeed,
+		"eta":            eta,
+		"uploadRatio":    t.Ratio,
+		"downloadDir":    t.SavePath,
+		"addedDate":      t.AddedOn,
+		"doneDate":       max(t.CompletionOn, 0),
+		"activityDate":   t.LastActivity,
+		"isFinished":     t.IsFinished() && status == trStopped,
+		"downloadedEver": t.Downloaded,
+		"uploadedEver":   t.Uploaded,
+		"peersConnected": t.NumSeeds + t.NumLeechs,
+		"queuePosition":  t.Priority,
+		"magnetLink":     t.MagnetURI,
+		"labels":         labels,
+		"seedRatioLimit": t.RatioLimit,
+		"seedRatioMode":  0,
+		"secondsSeeding": t.SeedingTime,
+		"trackers":       []map[string]any{{"announce": t.Tracker, "id": 0, "tier": 0}},
+	}
+}
+
+func (s *transmissionServer) torrentAdd(ctx context.Context, args map[string]any) (map[string]any, error) {
+	opts := qbapi.AddOptions{}
+	opts.SavePath, _ = args["download-dir"].(string)
+	opts.Paused, _ = args["paused"].(bool)
+	if labels, ok := args["labels"].([]any); ok {
+		for _, l := range labels {
+			if tag, ok := l.(string); ok && tag != "" {
+				opts.Tags = append(opts.Tags, tag)
+			}
+		}
+	}
+
+	var source torrentSource
+	var err error
+	if metainfo, ok := args["metainfo"].(string); ok && metainfo != "" {
+		data, decodeErr := base64.StdEncoding.DecodeString(metainfo)
+		if decodeErr != nil {
+			return nil, fmt.Errorf("invalid metainfo: %v", decodeErr)
+		}
+		source, err = sourceFromFile("upload.torrent", data)
+	} else if filename, ok := args["filename"].(string); ok && filename != "" {
+		source, err = resolveSource(ctx, filename)
+	} else {
+		return nil, fmt.Errorf("no filename or metainfo specified")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// 已存在的种子按 Transmission 的约定返回 torrent-duplicate
+	if source.hash != "" {
+		if existing, err := s.client.Torrents(ctx, map[string][]string{"hashes": {source.hash}}); err == nil && len(existing) > 0 {
+			s.ids.assign(existing)
+			return map[string]any{"torrent-duplicate": map[string]any{
+				"id": s.ids.id(source.hash), "name": existing[0].Name, "hashString": source.hash,
+			}}, nil
+		}
+	}
+
+	source.apply(&opts)
+	if err := s.client.AddTorrent(ctx, opts); err != nil {
+		return nil, err
+	}
+
+	added := map[string]any{"name": source.name, "hashString": source.hash}
+	if source.hash != "" {
+		added["id"] = s.ids.reserve(source.hash)
+	}
+	return map[string]any{"torrent-added": added}, nil
+}
+
+func (s *transmissionServer) sessionGet(ctx context.Context) (map[string]any, error) {
+	version, err := s.client.Version(ctx)
+	if err != nil {
+		return nil, err
+	}
+	savePath, _ := s.client.DefaultSavePath(ctx)
+	info, err := s.client.TransferInfo(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]any{
+		"version":                  fmt.Sprintf("3.00 (qBittorrent %s)", version),
+		"rpc-version":              transmissionRPCVer,
+		"rpc-version-minimum":      1,
+		"session-id":               s.sessionID,
+		"download-dir":             savePath,
+		"speed-limit-down":         info.DlRateLimit / 1024,
+		"speed-limit-down-enabled": info.DlRateLimit > 0,
+		"speed-limit-up":           info.UpRateLimit / 1024,
+		"speed-limit-up-enabled":   info.UpRateLimit > 0,
+		"alt-speed-enabled":        false,
+		"units": map[string]any{
+			"speed-units":  []string{"kB/s", "MB/s", "GB/s", "TB/s"},
+			"speed-bytes":  1000,
+			"size-units":   []string{"kB", "MB", "GB", "TB"},
+			"size-bytes":   1000,
+			"memory-units": []string{"KiB", "MiB", "GiB", "TiB"},
+			"memory-bytes": 1024,
+		},
+	}, nil
+}
+
+func (s *transmissionServer) sessionStats(ctx context.Context) (map[string]any, error) {
+	torrents, err := s.client.Torrents(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	info, err := s.client.TransferInfo(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	active, paused := 0, 0
+	for i := range torrents {
+		if torrents[i].IsPaused() {
+			paused++
+		} else if torrents[i].DlSpeed > 0 || torrents[i].UpSpeed > 0 {
+			active++
+		}
+	}
+	stats := map[string]any{
+		"uploadedBytes":   info.UpInfoData,
+		"downloadedBytes": info.DlInfoData,
+		"filesAdded":      len(torrents),
+		"sessionCount":    1,
+		"secondsActive":   0,
+	}
+	return map[string]any{
+		"activeTorrentCount": active,
+		"pausedTorrentCount": paused,
+		"torrentCount":       len(torrents),
+		"downloadSpeed":      info.DlInfoSpeed,
+		"uploadSpeed":        info.UpInfoSpeed,
+		"cumulative-stats":   stats,
+		"current-stats":      stats,
+	}, nil
+}
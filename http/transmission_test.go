@@ -0,0 +1,36 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestTransmissionAuth(t *testing.T) {
+	s := newTransmissionServer(nil, "secret")
+	tests := []struct {
+		user, pass string
+		basic      bool
+		want       int
+	}{
+		{want: http.StatusUnauthorized},
+		{basic: true, user: "admin", pass: "wrong", want: http.StatusUnauthorized},
+		{basic: true, user: "admin", pass: "secre", want: http.StatusUnauthorized},
+		// 认证通过后缺少 session id 返回 409
+		{basic: true, user: "admin", pass: "secret", want: http.StatusConflict},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodPost, transmissionRPCPath, nil)
+		if tt.basic {
+			r.SetBasicAuth(tt.user, tt.pass)
+		}
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, r)
+		if w.Code != tt.want {
+			t.Errorf("basic %v pass %q: status %d, want %d", tt.basic, tt.pass, w.Code, tt.want)
+		}
+		if tt.want == http.StatusConflict && w.Header().Get(transmissionSessionID) != s.sessionID {
+			t.Error("session id was not returned")
+		}
+	}
+}
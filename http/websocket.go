@@ -0,0 +1,185 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// websocketGUID RFC 6455 握手使用的固定 GUID
+const websocketGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// maxWebsocketMessage 单条消息允许的最大字节数
+const maxWebsocketMessage = 16 << 20
+
+// WebSocket 帧类型
+const (
+	wsOpContinuation = 0x0
+	wsOpText         = 0x1
+	wsOpBinary       = 0x2
+	wsOpClose        = 0x8
+	wsOpPing         = 0x9
+	wsOpPong         = 0xA
+)
+
+// websocketConn 只实现 JSON-RPC 所需的最小 WebSocket 服务端：文本消息、ping/pong 与关闭
+type websocketConn struct {
+	conn net.Conn
+	rw   *bufio.ReadWriter
+	wmu  sync.Mutex
+}
+
+// isWebsocketUpgrade 判断请求是否为 WebSocket 升级请求
+func isWebsocketUpgrade(r *http.Request) bool {
+	return strings.EqualFold(r.Header.Get("Upgrade"), "websocket") &&
+		strings.Contains(strings.ToLower(r.Header.Get("Connection")), "upgrade")
+}
+
+// upgradeWebsocket 完成握手并接管连接
+func upgradeWebsocket(w http.ResponseWriter, r *http.Request) (*websocketConn, error) {
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if key == "" || r.Header.Get("Sec-WebSocket-Version") != "13" {
+		http.Error(w, "Bad WebSocket handshake", http.StatusBadRequest)
+		return nil, errors.New("bad websocket handshake")
+	}
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "WebSocket not supported", http.StatusInternalServerError)
+		return nil, errors.New("response writer does not support hijacking")
+	}
+	conn, rw, err := hijacker.Hijack()
+	if err != nil {
+		return nil, err
+	}
+
+	sum := sha1.Sum([]byte(key + websocketGUID))
+	accept := base64.StdEncoding.EncodeToString(sum[:])
+	fmt.Fprintf(rw, "HTTP/1.1 101 Switching Protocols\r\n"+
+		"Upgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Accept: %s\r\n\r\n", accept)
+	if err := rw.Flush(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return &websocketConn{conn: conn, rw: rw}, nil
+}
+
+// ReadMessage 读取一条完整的文本或二进制消息，自动应答 ping，收到关闭帧时返回 io.EOF
+func (c *websocketConn) ReadMessage() ([]byte, error) {
+	var message []byte
+	for {
+		fin, opcode, payload, err := c.readFrame()
+		if err != nil {
+			return nil, err
+		}
+		switch opcode {
+		case wsOpPing:
+			if err := c.writeFrame(wsOpPong, payload); err != nil {
+				return nil, err
+			}
+			continue
+		case wsOpPong:
+			continue
+		case wsOpClose:
+			c.writeFrame(wsOpClose, nil)
+			return nil, io.EOF
+		case wsOpText, wsOpBinary, wsOpContinuation:
+			message = append(message, payload...)
+			if len(message) > maxWebsocketMessage {
+				return nil, errors.New("websocket message too large")
+			}
+			if fin {
+				return message, nil
+			}
+		default:
+			return nil, fmt.Errorf("unsupported websocket opcode %d", opcode)
+		}
+	}
+}
+
+func (c *websocketConn) readFrame() (fin bool, opcode byte, payload []byte, err error) {
+	var header [2]byte
+	if _, err = io.ReadFull(c.rw, header[:]); err != nil {
+		return
+	}
+	fin = header[0]&0x80 != 0
+	opcode = header[0] & 0x0F
+	masked := header[1]&0x80 != 0
+	length := uint64(header[1] & 0x7F)
+
+	switch length {
+	case 126:
+		var ext [2]byte
+		if _, err = io.ReadFull(c.rw, ext[:]); err != nil {
+			return
+		}
+		length = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err = io.ReadFull(c.rw, ext[:]); err != nil {
+			return
+		}
+		length = binary.BigEndian.Uint64(ext[:])
+	}
+	if length > maxWebsocketMessage {
+		err = errors.New("websocket frame too large")
+		return
+	}
+
+	var mask [4]byte
+	if masked {
+		if _, err = io.ReadFull(c.rw, mask[:]); err != nil {
+			return
+		}
+	}
+	payload = make([]byte, length)
+	if _, err = io.ReadFull(c.rw, payload); err != nil {
+		return
+	}
+	if masked {
+		for i := range payload {
+			payload[i] ^= mask[i%4]
+		}
+	}
+	return
+}
+
+// WriteText 发送一条文本消息，可并发调用
+func (c *websocketConn) WriteText(data []byte) error {
+	return c.writeFrame(wsOpText, data)
+}
+
+func (c *websocketConn) writeFrame(opcode byte, payload []byte) error {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+
+	header := []byte{0x80 | opcode}
+	switch n := len(payload); {
+	case n < 126:
+		header = append(header, byte(n))
+	case n <= 0xFFFF:
+		header = append(header, 126, byte(n>>8), byte(n))
+	default:
+		header = append(header, 127)
+		header = binary.BigEndian.AppendUint64(header, uint64(n))
+	}
+	if _, err := c.rw.Write(header); err != nil {
+		return err
+	}
+	if _, err := c.rw.Write(payload); err != nil {
+		return err
+	}
+	return c.rw.Flush()
+}
+
+// Close 关闭底层连接
+func (c *websocketConn) Close() error {
+	return c.conn.Close()
+}
@@ -0,0 +1,125 @@
+package metainfo
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strconv"
+)
+
+var errInvalid = errors.New("invalid bencode data")
+
+// maxDepth 列表与字典允许的最大嵌套层数，防止恶意数据耗尽栈空间
+const maxDepth = 128
+
+// decoder bencode 解码器，字典解码为 map[string]any，字符串解码为 string
+type decoder struct {
+	data  []byte
+	pos   int
+	depth int
+}
+
+// Decode 解码完整的 bencode 数据
+func Decode(data []byte) (any, error) {
+	d := &decoder{data: data}
+	v, err := d.value()
+	if err != nil {
+		return nil, err
+	}
+	if d.pos != len(data) {
+		return nil, fmt.Errorf("%w: trailing data at offset %d", errInvalid, d.pos)
+	}
+	return v, nil
+}
+
+func (d *decoder) value() (any, error) {
+	if d.pos >= len(d.data) {
+		return nil, fmt.Errorf("%w: unexpected end", errInvalid)
+	}
+	if c := d.data[d.pos]; c == 'l' || c == 'd' {
+		if d.depth >= maxDepth {
+			return nil, fmt.Errorf("%w: nesting too deep", errInvalid)
+		}
+		d.depth++
+		defer func() { d.depth-- }()
+	}
+	switch c := d.data[d.pos]; {
+	case c == 'i':
+		d.pos++
+		end := bytes.IndexByte(d.data[d.pos:], 'e')
+		if end < 0 {
+			return nil, fmt.Errorf("%w: unterminated integer", errInvalid)
+		}
+		n, err := strconv.ParseInt(string(d.data[d.pos:d.pos+end]), 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%w: bad integer", errInvalid)
+		}
+		d.pos += end + 1
+		return n, nil
+	case c == 'l':
+		d.pos++
+		list := []any{}
+		for d.pos < len(d.data) && d.data[d.pos] != 'e' {
+			v, err := d.value()
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, v)
+		}
+		if d.pos >= len(d.data) {
+			return nil, fmt.Errorf("%w: unterminated list", errInvalid)
+		}
+		d.pos++
+		return list, nil
+	case c == 'd':
+		d.pos++
+		dict := map[string]any{}
+		for d.pos < len(d.data) && d.data[d.pos] != 'e' {
+			key, err := d.str()
+			if err != nil {
+				return nil, err
+			}
+			v, err := d.value()
+			if err != nil {
+				return nil, err
+			}
+			dict[key] = v
+		}
+		if d.pos >= len(d.data) {
+			return nil, fmt.Errorf("%w: unterminated dict", errInvalid)
+		}
+		d.pos++
+		return dict, nil
+	case c >= '0' && c <= '9':
+		return d.str()
+	default:
+		return nil, fmt.Errorf("%w: unexpected byte %q at offset %d", errInvalid, c, d.pos)
+	}
+}
+
+func (d *decoder) str() (string, error) {
+	colon := bytes.IndexByte(d.data[d.pos:], ':')
+	if colon < 0 {
+		return "", fmt.Errorf("%w: bad string length", errInvalid)
+	}
+	n, err := strconv.Atoi(string(d.data[d.pos : d.pos+colon]))
+	if err != nil || n < 0 {
+		return "", fmt.Errorf("%w: bad string length", errInvalid)
+	}
+	start := d.pos + colon + 1
+	// n 可能接近 MaxInt，不能直接计算 start+n
+	if n > len(d.data)-start {
+		return "", fmt.Errorf("%w: string exceeds data", errInvalid)
+	}
+	d.pos = start + n
+	return string(d.data[start:d.pos]), nil
+}
+
+// rawValue 返回从 pos 开始的一个完整值的原始字节，用于计算 info 字典的哈希
+func (d *decoder) rawValue() ([]byte, error) {
+	start := d.pos
+	if _, err := d.value(); err != nil {
+		return nil, err
+	}
+	return d.data[start:d.pos], nil
+}
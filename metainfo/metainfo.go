@@ -0,0 +1,170 @@
+package metainfo
+
+import (
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/url"
+	"slices"
+	"strings"
+)
+
+// Torrent 从 .torrent 文件或磁力链接中解析出的基本信息
+type Torrent struct {
+	Name       string
+	InfoHashV1 string // 40 位小写十六进制，纯 v2 种子为空
+	InfoHashV2 string // 64 位小写十六进制，v1 种子为空
+	TotalSize  int64  // 磁力链接未知时为 0
+	Trackers   []string
+}
+
+// Hash 返回 qBittorrent 使用的种子标识：优先 v1，纯 v2 种子取 v2 的前 40 位
+func (t *Torrent) Hash() string {
+	if t.InfoHashV1 != "" {
+		return t.InfoHashV1
+	}
+	if len(t.InfoHashV2) >= 40 {
+		return t.InfoHashV2[:40]
+	}
+	return ""
+}
+
+// Parse 解析 .torrent 文件内容
+func Parse(data []byte) (*Torrent, error) {
+	if len(data) == 0 || data[0] != 'd' {
+		return nil, fmt.Errorf("%w: not a dictionary", errInvalid)
+	}
+
+	// 逐个读取顶层字典的键，保留 info 字典的原始字节用于计算哈希
+	d := &decoder{data: data, pos: 1}
+	var rawInfo []byte
+	top := map[string]any{}
+	for d.pos < len(d.data) && d.data[d.pos] != 'e' {
+		key, err := d.str()
+		if err != nil {
+			return nil, err
+		}
+		if key == "info" {
+			if rawInfo, err = d.rawValue(); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		v, err := d.value()
+		if err != nil {
+			return nil, err
+		}
+		top[key] = v
+	}
+	if rawInfo == nil {
+		return nil, errors.New("torrent has no info dictionary")
+	}
+
+	infoValue, err := Decode(rawInfo)
+	if err != nil {
+		return nil, err
+	}
+	info, ok := infoValue.(map[string]any)
+	if !ok {
+		return nil, fmt.Errorf("%w: info is not a dictionary", errInvalid)
+	}
+
+	t := &Torrent{}
+	t.Name, _ = info["name"].(string)
+	if version, _ := info["meta version"].(int64); version == 2 {
+		sum := sha256.Sum256(rawInfo)
+		t.InfoHashV2 = hex.EncodeToString(sum[:])
+	}
+	if _, hasPieces := info["pieces"]; hasPieces {
+		sum := sha1.Sum(rawInfo)
+		t.InfoHashV1 = hex.EncodeToString(sum[:])
+	}
+	if t.InfoHashV1 == "" && t.InfoHashV2 == "" {
+		return nil, errors.New("torrent has neither v1 pieces nor v2 meta version")
+	}
+
+	if length, ok := info["length"].(int64); ok {
+		t.TotalSize = length
+	} else if files, ok := info["files"].([]any); ok {
+		for _, f := range files {
+			if fd, ok := f.(map[string]any); ok {
+				n, _ := fd["length"].(int64)
+				t.TotalSize += n
+			}
+		}
+	} else if tree, ok := info["file tree"].(map[string]any); ok {
+		t.TotalSize = fileTreeSize(tree)
+	}
+
+	if announce, ok := top["announce"].(string); ok && announce != "" {
+		t.Trackers = append(t.Trackers, announce)
+	}
+	if tiers, ok := top["announce-list"].([]any); ok {
+		for _, tier := range tiers {
+			urls, _ := tier.([]any)
+			for _, u := range urls {
+				if s, ok := u.(string); ok && s != "" && !slices.Contains(t.Trackers, s) {
+					t.Trackers = append(t.Trackers, s)
+				}
+			}
+		}
+	}
+	return t, nil
+}
+
+// fileTreeSize 累加 v2 file tree 中所有文件的长度
+func fileTreeSize(tree map[string]any) int64 {
+	var total int64
+	for name, v := range tree {
+		node, ok := v.(map[string]any)
+		if !ok {
+			continue
+		}
+		if name == "" {
+			n, _ := node["length"].(int64)
+			total += n
+			continue
+		}
+		total += fileTreeSize(node)
+	}
+	return total
+}
+
+// ParseMagnet 解析磁力链接中的哈希、名称与 tracker
+func ParseMagnet(uri string) (*Torrent, error) {
+	u, err := url.Parse(strings.TrimSpace(uri))
+	if err != nil || u.Scheme != "magnet" {
+		return nil, fmt.Errorf("not a magnet link: %q", uri)
+	}
+	q := u.Query()
+	t := &Torrent{Name: q.Get("dn"), Trackers: q["tr"]}
+
+	for _, xt := range q["xt"] {
+		switch {
+		case strings.HasPrefix(xt, "urn:btih:"):
+			h := strings.TrimPrefix(xt, "urn:btih:")
+			switch len(h) {
+			case 40:
+				if _, err := hex.DecodeString(h); err == nil {
+					t.InfoHashV1 = strings.ToLower(h)
+				}
+			case 32:
+				if raw, err := base32.StdEncoding.DecodeString(strings.ToUpper(h)); err == nil {
+					t.InfoHashV1 = hex.EncodeToString(raw)
+				}
+			}
+		case strings.HasPrefix(xt, "urn:btmh:1220"):
+			h := strings.TrimPrefix(xt, "urn:btmh:1220")
+			if _, err := hex.DecodeString(h); err == nil && len(h) == 64 {
+				t.InfoHashV2 = strings.ToLower(h)
+			}
+		}
+	}
+	if t.InfoHashV1 == "" && t.InfoHashV2 == "" {
+		return nil, fmt.Errorf("magnet link has no valid infohash: %q", uri)
+	}
+	return t, nil
+}
@@ -0,0 +1,148 @@
+package metainfo
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"strings"
+	"testing"
+)
+
+func sha1Hex(s string) string {
+	sum := sha1.Sum([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
+
+func TestParse(t *testing.T) {
+	single := "d6:lengthi1000e4:name5:hello12:piece lengthi16384e6:pieces20:aaaaaaaaaaaaaaaaaaaae"
+	multi := "d5:filesld6:lengthi10e4:pathl1:aeed6:lengthi20e4:pathl1:beee4:name3:dir12:piece lengthi16384e6:pieces20:aaaaaaaaaaaaaaaaaaaae"
+
+	tests := []struct {
+		name     string
+		data     string
+		wantErr  bool
+		hash     string
+		size     int64
+		title    string
+		trackers []string
+	}{
+		{
+			name:     "single file",
+			data:     "d8:announce14:http://t/annce4:info" + single + "e",
+			hash:     sha1Hex(single),
+			size:     1000,
+			title:    "hello",
+			trackers: []string{"http://t/annce"},
+		},
+		{
+			name:     "multi file with announce list",
+			data:     "d13:announce-listll3:t/1el3:t/23:t/1ee4:info" + multi + "e",
+			hash:     sha1Hex(multi),
+			size:     30,
+			title:    "dir",
+			trackers: []string{"t/1", "t/2"},
+		},
+		{name: "empty", data: "", wantErr: true},
+		{name: "not a dictionary", data: "li1ee", wantErr: true},
+		{name: "no info", data: "d4:name1:xe", wantErr: true},
+		{name: "info without pieces", data: "d4:infod4:name1:xee", wantErr: true},
+		{name: "truncated string", data: "d4:info5:abc", wantErr: true},
+		{name: "huge string length", data: "d9223372036854775807:aaaa", wantErr: true},
+		{name: "negative string length", data: "d-1:ae", wantErr: true},
+		{name: "unterminated integer", data: "d4:infod6:lengthi10", wantErr: true},
+		{name: "deep nesting", data: "d4:info" + strings.Repeat("l", 10000) + strings.Repeat("e", 10000) + "e", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Parse([]byte(tt.data))
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("Parse() = %+v, want error", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Parse() error = %v", err)
+			}
+			if got.Hash() != tt.hash {
+				t.Errorf("Hash() = %s, want %s", got.Hash(), tt.hash)
+			}
+			if got.TotalSize != tt.size {
+				t.Errorf("TotalSize = %d, want %d", got.TotalSize, tt.size)
+			}
+			if got.Name != tt.title {
+				t.Errorf("Name = %q, want %q", got.Name, tt.title)
+			}
+			if strings.Join(got.Trackers, ",") != strings.Join(tt.trackers, ",") {
+				t.Errorf("Trackers = %v, want %v", got.Trackers, tt.trackers)
+			}
+		})
+	}
+}
+
+func TestParseMagnet(t *testing.T) {
+	tests := []struct {
+		name    string
+		uri     string
+		wantErr bool
+		hash    string
+		dn      string
+	}{
+		{
+			name: "hex btih",
+			uri:  "magnet:?xt=urn:btih:0123456789ABCDEF0123456789ABCDEF01234567&dn=Ubuntu&tr=http://t/a",
+			hash: "0123456789abcdef0123456789abcdef01234567",
+			dn:   "Ubuntu",
+		},
+		{
+			name: "base32 btih",
+			uri:  "magnet:?xt=urn:btih:AERUKZ4JVPG66AJDIVTYTK6N54ASGRLH",
+			hash: "0123456789abcdef0123456789abcdef01234567",
+		},
+		{
+			name: "v2 only",
+			uri:  "magnet:?xt=urn:btmh:1220" + strings.Repeat("ab", 32),
+			hash: strings.Repeat("ab", 20),
+		},
+		{name: "not a magnet", uri: "http://example.org/a.torrent", wantErr: true},
+		{name: "no hash", uri: "magnet:?dn=x", wantErr: true},
+		{name: "bad hex", uri: "magnet:?xt=urn:btih:" + strings.Repeat("z", 40), wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseMagnet(tt.uri)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("ParseMagnet() = %+v, want error", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseMagnet() error = %v", err)
+			}
+			if got.Hash() != tt.hash {
+				t.Errorf("Hash() = %s, want %s", got.Hash(), tt.hash)
+			}
+			if got.Name != tt.dn {
+				t.Errorf("Name = %q, want %q", got.Name, tt.dn)
+			}
+		})
+	}
+}
+
+func FuzzParse(f *testing.F) {
+	f.Add([]byte("d4:infod6:lengthi1e4:name1:x6:pieces20:aaaaaaaaaaaaaaaaaaaaee"))
+	f.Add([]byte("d9223372036854775807:aaaa"))
+	f.Add([]byte("d4:infoli1eee"))
+	f.Fuzz(func(t *testing.T, data []byte) {
+		Parse(data)
+		Decode(data)
+	})
+}
+
+func FuzzParseMagnet(f *testing.F) {
+	f.Add("magnet:?xt=urn:btih:0123456789abcdef0123456789abcdef01234567&dn=x")
+	f.Add("magnet:?xt=urn:btmh:1220" + strings.Repeat("ab", 32))
+	f.Fuzz(func(t *testing.T, uri string) {
+		ParseMagnet(uri)
+	})
+}
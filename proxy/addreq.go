@@ -0,0 +1,188 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/leganck/fn-qb-proxy/metainfo"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// maxAddRequestBody 解析添加请求时读取的最大请求体
+	maxAddRequestBody = 64 << 20
+	// addClientTTL 添加请求的来源记录保留时间，超时后仍未在 maindata 中出现的视为添加失败
+	addClientTTL = 10 * time.Minute
+)
+
+// errAddRequestTooLarge 添加请求超过 maxAddRequestBody，截断后转发会丢失部分种子
+var errAddRequestTooLarge = errors.New("add request too large")
+
+// addClient 发起添加请求的客户端身份
+type addClient struct {
+	Peer      string // 对端 uid，如 uid:1000
+	IP        string // 可信 HTTP 网关转发的客户端地址
+	UserAgent string
+}
+
+// addRequest 解析后的 torrents/add 请求
+type addRequest struct {
+	Client   addClient
+	Torrents []*metainfo.Torrent // 上传的 .torrent 文件与磁力链接，无法解析的不包含在内
+	URLs     []string            // 无法在本地解析的 http(s) 链接
+	Category string
+	SavePath string
+}
+
+// Hashes 返回请求中可以确定的 infohash
+func (a *addRequest) Hashes() []string {
+	hashes := make([]string, 0, len(a.Torrents))
+	for _, t := range a.Torrents {
+		hashes = append(hashes, t.Hash())
+	}
+	return hashes
+}
+
+// parseAddRequest 读取并解析添加请求，之后恢复请求体以便继续转发
+func parseAddRequest(r *http.Request) (*addRequest, error) {
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxAddRequestBody+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(body) > maxAddRequestBody {
+		return nil, errAddRequestTooLarge
+	}
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	r.ContentLength = int64(len(body))
+
+	clone := r.Clone(r.Context())
+	clone.Body = io.NopCloser(bytes.NewReader(body))
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/") {
+		err = clone.ParseMultipartForm(maxAddRequestBody)
+	} else {
+		err = clone.ParseForm()
+	}
+	if clone.MultipartForm != nil {
+		// 超出内存限制的文件写入了临时目录
+		defer clone.MultipartForm.RemoveAll()
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	peer, _ := r.Context().Value(peerKeyCtx{}).(string)
+	add := &addRequest{
+		Client:   addClient{Peer: peer, IP: forwardedFor(r), UserAgent: r.UserAgent()},
+		Category: clone.FormValue("category"),
+		SavePath: clone.FormValue("savepath"),
+	}
+
+	for _, line := range strings.Split(clone.FormValue("urls"), "\n") {
+		line = strings.TrimSpace(line)
+		switch {
+		case line == "":
+		case strings.HasPrefix(line, "magnet:"):
+			if t, err := metainfo.ParseMagnet(line); err == nil {
+				add.Torrents = append(add.Torrents, t)
+			}
+		default:
+			add.URLs = append(add.URLs, line)
+		}
+	}
+	if clone.MultipartForm != nil {
+		for _, fh := range clone.MultipartForm.File["torrents"] {
+			f, err := fh.Open()
+			if err != nil {
+				continue
+			}
+			data, err := io.ReadAll(f)
+			f.Close()
+			if err != nil {
+				continue
+			}
+			if t, err := metainfo.Parse(data); err == nil {
+				add.Torrents = append(add.Torrents, t)
+			} else {
+				logrus.Debugf("Failed to parse uploaded torrent %s: %v", fh.Filename, err)
+			}
+		}
+	}
+	return add, nil
+}
+
+// checkAddRequest 处理经过代理的添加请求，返回 false 表示请求已被拒绝并写入响应
+func checkAddRequest(username string, w http.ResponseWriter, r *http.Request) bool {
+	add, err := parseAddRequest(r)
+	if errors.Is(err, errAddRequestTooLarge) {
+		http.Error(w, "Request too large", http.StatusRequestEntityTooLarge)
+		return false
+	}
+	if err != nil {
+		http.Error(w, "Failed to read request", http.StatusBadRequest)
+		return false
+	}
+	// 共享给已有种子的请求不会添加新种子，先于空间与配额检查
+	if !checkDuplicatesForAdd(username, add, w, r) {
+		return false
+	}
+	if !checkDiskSpaceForAdd(username, add, w) || !checkQuotaForAdd(r.Context(), username, add, w) {
+		return false
+	}
+	rememberAdd(username, add)
+	return true
+}
+
+// pendingAdd 等待在 maindata 中出现的添加记录
+type pendingAdd struct {
+	client  addClient
+	torrent *metainfo.Torrent
+	at      time.Time
+}
+
+var (
+	pendingAdds   = make(map[string]pendingAdd) // key 为 用户名/infohash
+	pendingAddsMu sync.Mutex
+)
+
+// rememberAdd 记录添加请求中每个种子的来源，供规则等在种子出现后使用
+func rememberAdd(username string, add *addRequest) {
+	pendingAddsMu.Lock()
+	defer pendingAddsMu.Unlock()
+	now := time.Now()
+	for key, p := range pendingAdds {
+		if now.Sub(p.at) > addClientTTL {
+			delete(pendingAdds, key)
+		}
+	}
+	for _, t := range add.Torrents {
+		if t.Hash() == "" {
+			continue
+		}
+		pendingAdds[username+"/"+t.Hash()] = pendingAdd{client: add.Client, torrent: t, at: now}
+	}
+}
+
+// takePendingAdd 取出并删除种子的添加记录
+func takePendingAdd(username, hash string) (pendingAdd, bool) {
+	pendingAddsMu.Lock()
+	defer pendingAddsMu.Unlock()
+	p, ok := pendingAdds[username+"/"+hash]
+	if ok {
+		delete(pendingAdds, username+"/"+hash)
+	}
+	return p, ok
+}
+
+// clientIP 去掉端口，便于按地址匹配
+func (c addClient) clientIP() string {
+	if host, _, err := net.SplitHostPort(c.IP); err == nil {
+		return host
+	}
+	return c.IP
+}
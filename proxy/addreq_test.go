@@ -0,0 +1,65 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// zeroReader 无限输出 0 字节
+type zeroReader struct{}
+
+func (zeroReader) Read(p []byte) (int, error) {
+	clear(p)
+	return len(p), nil
+}
+
+func TestParseAddRequest(t *testing.T) {
+	data, hash := testTorrent("show", 100)
+	var buf bytes.Buffer
+	mw := multipart.NewWriter(&buf)
+	mw.WriteField("urls", "magnet:?xt=urn:btih:CCCCCCCCCCCCCCCCCCCCCCCCCCCCCCCCCCCCCCCC\nhttp://example.org/a.torrent\n")
+	mw.WriteField("category", "tv")
+	fw, _ := mw.CreateFormFile("torrents", "show.torrent")
+	fw.Write(data)
+	mw.Close()
+	body := buf.String()
+
+	r := httptest.NewRequest(http.MethodPost, "/api/v2/torrents/add", strings.NewReader(body))
+	r.Header.Set("Content-Type", mw.FormDataContentType())
+	add, err := parseAddRequest(r)
+	if err != nil {
+		t.Fatalf("parseAddRequest() error = %v", err)
+	}
+	hashes := strings.Join(add.Hashes(), ",")
+	if want := "cccccccccccccccccccccccccccccccccccccccc," + hash; hashes != want {
+		t.Errorf("Hashes() = %s, want %s", hashes, want)
+	}
+	if len(add.URLs) != 1 || add.URLs[0] != "http://example.org/a.torrent" {
+		t.Errorf("URLs = %v", add.URLs)
+	}
+	if add.Category != "tv" {
+		t.Errorf("Category = %q, want tv", add.Category)
+	}
+	// 请求体需要保留给后续转发
+	if rest, _ := io.ReadAll(r.Body); string(rest) != body {
+		t.Error("request body was not restored")
+	}
+}
+
+func TestCheckAddRequestTooLarge(t *testing.T) {
+	body := io.LimitReader(zeroReader{}, maxAddRequestBody+1)
+	r := httptest.NewRequest(http.MethodPost, "/api/v2/torrents/add", body)
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w := httptest.NewRecorder()
+	if checkAddRequest("alice", w, r) {
+		t.Fatal("oversized request was accepted")
+	}
+	if w.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("status = %d, want %d", w.Code, http.StatusRequestEntityTooLarge)
+	}
+}
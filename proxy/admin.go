@@ -0,0 +1,256 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/leganck/fn-qb-proxy/qbapi"
+	"github.com/sirupsen/logrus"
+)
+
+// adminMux 管理接口的路由，各功能模块在启动前注册自己的端点
+var adminMux = http.NewServeMux()
+
+func init() {
+	adminMux.HandleFunc("GET "+qbapi.TorrentsInfoPath, handleAdminTorrents)
+	adminMux.HandleFunc("GET "+qbapi.TransferInfoPath, handleAdminTransfer)
+}
+
+// startAdminServer 启动管理接口，addr 以 / 开头时监听 Unix Socket
+// TCP 监听可能被同一网络的任何人访问，必须设置令牌；
+// Unix Socket 只允许 root 访问，不沿用代理 Socket 的权限，否则能访问自己 qBittorrent 的用户也能管理所有实例
+func startAdminServer(ctx context.Context, addr, token string) error {
+	network := "tcp"
+	if strings.HasPrefix(addr, "/") {
+		network = "unix"
+		if err := os.Remove(addr); err != nil && !os.IsNotExist(err) {
+			logrus.Debugf("Failed to remove old socket file %s: %v", addr, err)
+		}
+	} else if token == "" {
+		return fmt.Errorf("admin API on %s requires --%s", addr, ADMIN_TOKEN)
+	}
+	listener, err := net.Listen(network, addr)
+	if err != nil {
+		return fmt.Errorf("admin listen: %w", err)
+	}
+	if network == "unix" {
+		if err := os.Chmod(addr, 0600); err != nil {
+			listener.Close()
+			return fmt.Errorf("admin socket permissions: %w", err)
+		}
+	}
+	if token == "" {
+		logrus.Warnf("Admin API on %s has no token, root has access to all instances through the socket", addr)
+	}
+
+	server := &http.Server{Handler: withAdminToken(token, adminMux)}
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		server.Shutdown(shutdownCtx)
+	}()
+	go func() {
+		logrus.Infof("Admin API listening on %s", addr)
+		if err := server.Serve(listener); err != nil && err != http.ErrServerClosed {
+			logrus.Errorf("Admin API server error: %v", err)
+		}
+	}()
+	return nil
+}
+
+// withAdminToken 校验 Authorization: Bearer <token>，不接受查询参数，避免令牌出现在日志与命令历史中
+func withAdminToken(token string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if token != "" {
+			got, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if !ok || subtle.ConstantTimeCompare([]byte(got), []byte(token)) != 1 {
+				http.Error(w, "Unauthorized", http.StatusUnauthorized)
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func writeAdminJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+// adminInstances 返回按用户名排序的实例，instance 参数（以 | 或 , 分隔）可以限定范围
+func adminInstances(r *http.Request) []*qbInstance {
+	list := listInstances()
+	if want := r.URL.Query().Get("instance"); want != "" {
+		names := strings.FieldsFunc(want, func(c rune) bool { return c == '|' || c == ',' })
+		list = slices.DeleteFunc(list, func(inst *qbInstance) bool { return !slices.Contains(names, inst.username) })
+	}
+	slices.SortFunc(list, func(a, b *qbInstance) int { return strings.Compare(a.username, b.username) })
+	return list
+}
+
+// fanOut 并发地对每个实例调用 fn，返回成功的结果（保持实例顺序）与失败的实例
+func fanOut[T any](ctx context.Context, list []*qbInstance, fn func(context.Context, *qbInstance) (T, error)) ([]T, []string) {
+	results := make([]T, len(list))
+	errs := make([]error, len(list))
+	var wg sync.WaitGroup
+	for i, inst := range list {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results[i], errs[i] = fn(ctx, inst)
+		}()
+	}
+	wg.Wait()
+
+	var ok []T
+	var failed []string
+	for i, err := range errs {
+		if err != nil {
+			logrus.Warnf("Admin API: request to user %s failed: %v", list[i].username, err)
+			failed = append(failed, list[i].username)
+			continue
+		}
+		ok = append(ok, results[i])
+	}
+	return ok, failed
+}
+
+// reportFailed 部分实例失败时通过响应头说明，全部失败时返回 502
+func reportFailed(w http.ResponseWriter, failed []string, total int) bool {
+	if len(failed) == 0 {
+		return true
+	}
+	w.Header().Set("X-Failed-Instances", strings.Join(failed, ","))
+	if len(failed) == total {
+		http.Error(w, "All instances failed", http.StatusBadGateway)
+		return false
+	}
+	return true
+}
+
+// handleAdminTorrents 合并所有实例的 torrents/info，过滤参数交给各实例，排序与分页在合并后进行
+func handleAdminTorrents(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	upstream := url.Values{}
+	for k, v := range query {
+		switch k {
+		case "sort", "reverse", "limit", "offset", "instance", "token":
+		default:
+			upstream[k] = v
+		}
+	}
+
+	list := adminInstances(r)
+	parts, failed := fanOut(r.Context(), list, func(ctx context.Context, inst *qbInstance) ([]map[string]any, error) {
+		var torrents []map[string]any
+		if err := inst.client.GetJSON(ctx, qbapi.TorrentsInfoPath, upstream, &torrents); err != nil {
+			return nil, err
+		}
+		for _, t := range torrents {
+			t["instance"] = inst.username
+		}
+		return torrents, nil
+	})
+	if !reportFailed(w, failed, len(list)) {
+		return
+	}
+
+	merged := []map[string]any{}
+	for _, p := range parts {
+		merged = append(merged, p...)
+	}
+	if key := query.Get("sort"); key != "" {
+		reverse := query.Get("reverse") == "true"
+		sort.SliceStable(merged, func(i, j int) bool {
+			if reverse {
+				return compareJSON(merged[j][key], merged[i][key]) < 0
+			}
+			return compareJSON(merged[i][key], merged[j][key]) < 0
+		})
+	}
+	writeAdminJSON(w, paginate(merged, query))
+}
+
+// paginate 按 qBittorrent 的语义处理 offset 与 limit，offset 为负数时从末尾计算
+func paginate[T any](list []T, query url.Values) []T {
+	if offset, err := strconv.Atoi(query.Get("offset")); err == nil {
+		if offset < 0 {
+			offset += len(list)
+		}
+		list = list[min(max(offset, 0), len(list)):]
+	}
+	if limit, err := strconv.Atoi(query.Get("limit")); err == nil && limit > 0 && limit < len(list) {
+		list = list[:limit]
+	}
+	return list
+}
+
+// compareJSON 比较 JSON 解码后的值，数字按数值、字符串按不区分大小写的字典序，缺失的值排在最前
+func compareJSON(a, b any) int {
+	switch x := a.(type) {
+	case float64:
+		if y, ok := b.(float64); ok {
+			switch {
+			case x < y:
+				return -1
+			case x > y:
+				return 1
+			}
+			return 0
+		}
+	case string:
+		if y, ok := b.(string); ok {
+			return strings.Compare(strings.ToLower(x), strings.ToLower(y))
+		}
+	case bool:
+		if y, ok := b.(bool); ok {
+			switch {
+			case x == y:
+				return 0
+			case !x:
+				return -1
+			}
+			return 1
+		}
+	}
+	switch {
+	case a == nil && b != nil:
+		return -1
+	case a != nil && b == nil:
+		return 1
+	}
+	return 0
+}
+
+// handleAdminTransfer 返回每个实例的 transfer/info，带有 instance 字段
+func handleAdminTransfer(w http.ResponseWriter, r *http.Request) {
+	list := adminInstances(r)
+	infos, failed := fanOut(r.Context(), list, func(ctx context.Context, inst *qbInstance) (map[string]any, error) {
+		var info map[string]any
+		if err := inst.client.GetJSON(ctx, qbapi.TransferInfoPath, nil, &info); err != nil {
+			return nil, err
+		}
+		info["instance"] = inst.username
+		return info, nil
+	})
+	if !reportFailed(w, failed, len(list)) {
+		return
+	}
+	if infos == nil {
+		infos = []map[string]any{}
+	}
+	writeAdminJSON(w, infos)
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestStartAdminServerRequiresToken(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	if err := startAdminServer(ctx, "127.0.0.1:0", ""); err == nil {
+		t.Error("TCP admin listener without a token was started")
+	}
+	if err := startAdminServer(ctx, "127.0.0.1:0", "secret"); err != nil {
+		t.Errorf("TCP admin listener with a token: %v", err)
+	}
+	sock := filepath.Join(t.TempDir(), "admin.sock")
+	if err := startAdminServer(ctx, sock, ""); err != nil {
+		t.Errorf("unix admin listener without a token: %v", err)
+	}
+	// 不沿用代理 Socket 的组权限
+	if fi, err := os.Stat(sock); err != nil || fi.Mode().Perm() != 0600 {
+		t.Errorf("admin socket mode = %v, %v, want 0600", fi.Mode().Perm(), err)
+	}
+}
+
+func TestWithAdminToken(t *testing.T) {
+	handler := withAdminToken("secret", http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+	tests := []struct {
+		auth  string
+		query string
+		want  int
+	}{
+		{want: http.StatusUnauthorized},
+		{auth: "Bearer wrong", want: http.StatusUnauthorized},
+		{auth: "Bearer secret", want: http.StatusOK},
+		// 令牌不能通过查询参数传递
+		{query: "?token=secret", want: http.StatusUnauthorized},
+		{auth: "secret", want: http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", "/admin/torrents"+tt.query, nil)
+		if tt.auth != "" {
+			r.Header.Set("Authorization", tt.auth)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		if w.Code != tt.want {
+			t.Errorf("auth %q query %q: status %d, want %d", tt.auth, tt.query, w.Code, tt.want)
+		}
+	}
+}
+
+func TestPaginate(t *testing.T) {
+	list := []int{0, 1, 2, 3, 4}
+	tests := []struct {
+		query string
+		want  []int
+	}{
+		{query: "", want: []int{0, 1, 2, 3, 4}},
+		{query: "limit=2", want: []int{0, 1}},
+		{query: "offset=3", want: []int{3, 4}},
+		{query: "offset=1&limit=2", want: []int{1, 2}},
+		{query: "offset=-2", want: []int{3, 4}},
+		{query: "offset=-10", want: []int{0, 1, 2, 3, 4}},
+		{query: "offset=10", want: []int{}},
+		{query: "limit=0", want: []int{0, 1, 2, 3, 4}},
+		{query: "limit=-1", want: []int{0, 1, 2, 3, 4}},
+		{query: "limit=10", want: []int{0, 1, 2, 3, 4}},
+		{query: "offset=x&limit=y", want: []int{0, 1, 2, 3, 4}},
+	}
+	for _, tt := range tests {
+		query, _ := url.ParseQuery(tt.query)
+		if got := paginate(list, query); !slices.Equal(got, tt.want) {
+			t.Errorf("paginate(%q) = %v, want %v", tt.query, got, tt.want)
+		}
+	}
+}
+
+func TestCompareJSON(t *testing.T) {
+	tests := []struct {
+		a, b any
+		want int
+	}{
+		{1.0, 2.0, -1},
+		{10.0, 2.0, 1},
+		{2.0, 2.0, 0},
+		{"abc", "ABD", -1},
+		{"Linux", "linux", 0},
+		{"b", "a", 1},
+		{false, true, -1},
+		{true, false, 1},
+		{true, true, 0},
+		{nil, 1.0, -1},
+		{"x", nil, 1},
+		{true, nil, 1},
+		{nil, false, -1},
+		{nil, nil, 0},
+		// 类型不同的值视为相等
+		{1.0, "1", 0},
+	}
+	for _, tt := range tests {
+		if got := compareJSON(tt.a, tt.b); got != tt.want {
+			t.Errorf("compareJSON(%v, %v) = %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
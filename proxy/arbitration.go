@@ -0,0 +1,317 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// 带宽分配方式
+const (
+	arbitrationFair     = "fair"
+	arbitrationWeighted = "weighted"
+)
+
+// ArbitrationConfig 全家共享带宽的分配配置
+// 启用后由代理接管各实例的全局限速，带宽计划中的 dl_limit/up_limit 作为该用户的上限
+type ArbitrationConfig struct {
+	Interval Duration           `json:"interval"`  // 调整间隔，默认 10s
+	TotalDl  ByteSize           `json:"total_dl"`  // 所有实例合计的下载预算（每秒字节数），0 表示不分配
+	TotalUp  ByteSize           `json:"total_up"`  // 所有实例合计的上传预算
+	Mode     string             `json:"mode"`      // fair 或 weighted，默认 fair
+	Weights  map[string]float64 `json:"weights"`   // weighted 模式下按用户的权重，未设置的用户为 1
+	MinShare ByteSize           `json:"min_share"` // 空闲实例的保底限速，保证新任务能够启动，默认 50KiB
+}
+
+func (c *ArbitrationConfig) validate() error {
+	switch c.Mode {
+	case "", arbitrationFair, arbitrationWeighted:
+	default:
+		return fmt.Errorf("unknown mode %q", c.Mode)
+	}
+	for user, w := range c.Weights {
+		if w <= 0 {
+			return fmt.Errorf("weight of %q must be positive", user)
+		}
+	}
+	return nil
+}
+
+func (c *ArbitrationConfig) weight(username string) float64 {
+	if c.Mode != arbitrationWeighted {
+		return 1
+	}
+	if w, ok := c.Weights[username]; ok {
+		return w
+	}
+	return 1
+}
+
+func (c *ArbitrationConfig) minShare() int64 {
+	if c.MinShare > 0 {
+		return int64(c.MinShare)
+	}
+	return 50 << 10
+}
+
+// bandwidthShare 分配中的一个实例，demand 与 max 为 0 表示不受限
+type bandwidthShare struct {
+	username string
+	weight   float64
+	demand   int64
+	max      int64 // 带宽计划的限速
+	limit    int64
+}
+
+// allocateBandwidth 按权重的最大最小公平分配（注水法）：
+// 需求低于公平份额的实例只分到需求，剩余预算在其余实例间按权重继续分配；
+// 所有需求都满足后仍有剩余时按权重分给所有实例，空闲实例开始下载时不必等下一轮调整
+func allocateBandwidth(total int64, shares []*bandwidthShare) {
+	// 取整后可能为 0，而 qBittorrent 将 0 视为不限速，分到的限速至少为 1
+	defer func() {
+		for _, s := range shares {
+			s.limit = max(s.limit, 1)
+		}
+	}()
+
+	remaining := total
+	pending := shares
+	for {
+		if len(pending) == 0 {
+			var sumWeight float64
+			for _, s := range shares {
+				sumWeight += s.weight
+			}
+			for _, s := range shares {
+				s.limit += int64(float64(remaining) / sumWeight * s.weight)
+				if s.max > 0 {
+					s.limit = min(s.limit, s.max)
+				}
+			}
+			return
+		}
+
+		var sumWeight float64
+		for _, s := range pending {
+			sumWeight += s.weight
+		}
+		perWeight := float64(remaining) / sumWeight
+
+		var next []*bandwidthShare
+		for _, s := range pending {
+			if s.demand > 0 && float64(s.demand) <= perWeight*s.weight {
+				s.limit = s.demand
+				remaining -= s.demand
+			} else {
+				next = append(next, s)
+			}
+		}
+		if len(next) == len(pending) {
+			for _, s := range next {
+				s.limit = int64(perWeight * s.weight)
+			}
+			return
+		}
+		pending = next
+	}
+}
+
+// estimateDemand 根据当前速度估算需求：接近当前限速说明被限制住了，视为需求不受限
+func estimateDemand(active bool, speed, limit, minShare int64) int64 {
+	if !active {
+		return minShare
+	}
+	if limit > 0 && speed >= limit*9/10 {
+		return 0
+	}
+	return max(speed*5/4, minShare)
+}
+
+// capDemand 带宽计划的限速作为需求上限，0 表示没有上限
+func capDemand(demand, limit int64) int64 {
+	if limit <= 0 || demand > 0 && demand <= limit {
+		return demand
+	}
+	return limit
+}
+
+// profileLimits 返回用户当前带宽方案中的限速，未设置时为 0
+func profileLimits(username string, now time.Time) (dl, up int64) {
+	cfg := currentConfig().Bandwidth
+	if cfg == nil {
+		return 0, 0
+	}
+	name := cfg.profileFor(username, now)
+	if name == "" {
+		return 0, 0
+	}
+	p := cfg.Profiles[name]
+	if p.DlLimit != nil {
+		dl = int64(*p.DlLimit)
+	}
+	if p.UpLimit != nil {
+		up = int64(*p.UpLimit)
+	}
+	return dl, up
+}
+
+// arbitratedLimits 已设置的限速，变化不大时不重复设置
+type arbitratedLimits struct {
+	inst   *qbInstance
+	dl, up int64
+}
+
+var (
+	arbitrated   = make(map[string]arbitratedLimits)
+	arbitratedMu sync.Mutex
+)
+
+// runArbitration 定期按各实例的活跃情况重新分配带宽预算
+func runArbitration(ctx context.Context) {
+	for {
+		cfg := currentConfig().Arbitration
+		interval := 10 * time.Second
+		if cfg != nil {
+			interval = cfg.Interval.Or(interval)
+			arbitrate(ctx, cfg)
+		} else {
+			releaseArbitration(ctx)
+		}
+
+		select {
+		case <-time.After(interval):
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func arbitrate(ctx context.Context, cfg *ArbitrationConfig) {
+	type usage struct {
+		inst               *qbInstance
+		dlActive, upActive bool
+		dlSpeed, upSpeed   int64
+		dlLimit, upLimit   int64
+	}
+	var usages []usage
+	for _, inst := range listInstances() {
+		state, err := inst.hub.Snapshot(ctx, syncInterval)
+		if err != nil {
+			logrus.Debugf("Arbitration: failed to get state for user %s: %v", inst.username, err)
+			continue
+		}
+		u := usage{
+			inst:    inst,
+			dlSpeed: serverStateInt(state, "dl_info_speed"),
+			upSpeed: serverStateInt(state, "up_info_speed"),
+			dlLimit: serverStateInt(state, "dl_rate_limit"),
+			upLimit: serverStateInt(state, "up_rate_limit"),
+		}
+		for _, t := range state.torrentList() {
+			switch t.State {
+			case "downloading", "forcedDL", "metaDL", "forcedMetaDL":
+				u.dlActive = true
+			case "uploading", "forcedUP":
+				u.upActive = true
+			}
+		}
+		usages = append(usages, u)
+	}
+	if len(usages) == 0 {
+		return
+	}
+
+	minShare := cfg.minShare()
+	now := time.Now()
+	dlShares := make([]*bandwidthShare, len(usages))
+	upShares := make([]*bandwidthShare, len(usages))
+	for i, u := range usages {
+		w := cfg.weight(u.inst.username)
+		dlCap, upCap := profileLimits(u.inst.username, now)
+		dlShares[i] = &bandwidthShare{username: u.inst.username, weight: w, max: dlCap,
+			demand: capDemand(estimateDemand(u.dlActive, u.dlSpeed, u.dlLimit, minShare), dlCap)}
+		upShares[i] = &bandwidthShare{username: u.inst.username, weight: w, max: upCap,
+			demand: capDemand(estimateDemand(u.upActive, u.upSpeed, u.upLimit, minShare), upCap)}
+	}
+	// 未设置预算的方向不做分配，limit 保持 -1
+	for i := range usages {
+		dlShares[i].limit, upShares[i].limit = -1, -1
+	}
+	if cfg.TotalDl > 0 {
+		allocateBandwidth(int64(cfg.TotalDl), dlShares)
+	}
+	if cfg.TotalUp > 0 {
+		allocateBandwidth(int64(cfg.TotalUp), upShares)
+	}
+
+	for i, u := range usages {
+		applyArbitratedLimits(ctx, u.inst, dlShares[i].limit, upShares[i].limit)
+	}
+}
+
+func serverStateInt(state *syncState, key string) int64 {
+	v, _ := state.ServerState[key].(float64)
+	return int64(v)
+}
+
+// applyArbitratedLimits 设置实例的全局限速，与上次相差不到 5% 时跳过，避免频繁抖动，负数表示不修改
+func applyArbitratedLimits(ctx context.Context, inst *qbInstance, dl, up int64) {
+	arbitratedMu.Lock()
+	prev, ok := arbitrated[inst.username]
+	arbitratedMu.Unlock()
+	if ok && prev.inst != inst {
+		ok = false
+	}
+
+	next := arbitratedLimits{inst: inst, dl: prev.dl, up: prev.up}
+	if dl >= 0 && (!ok || significantChange(prev.dl, dl)) {
+		if err := inst.client.SetGlobalDownloadLimit(ctx, dl); err != nil {
+			logrus.Warnf("Arbitration: failed to set download limit for user %s: %v", inst.username, err)
+		} else {
+			next.dl = dl
+		}
+	}
+	if up >= 0 && (!ok || significantChange(prev.up, up)) {
+		if err := inst.client.SetGlobalUploadLimit(ctx, up); err != nil {
+			logrus.Warnf("Arbitration: failed to set upload limit for user %s: %v", inst.username, err)
+		} else {
+			next.up = up
+		}
+	}
+	if next != prev {
+		logrus.Debugf("Arbitration: user %s limited to %s/s down, %s/s up", inst.username, humanSize(next.dl), humanSize(next.up))
+	}
+
+	arbitratedMu.Lock()
+	arbitrated[inst.username] = next
+	arbitratedMu.Unlock()
+}
+
+func significantChange(old, cur int64) bool {
+	diff := old - cur
+	if diff < 0 {
+		diff = -diff
+	}
+	return diff*20 > max(old, cur)
+}
+
+// releaseArbitration 关闭分配后取消限速，并让带宽计划重新应用当前方案
+func releaseArbitration(ctx context.Context) {
+	arbitratedMu.Lock()
+	released := arbitrated
+	arbitrated = make(map[string]arbitratedLimits)
+	arbitratedMu.Unlock()
+
+	for username, limits := range released {
+		if getInstance(username) != limits.inst {
+			continue
+		}
+		limits.inst.client.SetGlobalDownloadLimit(ctx, 0)
+		limits.inst.client.SetGlobalUploadLimit(ctx, 0)
+		forgetAppliedProfile(username)
+		logrus.Infof("Arbitration: released bandwidth limits for user %s", username)
+	}
+}
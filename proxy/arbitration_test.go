@@ -0,0 +1,109 @@
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestAllocateBandwidth(t *testing.T) {
+	tests := []struct {
+		name   string
+		total  int64
+		shares []bandwidthShare // 只需要 weight、demand 与 max
+		want   []int64
+	}{
+		{
+			name:   "equal unlimited demand",
+			total:  900,
+			shares: []bandwidthShare{{weight: 1}, {weight: 1}, {weight: 1}},
+			want:   []int64{300, 300, 300},
+		},
+		{
+			name:   "low demand is satisfied and the rest is shared",
+			total:  1000,
+			shares: []bandwidthShare{{weight: 1, demand: 100}, {weight: 1}, {weight: 1}},
+			want:   []int64{100, 450, 450},
+		},
+		{
+			name:   "weighted",
+			total:  900,
+			shares: []bandwidthShare{{weight: 2}, {weight: 1}},
+			want:   []int64{600, 300},
+		},
+		{
+			name:   "surplus is shared when all demand is met",
+			total:  1000,
+			shares: []bandwidthShare{{weight: 1, demand: 100}, {weight: 1, demand: 300}},
+			want:   []int64{400, 600},
+		},
+		{
+			name:   "surplus is capped by the profile limit",
+			total:  1000,
+			shares: []bandwidthShare{{weight: 1, demand: 100, max: 200}, {weight: 1, demand: 300}},
+			want:   []int64{200, 600},
+		},
+		{
+			name:   "demand above the fair share",
+			total:  1000,
+			shares: []bandwidthShare{{weight: 1, demand: 800}, {weight: 1, demand: 900}},
+			want:   []int64{500, 500},
+		},
+		// qBittorrent 将 0 视为不限速
+		{
+			name:   "tiny budget never rounds down to unlimited",
+			total:  2,
+			shares: []bandwidthShare{{weight: 1}, {weight: 1}, {weight: 1}},
+			want:   []int64{1, 1, 1},
+		},
+		{
+			name:   "tiny weight never rounds down to unlimited",
+			total:  100,
+			shares: []bandwidthShare{{weight: 1000}, {weight: 0.001}},
+			want:   []int64{99, 1},
+		},
+		{
+			name:   "tiny surplus",
+			total:  101,
+			shares: []bandwidthShare{{weight: 1000, demand: 100}, {weight: 0.001, demand: 0}},
+			want:   []int64{100, 1},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			shares := make([]*bandwidthShare, len(tt.shares))
+			for i := range tt.shares {
+				s := tt.shares[i]
+				s.limit = -1
+				shares[i] = &s
+			}
+			allocateBandwidth(tt.total, shares)
+			got := make([]int64, len(shares))
+			for i, s := range shares {
+				got[i] = s.limit
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("allocateBandwidth(%d) = %v, want %v", tt.total, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEstimateDemand(t *testing.T) {
+	tests := []struct {
+		active                 bool
+		speed, limit, minShare int64
+		want                   int64
+	}{
+		{active: false, speed: 0, limit: 1000, minShare: 50, want: 50},
+		{active: true, speed: 400, limit: 1000, minShare: 50, want: 500},
+		{active: true, speed: 10, limit: 1000, minShare: 50, want: 50},
+		// 接近当前限速视为需求不受限
+		{active: true, speed: 950, limit: 1000, minShare: 50, want: 0},
+		{active: true, speed: 950, limit: 0, minShare: 50, want: 1187},
+	}
+	for _, tt := range tests {
+		if got := estimateDemand(tt.active, tt.speed, tt.limit, tt.minShare); got != tt.want {
+			t.Errorf("estimateDemand(%v, %d, %d, %d) = %d, want %d", tt.active, tt.speed, tt.limit, tt.minShare, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const snapshotTimeFormat = "20060102-150405"
+
+// BackupConfig 定时备份配置
+// 目录结构：torrents/<用户>/<hash>.torrent 为所有快照共享的 .torrent 文件，
+// snapshots/<时间>/manifest.json 为每次备份的清单，可以直接用 restore -i 恢复
+type BackupConfig struct {
+	Dir        string   `json:"dir"`
+	Interval   Duration `json:"interval"`    // 备份间隔，默认 24h
+	Users      []string `json:"users"`       // 只备份这些用户，为空表示所有实例
+	KeepDaily  int      `json:"keep_daily"`  // 保留最近多少天每天最新的快照，默认 7
+	KeepWeekly int      `json:"keep_weekly"` // 保留最近多少周每周最新的快照，默认 4
+}
+
+func (c *BackupConfig) validate() error {
+	if c.Dir == "" {
+		return fmt.Errorf("dir is required")
+	}
+	return nil
+}
+
+func (c *BackupConfig) keep() (daily, weekly int) {
+	daily, weekly = 7, 4
+	if c.KeepDaily > 0 {
+		daily = c.KeepDaily
+	}
+	if c.KeepWeekly > 0 {
+		weekly = c.KeepWeekly
+	}
+	return daily, weekly
+}
+
+// runScheduledBackups 距离最近一次快照超过间隔时备份，重启后不会立即重复备份
+func runScheduledBackups(ctx context.Context) {
+	for {
+		wait := 10 * time.Minute
+		if cfg := currentConfig().Backup; cfg != nil {
+			interval := cfg.Interval.Or(24 * time.Hour)
+			snapshots, _ := listSnapshots(cfg.Dir)
+			switch {
+			case len(snapshots) > 0 && time.Since(snapshots[0]) < interval:
+				wait = min(wait, time.Until(snapshots[0].Add(interval)))
+			case len(listInstances()) == 0:
+				// 启动后尚未发现 qBittorrent 实例，稍后再试
+				wait = time.Minute
+			default:
+				if err := runBackup(ctx, cfg); err != nil {
+					logrus.Errorf("Scheduled backup failed: %v", err)
+				}
+				wait = min(wait, interval)
+			}
+		}
+
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// listSnapshots 返回已有快照的时间，从新到旧排列
+func listSnapshots(dir string) ([]time.Time, error) {
+	entries, err := os.ReadDir(filepath.Join(dir, "snapshots"))
+	if err != nil {
+		return nil, err
+	}
+	var list []time.Time
+	for _, e := range entries {
+		if t, err := time.ParseInLocation(snapshotTimeFormat, e.Name(), time.Local); err == nil && e.IsDir() {
+			list = append(list, t)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].After(list[j]) })
+	return list, nil
+}
+
+// runBackup 备份所有实例，只导出存储中还没有的 .torrent 文件
+func runBackup(ctx context.Context, cfg *BackupConfig) error {
+	// .torrent 文件与清单包含 passkey，之前版本以 0755 创建的备份目录同样收紧权限
+	if err := os.MkdirAll(cfg.Dir, 0700); err != nil {
+		return err
+	}
+	if err := os.Chmod(cfg.Dir, 0700); err != nil {
+		return err
+	}
+	now := time.Now()
+	manifest := backupManifest{Version: backupVersion, Created: now, Instances: make(map[string]*instanceBackup)}
+	exported := 0
+	for _, inst := range listInstances() {
+		if len(cfg.Users) > 0 && !slices.Contains(cfg.Users, inst.username) {
+			continue
+		}
+		b, err := collectInstanceBackup(ctx, inst.client)
+		if err != nil {
+			logrus.Warnf("Backup: skipped user %s: %v", inst.username, err)
+			continue
+		}
+		for i := range b.Torrents {
+			t := &b.Torrents[i]
+			file := fmt.Sprintf("torrents/%s/%s.torrent", inst.username, t.Hash)
+			path := filepath.Join(cfg.Dir, file)
+			if _, err := os.Stat(path); err != nil {
+				data, err := inst.client.Export(ctx, t.Hash)
+				if err != nil {
+					logrus.Debugf("Backup: failed to export %s of user %s, only the magnet link is kept: %v", t.Hash, inst.username, err)
+					continue
+				}
+				if err := writeFileAtomic(path, data); err != nil {
+					return err
+				}
+				exported++
+			}
+			t.File = file
+		}
+		manifest.Instances[inst.username] = b
+	}
+
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	snapshot := filepath.Join(cfg.Dir, "snapshots", now.Format(snapshotTimeFormat))
+	if err := writeFileAtomic(filepath.Join(snapshot, backupManifestName), data); err != nil {
+		return err
+	}
+
+	total := 0
+	for _, b := range manifest.Instances {
+		total += len(b.Torrents)
+	}
+	logrus.Infof("Backup snapshot %s: %d torrents of %d users, %d new torrent files", snapshot, total, len(manifest.Instances), exported)
+	return pruneBackups(cfg)
+}
+
+// writeFileAtomic 先写入临时文件再替换，文件与新建的目录只有 root 可以访问
+func writeFileAtomic(path string, data []byte) error {
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(dir, filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	_, err = f.Write(data)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
+
+// pruneBackups 按天与按周保留快照，删除其余快照及不再被引用的 .torrent 文件
+func pruneBackups(cfg *BackupConfig) error {
+	snapshots, err := listSnapshots(cfg.Dir)
+	if err != nil {
+		return err
+	}
+	keepDaily, keepWeekly := cfg.keep()
+	days := make(map[string]bool)
+	weeks := make(map[string]bool)
+	referenced := make(map[string]bool)
+	complete := true
+	for i, t := range snapshots {
+		day := t.Format("2006-01-02")
+		year, week := t.ISOWeek()
+		weekKey := fmt.Sprintf("%d-%d", year, week)
+
+		keep := i == 0
+		if !days[day] && len(days) < keepDaily {
+			days[day] = true
+			keep = true
+		}
+		if !weeks[weekKey] && len(weeks) < keepWeekly {
+			weeks[weekKey] = true
+			keep = true
+		}
+
+		dir := filepath.Join(cfg.Dir, "snapshots", t.Format(snapshotTimeFormat))
+		if !keep {
+			logrus.Infof("Backup: removing old snapshot %s", dir)
+			if err := os.RemoveAll(dir); err != nil {
+				logrus.Warnf("Backup: failed to remove %s: %v", dir, err)
+			}
+			continue
+		}
+		manifest, err := readSnapshotManifest(dir)
+		if err != nil {
+			logrus.Warnf("Backup: %v", err)
+			complete = false
+			continue
+		}
+		for _, b := range manifest.Instances {
+			for _, t := range b.Torrents {
+				referenced[t.File] = true
+			}
+		}
+	}
+
+	// 有清单无法读取时不清理 .torrent 文件，以免误删
+	if !complete {
+		return nil
+	}
+	return filepath.WalkDir(filepath.Join(cfg.Dir, "torrents"), func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return nil
+		}
+		rel, err := filepath.Rel(cfg.Dir, path)
+		if err == nil && !referenced[filepath.ToSlash(rel)] && strings.HasSuffix(rel, ".torrent") {
+			os.Remove(path)
+		}
+		return nil
+	})
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWriteFileAtomic(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "torrents", "alice", "abc.torrent")
+	if err := writeFileAtomic(path, []byte("first")); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeFileAtomic(path, []byte("second")); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := os.ReadFile(path); string(data) != "second" {
+		t.Errorf("content = %q, want second", data)
+	}
+	if fi, err := os.Stat(path); err != nil || fi.Mode().Perm() != 0600 {
+		t.Errorf("file mode = %v, %v, want 0600", fi.Mode().Perm(), err)
+	}
+	for _, d := range []string{filepath.Join(dir, "torrents"), filepath.Dir(path)} {
+		if fi, err := os.Stat(d); err != nil || fi.Mode().Perm() != 0700 {
+			t.Errorf("%s: mode = %v, %v, want 0700", d, fi.Mode().Perm(), err)
+		}
+	}
+	// 临时文件不应残留
+	if entries, _ := os.ReadDir(filepath.Dir(path)); len(entries) != 1 {
+		t.Errorf("directory contains %d entries, want 1", len(entries))
+	}
+}
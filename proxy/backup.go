@@ -0,0 +1,490 @@
+package main
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/leganck/fn-qb-proxy/qbapi"
+	"github.com/sirupsen/logrus"
+	"github.com/urfave/cli/v2"
+)
+
+const (
+	backupManifestName = "manifest.json"
+	backupVersion      = 1
+)
+
+// backupManifest 备份中的清单，记录每个实例的分类与种子
+type backupManifest struct {
+	Version   int                        `json:"version"`
+	Created   time.Time                  `json:"created"`
+	Instances map[string]*instanceBackup `json:"instances"`
+}
+
+// instanceBackup 单个实例的备份内容
+type instanceBackup struct {
+	Categories map[string]string `json:"categories"` // 分类名称 -> 保存路径
+	Torrents   []torrentBackup   `json:"torrents"`
+}
+
+// torrentBackup 单个种子的备份内容
+type torrentBackup struct {
+	Hash             string   `json:"hash"`
+	Name             string   `json:"name"`
+	Category         string   `json:"category,omitempty"`
+	Tags             []string `json:"tags,omitempty"`
+	SavePath         string   `json:"save_path"`
+	RatioLimit       float64  `json:"ratio_limit"`        // -2 使用全局设置，-1 不限制
+	SeedingTimeLimit int64    `json:"seeding_time_limit"` // 分钟，含义同上
+	Trackers         []string `json:"trackers,omitempty"`
+	Paused           bool     `json:"paused,omitempty"`
+	AddedOn          int64    `json:"added_on"`
+	Magnet           string   `json:"magnet,omitempty"`
+	// 备份中的 .torrent 文件，导出失败（如尚未获取元数据）时为空，恢复时改用磁力链接
+	File string `json:"file,omitempty"`
+}
+
+// collectInstanceBackup 读取实例的分类、种子及其 Tracker，不包含 .torrent 文件内容
+func collectInstanceBackup(ctx context.Context, client *qbapi.Client) (*instanceBackup, error) {
+	categories, err := client.Categories(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("get categories: %w", err)
+	}
+	torrents, err := client.Torrents(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("get torrents: %w", err)
+	}
+
+	b := &instanceBackup{Categories: make(map[string]string)}
+	for name, c := range categories {
+		b.Categories[name] = c.SavePath
+	}
+	for _, t := range torrents {
+		b.Torrents = append(b.Torrents, backupTorrent(ctx, client, t))
+	}
+	slices.SortFunc(b.Torrents, func(a, b torrentBackup) int { return strings.Compare(a.Hash, b.Hash) })
+	return b, nil
+}
+
+// backupTorrent 记录单个种子的设置与 Tracker
+func backupTorrent(ctx context.Context, client *qbapi.Client, t qbapi.Torrent) torrentBackup {
+	tb := torrentBackup{
+		Hash:             t.Hash,
+		Name:             t.Name,
+		Category:         t.Category,
+		SavePath:         t.SavePath,
+		RatioLimit:       t.RatioLimit,
+		SeedingTimeLimit: t.SeedingTimeLimit,
+		Paused:           t.IsPaused(),
+		AddedOn:          t.AddedOn,
+		Magnet:           t.MagnetURI,
+	}
+	for _, tag := range strings.Split(t.Tags, ",") {
+		if tag = strings.TrimSpace(tag); tag != "" {
+			tb.Tags = append(tb.Tags, tag)
+		}
+	}
+	if trackers, err := client.Trackers(ctx, t.Hash); err == nil {
+		for _, tr := range trackers {
+			if !tr.IsPseudo() && tr.URL != "" {
+				tb.Trackers = append(tb.Trackers, tr.URL)
+			}
+		}
+	}
+	return tb
+}
+
+// proxyClients 返回正在运行的 fn-qb-proxy 为各用户创建的代理 Socket 对应的客户端
+// 代理会自动注入密码，命令行工具无需知道 qBittorrent 的动态密码
+func proxyClients(dir string, users []string) (map[string]*qbapi.Client, error) {
+	socks, err := filepath.Glob(filepath.Join(dir, "*-qb-proxy.sock"))
+	if err != nil {
+		return nil, err
+	}
+	clients := make(map[string]*qbapi.Client)
+	for _, sock := range socks {
+		username := strings.TrimSuffix(filepath.Base(sock), "-qb-proxy.sock")
+		if len(users) == 0 || slices.Contains(users, username) {
+			clients[username] = qbapi.New(sock, "")
+		}
+	}
+	for _, u := range users {
+		if _, ok := clients[u]; !ok {
+			return nil, fmt.Errorf("no proxy socket for user %s in %s (is fn-qb-proxy running?)", u, dir)
+		}
+	}
+	if len(clients) == 0 {
+		return nil, fmt.Errorf("no proxy sockets found in %s (is fn-qb-proxy running?)", dir)
+	}
+	return clients, nil
+}
+
+// backupCommand 将各实例的种子导出到 tar.gz 归档
+func backupCommand(c *cli.Context) error {
+	clients, err := proxyClients(c.String(PROXY_SOCKET_DIR), c.StringSlice("user"))
+	if err != nil {
+		return err
+	}
+	output := c.String("output")
+	if output == "" {
+		output = fmt.Sprintf("fn-qb-backup-%s.tar.gz", time.Now().Format("20060102-150405"))
+	}
+
+	f, err := createPrivateFile(output)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	gz := gzip.NewWriter(f)
+	tw := tar.NewWriter(gz)
+
+	manifest := backupManifest{Version: backupVersion, Created: time.Now(), Instances: make(map[string]*instanceBackup)}
+	for username, client := range clients {
+		b, err := collectInstanceBackup(c.Context, client)
+		if err != nil {
+			return fmt.Errorf("backup user %s: %w", username, err)
+		}
+		for i := range b.Torrents {
+			t := &b.Torrents[i]
+			data, err := client.Export(c.Context, t.Hash)
+			if err != nil {
+				logrus.Warnf("[%s %d/%d] %s: export failed, only the magnet link is kept: %v", username, i+1, len(b.Torrents), t.Name, err)
+				continue
+			}
+			t.File = fmt.Sprintf("torrents/%s/%s.torrent", username, t.Hash)
+			if err := writeTarFile(tw, t.File, data); err != nil {
+				return err
+			}
+			logrus.Debugf("[%s %d/%d] %s: exported", username, i+1, len(b.Torrents), t.Name)
+		}
+		manifest.Instances[username] = b
+		logrus.Infof("Backed up %d torrents and %d categories of user %s", len(b.Torrents), len(b.Categories), username)
+	}
+
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := writeTarFile(tw, backupManifestName, data); err != nil {
+		return err
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	if err := gz.Close(); err != nil {
+		return err
+	}
+	logrus.Infof("Backup written to %s", output)
+	return f.Close()
+}
+
+// createPrivateFile 创建只有 root 可读写的文件，.torrent 文件与 tracker 地址包含 passkey；
+// 覆盖已有文件时同样收紧权限
+func createPrivateFile(path string) (*os.File, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return nil, err
+	}
+	if err := f.Chmod(0600); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return f, nil
+}
+
+func writeTarFile(tw *tar.Writer, name string, data []byte) error {
+	hdr := &tar.Header{Name: name, Mode: 0600, Size: int64(len(data)), ModTime: time.Now()}
+	if err := tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+	_, err := tw.Write(data)
+	return err
+}
+
+// readBackup 读取归档中的清单与所有文件，path 为目录时读取定时备份的快照
+func readBackup(path string) (*backupManifest, map[string][]byte, error) {
+	if info, err := os.Stat(path); err == nil && info.IsDir() {
+		return readSnapshot(path)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return nil, nil, fmt.Errorf("read %s: %w", path, err)
+	}
+
+	files := make(map[string][]byte)
+	tr := tar.NewReader(gz)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, nil, fmt.Errorf("read %s: %w", path, err)
+		}
+		data, err := io.ReadAll(tr)
+		if err != nil {
+			return nil, nil, fmt.Errorf("read %s: %w", hdr.Name, err)
+		}
+		files[hdr.Name] = data
+	}
+
+	var manifest backupManifest
+	data, ok := files[backupManifestName]
+	if !ok {
+		return nil, nil, fmt.Errorf("%s has no %s", path, backupManifestName)
+	}
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return nil, nil, fmt.Errorf("decode %s: %w", backupManifestName, err)
+	}
+	return &manifest, files, nil
+}
+
+// readSnapshot 读取快照目录中的清单，.torrent 文件位于备份目录（快照目录的上两级）的共享存储中
+func readSnapshot(dir string) (*backupManifest, map[string][]byte, error) {
+	manifest, err := readSnapshotManifest(dir)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	root := filepath.Join(dir, "..", "..")
+	files := make(map[string][]byte)
+	for _, b := range manifest.Instances {
+		for _, t := range b.Torrents {
+			if t.File == "" {
+				continue
+			}
+			if data, err := os.ReadFile(filepath.Join(root, filepath.FromSlash(t.File))); err == nil {
+				files[t.File] = data
+			}
+		}
+	}
+	return manifest, files, nil
+}
+
+func readSnapshotManifest(dir string) (*backupManifest, error) {
+	path := filepath.Join(dir, backupManifestName)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var manifest backupManifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return nil, fmt.Errorf("decode %s: %w", path, err)
+	}
+	return &manifest, nil
+}
+
+// pathMapper 按前缀替换保存路径，用于恢复到其它用户或其它磁盘
+type pathMapper [][2]string
+
+func parsePathMap(specs []string) (pathMapper, error) {
+	var m pathMapper
+	for _, spec := range specs {
+		from, to, ok := strings.Cut(spec, "=")
+		if !ok || from == "" {
+			return nil, fmt.Errorf("invalid path mapping %q, expected old=new", spec)
+		}
+		m = append(m, [2]string{strings.TrimSuffix(from, "/"), strings.TrimSuffix(to, "/")})
+	}
+	return m, nil
+}
+
+// apply 使用前缀最长的映射，与映射的先后顺序无关；只替换完整的路径段
+func (m pathMapper) apply(path string) string {
+	best := -1
+	for i, pair := range m {
+		if path == pair[0] || strings.HasPrefix(path, pair[0]+"/") {
+			if best < 0 || len(pair[0]) > len(m[best][0]) {
+				best = i
+			}
+		}
+	}
+	if best < 0 {
+		return path
+	}
+	return m[best][1] + strings.TrimPrefix(path, m[best][0])
+}
+
+// restoreOptions 恢复选项
+type restoreOptions struct {
+	skipChecking bool
+	paused       bool
+	dryRun       bool
+	paths        pathMapper
+}
+
+// restoreCommand 将归档中某个用户的种子恢复到（可能是其它用户的）实例
+func restoreCommand(c *cli.Context) error {
+	input := c.String("input")
+	if input == "" {
+		input = c.Args().First()
+	}
+	if input == "" {
+		return fmt.Errorf("missing backup archive, use --input")
+	}
+	manifest, files, err := readBackup(input)
+	if err != nil {
+		return err
+	}
+
+	from := c.String("from")
+	if from == "" {
+		if len(manifest.Instances) != 1 {
+			return fmt.Errorf("backup contains %d users, choose one with --from", len(manifest.Instances))
+		}
+		for username := range manifest.Instances {
+			from = username
+		}
+	}
+	b, ok := manifest.Instances[from]
+	if !ok {
+		return fmt.Errorf("backup has no user %s", from)
+	}
+	to := c.String("to")
+	if to == "" {
+		to = from
+	}
+	clients, err := proxyClients(c.String(PROXY_SOCKET_DIR), []string{to})
+	if err != nil {
+		return err
+	}
+	paths, err := parsePathMap(c.StringSlice("map-path"))
+	if err != nil {
+		return err
+	}
+	opts := restoreOptions{
+		skipChecking: c.Bool("skip-checking"),
+		paused:       c.Bool("paused"),
+		dryRun:       c.Bool("dry-run"),
+		paths:        paths,
+	}
+
+	logrus.Infof("Restoring %d torrents of user %s into the instance of user %s", len(b.Torrents), from, to)
+	added, skipped, failed := restoreInstance(c.Context, clients[to], b, files, opts, func(i int, t *torrentBackup, status string) {
+		logrus.Infof("[%d/%d] %s (%s): %s", i+1, len(b.Torrents), t.Name, t.Hash, status)
+	})
+	logrus.Infof("Restore finished: %d added, %d skipped, %d failed", added, skipped, failed)
+	if failed > 0 {
+		return fmt.Errorf("%d torrents failed to restore", failed)
+	}
+	return nil
+}
+
+// restoreInstance 恢复分类与种子，progress 报告每个种子的结果
+func restoreInstance(ctx context.Context, client *qbapi.Client, b *instanceBackup, files map[string][]byte,
+	opts restoreOptions, progress func(int, *torrentBackup, string)) (added, skipped, failed int) {
+	existingCategories, err := client.Categories(ctx)
+	if err != nil {
+		logrus.Warnf("Failed to get categories: %v", err)
+	}
+	for name, savePath := range b.Categories {
+		if _, ok := existingCategories[name]; ok || opts.dryRun {
+			continue
+		}
+		if err := client.CreateCategory(ctx, name, opts.paths.apply(savePath)); err != nil {
+			logrus.Warnf("Failed to create category %s: %v", name, err)
+		}
+	}
+
+	for i := range b.Torrents {
+		t := &b.Torrents[i]
+		status, err := restoreTorrent(ctx, client, t, files, opts)
+		switch {
+		case err != nil:
+			failed++
+			status = "failed: " + err.Error()
+		case status == "added":
+			added++
+		default:
+			skipped++
+		}
+		progress(i, t, status)
+	}
+	return added, skipped, failed
+}
+
+func restoreTorrent(ctx context.Context, client *qbapi.Client, t *torrentBackup, files map[string][]byte, opts restoreOptions) (string, error) {
+	if existing, err := client.Torrents(ctx, url.Values{"hashes": {t.Hash}}); err != nil {
+		return "", err
+	} else if len(existing) > 0 {
+		return "skipped (already exists)", nil
+	}
+
+	add := qbapi.AddOptions{
+		SavePath:     opts.paths.apply(t.SavePath),
+		Category:     t.Category,
+		Tags:         t.Tags,
+		Paused:       t.Paused || opts.paused,
+		SkipChecking: opts.skipChecking,
+	}
+	if data, ok := files[t.File]; ok && t.File != "" {
+		add.Files = []qbapi.TorrentFile{{Name: t.Hash + ".torrent", Data: data}}
+	} else if t.Magnet != "" {
+		add.URLs = []string{t.Magnet}
+	} else {
+		return "", fmt.Errorf("no torrent file or magnet link in backup")
+	}
+	if opts.dryRun {
+		return "skipped (dry run, would add to " + add.SavePath + ")", nil
+	}
+	if err := client.AddTorrent(ctx, add); err != nil {
+		return "", err
+	}
+
+	// 种子出现后再恢复分享限制与额外的 Tracker
+	if !waitForTorrent(ctx, client, t.Hash, 15*time.Second) {
+		logrus.Warnf("Torrent %s did not appear after adding, share limits and trackers not restored", t.Hash)
+		return "added", nil
+	}
+	if t.RatioLimit != -2 || t.SeedingTimeLimit != -2 {
+		if err := client.SetShareLimits(ctx, []string{t.Hash}, t.RatioLimit, t.SeedingTimeLimit); err != nil {
+			logrus.Warnf("Failed to restore share limits of %s: %v", t.Hash, err)
+		}
+	}
+	if len(t.Trackers) > 0 {
+		current, _ := client.Trackers(ctx, t.Hash)
+		var missing []string
+		for _, u := range t.Trackers {
+			if !slices.ContainsFunc(current, func(tr qbapi.Tracker) bool { return tr.URL == u }) {
+				missing = append(missing, u)
+			}
+		}
+		if len(missing) > 0 {
+			if err := client.AddTrackers(ctx, t.Hash, missing); err != nil {
+				logrus.Warnf("Failed to restore trackers of %s: %v", t.Hash, err)
+			}
+		}
+	}
+	return "added", nil
+}
+
+// waitForTorrent 等待种子出现在 qBittorrent 中
+func waitForTorrent(ctx context.Context, client *qbapi.Client, hash string, timeout time.Duration) bool {
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		if list, err := client.Torrents(ctx, url.Values{"hashes": {hash}}); err == nil && len(list) > 0 {
+			return true
+		}
+		select {
+		case <-time.After(500 * time.Millisecond):
+		case <-ctx.Done():
+			return false
+		}
+	}
+	return false
+}
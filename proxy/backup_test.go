@@ -0,0 +1,64 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestPathMapper(t *testing.T) {
+	// 顺序与前缀长度相反，结果仍按最长前缀匹配
+	m, err := parsePathMap([]string{"/=/mnt", "/vol1=/vol3", "/vol1/alice/=/vol2/bob"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		path string
+		want string
+	}{
+		{"/vol1/alice", "/vol2/bob"},
+		{"/vol1/alice/Downloads", "/vol2/bob/Downloads"},
+		// 只替换完整的路径段
+		{"/vol1/alicia/Downloads", "/vol3/alicia/Downloads"},
+		{"/vol10/x", "/mnt/vol10/x"},
+		{"/vol1", "/vol3"},
+		{"relative/path", "relative/path"},
+	}
+	for _, tt := range tests {
+		if got := m.apply(tt.path); got != tt.want {
+			t.Errorf("apply(%q) = %q, want %q", tt.path, got, tt.want)
+		}
+	}
+
+	for _, spec := range []string{"/vol1", "=/vol2"} {
+		if _, err := parsePathMap([]string{spec}); err == nil {
+			t.Errorf("parsePathMap(%q) was accepted", spec)
+		}
+	}
+	var empty pathMapper
+	if got := empty.apply("/vol1/x"); got != "/vol1/x" {
+		t.Errorf("empty mapper changed the path: %q", got)
+	}
+}
+
+func TestCreatePrivateFile(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"new.tar.gz", "existing.tar.gz"} {
+		path := filepath.Join(dir, name)
+		if name == "existing.tar.gz" {
+			os.WriteFile(path, []byte("old data"), 0644)
+		}
+		f, err := createPrivateFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		f.Close()
+		fi, err := os.Stat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if fi.Mode().Perm() != 0600 || fi.Size() != 0 {
+			t.Errorf("%s: mode = %v, size = %d, want 0600 and empty", name, fi.Mode().Perm(), fi.Size())
+		}
+	}
+}
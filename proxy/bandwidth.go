@@ -0,0 +1,259 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// BandwidthConfig 按周计划切换的带宽方案
+type BandwidthConfig struct {
+	Interval Duration                     `json:"interval"` // 检查间隔，默认 1m
+	Profiles map[string]BandwidthProfile  `json:"profiles"`
+	Schedule []BandwidthSlot              `json:"schedule"`
+	Default  string                       `json:"default"` // 不在任何时间段内时使用的方案，为空表示不修改
+	Users    map[string]BandwidthUserPlan `json:"users"`   // 按用户单独指定计划，未指定的用户使用上面的计划
+}
+
+// BandwidthUserPlan 单个用户的计划
+type BandwidthUserPlan struct {
+	Schedule []BandwidthSlot `json:"schedule"`
+	Default  string          `json:"default"`
+}
+
+// BandwidthProfile 带宽方案，未设置的字段保持 qBittorrent 当前设置
+type BandwidthProfile struct {
+	DlLimit            *ByteSize `json:"dl_limit"` // 全局下载限速（每秒字节数），0 表示不限速
+	UpLimit            *ByteSize `json:"up_limit"`
+	AltSpeed           *bool     `json:"alt_speed"` // 是否启用备用限速
+	MaxActiveDownloads *int      `json:"max_active_downloads"`
+	MaxActiveUploads   *int      `json:"max_active_uploads"`
+	MaxActiveTorrents  *int      `json:"max_active_torrents"`
+}
+
+// BandwidthSlot 每周的时间段，end 早于 start 表示跨越午夜，跨夜部分属于 start 所在的那天
+type BandwidthSlot struct {
+	Days    []string `json:"days"`  // mon、tue、wed、thu、fri、sat、sun，为空表示每天
+	Start   string   `json:"start"` // HH:MM
+	End     string   `json:"end"`
+	Profile string   `json:"profile"`
+}
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday, "mon": time.Monday, "tue": time.Tuesday, "wed": time.Wednesday,
+	"thu": time.Thursday, "fri": time.Friday, "sat": time.Saturday,
+}
+
+// parseClock 解析 HH:MM，返回自零点起的分钟数
+func parseClock(s string) (int, error) {
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time %q", s)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+func (s *BandwidthSlot) validate(profiles map[string]BandwidthProfile) error {
+	if _, ok := profiles[s.Profile]; !ok {
+		return fmt.Errorf("unknown profile %q", s.Profile)
+	}
+	for _, d := range s.Days {
+		if _, ok := weekdays[strings.ToLower(d)]; !ok {
+			return fmt.Errorf("invalid day %q", d)
+		}
+	}
+	if _, err := parseClock(s.Start); err != nil {
+		return err
+	}
+	_, err := parseClock(s.End)
+	return err
+}
+
+func (s *BandwidthSlot) onDay(day time.Weekday) bool {
+	if len(s.Days) == 0 {
+		return true
+	}
+	for _, d := range s.Days {
+		if weekdays[strings.ToLower(d)] == day {
+			return true
+		}
+	}
+	return false
+}
+
+// contains 判断时间是否落在时间段内
+func (s *BandwidthSlot) contains(now time.Time) bool {
+	start, _ := parseClock(s.Start)
+	end, _ := parseClock(s.End)
+	minute := now.Hour()*60 + now.Minute()
+	if start <= end {
+		return s.onDay(now.Weekday()) && minute >= start && minute < end
+	}
+	// 跨越午夜：当天 start 之后，或前一天开始的时间段在今天 end 之前
+	yesterday := now.AddDate(0, 0, -1).Weekday()
+	return s.onDay(now.Weekday()) && minute >= start || s.onDay(yesterday) && minute < end
+}
+
+func (c *BandwidthConfig) validate() error {
+	plans := map[string]BandwidthUserPlan{"": {Schedule: c.Schedule, Default: c.Default}}
+	for user, plan := range c.Users {
+		plans[user] = plan
+	}
+	for user, plan := range plans {
+		for i := range plan.Schedule {
+			if err := plan.Schedule[i].validate(c.Profiles); err != nil {
+				return fmt.Errorf("schedule %d of %q: %w", i, user, err)
+			}
+		}
+		if _, ok := c.Profiles[plan.Default]; plan.Default != "" && !ok {
+			return fmt.Errorf("unknown default profile %q for %q", plan.Default, user)
+		}
+	}
+	return nil
+}
+
+// profileFor 返回用户当前应使用的方案名称，为空表示不修改
+func (c *BandwidthConfig) profileFor(username string, now time.Time) string {
+	schedule, def := c.Schedule, c.Default
+	if plan, ok := c.Users[username]; ok {
+		schedule, def = plan.Schedule, plan.Default
+	}
+	for i := range schedule {
+		if schedule[i].contains(now) {
+			return schedule[i].Profile
+		}
+	}
+	return def
+}
+
+// appliedProfile 记录每个用户已应用的方案
+// qBittorrent 重启后实例对象会重新创建，此时需要重新应用
+type appliedProfile struct {
+	inst *qbInstance
+	key  string
+}
+
+var (
+	appliedProfiles   = make(map[string]appliedProfile)
+	appliedProfilesMu sync.Mutex
+)
+
+// runBandwidthScheduler 定期检查每个用户当前应使用的方案，发生变化时应用
+func runBandwidthScheduler(ctx context.Context) {
+	for {
+		cfg := currentConfig().Bandwidth
+		interval := time.Minute
+		if cfg != nil {
+			interval = cfg.Interval.Or(interval)
+			now := time.Now()
+			for _, inst := range listInstances() {
+				name := cfg.profileFor(inst.username, now)
+				if name == "" {
+					continue
+				}
+				applyBandwidthProfile(ctx, inst, name, cfg.Profiles[name])
+			}
+		}
+
+		select {
+		case <-time.After(interval):
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func applyBandwidthProfile(ctx context.Context, inst *qbInstance, name string, p BandwidthProfile) {
+	// 方案内容也计入，重新加载配置修改了方案后同样会重新应用
+	key := name + "/" + p.String()
+	appliedProfilesMu.Lock()
+	prev := appliedProfiles[inst.username]
+	appliedProfilesMu.Unlock()
+	if prev.inst == inst && prev.key == key {
+		return
+	}
+
+	if err := p.apply(ctx, inst); err != nil {
+		logrus.Errorf("Failed to apply bandwidth profile %s for user %s: %v", name, inst.username, err)
+		return
+	}
+	logrus.Infof("Applied bandwidth profile %s for user %s", name, inst.username)
+
+	appliedProfilesMu.Lock()
+	appliedProfiles[inst.username] = appliedProfile{inst: inst, key: key}
+	appliedProfilesMu.Unlock()
+}
+
+// forgetAppliedProfile 清除已应用的记录，下次检查时重新应用方案
+func forgetAppliedProfile(username string) {
+	appliedProfilesMu.Lock()
+	delete(appliedProfiles, username)
+	appliedProfilesMu.Unlock()
+}
+
+func (p BandwidthProfile) String() string {
+	var parts []string
+	if p.DlLimit != nil {
+		parts = append(parts, fmt.Sprintf("dl=%d", *p.DlLimit))
+	}
+	if p.UpLimit != nil {
+		parts = append(parts, fmt.Sprintf("up=%d", *p.UpLimit))
+	}
+	if p.AltSpeed != nil {
+		parts = append(parts, fmt.Sprintf("alt=%t", *p.AltSpeed))
+	}
+	if p.MaxActiveDownloads != nil {
+		parts = append(parts, fmt.Sprintf("downloads=%d", *p.MaxActiveDownloads))
+	}
+	if p.MaxActiveUploads != nil {
+		parts = append(parts, fmt.Sprintf("uploads=%d", *p.MaxActiveUploads))
+	}
+	if p.MaxActiveTorrents != nil {
+		parts = append(parts, fmt.Sprintf("torrents=%d", *p.MaxActiveTorrents))
+	}
+	return strings.Join(parts, ",")
+}
+
+// apply 通过 transfer 接口设置限速与备用限速，通过 setPreferences 设置队列
+// 启用带宽分配的方向由分配接管，方案中的限速只作为上限
+func (p BandwidthProfile) apply(ctx context.Context, inst *qbInstance) error {
+	arb := currentConfig().Arbitration
+	var errs []error
+	if p.DlLimit != nil && (arb == nil || arb.TotalDl <= 0) {
+		if err := inst.client.SetGlobalDownloadLimit(ctx, int64(*p.DlLimit)); err != nil {
+			errs = append(errs, fmt.Errorf("set download limit: %w", err))
+		}
+	}
+	if p.UpLimit != nil && (arb == nil || arb.TotalUp <= 0) {
+		if err := inst.client.SetGlobalUploadLimit(ctx, int64(*p.UpLimit)); err != nil {
+			errs = append(errs, fmt.Errorf("set upload limit: %w", err))
+		}
+	}
+	if p.AltSpeed != nil {
+		if err := inst.client.SetAltSpeed(ctx, *p.AltSpeed); err != nil {
+			errs = append(errs, fmt.Errorf("set alternative speed: %w", err))
+		}
+	}
+
+	prefs := make(map[string]any)
+	if p.MaxActiveDownloads != nil {
+		prefs["max_active_downloads"] = *p.MaxActiveDownloads
+	}
+	if p.MaxActiveUploads != nil {
+		prefs["max_active_uploads"] = *p.MaxActiveUploads
+	}
+	if p.MaxActiveTorrents != nil {
+		prefs["max_active_torrents"] = *p.MaxActiveTorrents
+	}
+	if len(prefs) > 0 {
+		if err := inst.client.SetPreferences(ctx, prefs); err != nil {
+			errs = append(errs, fmt.Errorf("set preferences: %w", err))
+		}
+	}
+	return errors.Join(errs...)
+}
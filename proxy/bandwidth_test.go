@@ -0,0 +1,88 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBandwidthSlotContains(t *testing.T) {
+	// 2024-01-01 为周一
+	at := func(day int, clock string) time.Time {
+		c, _ := time.Parse("15:04", clock)
+		return time.Date(2024, 1, day, c.Hour(), c.Minute(), 0, 0, time.Local)
+	}
+	tests := []struct {
+		name string
+		slot BandwidthSlot
+		now  time.Time
+		want bool
+	}{
+		{name: "inside", slot: BandwidthSlot{Start: "09:00", End: "18:00"}, now: at(1, "12:00"), want: true},
+		{name: "start is inclusive", slot: BandwidthSlot{Start: "09:00", End: "18:00"}, now: at(1, "09:00"), want: true},
+		{name: "end is exclusive", slot: BandwidthSlot{Start: "09:00", End: "18:00"}, now: at(1, "18:00"), want: false},
+		{name: "before", slot: BandwidthSlot{Start: "09:00", End: "18:00"}, now: at(1, "08:59"), want: false},
+		{name: "listed day", slot: BandwidthSlot{Days: []string{"Mon", "tue"}, Start: "09:00", End: "18:00"}, now: at(2, "10:00"), want: true},
+		{name: "other day", slot: BandwidthSlot{Days: []string{"sat", "sun"}, Start: "09:00", End: "18:00"}, now: at(1, "10:00"), want: false},
+		{name: "overnight before midnight", slot: BandwidthSlot{Days: []string{"fri"}, Start: "23:00", End: "07:00"}, now: at(5, "23:30"), want: true},
+		// 跨夜部分属于 start 所在的那天
+		{name: "overnight after midnight", slot: BandwidthSlot{Days: []string{"fri"}, Start: "23:00", End: "07:00"}, now: at(6, "06:59"), want: true},
+		{name: "overnight after midnight of another day", slot: BandwidthSlot{Days: []string{"fri"}, Start: "23:00", End: "07:00"}, now: at(5, "06:00"), want: false},
+		{name: "overnight end", slot: BandwidthSlot{Days: []string{"fri"}, Start: "23:00", End: "07:00"}, now: at(6, "07:00"), want: false},
+		{name: "overnight across week", slot: BandwidthSlot{Days: []string{"sun"}, Start: "22:00", End: "02:00"}, now: at(8, "01:00"), want: true},
+		{name: "overnight every day", slot: BandwidthSlot{Start: "22:00", End: "02:00"}, now: at(3, "12:00"), want: false},
+	}
+	for _, tt := range tests {
+		if got := tt.slot.contains(tt.now); got != tt.want {
+			t.Errorf("%s: contains(%s) = %v, want %v", tt.name, tt.now.Format("Mon 15:04"), got, tt.want)
+		}
+	}
+}
+
+func TestBandwidthProfileFor(t *testing.T) {
+	cfg := &BandwidthConfig{
+		Profiles: map[string]BandwidthProfile{"day": {}, "night": {}, "weekend": {}},
+		Schedule: []BandwidthSlot{
+			{Days: []string{"sat", "sun"}, Start: "00:00", End: "23:59", Profile: "weekend"},
+			{Start: "08:00", End: "23:00", Profile: "day"},
+		},
+		Default: "night",
+		Users: map[string]BandwidthUserPlan{
+			"bob": {Schedule: []BandwidthSlot{{Start: "01:00", End: "02:00", Profile: "night"}}},
+		},
+	}
+	if err := cfg.validate(); err != nil {
+		t.Fatal(err)
+	}
+	monday := time.Date(2024, 1, 1, 12, 0, 0, 0, time.Local)
+	saturday := time.Date(2024, 1, 6, 12, 0, 0, 0, time.Local)
+	tests := []struct {
+		user string
+		now  time.Time
+		want string
+	}{
+		{"alice", monday, "day"},
+		{"alice", monday.Add(12 * time.Hour), "night"},
+		// 按顺序使用第一个匹配的时间段
+		{"alice", saturday, "weekend"},
+		// 单独指定计划的用户不使用全局计划与默认方案
+		{"bob", monday, ""},
+		{"bob", monday.Add(-10*time.Hour - 30*time.Minute), "night"},
+	}
+	for _, tt := range tests {
+		if got := cfg.profileFor(tt.user, tt.now); got != tt.want {
+			t.Errorf("profileFor(%s, %s) = %q, want %q", tt.user, tt.now.Format("Mon 15:04"), got, tt.want)
+		}
+	}
+
+	bad := []BandwidthConfig{
+		{Profiles: cfg.Profiles, Schedule: []BandwidthSlot{{Start: "08:00", End: "09:00", Profile: "missing"}}},
+		{Profiles: cfg.Profiles, Schedule: []BandwidthSlot{{Start: "8", End: "09:00", Profile: "day"}}},
+		{Profiles: cfg.Profiles, Schedule: []BandwidthSlot{{Days: []string{"monday"}, Start: "08:00", End: "09:00", Profile: "day"}}},
+		{Profiles: cfg.Profiles, Default: "missing"},
+	}
+	for i := range bad {
+		if err := bad[i].validate(); err == nil {
+			t.Errorf("invalid config %d was accepted", i)
+		}
+	}
+}
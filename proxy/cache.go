@@ -0,0 +1,228 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/leganck/fn-qb-proxy/ratelimit"
+	"github.com/sirupsen/logrus"
+)
+
+const mainDataAPIPath = "/api/v2/sync/maindata"
+
+// cacheTTL 只读接口响应的缓存时间，0 表示不缓存
+var cacheTTL = time.Second
+
+// maxCachedBody 单个响应允许缓存的最大字节数
+const maxCachedBody = 4 << 20
+
+// sessionTTL 已确认有效的 SID 在该时间内无需再经过 qBittorrent 校验
+const sessionTTL = 10 * time.Minute
+
+// sessionSet 记录经 qBittorrent 确认有效的 SID
+// 只有有效会话的请求才会由代理直接应答，其余请求交给 qBittorrent 处理鉴权
+type sessionSet struct {
+	mu   sync.Mutex
+	seen map[string]time.Time
+}
+
+func newSessionSet() *sessionSet {
+	return &sessionSet{seen: make(map[string]time.Time)}
+}
+
+func (s *sessionSet) valid(sid string) bool {
+	if sid == "" {
+		return false
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	t, ok := s.seen[sid]
+	return ok && time.Since(t) < sessionTTL
+}
+
+func (s *sessionSet) mark(sid string, ok bool) {
+	if sid == "" {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if ok {
+		s.seen[sid] = time.Now()
+	} else {
+		delete(s.seen, sid)
+	}
+}
+
+type cachedResponse struct {
+	header  http.Header
+	body    []byte
+	expires time.Time
+}
+
+// responseCache 只读接口的短时响应缓存
+type responseCache struct {
+	mu      sync.Mutex
+	entries map[string]cachedResponse
+}
+
+func newResponseCache() *responseCache {
+	return &responseCache{entries: make(map[string]cachedResponse)}
+}
+
+func (c *responseCache) get(key string) (cachedResponse, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry, ok := c.entries[key]
+	if !ok || time.Now().After(entry.expires) {
+		delete(c.entries, key)
+		return cachedResponse{}, false
+	}
+	return entry, true
+}
+
+func (c *responseCache) put(key string, entry cachedResponse) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := time.Now()
+	for k, e := range c.entries {
+		if now.After(e.expires) {
+			delete(c.entries, k)
+		}
+	}
+	c.entries[key] = entry
+}
+
+func (c *responseCache) clear() {
+	c.mu.Lock()
+	c.entries = make(map[string]cachedResponse)
+	c.mu.Unlock()
+}
+
+// captureWriter 在写出响应的同时记录状态码与响应体
+type captureWriter struct {
+	http.ResponseWriter
+	status int
+	buf    bytes.Buffer
+	over   bool
+}
+
+func (cw *captureWriter) WriteHeader(code int) {
+	cw.status = code
+	cw.ResponseWriter.WriteHeader(code)
+}
+
+func (cw *captureWriter) Write(p []byte) (int, error) {
+	if cw.status == 0 {
+		cw.status = http.StatusOK
+	}
+	if !cw.over {
+		if cw.buf.Len()+len(p) > maxCachedBody {
+			cw.over = true
+			cw.buf.Reset()
+		} else {
+			cw.buf.Write(p)
+		}
+	}
+	return cw.ResponseWriter.Write(p)
+}
+
+func (cw *captureWriter) Flush() {
+	if f, ok := cw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func requestSID(r *http.Request) string {
+	if cookie, err := r.Cookie("SID"); err == nil {
+		return cookie.Value
+	}
+	return ""
+}
+
+// serveCached 处理 sync/maindata 合并与只读接口缓存，请求未被处理时交给 next
+func serveCached(inst *qbInstance, w http.ResponseWriter, r *http.Request, next http.Handler) {
+	sid := requestSID(r)
+	path := strings.TrimSuffix(r.URL.Path, "/")
+
+	if inst.sessions.valid(sid) {
+		if syncInterval > 0 && path == mainDataAPIPath {
+			rid, _ := strconv.ParseInt(r.FormValue("rid"), 10, 64)
+			data, err := inst.hub.MainData(r.Context(), rid)
+			if err != nil {
+				logrus.Warnf("Sync hub for user %s failed: %v", inst.username, err)
+				http.Error(w, "Failed to fetch maindata", http.StatusBadGateway)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			w.Write(data)
+			return
+		}
+
+		if cacheTTL > 0 && ratelimit.IsReadOnly(path) {
+			body, err := io.ReadAll(r.Body)
+			if err != nil {
+				http.Error(w, "Failed to read request", http.StatusBadRequest)
+				return
+			}
+			r.Body = io.NopCloser(bytes.NewReader(body))
+
+			key := path + "?" + r.URL.RawQuery + "\x00" + string(body) + "\x00" + r.Header.Get("Accept-Encoding")
+			if entry, ok := inst.cache.get(key); ok {
+				for k, v := range entry.header {
+					w.Header()[k] = v
+				}
+				w.Write(entry.body)
+				return
+			}
+
+			cw := &captureWriter{ResponseWriter: w}
+			next.ServeHTTP(cw, r)
+			inst.sessions.mark(sid, cw.status != http.StatusForbidden)
+			if cw.status == http.StatusOK && !cw.over {
+				header := make(http.Header)
+				for _, k := range []string{"Content-Type", "Content-Encoding"} {
+					if v := w.Header().Get(k); v != "" {
+						header.Set(k, v)
+					}
+				}
+				inst.cache.put(key, cachedResponse{header: header, body: cw.buf.Bytes(), expires: time.Now().Add(cacheTTL)})
+			}
+			return
+		}
+	}
+
+	cw := &captureWriter{ResponseWriter: w, over: true}
+	next.ServeHTTP(cw, r)
+
+	// 根据 qBittorrent 的响应学习会话是否有效
+	switch {
+	case cw.status == http.StatusForbidden:
+		inst.sessions.mark(sid, false)
+	case strings.HasPrefix(path, loginAPIPath):
+		for _, cookie := range (&http.Response{Header: w.Header()}).Cookies() {
+			if cookie.Name == "SID" {
+				inst.sessions.mark(cookie.Value, cw.status == http.StatusOK)
+			}
+		}
+	case cw.status == http.StatusOK:
+		inst.sessions.mark(sid, true)
+	}
+
+	// 修改类请求后清空缓存，保证客户端能立即看到变化
+	if isMutation(path) {
+		inst.cache.clear()
+		inst.hub.invalidate()
+	}
+}
+
+// isMutation 判断请求是否可能修改 qBittorrent 状态
+func isMutation(path string) bool {
+	return !ratelimit.IsReadOnly(path) &&
+		!strings.HasPrefix(path, "/api/v2/sync/") &&
+		!strings.HasPrefix(path, "/api/v2/auth/")
+}
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/leganck/fn-qb-proxy/sigctx"
+	"github.com/sirupsen/logrus"
+)
+
+// Duration 支持在 JSON 中以 "10s"、"1h30m" 形式书写的时间间隔
+type Duration time.Duration
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		var n float64
+		if err := json.Unmarshal(data, &n); err != nil {
+			return fmt.Errorf("invalid duration %s", data)
+		}
+		*d = Duration(time.Duration(n) * time.Second)
+		return nil
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return fmt.Errorf("invalid duration %q: %w", s, err)
+	}
+	*d = Duration(v)
+	return nil
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// Or 返回 d，为 0 时返回默认值
+func (d Duration) Or(def time.Duration) time.Duration {
+	if d <= 0 {
+		return def
+	}
+	return time.Duration(d)
+}
+
+// ByteSize 支持在 JSON 中以 "10GiB"、"500MB" 或字节数书写的大小
+type ByteSize int64
+
+var byteUnits = map[string]float64{
+	"": 1, "B": 1,
+	"K": 1 << 10, "KB": 1e3, "KIB": 1 << 10,
+	"M": 1 << 20, "MB": 1e6, "MIB": 1 << 20,
+	"G": 1 << 30, "GB": 1e9, "GIB": 1 << 30,
+	"T": 1 << 40, "TB": 1e12, "TIB": 1 << 40,
+}
+
+func (b *ByteSize) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		var n float64
+		if err := json.Unmarshal(data, &n); err != nil {
+			return fmt.Errorf("invalid size %s", data)
+		}
+		*b = ByteSize(n)
+		return nil
+	}
+	s = strings.TrimSpace(s)
+	i := strings.IndexFunc(s, func(r rune) bool { return (r < '0' || r > '9') && r != '.' && r != '-' })
+	if i < 0 {
+		i = len(s)
+	}
+	n, err := strconv.ParseFloat(s[:i], 64)
+	unit, ok := byteUnits[strings.ToUpper(strings.TrimSpace(s[i:]))]
+	if err != nil || !ok {
+		return fmt.Errorf("invalid size %q", s)
+	}
+	*b = ByteSize(n * unit)
+	return nil
+}
+
+// Config fn-qb-proxy 的配置文件（JSON），各功能模块的配置均为可选
+type Config struct {
+	Watch       *WatchConfig       `json:"watch,omitempty"`
+	Events      *EventsConfig      `json:"events,omitempty"`
+	Hooks       *HooksConfig       `json:"hooks,omitempty"`
+	Notify      *NotifyConfig      `json:"notify,omitempty"`
+	Rules       []TorrentRule      `json:"rules,omitempty"`
+	Seeding     *SeedingConfig     `json:"seeding,omitempty"`
+	DiskGuard   *DiskGuardConfig   `json:"disk_guard,omitempty"`
+	Bandwidth   *BandwidthConfig   `json:"bandwidth,omitempty"`
+	Arbitration *ArbitrationConfig `json:"arbitration,omitempty"`
+	Quotas      *QuotaConfig       `json:"quotas,omitempty"`
+	Duplicates  *DuplicatesConfig  `json:"duplicates,omitempty"`
+	Backup      *BackupConfig      `json:"backup,omitempty"`
+	Orphans     *OrphansConfig     `json:"orphans,omitempty"`
+	Library     *LibraryConfig     `json:"library,omitempty"`
+	Trackers    *TrackersConfig    `json:"trackers,omitempty"`
+	Watchdog    *WatchdogConfig    `json:"watchdog,omitempty"`
+	RSS         *RSSConfig         `json:"rss,omitempty"`
+}
+
+// validate 检查配置并预先编译其中的正则表达式等
+func (c *Config) validate() error {
+	for i := range c.Rules {
+		if err := c.Rules[i].compile(); err != nil {
+			return fmt.Errorf("rule %d: %w", i, err)
+		}
+	}
+	if c.Bandwidth != nil {
+		if err := c.Bandwidth.validate(); err != nil {
+			return fmt.Errorf("bandwidth: %w", err)
+		}
+	}
+	if c.Arbitration != nil {
+		if err := c.Arbitration.validate(); err != nil {
+			return fmt.Errorf("arbitration: %w", err)
+		}
+	}
+	if c.Quotas != nil {
+		if err := c.Quotas.validate(); err != nil {
+			return fmt.Errorf("quotas: %w", err)
+		}
+	}
+	if c.Duplicates != nil {
+		if err := c.Duplicates.validate(); err != nil {
+			return fmt.Errorf("duplicates: %w", err)
+		}
+	}
+	if c.Backup != nil {
+		if err := c.Backup.validate(); err != nil {
+			return fmt.Errorf("backup: %w", err)
+		}
+	}
+	if c.Watchdog != nil {
+		if err := c.Watchdog.validate(); err != nil {
+			return fmt.Errorf("watchdog: %w", err)
+		}
+	}
+	if c.Trackers != nil {
+		if err := c.Trackers.validate(); err != nil {
+			return fmt.Errorf("trackers: %w", err)
+		}
+	}
+	if c.Library != nil {
+		if err := c.Library.validate(); err != nil {
+			return fmt.Errorf("library: %w", err)
+		}
+	}
+	if c.RSS != nil {
+		if err := c.RSS.validate(); err != nil {
+			return fmt.Errorf("rss: %w", err)
+		}
+	}
+	if c.Orphans != nil {
+		if err := c.Orphans.validate(); err != nil {
+			return fmt.Errorf("orphans: %w", err)
+		}
+	}
+	if c.Seeding != nil {
+		if err := c.Seeding.validate(); err != nil {
+			return fmt.Errorf("seeding: %w", err)
+		}
+	}
+	return nil
+}
+
+var (
+	configPath string
+	config     atomic.Pointer[Config]
+)
+
+// currentConfig 返回当前生效的配置，未指定配置文件时返回空配置
+func currentConfig() *Config {
+	if c := config.Load(); c != nil {
+		return c
+	}
+	return &Config{}
+}
+
+// loadConfig 读取并解析配置文件
+func loadConfig(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read config: %w", err)
+	}
+	cfg := &Config{}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(cfg); err != nil {
+		return nil, fmt.Errorf("parse config %s: %w", path, err)
+	}
+	if err := cfg.validate(); err != nil {
+		return nil, fmt.Errorf("invalid config %s: %w", path, err)
+	}
+	return cfg, nil
+}
+
+// initConfig 加载配置文件并在收到 SIGHUP 时重新加载
+func initConfig(ctx context.Context, path string) error {
+	configPath = path
+	if path == "" {
+		return nil
+	}
+	cfg, err := loadConfig(path)
+	if err != nil {
+		return err
+	}
+	config.Store(cfg)
+	logrus.Infof("Loaded config from %s", path)
+
+	reload := sigctx.NotifyReload()
+	go func() {
+		for {
+			select {
+			case <-reload:
+				cfg, err := loadConfig(configPath)
+				if err != nil {
+					logrus.Errorf("Failed to reload config, keeping previous one: %v", err)
+					continue
+				}
+				config.Store(cfg)
+				logrus.Infof("Reloaded config from %s", configPath)
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return nil
+}
@@ -3,6 +3,7 @@ package main
 import (
 	"context"
 	"fmt"
+	"os"
 	"os/exec"
 	"regexp"
 	"strings"
@@ -15,6 +16,7 @@ import (
 type UserCredentials struct {
 	Password string // 密码
 	SockPath string // Socket文件位置
+	PID      string // 进程号，qBittorrent 以相同参数重启时也能识别为新实例
 }
 
 var (
@@ -100,39 +102,50 @@ func doFindQbUser() error {
 		newCredentials[username] = UserCredentials{
 			Password: pwd,
 			SockPath: sockPath,
+			PID:      pid,
 		}
 		logrus.Debugf("Extracted credentials for user: %s (PID: %s, Socket: %s)", username, pid, sockPath)
 		found = true
 	}
 
+	// Socket 尚未就绪的用户不保存凭据，下一轮检查时作为新用户重新添加；
+	// 凭据在发送事件之前保存，添加代理时读取到的是新的凭据
+	var events []UserEvent
 	for username, oldCred := range oldCredentials {
 		newCred, exists := newCredentials[username]
 		if !exists {
 			logrus.Debugf("User %s removed, sending remove event", username)
-			sendUserEvent(eventTypeRemove, username)
+			events = append(events, UserEvent{EventType: eventTypeRemove, Username: username})
 		} else if oldCred != newCred {
-			// 检查新的 sock 文件是否存在
-			if _, err := exec.Command("test", "-S", newCred.SockPath).CombinedOutput(); err != nil {
+			events = append(events, UserEvent{EventType: eventTypeRemove, Username: username})
+			if !socketReady(newCred.SockPath) {
 				logrus.Debugf("User %s credentials changed but new sock %s not ready, only removing", username, newCred.SockPath)
-				sendUserEvent(eventTypeRemove, username)
+				delete(newCredentials, username)
 			} else {
 				logrus.Debugf("User %s credentials changed, removing and re-adding", username)
-				sendUserEvent(eventTypeRemove, username)
-				sendUserEvent(eventTypeAdd, username)
+				events = append(events, UserEvent{EventType: eventTypeAdd, Username: username})
 			}
 		}
 	}
 
-	for username := range newCredentials {
+	for username, newCred := range newCredentials {
 		if _, exists := oldCredentials[username]; !exists {
+			if !socketReady(newCred.SockPath) {
+				logrus.Debugf("User %s found but sock %s not ready, will retry", username, newCred.SockPath)
+				delete(newCredentials, username)
+				continue
+			}
 			logrus.Debugf("User %s added, sending add event", username)
-			sendUserEvent(eventTypeAdd, username)
+			events = append(events, UserEvent{EventType: eventTypeAdd, Username: username})
 		}
 	}
 
 	credsMutex.Lock()
 	credentials = newCredentials
 	credsMutex.Unlock()
+	for _, ev := range events {
+		sendUserEvent(ev.EventType, ev.Username)
+	}
 
 	if !found {
 		return fmt.Errorf("no valid qbittorrent-nox processes with required parameters found")
@@ -140,6 +153,12 @@ func doFindQbUser() error {
 	return nil
 }
 
+// socketReady 判断 qBittorrent 的 Unix Socket 是否已创建
+func socketReady(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && info.Mode()&os.ModeSocket != 0
+}
+
 func findQbUser(ctx context.Context) {
 	logrus.Info("Starting qb user finder...")
 
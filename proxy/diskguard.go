@@ -0,0 +1,227 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/leganck/fn-qb-proxy/qbapi"
+	"github.com/sirupsen/logrus"
+)
+
+// DiskGuardConfig 磁盘空间保护配置
+type DiskGuardConfig struct {
+	Interval       Duration `json:"interval"`         // 检查间隔，默认 1m
+	MinFree        ByteSize `json:"min_free"`         // 可用空间低于该值时暂停下载
+	MinFreePercent float64  `json:"min_free_percent"` // 可用空间占比低于该值时暂停下载
+	// 恢复阈值，默认为暂停阈值的 1.1 倍，避免在阈值附近反复暂停与恢复
+	ResumeFree        ByteSize `json:"resume_free"`
+	ResumeFreePercent float64  `json:"resume_free_percent"`
+	RejectAdds        bool     `json:"reject_adds"` // 空间不足时拒绝经过代理的添加请求
+	Tag               string   `json:"tag"`         // 标记由保护暂停的种子，恢复时只继续这些种子，默认 disk-guard
+}
+
+func (c *DiskGuardConfig) tag() string {
+	if c.Tag != "" {
+		return c.Tag
+	}
+	return "disk-guard"
+}
+
+// diskSpace 某个路径所在磁盘的空间，total 为 0 表示只知道可用空间
+type diskSpace struct {
+	path  string
+	free  uint64
+	total uint64
+}
+
+func (d diskSpace) String() string {
+	if d.total == 0 {
+		return fmt.Sprintf("%s free on %s", humanSize(int64(d.free)), d.path)
+	}
+	return fmt.Sprintf("%s (%.1f%%) free on %s", humanSize(int64(d.free)), d.percent(), d.path)
+}
+
+func (d diskSpace) percent() float64 {
+	return float64(d.free) / float64(d.total) * 100
+}
+
+// below 判断空间是否低于阈值
+func (d diskSpace) below(minFree ByteSize, minPercent float64) bool {
+	if minFree > 0 && d.free < uint64(minFree) {
+		return true
+	}
+	return minPercent > 0 && d.total > 0 && d.percent() < minPercent
+}
+
+func (c *DiskGuardConfig) low(d diskSpace) bool {
+	return d.below(c.MinFree, c.MinFreePercent)
+}
+
+func (c *DiskGuardConfig) recovered(d diskSpace) bool {
+	resumeFree, resumePercent := c.ResumeFree, c.ResumeFreePercent
+	if resumeFree <= 0 {
+		resumeFree = c.MinFree * 11 / 10
+	}
+	if resumePercent <= 0 {
+		resumePercent = c.MinFreePercent * 1.1
+	}
+	return !d.below(resumeFree, resumePercent)
+}
+
+// lowSpaceUsers 最近一次检查中空间不足的用户及原因，用于拒绝添加请求
+var (
+	lowSpaceUsers   = make(map[string]string)
+	lowSpaceUsersMu sync.RWMutex
+)
+
+// runDiskGuard 定期检查各实例保存路径的磁盘空间
+func runDiskGuard(ctx context.Context) {
+	for {
+		cfg := currentConfig().DiskGuard
+		interval := time.Minute
+		if cfg != nil {
+			interval = cfg.Interval.Or(interval)
+			for _, inst := range listInstances() {
+				checkDiskSpace(ctx, cfg, inst)
+			}
+		} else {
+			lowSpaceUsersMu.Lock()
+			clear(lowSpaceUsers)
+			lowSpaceUsersMu.Unlock()
+		}
+
+		select {
+		case <-time.After(interval):
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// statSpace 使用 statfs 获取磁盘空间，失败时对默认保存路径使用 qBittorrent 报告的可用空间
+func statSpace(path, defaultPath string, reported int64, hasReported bool) (diskSpace, bool) {
+	free, total, err := diskUsage(path)
+	if err == nil {
+		return diskSpace{path: path, free: free, total: total}, true
+	}
+	if path == defaultPath && hasReported {
+		return diskSpace{path: path, free: uint64(reported)}, true
+	}
+	logrus.Debugf("Disk guard: failed to stat %s: %v", path, err)
+	return diskSpace{}, false
+}
+
+func checkDiskSpace(ctx context.Context, cfg *DiskGuardConfig, inst *qbInstance) {
+	state, err := inst.hub.Snapshot(ctx, syncInterval)
+	if err != nil {
+		logrus.Warnf("Disk guard: failed to get torrents for user %s: %v", inst.username, err)
+		return
+	}
+	reported, hasReported := state.freeSpace()
+	defaultPath, _ := inst.client.DefaultSavePath(ctx)
+
+	spaces := make(map[string]diskSpace)
+	space := func(path string) (diskSpace, bool) {
+		if path == "" {
+			path = defaultPath
+		}
+		if d, ok := spaces[path]; ok {
+			return d, true
+		}
+		d, ok := statSpace(path, defaultPath, reported, hasReported)
+		if ok {
+			spaces[path] = d
+		}
+		return d, ok
+	}
+
+	var toPause, toResume []string
+	var lowReason string
+	if d, ok := space(defaultPath); ok && cfg.low(d) {
+		lowReason = d.String()
+	}
+	for _, t := range state.torrentList() {
+		d, ok := space(t.SavePath)
+		if !ok {
+			continue
+		}
+		if low := cfg.low(d); low && !t.IsFinished() {
+			// 已暂停的种子所在磁盘同样计入，否则暂停后就不再拒绝添加请求
+			if lowReason == "" {
+				lowReason = d.String()
+			}
+			if !t.IsPaused() {
+				toPause = append(toPause, t.Hash)
+			}
+		} else if !low && t.HasTag(cfg.tag()) && cfg.recovered(d) {
+			toResume = append(toResume, t.Hash)
+		}
+	}
+
+	lowSpaceUsersMu.Lock()
+	if lowReason != "" {
+		lowSpaceUsers[inst.username] = lowReason
+	} else {
+		delete(lowSpaceUsers, inst.username)
+	}
+	lowSpaceUsersMu.Unlock()
+
+	if len(toPause) > 0 {
+		logrus.Warnf("Disk guard: pausing %d downloads for user %s: only %s", len(toPause), inst.username, lowReason)
+		if err := pauseForDiskGuard(ctx, inst.client, toPause, cfg.tag()); err != nil {
+			logrus.Errorf("Disk guard: failed to pause downloads for user %s: %v", inst.username, err)
+		}
+	}
+	if len(toResume) > 0 {
+		logrus.Infof("Disk guard: resuming %d downloads for user %s", len(toResume), inst.username)
+		if err := resumeFromDiskGuard(ctx, inst.client, toResume, cfg.tag()); err != nil {
+			logrus.Errorf("Disk guard: failed to resume downloads for user %s: %v", inst.username, err)
+		}
+	}
+}
+
+// pauseForDiskGuard 先打标签再暂停，这样即使暂停后代理重启也能正确恢复
+func pauseForDiskGuard(ctx context.Context, client *qbapi.Client, hashes []string, tag string) error {
+	if err := client.AddTags(ctx, hashes, []string{tag}); err != nil {
+		return err
+	}
+	return client.Stop(ctx, hashes)
+}
+
+func resumeFromDiskGuard(ctx context.Context, client *qbapi.Client, hashes []string, tag string) error {
+	if err := client.Start(ctx, hashes); err != nil {
+		return err
+	}
+	return client.RemoveTags(ctx, hashes, []string{tag})
+}
+
+// checkDiskSpaceForAdd 空间不足时拒绝添加请求，返回 false 表示已拒绝
+func checkDiskSpaceForAdd(username string, add *addRequest, w http.ResponseWriter) bool {
+	cfg := currentConfig().DiskGuard
+	if cfg == nil || !cfg.RejectAdds {
+		return true
+	}
+
+	reason := ""
+	if add.SavePath != "" {
+		if free, total, err := diskUsage(add.SavePath); err == nil {
+			if d := (diskSpace{path: add.SavePath, free: free, total: total}); cfg.low(d) {
+				reason = d.String()
+			}
+		}
+	} else {
+		lowSpaceUsersMu.RLock()
+		reason = lowSpaceUsers[username]
+		lowSpaceUsersMu.RUnlock()
+	}
+	if reason == "" {
+		return true
+	}
+
+	logrus.Warnf("Disk guard: rejected add request for user %s: only %s", username, reason)
+	http.Error(w, "Not enough disk space: only "+reason, http.StatusInsufficientStorage)
+	return false
+}
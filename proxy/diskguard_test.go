@@ -0,0 +1,158 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"slices"
+	"sync"
+	"testing"
+
+	"github.com/leganck/fn-qb-proxy/qbapi"
+)
+
+func TestDiskGuardThresholds(t *testing.T) {
+	d := diskSpace{path: "/data", free: 900, total: 10000}
+	tests := []struct {
+		name           string
+		cfg            DiskGuardConfig
+		low, recovered bool
+	}{
+		{"above bytes", DiskGuardConfig{MinFree: 800}, false, true},
+		{"below bytes", DiskGuardConfig{MinFree: 1000}, true, false},
+		// 高于暂停阈值但低于默认 1.1 倍的恢复阈值
+		{"hysteresis bytes", DiskGuardConfig{MinFree: 850}, false, false},
+		{"explicit resume", DiskGuardConfig{MinFree: 850, ResumeFree: 900}, false, true},
+		{"above percent", DiskGuardConfig{MinFreePercent: 5}, false, true},
+		{"below percent", DiskGuardConfig{MinFreePercent: 10}, true, false},
+		{"hysteresis percent", DiskGuardConfig{MinFreePercent: 8.5}, false, false},
+		{"either threshold", DiskGuardConfig{MinFree: 100, MinFreePercent: 10}, true, false},
+	}
+	for _, tt := range tests {
+		if got := tt.cfg.low(d); got != tt.low {
+			t.Errorf("%s: low = %v, want %v", tt.name, got, tt.low)
+		}
+		if got := tt.cfg.recovered(d); got != tt.recovered {
+			t.Errorf("%s: recovered = %v, want %v", tt.name, got, tt.recovered)
+		}
+	}
+	// 只知道可用空间时忽略百分比阈值
+	if (&DiskGuardConfig{MinFreePercent: 50}).low(diskSpace{free: 1}) {
+		t.Error("percent threshold applied without total")
+	}
+}
+
+// fakeDiskQB 默认保存路径无法 statfs，只能使用 qBittorrent 报告的可用空间
+type fakeDiskQB struct {
+	mu    sync.Mutex
+	free  int64
+	calls []string
+}
+
+const fakeDiskDefaultPath = "/nonexistent/fn-qb-proxy-disk-guard"
+
+func (f *fakeDiskQB) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	switch r.URL.Path {
+	case qbapi.MainDataPath:
+		json.NewEncoder(w).Encode(map[string]any{
+			"rid":          1,
+			"full_update":  true,
+			"server_state": map[string]any{"free_space_on_disk": f.free},
+			"torrents": map[string]any{
+				"dl":      map[string]any{"state": "downloading", "progress": 0.5},
+				"paused":  map[string]any{"state": "stoppedDL", "progress": 0.5},
+				"done":    map[string]any{"state": "uploading", "progress": 1},
+				"guarded": map[string]any{"state": "stoppedDL", "progress": 0.5, "tags": "disk-guard"},
+			},
+		})
+	case qbapi.DefaultSavePathPath:
+		io.WriteString(w, fakeDiskDefaultPath)
+	default:
+		r.ParseForm()
+		f.calls = append(f.calls, r.URL.Path+" "+r.PostForm.Get("hashes"))
+	}
+}
+
+func TestCheckDiskSpace(t *testing.T) {
+	qb := &fakeDiskQB{free: 1000}
+	inst := addFakeInstance(t, "disk-alice", qb)
+	t.Cleanup(func() {
+		lowSpaceUsersMu.Lock()
+		delete(lowSpaceUsers, "disk-alice")
+		lowSpaceUsersMu.Unlock()
+	})
+
+	tests := []struct {
+		name string
+		cfg  DiskGuardConfig
+		want []string
+		low  bool
+	}{
+		// 只暂停下载中的种子，已暂停与已完成的不动
+		{"low", DiskGuardConfig{MinFree: 2000}, []string{"/api/v2/torrents/addTags dl", "/api/v2/torrents/stop dl"}, true},
+		{"not recovered", DiskGuardConfig{MinFree: 950}, nil, false},
+		{"recovered", DiskGuardConfig{MinFree: 900}, []string{"/api/v2/torrents/start guarded", "/api/v2/torrents/removeTags guarded"}, false},
+		{"explicit resume", DiskGuardConfig{MinFree: 950, ResumeFree: 1000}, []string{"/api/v2/torrents/start guarded", "/api/v2/torrents/removeTags guarded"}, false},
+	}
+	for _, tt := range tests {
+		inst.hub.invalidate()
+		checkDiskSpace(context.Background(), &tt.cfg, inst)
+		qb.mu.Lock()
+		calls := qb.calls
+		qb.calls = nil
+		qb.mu.Unlock()
+		if !slices.Equal(calls, tt.want) {
+			t.Errorf("%s: calls = %q, want %q", tt.name, calls, tt.want)
+		}
+		lowSpaceUsersMu.RLock()
+		_, low := lowSpaceUsers["disk-alice"]
+		lowSpaceUsersMu.RUnlock()
+		if low != tt.low {
+			t.Errorf("%s: low space = %v, want %v", tt.name, low, tt.low)
+		}
+	}
+}
+
+func TestCheckDiskSpaceForAdd(t *testing.T) {
+	old := config.Load()
+	config.Store(&Config{DiskGuard: &DiskGuardConfig{MinFree: 1 << 50, RejectAdds: true}})
+	defer config.Store(old)
+	lowSpaceUsersMu.Lock()
+	lowSpaceUsers["disk-bob"] = "1.0 KiB free on /data"
+	lowSpaceUsersMu.Unlock()
+	t.Cleanup(func() {
+		lowSpaceUsersMu.Lock()
+		delete(lowSpaceUsers, "disk-bob")
+		lowSpaceUsersMu.Unlock()
+	})
+
+	tests := []struct {
+		name string
+		user string
+		add  addRequest
+		want bool
+	}{
+		{"low user", "disk-bob", addRequest{}, false},
+		{"other user", "disk-carol", addRequest{}, true},
+		// 指定保存路径时直接检查该路径所在磁盘
+		{"save path", "disk-carol", addRequest{SavePath: t.TempDir()}, false},
+		{"missing save path", "disk-bob", addRequest{SavePath: fakeDiskDefaultPath}, true},
+	}
+	for _, tt := range tests {
+		if tt.add.SavePath != "" && runtime.GOOS != "linux" {
+			continue
+		}
+		rec := httptest.NewRecorder()
+		if got := checkDiskSpaceForAdd(tt.user, &tt.add, rec); got != tt.want {
+			t.Errorf("%s: allowed = %v, want %v", tt.name, got, tt.want)
+		}
+		if !tt.want && rec.Code != http.StatusInsufficientStorage {
+			t.Errorf("%s: status = %d, want 507", tt.name, rec.Code)
+		}
+	}
+}
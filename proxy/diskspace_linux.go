@@ -0,0 +1,12 @@
+package main
+
+import "syscall"
+
+// diskUsage 返回路径所在文件系统的可用空间与总容量（字节），可用空间不含 root 保留部分
+func diskUsage(path string) (free, total uint64, err error) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(path, &st); err != nil {
+		return 0, 0, err
+	}
+	return st.Bavail * uint64(st.Bsize), st.Blocks * uint64(st.Bsize), nil
+}
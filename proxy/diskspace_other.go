@@ -0,0 +1,10 @@
+//go:build !linux
+
+package main
+
+import "errors"
+
+// diskUsage 非 Linux 平台不支持，调用方回退为 qBittorrent 报告的 free_space_on_disk
+func diskUsage(path string) (free, total uint64, err error) {
+	return 0, 0, errors.New("statfs is not supported on this platform")
+}
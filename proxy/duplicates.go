@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/leganck/fn-qb-proxy/metainfo"
+	"github.com/leganck/fn-qb-proxy/qbapi"
+	"github.com/sirupsen/logrus"
+)
+
+// 发现其它用户已有同一种子时的处理方式
+const (
+	duplicateReject = "reject"
+	duplicateWarn   = "warn"
+	duplicateShare  = "share"
+)
+
+// DuplicatesConfig 跨实例的重复种子检测
+type DuplicatesConfig struct {
+	Action string `json:"action"` // reject、warn（默认）或 share
+	Tag    string `json:"tag"`    // share 时为已有的种子添加的标签，默认 shared
+}
+
+func (c *DuplicatesConfig) validate() error {
+	switch c.Action {
+	case "", duplicateReject, duplicateWarn, duplicateShare:
+		return nil
+	}
+	return fmt.Errorf("unknown action %q", c.Action)
+}
+
+func (c *DuplicatesConfig) tag() string {
+	if c.Tag != "" {
+		return c.Tag
+	}
+	return "shared"
+}
+
+// duplicate 在其它实例中找到的同一种子
+type duplicate struct {
+	inst    *qbInstance
+	torrent qbapi.Torrent
+}
+
+func (d duplicate) String() string {
+	return d.inst.username + ":" + d.torrent.Hash
+}
+
+// sameTorrent 按 v1 与 v2 infohash 比较，混合种子在不同实例中可能以任一哈希出现
+func sameTorrent(t *metainfo.Torrent, existing *qbapi.Torrent) bool {
+	if v1 := t.InfoHashV1; v1 != "" && (v1 == existing.Hash || v1 == existing.InfohashV1) {
+		return true
+	}
+	if v2 := t.InfoHashV2; v2 != "" && (v2 == existing.InfohashV2 || v2[:40] == existing.Hash) {
+		return true
+	}
+	return false
+}
+
+// findDuplicates 在其它用户的实例中查找请求中的种子，同一用户的重复由 qBittorrent 自己处理
+func findDuplicates(ctx context.Context, username string, add *addRequest) map[string]duplicate {
+	found := make(map[string]duplicate)
+	for _, inst := range listInstances() {
+		if inst.username == username {
+			continue
+		}
+		state, err := inst.hub.Snapshot(ctx, syncInterval)
+		if err != nil {
+			logrus.Debugf("Duplicates: failed to get torrents for user %s: %v", inst.username, err)
+			continue
+		}
+		for _, existing := range state.torrentList() {
+			// 正在迁移出去的种子会以同一哈希添加到目标实例
+			if existing.HasTag(migratingTag) {
+				continue
+			}
+			for _, t := range add.Torrents {
+				if _, ok := found[t.Hash()]; !ok && sameTorrent(t, &existing) {
+					found[t.Hash()] = duplicate{inst: inst, torrent: existing}
+				}
+			}
+		}
+	}
+	return found
+}
+
+// checkDuplicatesForAdd 检查请求中的种子是否已在其它用户的实例中，返回 false 表示请求已处理
+// share 只在请求中的种子全部重复时生效，否则与 warn 相同
+func checkDuplicatesForAdd(username string, add *addRequest, w http.ResponseWriter, r *http.Request) bool {
+	cfg := currentConfig().Duplicates
+	if cfg == nil || len(add.Torrents) == 0 {
+		return true
+	}
+	found := findDuplicates(r.Context(), username, add)
+	if len(found) == 0 {
+		return true
+	}
+
+	var owners []string
+	for _, d := range found {
+		owners = append(owners, d.String())
+	}
+	summary := strings.Join(owners, ",")
+
+	switch {
+	case cfg.Action == duplicateReject:
+		logrus.Warnf("Duplicates: rejected add request for user %s: already in %s", username, summary)
+		http.Error(w, "Torrent already exists in another instance: "+summary, http.StatusConflict)
+		return false
+	case cfg.Action == duplicateShare && len(found) == len(add.Torrents) && len(add.URLs) == 0:
+		if err := shareDuplicates(r.Context(), cfg, username, found); err != nil {
+			logrus.Errorf("Duplicates: failed to share torrents for user %s: %v", username, err)
+			http.Error(w, "Failed to share existing torrent: "+err.Error(), http.StatusBadGateway)
+			return false
+		}
+		logrus.Infof("Duplicates: shared %s with user %s instead of adding", summary, username)
+		w.Header().Set("X-Duplicate-Of", summary)
+		w.Header().Set("Content-Type", "text/plain")
+		fmt.Fprint(w, "Ok.")
+		return false
+	}
+
+	logrus.Infof("Duplicates: torrent added by user %s already exists in %s", username, summary)
+	w.Header().Set("X-Duplicate-Of", summary)
+	return true
+}
+
+// shareDuplicates 为已有的种子添加共享标签，并记录是哪个用户请求的
+func shareDuplicates(ctx context.Context, cfg *DuplicatesConfig, username string, found map[string]duplicate) error {
+	for _, d := range found {
+		tags := []string{cfg.tag(), cfg.tag() + ":" + username}
+		if err := d.inst.client.AddTags(ctx, []string{d.torrent.Hash}, tags); err != nil {
+			return fmt.Errorf("tag %s: %w", d, err)
+		}
+	}
+	return nil
+}
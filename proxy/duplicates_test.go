@@ -0,0 +1,119 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/leganck/fn-qb-proxy/metainfo"
+	"github.com/leganck/fn-qb-proxy/qbapi"
+)
+
+func TestSameTorrent(t *testing.T) {
+	v1 := strings.Repeat("1", 40)
+	v2 := strings.Repeat("2", 64)
+	tests := []struct {
+		name     string
+		t        metainfo.Torrent
+		existing qbapi.Torrent
+		want     bool
+	}{
+		{"v1", metainfo.Torrent{InfoHashV1: v1}, qbapi.Torrent{Hash: v1}, true},
+		{"v1 of hybrid", metainfo.Torrent{InfoHashV1: v1}, qbapi.Torrent{Hash: v2[:40], InfohashV1: v1}, true},
+		{"v2", metainfo.Torrent{InfoHashV2: v2}, qbapi.Torrent{Hash: v1, InfohashV2: v2}, true},
+		// 纯 v2 种子在 qBittorrent 中以 v2 的前 40 位作为哈希
+		{"truncated v2", metainfo.Torrent{InfoHashV2: v2}, qbapi.Torrent{Hash: v2[:40]}, true},
+		{"hybrid seen as v2", metainfo.Torrent{InfoHashV1: v1, InfoHashV2: v2}, qbapi.Torrent{Hash: v2[:40]}, true},
+		{"different", metainfo.Torrent{InfoHashV1: v1}, qbapi.Torrent{Hash: strings.Repeat("3", 40)}, false},
+		// 纯 v2 种子没有 v1 哈希，不能与已有种子的空 v1 字段匹配
+		{"pure v2 vs v1", metainfo.Torrent{InfoHashV2: v2}, qbapi.Torrent{Hash: v1}, false},
+	}
+	for _, tt := range tests {
+		if got := sameTorrent(&tt.t, &tt.existing); got != tt.want {
+			t.Errorf("%s: sameTorrent = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+// fakeDuplicatesQB 返回固定种子列表并记录添加的标签
+type fakeDuplicatesQB struct {
+	torrents map[string]any
+	mu       sync.Mutex
+	tagged   []string
+}
+
+func (f *fakeDuplicatesQB) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path == qbapi.MainDataPath {
+		json.NewEncoder(w).Encode(map[string]any{"rid": 1, "full_update": true, "torrents": f.torrents})
+		return
+	}
+	r.ParseForm()
+	f.mu.Lock()
+	f.tagged = append(f.tagged, r.PostForm.Get("hashes")+" "+r.PostForm.Get("tags"))
+	f.mu.Unlock()
+}
+
+func (f *fakeDuplicatesQB) takeTagged() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	tagged := f.tagged
+	f.tagged = nil
+	return tagged
+}
+
+func TestCheckDuplicatesForAdd(t *testing.T) {
+	hash := func(c string) string { return strings.Repeat(c, 40) }
+	addFakeInstance(t, "dup-alice", &fakeDuplicatesQB{torrents: map[string]any{
+		hash("a"): map[string]any{"name": "own"},
+	}})
+	bob := &fakeDuplicatesQB{torrents: map[string]any{
+		hash("b"): map[string]any{"name": "shared"},
+		hash("c"): map[string]any{"name": "leaving", "tags": "foo, " + migratingTag},
+	}}
+	addFakeInstance(t, "dup-bob", bob)
+
+	torrent := func(c string) *metainfo.Torrent { return &metainfo.Torrent{InfoHashV1: hash(c)} }
+	tests := []struct {
+		name     string
+		action   string
+		torrents []*metainfo.Torrent
+		urls     []string
+		allowed  bool
+		status   int
+		dupOf    string
+		tagged   []string
+	}{
+		// 自己实例中的种子与正在迁移出去的种子不算重复
+		{name: "own instance", action: duplicateReject, torrents: []*metainfo.Torrent{torrent("a")}, allowed: true, status: http.StatusOK},
+		{name: "migrating", action: duplicateReject, torrents: []*metainfo.Torrent{torrent("c")}, allowed: true, status: http.StatusOK},
+		{name: "warn", action: duplicateWarn, torrents: []*metainfo.Torrent{torrent("b")}, allowed: true, status: http.StatusOK, dupOf: "dup-bob:" + hash("b")},
+		{name: "default warn", torrents: []*metainfo.Torrent{torrent("b")}, allowed: true, status: http.StatusOK, dupOf: "dup-bob:" + hash("b")},
+		{name: "reject", action: duplicateReject, torrents: []*metainfo.Torrent{torrent("a"), torrent("b")}, status: http.StatusConflict},
+		{name: "share", action: duplicateShare, torrents: []*metainfo.Torrent{torrent("b")}, status: http.StatusOK, dupOf: "dup-bob:" + hash("b"),
+			tagged: []string{hash("b") + " shared,shared:dup-alice"}},
+		// 只有部分种子重复时按 warn 处理，仍然添加
+		{name: "share partial", action: duplicateShare, torrents: []*metainfo.Torrent{torrent("b"), torrent("d")}, allowed: true, status: http.StatusOK, dupOf: "dup-bob:" + hash("b")},
+		{name: "share with urls", action: duplicateShare, torrents: []*metainfo.Torrent{torrent("b")}, urls: []string{"https://example.org/x.torrent"}, allowed: true, status: http.StatusOK, dupOf: "dup-bob:" + hash("b")},
+	}
+	old := config.Load()
+	defer config.Store(old)
+	for _, tt := range tests {
+		config.Store(&Config{Duplicates: &DuplicatesConfig{Action: tt.action}})
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodPost, qbapi.TorrentsAddPath, nil)
+		allowed := checkDuplicatesForAdd("dup-alice", &addRequest{Torrents: tt.torrents, URLs: tt.urls}, w, r)
+		if allowed != tt.allowed || w.Code != tt.status {
+			t.Errorf("%s: allowed = %v, status %d, want %v, %d", tt.name, allowed, w.Code, tt.allowed, tt.status)
+		}
+		if got := w.Header().Get("X-Duplicate-Of"); got != tt.dupOf {
+			t.Errorf("%s: X-Duplicate-Of = %q, want %q", tt.name, got, tt.dupOf)
+		}
+		if got := bob.takeTagged(); !slices.Equal(got, tt.tagged) {
+			t.Errorf("%s: tagged %q, want %q", tt.name, got, tt.tagged)
+		}
+	}
+}
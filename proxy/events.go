@@ -0,0 +1,243 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/leganck/fn-qb-proxy/qbapi"
+	"github.com/sirupsen/logrus"
+)
+
+// 种子事件类型
+const (
+	torrentAdded     = "added"
+	torrentCompleted = "completed"
+	torrentErrored   = "errored"
+	torrentRemoved   = "removed"
+	torrentStalled   = "stalled"
+	torrentMetadata  = "metadata" // 磁力链接获取到元数据
+	torrentStuck     = "stuck"    // 停滞检测的 notify 步骤
+)
+
+// torrentEvent 由 sync/maindata 状态变化推导出的种子事件
+type torrentEvent struct {
+	Type    string        `json:"event"`
+	User    string        `json:"user"`
+	Time    time.Time     `json:"time"`
+	Torrent qbapi.Torrent `json:"torrent"`
+}
+
+// EventsConfig 事件轮询配置
+type EventsConfig struct {
+	Interval     Duration `json:"interval"`      // 无客户端轮询时代理自行拉取 maindata 的间隔，默认 5s
+	StalledDelay Duration `json:"stalled_delay"` // 种子持续停滞超过该时间才产生 stalled 事件，默认 5m
+}
+
+func (c *EventsConfig) stalledDelay() time.Duration {
+	if c == nil {
+		return 5 * time.Minute
+	}
+	return c.StalledDelay.Or(5 * time.Minute)
+}
+
+var (
+	eventHandlers []func(torrentEvent)
+	eventQueue    = make(chan torrentEvent, 256)
+	eventMu       sync.RWMutex
+)
+
+// stallMark 种子进入 stalledDL 的时间以及本次停滞是否已产生事件
+type stallMark struct {
+	since     time.Time
+	published bool
+}
+
+var (
+	stallMarks = make(map[string]*stallMark) // 用户/哈希 -> 停滞记录
+	stallMu    sync.Mutex
+)
+
+// onTorrentEvent 注册事件处理函数，处理函数应尽快返回，耗时操作需自行异步执行
+func onTorrentEvent(handler func(torrentEvent)) {
+	eventMu.Lock()
+	eventHandlers = append(eventHandlers, handler)
+	eventMu.Unlock()
+}
+
+// publishEvent 发送事件（非阻塞）
+func publishEvent(ev torrentEvent) {
+	select {
+	case eventQueue <- ev:
+		logrus.Debugf("Torrent %s %s for user %s", ev.Torrent.Hash, ev.Type, ev.User)
+	default:
+		logrus.Warnf("Dropped %s event for torrent %s: queue full", ev.Type, ev.Torrent.Hash)
+	}
+}
+
+// runEventDispatcher 将事件依次分发给所有处理函数
+func runEventDispatcher(ctx context.Context) {
+	for {
+		select {
+		case ev := <-eventQueue:
+			eventMu.RLock()
+			handlers := eventHandlers
+			eventMu.RUnlock()
+			for _, handler := range handlers {
+				handler(ev)
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// wantsEvents 是否有功能需要种子事件，没有时不主动轮询 qBittorrent
+func (c *Config) wantsEvents() bool {
+	return c.Hooks != nil || c.Notify != nil || len(c.Rules) > 0 || c.Quotas != nil || c.Library != nil
+}
+
+// runEventPoller 没有客户端轮询时，代理也需要定期拉取 maindata 才能发现状态变化
+func runEventPoller(ctx context.Context) {
+	for {
+		cfg := currentConfig()
+		interval := 5 * time.Second
+		if cfg.Events != nil {
+			interval = cfg.Events.Interval.Or(interval)
+		}
+
+		if cfg.wantsEvents() {
+			for _, inst := range listInstances() {
+				if err := inst.hub.Poll(ctx, interval); err != nil {
+					logrus.Debugf("Event poll for user %s failed: %v", inst.username, err)
+				}
+			}
+		}
+
+		select {
+		case <-time.After(interval):
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// detectEvents 比较前后两次状态，发布种子事件
+func detectEvents(username string, prev, cur *syncState) {
+	now := time.Now()
+	stalledDelay := currentConfig().Events.stalledDelay()
+	for hash, t := range cur.Torrents {
+		old, existed := prev.Torrents[hash]
+		if !existed {
+			publishEvent(torrentEvent{Type: torrentAdded, User: username, Time: now, Torrent: torrentFromMap(hash, t)})
+			continue
+		}
+		if isMetaState(old["state"]) && !isMetaState(t["state"]) {
+			publishEvent(torrentEvent{Type: torrentMetadata, User: username, Time: now, Torrent: torrentFromMap(hash, t)})
+		}
+		if progressOf(old) < 1 && progressOf(t) >= 1 {
+			publishEvent(torrentEvent{Type: torrentCompleted, User: username, Time: now, Torrent: torrentFromMap(hash, t)})
+		}
+		if isErrorState(t["state"]) && !isErrorState(old["state"]) {
+			publishEvent(torrentEvent{Type: torrentErrored, User: username, Time: now, Torrent: torrentFromMap(hash, t)})
+		}
+		checkStalled(username, hash, t, now, stalledDelay)
+	}
+	for hash, old := range prev.Torrents {
+		if _, exists := cur.Torrents[hash]; !exists {
+			stallMu.Lock()
+			delete(stallMarks, username+"/"+hash)
+			stallMu.Unlock()
+			publishEvent(torrentEvent{Type: torrentRemoved, User: username, Time: now, Torrent: torrentFromMap(hash, old)})
+		}
+	}
+}
+
+// checkStalled 种子持续处于 stalledDL 超过 delay 时产生一次 stalled 事件；
+// 停滞与下载频繁交替时每次恢复下载都重新计时，不会反复产生事件
+func checkStalled(username, hash string, t map[string]any, now time.Time, delay time.Duration) {
+	key := username + "/" + hash
+	stallMu.Lock()
+	defer stallMu.Unlock()
+	if t["state"] != "stalledDL" {
+		delete(stallMarks, key)
+		return
+	}
+	m := stallMarks[key]
+	if m == nil {
+		m = &stallMark{since: now}
+		stallMarks[key] = m
+	}
+	if !m.published && now.Sub(m.since) >= delay {
+		m.published = true
+		publishEvent(torrentEvent{Type: torrentStalled, User: username, Time: now, Torrent: torrentFromMap(hash, t)})
+	}
+}
+
+func progressOf(t map[string]any) float64 {
+	p, _ := t["progress"].(float64)
+	return p
+}
+
+func isMetaState(state any) bool {
+	return state == "metaDL" || state == "forcedMetaDL"
+}
+
+func isErrorState(state any) bool {
+	return state == "error" || state == "missingFiles"
+}
+
+// torrentFromMap 将 maindata 中的种子字段转换为结构体
+func torrentFromMap(hash string, fields map[string]any) qbapi.Torrent {
+	var t qbapi.Torrent
+	if data, err := json.Marshal(fields); err == nil {
+		json.Unmarshal(data, &t)
+	}
+	t.Hash = hash
+	return t
+}
+
+// eventVars 钩子与通知模板中占位符对应的值
+func eventVars(ev torrentEvent) map[string]string {
+	t := ev.Torrent
+	return map[string]string{
+		"event":        ev.Type,
+		"user":         ev.User,
+		"name":         t.Name,
+		"hash":         t.Hash,
+		"category":     t.Category,
+		"tags":         t.Tags,
+		"save_path":    t.SavePath,
+		"content_path": t.ContentPath,
+		"size":         strconv.FormatInt(t.Size, 10),
+		"size_human":   humanSize(t.Size),
+		"tracker":      t.Tracker,
+	}
+}
+
+// expandTemplate 替换字符串中的 {key} 占位符
+func expandTemplate(s string, vars map[string]string) string {
+	pairs := make([]string, 0, len(vars)*2)
+	for k, v := range vars {
+		pairs = append(pairs, "{"+k+"}", v)
+	}
+	return strings.NewReplacer(pairs...).Replace(s)
+}
+
+// humanSize 以 1024 为进制格式化字节数
+func humanSize(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for v := n / unit; v >= unit; v /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
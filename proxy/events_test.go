@@ -0,0 +1,72 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// drainEvents 取出队列中已发布的事件
+func drainEvents() []torrentEvent {
+	var events []torrentEvent
+	for {
+		select {
+		case ev := <-eventQueue:
+			events = append(events, ev)
+		default:
+			return events
+		}
+	}
+}
+
+func TestCheckStalled(t *testing.T) {
+	drainEvents()
+	defer func() {
+		stallMu.Lock()
+		clear(stallMarks)
+		stallMu.Unlock()
+	}()
+
+	start := time.Now()
+	stalled := map[string]any{"state": "stalledDL"}
+	downloading := map[string]any{"state": "downloading"}
+	steps := []struct {
+		at    time.Duration
+		state map[string]any
+		want  int // 本步产生的 stalled 事件数
+	}{
+		{0, stalled, 0},
+		{time.Minute, stalled, 0},
+		// 短暂恢复下载后重新计时
+		{2 * time.Minute, downloading, 0},
+		{3 * time.Minute, stalled, 0},
+		{7 * time.Minute, stalled, 0},
+		{8 * time.Minute, stalled, 1},
+		// 同一次停滞只产生一次事件
+		{20 * time.Minute, stalled, 0},
+		{21 * time.Minute, downloading, 0},
+		{22 * time.Minute, stalled, 0},
+		{27 * time.Minute, stalled, 1},
+	}
+	for _, step := range steps {
+		checkStalled("alice", "aaa", step.state, start.Add(step.at), 5*time.Minute)
+		if got := len(drainEvents()); got != step.want {
+			t.Errorf("at %v (%s): %d stalled events, want %d", step.at, step.state["state"], got, step.want)
+		}
+	}
+}
+
+func TestHookActionDefaultEvents(t *testing.T) {
+	all := &HookAction{}
+	explicit := &HookAction{Events: []string{torrentStalled}}
+	for _, typ := range []string{torrentAdded, torrentCompleted, torrentErrored, torrentRemoved, torrentMetadata, torrentStuck} {
+		if !all.matches(torrentEvent{Type: typ}) {
+			t.Errorf("hook without events does not match %s", typ)
+		}
+	}
+	if all.matches(torrentEvent{Type: torrentStalled}) {
+		t.Error("hook without events matches stalled")
+	}
+	if !explicit.matches(torrentEvent{Type: torrentStalled}) {
+		t.Error("hook subscribed to stalled does not match it")
+	}
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// HooksConfig 种子事件钩子配置
+type HooksConfig struct {
+	Actions    []HookAction `json:"actions"`
+	Retries    int          `json:"retries"`     // 失败后的重试次数
+	RetryDelay Duration     `json:"retry_delay"` // 首次重试的等待时间，之后每次翻倍，默认 10s
+	DeadLetter string       `json:"dead_letter"` // 重试耗尽后记录失败事件的文件（JSON Lines）
+}
+
+// HookAction 单个钩子，设置 command 时执行本地命令，否则向 url 发送 JSON
+// args、env 以及 url 中可以使用 {event} {user} {name} {hash} {category} {tags}
+// {save_path} {content_path} {size} {size_human} {tracker} 占位符
+type HookAction struct {
+	Name       string   `json:"name"`
+	Events     []string `json:"events"`     // 为空表示除 stalled 外的所有事件
+	Users      []string `json:"users"`      // 为空表示所有用户
+	Categories []string `json:"categories"` // 为空表示所有分类
+
+	Command string            `json:"command"`
+	Args    []string          `json:"args"`
+	Env     map[string]string `json:"env"`
+	RunAs   string            `json:"run_as"` // 以指定系统用户执行，可以为 {user}；为空时以代理自身身份执行
+
+	URL     string            `json:"url"`
+	Headers map[string]string `json:"headers"`
+
+	Timeout Duration `json:"timeout"` // 单次执行超时，默认 1m
+}
+
+// hookSlots 限制同时执行的钩子数量
+var hookSlots = make(chan struct{}, 4)
+
+// deadLetterMu 保证多个钩子同时写入失败记录时不交错
+var deadLetterMu sync.Mutex
+
+func (a *HookAction) label() string {
+	if a.Name != "" {
+		return a.Name
+	}
+	if a.Command != "" {
+		return a.Command
+	}
+	return a.URL
+}
+
+func (a *HookAction) matches(ev torrentEvent) bool {
+	if len(a.Events) == 0 {
+		// 停滞可能反复出现，需要显式订阅
+		if ev.Type == torrentStalled {
+			return false
+		}
+	} else if !slices.Contains(a.Events, ev.Type) {
+		return false
+	}
+	if len(a.Users) > 0 && !slices.Contains(a.Users, ev.User) {
+		return false
+	}
+	if len(a.Categories) > 0 && !slices.Contains(a.Categories, ev.Torrent.Category) {
+		return false
+	}
+	return true
+}
+
+// handleHookEvent 为匹配的钩子启动执行，不阻塞事件分发
+func handleHookEvent(ev torrentEvent) {
+	cfg := currentConfig().Hooks
+	if cfg == nil {
+		return
+	}
+	for _, action := range cfg.Actions {
+		if !action.matches(ev) {
+			continue
+		}
+		go runHook(cfg, action, ev)
+	}
+}
+
+// runHook 执行钩子，失败时按指数退避重试，仍失败则写入失败记录
+func runHook(cfg *HooksConfig, action HookAction, ev torrentEvent) {
+	delay := cfg.RetryDelay.Or(10 * time.Second)
+	var err error
+	for attempt := 0; attempt <= cfg.Retries; attempt++ {
+		if attempt > 0 {
+			time.Sleep(delay)
+			delay *= 2
+		}
+
+		hookSlots <- struct{}{}
+		err = execHook(action, ev)
+		<-hookSlots
+
+		if err == nil {
+			logrus.Infof("Hook %s ran for %s event of torrent %s", action.label(), ev.Type, ev.Torrent.Hash)
+			return
+		}
+		logrus.Warnf("Hook %s failed (attempt %d/%d): %v", action.label(), attempt+1, cfg.Retries+1, err)
+	}
+	writeDeadLetter(cfg.DeadLetter, action, ev, err)
+}
+
+func execHook(action HookAction, ev torrentEvent) error {
+	ctx, cancel := context.WithTimeout(context.Background(), action.Timeout.Or(time.Minute))
+	defer cancel()
+	if action.Command != "" {
+		return runHookCommand(ctx, action, ev)
+	}
+	if action.URL != "" {
+		return postHookWebhook(ctx, action, ev)
+	}
+	return fmt.Errorf("hook has neither command nor url")
+}
+
+// runHookCommand 直接执行命令（不经过 shell），事件信息同时以 FNQB_* 环境变量传入
+func runHookCommand(ctx context.Context, action HookAction, ev torrentEvent) error {
+	vars := eventVars(ev)
+	args := make([]string, len(action.Args))
+	for i, arg := range action.Args {
+		args[i] = expandTemplate(arg, vars)
+	}
+
+	cmd := exec.CommandContext(ctx, action.Command, args...)
+	cmd.Env = os.Environ()
+	for k, v := range vars {
+		cmd.Env = append(cmd.Env, "FNQB_"+strings.ToUpper(k)+"="+v)
+	}
+	for k, v := range action.Env {
+		cmd.Env = append(cmd.Env, k+"="+expandTemplate(v, vars))
+	}
+
+	if action.RunAs != "" {
+		attr, err := runAsProcAttr(expandTemplate(action.RunAs, vars))
+		if err != nil {
+			return err
+		}
+		cmd.SysProcAttr = attr
+	}
+
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%w: %s", err, bytes.TrimSpace(out))
+	}
+	return nil
+}
+
+// postHookWebhook 以 JSON 发送事件，非 2xx 响应视为失败
+func postHookWebhook(ctx context.Context, action HookAction, ev torrentEvent) error {
+	return postJSON(ctx, expandTemplate(action.URL, eventVars(ev)), action.Headers, ev)
+}
+
+// writeDeadLetter 记录重试耗尽的钩子，便于人工补发
+func writeDeadLetter(path string, action HookAction, ev torrentEvent, cause error) {
+	logrus.Errorf("Hook %s gave up on %s event of torrent %s: %v", action.label(), ev.Type, ev.Torrent.Hash, cause)
+	if path == "" {
+		return
+	}
+	line, err := json.Marshal(map[string]any{
+		"time":  time.Now(),
+		"hook":  action.label(),
+		"error": cause.Error(),
+		"event": ev,
+	})
+	if err != nil {
+		return
+	}
+
+	deadLetterMu.Lock()
+	defer deadLetterMu.Unlock()
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		logrus.Errorf("Failed to open dead letter log %s: %v", path, err)
+		return
+	}
+	defer f.Close()
+	f.Write(append(line, '\n'))
+}
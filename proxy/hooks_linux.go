@@ -0,0 +1,24 @@
+package main
+
+import (
+	"os/user"
+	"strconv"
+	"syscall"
+)
+
+// runAsProcAttr 以指定系统用户的 uid/gid 执行钩子命令
+func runAsProcAttr(name string) (*syscall.SysProcAttr, error) {
+	u, err := user.Lookup(name)
+	if err != nil {
+		return nil, err
+	}
+	uid, err := strconv.ParseUint(u.Uid, 10, 32)
+	if err != nil {
+		return nil, err
+	}
+	gid, err := strconv.ParseUint(u.Gid, 10, 32)
+	if err != nil {
+		return nil, err
+	}
+	return &syscall.SysProcAttr{Credential: &syscall.Credential{Uid: uint32(uid), Gid: uint32(gid)}}, nil
+}
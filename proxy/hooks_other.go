@@ -0,0 +1,13 @@
+//go:build !linux
+
+package main
+
+import (
+	"errors"
+	"syscall"
+)
+
+// runAsProcAttr 非 Linux 平台不支持切换用户，拒绝执行设置了 run_as 的钩子
+func runAsProcAttr(name string) (*syscall.SysProcAttr, error) {
+	return nil, errors.New("run_as is not supported on this platform")
+}
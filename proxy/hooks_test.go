@@ -0,0 +1,127 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/leganck/fn-qb-proxy/qbapi"
+)
+
+func TestHookActionMatches(t *testing.T) {
+	ev := torrentEvent{Type: torrentCompleted, User: "alice", Torrent: qbapi.Torrent{Category: "tv"}}
+	tests := []struct {
+		name   string
+		action HookAction
+		want   bool
+	}{
+		{"empty", HookAction{}, true},
+		{"event", HookAction{Events: []string{torrentAdded, torrentCompleted}}, true},
+		{"other event", HookAction{Events: []string{torrentAdded}}, false},
+		{"user", HookAction{Users: []string{"bob", "alice"}}, true},
+		{"other user", HookAction{Users: []string{"bob"}}, false},
+		{"category", HookAction{Categories: []string{"tv"}}, true},
+		{"other category", HookAction{Categories: []string{"movies"}}, false},
+		{"all", HookAction{Events: []string{torrentCompleted}, Users: []string{"alice"}, Categories: []string{"tv"}}, true},
+		{"one mismatch", HookAction{Events: []string{torrentCompleted}, Users: []string{"alice"}, Categories: []string{"movies"}}, false},
+	}
+	for _, tt := range tests {
+		if got := tt.action.matches(ev); got != tt.want {
+			t.Errorf("%s: matches = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestExpandTemplate(t *testing.T) {
+	ev := torrentEvent{Type: torrentCompleted, User: "alice", Torrent: qbapi.Torrent{
+		Hash: "aaa", Name: "Show S01E01", Category: "tv", Tags: "a,b",
+		SavePath: "/data/tv", ContentPath: "/data/tv/Show S01E01", Size: 3 << 30, Tracker: "http://t/announce",
+	}}
+	vars := eventVars(ev)
+	tests := []struct{ in, want string }{
+		{"{event} {user} {hash}", "completed alice aaa"},
+		{"{content_path}", "/data/tv/Show S01E01"},
+		{"{size} ({size_human})", "3221225472 (3.0 GiB)"},
+		{"/notify?c={category}&t={tags}", "/notify?c=tv&t=a,b"},
+		{"{unknown} {name", "{unknown} {name"},
+	}
+	for _, tt := range tests {
+		if got := expandTemplate(tt.in, vars); got != tt.want {
+			t.Errorf("expandTemplate(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+	// 替换结果中的占位符不会再次展开
+	if got := expandTemplate("{name}", eventVars(torrentEvent{Torrent: qbapi.Torrent{Name: "{user}"}})); got != "{user}" {
+		t.Errorf("placeholder in value expanded to %q", got)
+	}
+}
+
+func TestExecHookCommand(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("requires sh")
+	}
+	out := filepath.Join(t.TempDir(), "out")
+	action := HookAction{
+		Command: "sh",
+		Args:    []string{"-c", `printf '%s|%s|%s' "$1" "$FNQB_CATEGORY" "$DEST" > "$2"`, "hook", "{name}", out},
+		Env:     map[string]string{"DEST": "/library/{category}"},
+	}
+	ev := torrentEvent{Type: torrentCompleted, User: "alice", Torrent: qbapi.Torrent{Name: "a b; $(rm -rf /)", Category: "tv"}}
+	if err := execHook(action, ev); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 占位符展开为单个参数，不经过 shell 解析
+	if got, want := string(data), "a b; $(rm -rf /)|tv|/library/tv"; got != want {
+		t.Errorf("output = %q, want %q", got, want)
+	}
+
+	if err := execHook(HookAction{Command: "sh", Args: []string{"-c", "echo oops; exit 3"}}, ev); err == nil {
+		t.Error("failing command returned nil")
+	}
+	if err := execHook(HookAction{}, ev); err == nil {
+		t.Error("hook without command or url returned nil")
+	}
+}
+
+func TestRunHookWebhookDeadLetter(t *testing.T) {
+	var got []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var ev torrentEvent
+		json.NewDecoder(r.Body).Decode(&ev)
+		got = append(got, r.URL.Path+" "+ev.Torrent.Hash+" "+r.Header.Get("X-Token"))
+		http.Error(w, "down", http.StatusBadGateway)
+	}))
+	defer srv.Close()
+
+	deadLetter := filepath.Join(t.TempDir(), "dead.jsonl")
+	cfg := &HooksConfig{Retries: 1, RetryDelay: Duration(1), DeadLetter: deadLetter}
+	action := HookAction{Name: "web", URL: srv.URL + "/hook/{user}", Headers: map[string]string{"X-Token": "secret"}}
+	runHook(cfg, action, torrentEvent{Type: torrentAdded, User: "alice", Torrent: qbapi.Torrent{Hash: "aaa"}})
+
+	if len(got) != 2 || got[0] != "/hook/alice aaa secret" {
+		t.Fatalf("requests = %q, want 2 × %q", got, "/hook/alice aaa secret")
+	}
+	data, err := os.ReadFile(deadLetter)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var entry struct {
+		Hook  string       `json:"hook"`
+		Error string       `json:"error"`
+		Event torrentEvent `json:"event"`
+	}
+	if err := json.Unmarshal(data, &entry); err != nil {
+		t.Fatalf("dead letter %q: %v", data, err)
+	}
+	if entry.Hook != "web" || entry.Event.Torrent.Hash != "aaa" || entry.Error == "" {
+		t.Errorf("dead letter = %+v", entry)
+	}
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"sync"
+
+	"github.com/leganck/fn-qb-proxy/qbapi"
+)
+
+// qbInstance 每个 qBittorrent 实例（即每个 fnOS 用户）的运行时状态
+type qbInstance struct {
+	username string
+	client   *qbapi.Client // 直连 qBittorrent 原生 Socket 的 API 客户端
+	// 经过本代理 Socket 的 API 客户端，代理上的拦截与检查同样生效
+	proxyClient *qbapi.Client
+	hub         *syncHub
+	cache       *responseCache
+	sessions    *sessionSet
+}
+
+var (
+	instances     = make(map[string]*qbInstance)
+	instanceMutex sync.RWMutex
+)
+
+// addInstance 根据用户凭据创建实例状态
+func addInstance(username string, cred UserCredentials) *qbInstance {
+	client := qbapi.New(cred.SockPath, cred.Password)
+	inst := &qbInstance{
+		username:    username,
+		client:      client,
+		proxyClient: qbapi.New(getProxySocketPath(username), ""),
+		hub:         newSyncHub(username, client),
+		cache:       newResponseCache(),
+		sessions:    newSessionSet(),
+	}
+
+	instanceMutex.Lock()
+	instances[username] = inst
+	instanceMutex.Unlock()
+	return inst
+}
+
+// removeInstance 删除实例状态
+func removeInstance(username string) {
+	instanceMutex.Lock()
+	delete(instances, username)
+	instanceMutex.Unlock()
+}
+
+// getInstance 获取实例状态，不存在时返回 nil
+func getInstance(username string) *qbInstance {
+	instanceMutex.RLock()
+	defer instanceMutex.RUnlock()
+	return instances[username]
+}
+
+// listInstances 返回当前所有实例
+func listInstances() []*qbInstance {
+	instanceMutex.RLock()
+	defer instanceMutex.RUnlock()
+	list := make([]*qbInstance, 0, len(instances))
+	for _, inst := range instances {
+		list = append(list, inst)
+	}
+	return list
+}
@@ -0,0 +1,459 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// 媒体库链接方式，前者失败（如跨文件系统）时依次回退
+const (
+	linkHardlink = "hardlink" // 硬链接 → reflink → 复制
+	linkReflink  = "reflink"  // reflink → 复制
+	linkCopy     = "copy"
+)
+
+// LibraryConfig 种子完成后在媒体库中创建文件链接，种子连同文件删除时清理这些链接
+type LibraryConfig struct {
+	Targets []LibraryTarget `json:"targets"`
+	Record  string          `json:"record"` // 记录已创建链接的文件，默认为配置文件目录下的 library-links.json
+}
+
+// LibraryTarget 一个媒体库目录，种子使用第一个匹配的目标
+// dir 与 template 中可以使用事件占位符以及 {file}（种子内的相对路径）、{file_name}、{file_stem}、{ext}；
+// 分类、名称等由用户控制，展开后的目录不能超出 dir 中第一个占位符之前的固定部分
+type LibraryTarget struct {
+	Name       string   `json:"name"`
+	Users      []string `json:"users"`      // 为空表示所有用户
+	Categories []string `json:"categories"` // 为空表示所有分类
+	Extensions []string `json:"extensions"` // 只链接这些扩展名的文件，如 .mkv；为空表示所有文件
+
+	Dir      string `json:"dir"`
+	Template string `json:"template"` // 文件在 dir 下的相对路径，默认 {file}
+	Mode     string `json:"mode"`     // hardlink（默认）、reflink 或 copy
+}
+
+func (c *LibraryConfig) validate() error {
+	for i, t := range c.Targets {
+		if t.Dir == "" {
+			return fmt.Errorf("target %s: dir is required", t.label(i))
+		}
+		if base := libraryBase(t.Dir); !filepath.IsAbs(base) || base == "/" {
+			return fmt.Errorf("target %s: dir must start with an absolute directory other than /", t.label(i))
+		}
+		switch t.Mode {
+		case "", linkHardlink, linkReflink, linkCopy:
+		default:
+			return fmt.Errorf("target %s: unknown mode %q", t.label(i), t.Mode)
+		}
+	}
+	return nil
+}
+
+func (c *LibraryConfig) recordPath() string {
+	if c.Record != "" {
+		return c.Record
+	}
+	return filepath.Join(filepath.Dir(configPath), "library-links.json")
+}
+
+func (t *LibraryTarget) label(i int) string {
+	if t.Name != "" {
+		return t.Name
+	}
+	return fmt.Sprintf("#%d", i)
+}
+
+func (t *LibraryTarget) matches(ev torrentEvent) bool {
+	if len(t.Users) > 0 && !slices.Contains(t.Users, ev.User) {
+		return false
+	}
+	if len(t.Categories) > 0 && !slices.Contains(t.Categories, ev.Torrent.Category) {
+		return false
+	}
+	return true
+}
+
+func (t *LibraryTarget) wantsFile(name string) bool {
+	if len(t.Extensions) == 0 {
+		return true
+	}
+	ext := filepath.Ext(name)
+	return slices.ContainsFunc(t.Extensions, func(e string) bool { return strings.EqualFold(e, ext) })
+}
+
+// libraryRecord 一个种子在媒体库中创建的链接
+type libraryRecord struct {
+	Base    string    `json:"base,omitempty"` // dir 的固定部分，其下的路径删除前检查是否被替换为符号链接
+	Dir     string    `json:"dir"`            // 清理空目录时不会超出该目录
+	Links   []string  `json:"links"`
+	Created time.Time `json:"created"`
+}
+
+// libraryBase 返回 dir 中第一个占位符之前的完整目录
+func libraryBase(dir string) string {
+	if i := strings.IndexByte(dir, '{'); i >= 0 {
+		dir = dir[:strings.LastIndexByte(dir[:i], filepath.Separator)+1]
+	}
+	return filepath.Clean(dir)
+}
+
+// expandPathTemplate 展开路径模板，模板中用到的值不能包含 .. 路径段
+func expandPathTemplate(tmpl string, vars map[string]string) (string, error) {
+	for k, v := range vars {
+		if !strings.Contains(tmpl, "{"+k+"}") {
+			continue
+		}
+		if slices.Contains(strings.Split(filepath.ToSlash(v), "/"), "..") {
+			return "", fmt.Errorf("{%s} contains \"..\": %q", k, v)
+		}
+	}
+	return expandTemplate(tmpl, vars), nil
+}
+
+// libraryDir 展开目标目录，结果必须位于固定部分之内
+func libraryDir(target *LibraryTarget, vars map[string]string) (base, dir string, err error) {
+	base = libraryBase(target.Dir)
+	expanded, err := expandPathTemplate(target.Dir, vars)
+	if err != nil {
+		return "", "", err
+	}
+	dir = filepath.Clean(expanded)
+	if !isWithin(dir, base) {
+		return "", "", fmt.Errorf("dir %q is outside %s", dir, base)
+	}
+	return base, dir, nil
+}
+
+// isWithin 判断 path 是否为 root 或位于 root 之下
+func isWithin(path, root string) bool {
+	return path == root || strings.HasPrefix(path, strings.TrimSuffix(root, string(filepath.Separator))+string(filepath.Separator))
+}
+
+// checkNoSymlinks 检查 root 之下直到 path 的每一级是否为符号链接；
+// 媒体库中的目录属于用户，用户可以把目录替换为符号链接，让以 root 运行的代理在任意位置创建或删除文件
+func checkNoSymlinks(root, path string) error {
+	rel, err := filepath.Rel(root, path)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return fmt.Errorf("%s is outside %s", path, root)
+	}
+	cur := root
+	for _, part := range strings.Split(rel, string(filepath.Separator)) {
+		if part == "." {
+			continue
+		}
+		cur = filepath.Join(cur, part)
+		info, err := os.Lstat(cur)
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if info.Mode()&os.ModeSymlink != 0 {
+			return fmt.Errorf("%s is a symlink", cur)
+		}
+	}
+	return nil
+}
+
+// libraryMu 保护记录文件的读写，同时保证同一时间只有一个种子在创建链接（复制可能很慢）
+var libraryMu sync.Mutex
+
+// loadLibraryRecords 读取记录文件，键为 用户/hash
+func loadLibraryRecords(path string) (map[string]*libraryRecord, error) {
+	records := make(map[string]*libraryRecord)
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return records, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &records); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", path, err)
+	}
+	return records, nil
+}
+
+func saveLibraryRecords(path string, records map[string]*libraryRecord) error {
+	data, err := json.MarshalIndent(records, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(path, data)
+}
+
+// handleLibraryEvent 完成时创建链接，删除后检查文件是否一并删除并清理链接
+func handleLibraryEvent(ev torrentEvent) {
+	cfg := currentConfig().Library
+	if cfg == nil {
+		return
+	}
+	switch ev.Type {
+	case torrentCompleted:
+		for i := range cfg.Targets {
+			if cfg.Targets[i].matches(ev) {
+				go linkTorrent(cfg, &cfg.Targets[i], ev)
+				return
+			}
+		}
+	case torrentRemoved:
+		go unlinkTorrent(cfg, ev)
+	}
+}
+
+// linkTorrent 为已完成种子的文件创建链接，并记录下来
+func linkTorrent(cfg *LibraryConfig, target *LibraryTarget, ev torrentEvent) {
+	inst := getInstance(ev.User)
+	if inst == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	files, err := inst.client.Files(ctx, ev.Torrent.Hash)
+	cancel()
+	if err != nil {
+		logrus.Errorf("Library: failed to get files of %s for user %s: %v", ev.Torrent.Hash, ev.User, err)
+		return
+	}
+
+	libraryMu.Lock()
+	defer libraryMu.Unlock()
+	path := cfg.recordPath()
+	records, err := loadLibraryRecords(path)
+	if err != nil {
+		logrus.Errorf("Library: %v", err)
+		return
+	}
+	key := ev.User + "/" + ev.Torrent.Hash
+	old := records[key]
+
+	vars := eventVars(ev)
+	base, dir, err := libraryDir(target, vars)
+	if err != nil {
+		logrus.Warnf("Library: skipped %s of user %s: %v", ev.Torrent.Name, ev.User, err)
+		return
+	}
+	record := &libraryRecord{Base: base, Dir: dir, Created: time.Now()}
+	for _, f := range files {
+		// 未下载的文件（优先级为 0）不链接
+		if f.Priority == 0 || !target.wantsFile(f.Name) {
+			continue
+		}
+		src := filepath.Join(ev.Torrent.SavePath, f.Name)
+		dst, err := libraryPath(dir, target.Template, vars, f.Name)
+		if err != nil {
+			logrus.Warnf("Library: skipped %s: %v", src, err)
+			continue
+		}
+		// 重新校验等原因再次完成时，已创建的链接保持不变
+		if old != nil && slices.Contains(old.Links, dst) {
+			record.Links = append(record.Links, dst)
+			continue
+		}
+		method, err := linkFile(src, dst, base, target.Mode)
+		if err != nil {
+			logrus.Errorf("Library: failed to link %s to %s: %v", src, dst, err)
+			continue
+		}
+		logrus.Debugf("Library: %s %s -> %s", method, src, dst)
+		record.Links = append(record.Links, dst)
+	}
+	if len(record.Links) == 0 {
+		return
+	}
+	records[key] = record
+	if err := saveLibraryRecords(path, records); err != nil {
+		logrus.Errorf("Library: failed to save %s: %v", path, err)
+	}
+	logrus.Infof("Library: linked %d files of %s into %s", len(record.Links), ev.Torrent.Name, dir)
+}
+
+// libraryPath 按模板生成目标路径，结果不能超出媒体库目录
+func libraryPath(dir, template string, vars map[string]string, file string) (string, error) {
+	if template == "" {
+		template = "{file}"
+	}
+	name := filepath.Base(file)
+	ext := filepath.Ext(name)
+	fileVars := make(map[string]string, len(vars)+4)
+	for k, v := range vars {
+		fileVars[k] = v
+	}
+	fileVars["file"] = file
+	fileVars["file_name"] = name
+	fileVars["file_stem"] = strings.TrimSuffix(name, ext)
+	fileVars["ext"] = ext
+
+	expanded, err := expandPathTemplate(template, fileVars)
+	if err != nil {
+		return "", err
+	}
+	// 空占位符（如没有分类）会留下多余的分隔符
+	rel := filepath.Clean(strings.TrimLeft(expanded, string(filepath.Separator)))
+	if rel == "." || filepath.IsAbs(rel) || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("template %q gives invalid path %q", template, rel)
+	}
+	return filepath.Join(dir, rel), nil
+}
+
+// linkFile 创建链接并返回实际使用的方式；目标已是同一文件时视为成功，已有其它文件时不覆盖
+// base 之下的目录在创建前后都不能是符号链接，源文件必须是普通文件
+func linkFile(src, dst, base, mode string) (string, error) {
+	info, err := os.Lstat(src)
+	if err != nil {
+		return "", err
+	}
+	if !info.Mode().IsRegular() {
+		return "", fmt.Errorf("not a regular file")
+	}
+	if existing, err := os.Lstat(dst); err == nil {
+		if os.SameFile(info, existing) {
+			return "existing", nil
+		}
+		return "", fmt.Errorf("a different file already exists")
+	}
+	if err := checkNoSymlinks(base, filepath.Dir(dst)); err != nil {
+		return "", err
+	}
+	if err := mkdirAllLike(filepath.Dir(dst), src); err != nil {
+		return "", err
+	}
+	if err := checkNoSymlinks(base, filepath.Dir(dst)); err != nil {
+		return "", err
+	}
+
+	if mode == "" || mode == linkHardlink {
+		err := os.Link(src, dst)
+		if err == nil {
+			return linkHardlink, nil
+		}
+		if !errors.Is(err, syscall.EXDEV) && !errors.Is(err, syscall.EPERM) {
+			return "", err
+		}
+	}
+	if mode != linkCopy {
+		if err := reflinkFile(src, dst); err == nil {
+			chownLike(dst, src)
+			return linkReflink, nil
+		}
+	}
+	if err := copyFile(src, dst, info); err != nil {
+		return "", err
+	}
+	return linkCopy, nil
+}
+
+// copyFile 复制文件，先写入临时文件，避免媒体服务器扫描到不完整的文件
+func copyFile(src, dst string, info os.FileInfo) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	tmp := dst + ".part"
+	// 上次中断留下的临时文件（也可能是符号链接）先删除，再以 O_EXCL 创建，不跟随已有的链接
+	os.Remove(tmp)
+	out, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_EXCL, info.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := out.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	chownLike(tmp, src)
+	os.Chtimes(tmp, info.ModTime(), info.ModTime())
+	return os.Rename(tmp, dst)
+}
+
+// unlinkTorrent 种子删除后，等待 qBittorrent 删除文件；文件已删除时清理链接，否则只丢弃记录
+func unlinkTorrent(cfg *LibraryConfig, ev torrentEvent) {
+	key := ev.User + "/" + ev.Torrent.Hash
+	path := cfg.recordPath()
+	libraryMu.Lock()
+	records, err := loadLibraryRecords(path)
+	libraryMu.Unlock()
+	if err != nil || records[key] == nil {
+		return
+	}
+
+	// qBittorrent 在移除种子后异步删除文件
+	filesDeleted := false
+	if content := ev.Torrent.ContentPath; content != "" {
+		for i := 0; i < 6 && !filesDeleted; i++ {
+			time.Sleep(5 * time.Second)
+			_, err := os.Stat(content)
+			filesDeleted = errors.Is(err, os.ErrNotExist)
+		}
+	}
+
+	libraryMu.Lock()
+	defer libraryMu.Unlock()
+	records, err = loadLibraryRecords(path)
+	if err != nil {
+		logrus.Errorf("Library: %v", err)
+		return
+	}
+	record := records[key]
+	if record == nil {
+		return
+	}
+	if filesDeleted {
+		// 旧版本的记录没有 base，只能检查 dir 之下的部分
+		base := record.Base
+		if base == "" {
+			base = record.Dir
+		}
+		removed := 0
+		for _, link := range record.Links {
+			if !isWithin(link, record.Dir) {
+				logrus.Warnf("Library: refused to remove %s outside %s", link, record.Dir)
+				continue
+			}
+			if err := checkNoSymlinks(base, filepath.Dir(link)); err != nil {
+				logrus.Warnf("Library: refused to remove %s: %v", link, err)
+				continue
+			}
+			if err := os.Remove(link); err != nil && !errors.Is(err, os.ErrNotExist) {
+				logrus.Warnf("Library: failed to remove %s: %v", link, err)
+				continue
+			}
+			removed++
+			removeEmptyDirs(filepath.Dir(link), record.Dir)
+		}
+		logrus.Infof("Library: removed %d links of %s (torrent deleted with files)", removed, ev.Torrent.Name)
+	} else {
+		logrus.Infof("Library: kept links of %s (torrent removed without files)", ev.Torrent.Name)
+	}
+	delete(records, key)
+	if err := saveLibraryRecords(path, records); err != nil {
+		logrus.Errorf("Library: failed to save %s: %v", path, err)
+	}
+}
+
+// removeEmptyDirs 自下而上删除空目录，不删除 root 本身
+func removeEmptyDirs(dir, root string) {
+	for dir != root && strings.HasPrefix(dir, root+string(filepath.Separator)) {
+		if os.Remove(dir) != nil {
+			return
+		}
+		dir = filepath.Dir(dir)
+	}
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+// ficlone ioctl(FICLONE)，btrfs、xfs 等文件系统上共享数据块的复制
+const ficlone = 0x40049409
+
+// reflinkFile 以 reflink 方式复制文件，文件系统不支持时返回错误
+func reflinkFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return err
+	}
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, out.Fd(), ficlone, in.Fd())
+	out.Close()
+	if errno != 0 {
+		os.Remove(dst)
+		return errno
+	}
+	return nil
+}
@@ -0,0 +1,10 @@
+//go:build !linux
+
+package main
+
+import "errors"
+
+// reflinkFile 非 Linux 平台不支持，调用方回退为普通复制
+func reflinkFile(src, dst string) error {
+	return errors.New("reflink is not supported on this platform")
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLibraryDir(t *testing.T) {
+	tests := []struct {
+		dir     string
+		vars    map[string]string
+		want    string
+		wantErr bool
+	}{
+		{dir: "/vol1/lib/{category}", vars: map[string]string{"category": "tv"}, want: "/vol1/lib/tv"},
+		{dir: "/vol1/lib/{category}", vars: map[string]string{"category": "tv/anime"}, want: "/vol1/lib/tv/anime"},
+		{dir: "/vol1/lib/{category}", vars: map[string]string{"category": ""}, want: "/vol1/lib"},
+		{dir: "/vol1/lib/{category}", vars: map[string]string{"category": "../../root/.ssh"}, wantErr: true},
+		{dir: "/vol1/lib/{category}", vars: map[string]string{"category": "tv/../.."}, wantErr: true},
+		{dir: "/vol1/lib-{user}/x", vars: map[string]string{"user": "alice"}, want: "/vol1/lib-alice/x"},
+		{dir: "/vol1/lib/{name}", vars: map[string]string{"name": "/etc"}, want: "/vol1/lib/etc"},
+		// 未使用的占位符不检查
+		{dir: "/vol1/lib", vars: map[string]string{"category": ".."}, want: "/vol1/lib"},
+	}
+	for _, tt := range tests {
+		base, got, err := libraryDir(&LibraryTarget{Dir: tt.dir}, tt.vars)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("libraryDir(%q, %v) = %q, want error", tt.dir, tt.vars, got)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("libraryDir(%q, %v) = %q, %v, want %q", tt.dir, tt.vars, got, err, tt.want)
+		}
+		if !isWithin(got, base) {
+			t.Errorf("libraryDir(%q) = %q is outside base %q", tt.dir, got, base)
+		}
+	}
+}
+
+func TestLibraryPath(t *testing.T) {
+	vars := map[string]string{"category": "tv", "name": "Show"}
+	tests := []struct {
+		template string
+		file     string
+		want     string
+		wantErr  bool
+	}{
+		{template: "", file: "Show/e01.mkv", want: "/lib/Show/e01.mkv"},
+		{template: "{category}/{file_name}", file: "Show/e01.mkv", want: "/lib/tv/e01.mkv"},
+		{template: "{name}/{file_stem}{ext}", file: "a/b.mkv", want: "/lib/Show/b.mkv"},
+		{template: "{file}", file: "../../etc/passwd", wantErr: true},
+		{template: "../{file_name}", file: "a.mkv", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := libraryPath("/lib", tt.template, vars, tt.file)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("libraryPath(%q, %q) = %q, want error", tt.template, tt.file, got)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("libraryPath(%q, %q) = %q, %v, want %q", tt.template, tt.file, got, err, tt.want)
+		}
+	}
+}
+
+func TestCheckNoSymlinks(t *testing.T) {
+	root := t.TempDir()
+	os.MkdirAll(filepath.Join(root, "tv", "show"), 0755)
+	os.Symlink("/etc", filepath.Join(root, "evil"))
+
+	if err := checkNoSymlinks(root, filepath.Join(root, "tv", "show")); err != nil {
+		t.Errorf("real directories: %v", err)
+	}
+	if err := checkNoSymlinks(root, filepath.Join(root, "tv", "new", "dir")); err != nil {
+		t.Errorf("missing directories: %v", err)
+	}
+	if err := checkNoSymlinks(root, filepath.Join(root, "evil", "ssh")); err == nil {
+		t.Error("symlinked directory was accepted")
+	}
+	if err := checkNoSymlinks(root, "/etc"); err == nil {
+		t.Error("path outside root was accepted")
+	}
+}
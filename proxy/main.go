@@ -3,6 +3,7 @@ package main
 import (
 	"fmt"
 	"os"
+	"time"
 
 	"github.com/leganck/fn-qb-proxy/sigctx"
 	"github.com/sirupsen/logrus"
@@ -12,6 +13,14 @@ import (
 const DEBUG = "debug"
 const SOCKET_PERM = "socket-perm"
 const PROXY_SOCKET_DIR = "proxy-socket-dir"
+const CLIENT_RATE_LIMIT = "client-rate-limit"
+const UPSTREAM_RATE_LIMIT = "upstream-rate-limit"
+const SYNC_INTERVAL = "sync-interval"
+const CACHE_TTL = "cache-ttl"
+const CONFIG = "config"
+const ADMIN_LISTEN = "admin-listen"
+const ADMIN_TOKEN = "admin-token"
+const TRUSTED_FORWARDERS = "trusted-forwarders"
 
 var socketPerm os.FileMode
 var proxySocketDir string
@@ -42,13 +51,65 @@ func proxySocket(ctlCtx *cli.Context) error {
 	}
 	logrus.Infof("Proxy socket directory: %s", proxySocketDir)
 
+	// 初始化限流配置
+	if err := initRateLimiters(ctlCtx.String(CLIENT_RATE_LIMIT), ctlCtx.String(UPSTREAM_RATE_LIMIT)); err != nil {
+		return err
+	}
+	if err := initTrustedForwarders(ctlCtx.String(TRUSTED_FORWARDERS)); err != nil {
+		return err
+	}
+
+	// 合并轮询与只读缓存配置
+	syncInterval = ctlCtx.Duration(SYNC_INTERVAL)
+	cacheTTL = ctlCtx.Duration(CACHE_TTL)
+
 	// 创建带取消功能的上下文来处理终止信号
 	ctx, cancel := sigctx.SignalContext()
 	defer cancel()
 
+	// 加载配置文件
+	if err := initConfig(ctx, ctlCtx.String(CONFIG)); err != nil {
+		return err
+	}
+
 	// 启动查找qb密码的goroutine
 	go findQbUser(ctx)
 
+	// 启动监视目录导入
+	go runWatcher(ctx)
+
+	// 启动种子事件分发、完成钩子、通知、自动分类规则、配额检查与媒体库链接
+	onTorrentEvent(handleHookEvent)
+	onTorrentEvent(handleNotifyEvent)
+	onTorrentEvent(handleRuleEvent)
+	onTorrentEvent(handleQuotaEvent)
+	onTorrentEvent(handleLibraryEvent)
+	go runEventDispatcher(ctx)
+	go runEventPoller(ctx)
+
+	// 启动做种策略评估、磁盘空间保护、Tracker 检查与停滞下载处理
+	go runSeedingPolicies(ctx)
+	go runDiskGuard(ctx)
+	go runTrackerMonitor(ctx)
+	go runWatchdog(ctx)
+
+	// 启动带宽计划与全家带宽分配
+	go runBandwidthScheduler(ctx)
+	go runArbitration(ctx)
+
+	// 启动定时备份
+	go runScheduledBackups(ctx)
+
+	// 启动 RSS 自动下载
+	go runRSS(ctx)
+
+	// 启动管理接口
+	if addr := ctlCtx.String(ADMIN_LISTEN); addr != "" {
+		if err := startAdminServer(ctx, addr, ctlCtx.String(ADMIN_TOKEN)); err != nil {
+			return err
+		}
+	}
+
 	// 启动HTTP服务器
 	return startHTTPServer(ctx)
 }
@@ -86,9 +147,163 @@ func main() {
 				Aliases: []string{"psd"},
 				EnvVars: []string{"PROXY_SOCKET_DIR"},
 			},
+			&cli.StringFlag{
+				Name:    CONFIG,
+				Usage:   "Path to the JSON config file (reloaded on SIGHUP)",
+				Aliases: []string{"c"},
+				EnvVars: []string{"FN_QB_PROXY_CONFIG"},
+			},
+			&cli.StringFlag{
+				Name:    CLIENT_RATE_LIMIT,
+				Usage:   "Per-client rate limits, format class=rate:burst:inflight,... (classes: sync,read,add,write,default)",
+				EnvVars: []string{"CLIENT_RATE_LIMIT"},
+			},
+			&cli.StringFlag{
+				Name:    UPSTREAM_RATE_LIMIT,
+				Usage:   "Per-qBittorrent-instance rate limits, same format as --client-rate-limit",
+				EnvVars: []string{"UPSTREAM_RATE_LIMIT"},
+			},
+			&cli.StringFlag{
+				Name:    TRUSTED_FORWARDERS,
+				Usage:   "Comma-separated uids or user names of HTTP gateways (such as fn-qb-http) whose X-Forwarded-For header is trusted",
+				EnvVars: []string{"TRUSTED_FORWARDERS"},
+			},
+			&cli.DurationFlag{
+				Name:    SYNC_INTERVAL,
+				Usage:   "Minimum interval between upstream sync/maindata polls shared by all clients (0 disables coalescing)",
+				Value:   time.Second,
+				EnvVars: []string{"SYNC_INTERVAL"},
+			},
+			&cli.DurationFlag{
+				Name:    CACHE_TTL,
+				Usage:   "TTL for cached responses of read-only endpoints (0 disables caching)",
+				Value:   time.Second,
+				EnvVars: []string{"CACHE_TTL"},
+			},
+			&cli.StringFlag{
+				Name:    ADMIN_LISTEN,
+				Usage:   "Address (host:port or unix socket path) for the admin API aggregating all instances, disabled if empty",
+				EnvVars: []string{"ADMIN_LISTEN"},
+			},
+			&cli.StringFlag{
+				Name:    ADMIN_TOKEN,
+				Usage:   "Bearer token required by the admin API",
+				EnvVars: []string{"ADMIN_TOKEN"},
+			},
 		},
 		// 添加service子命令
 		Commands: []*cli.Command{
+			{
+				Name:   "backup",
+				Usage:  "Export torrents, categories, tags, save paths, share limits and trackers of each instance into a tar.gz archive",
+				Action: backupCommand,
+				Flags: []cli.Flag{
+					&cli.StringSliceFlag{
+						Name:  "user",
+						Usage: "Only back up these users (default: all instances served by the running proxy)",
+					},
+					&cli.StringFlag{
+						Name:    "output",
+						Usage:   "Archive path (default: fn-qb-backup-<time>.tar.gz)",
+						Aliases: []string{"o"},
+					},
+				},
+			},
+			{
+				Name:      "restore",
+				Usage:     "Restore torrents of one user from a backup archive into an instance",
+				ArgsUsage: "[archive]",
+				Action:    restoreCommand,
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:    "input",
+						Usage:   "Backup archive to restore from",
+						Aliases: []string{"i"},
+					},
+					&cli.StringFlag{
+						Name:  "from",
+						Usage: "User in the archive to restore (optional if the archive contains only one)",
+					},
+					&cli.StringFlag{
+						Name:  "to",
+						Usage: "User whose instance receives the torrents (default: same as --from)",
+					},
+					&cli.StringSliceFlag{
+						Name:  "map-path",
+						Usage: "Rewrite save path prefixes, format old=new (repeatable)",
+					},
+					&cli.BoolFlag{
+						Name:  "skip-checking",
+						Usage: "Skip hash checking of existing data",
+					},
+					&cli.BoolFlag{
+						Name:  "paused",
+						Usage: "Add all torrents paused",
+					},
+					&cli.BoolFlag{
+						Name:  "dry-run",
+						Usage: "Only report what would be restored",
+					},
+				},
+			},
+			{
+				Name:   "migrate",
+				Usage:  "Move torrents from one user's instance to another, keeping the downloaded data",
+				Action: migrateCommand,
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:     "from",
+						Usage:    "User whose instance currently has the torrents",
+						Required: true,
+					},
+					&cli.StringFlag{
+						Name:     "to",
+						Usage:    "User whose instance receives the torrents",
+						Required: true,
+					},
+					&cli.StringSliceFlag{
+						Name:     "hash",
+						Usage:    "Torrent hash to migrate (repeatable)",
+						Required: true,
+					},
+					&cli.StringSliceFlag{
+						Name:  "map-path",
+						Usage: "Rewrite save path prefixes, format old=new (repeatable)",
+					},
+					&cli.BoolFlag{
+						Name:  "remove-source",
+						Usage: "Remove the torrents from the source instance after a successful recheck (files are kept)",
+					},
+					&cli.DurationFlag{
+						Name:  "timeout",
+						Usage: "Maximum time to wait for the recheck of each torrent",
+						Value: time.Hour,
+					},
+				},
+			},
+			{
+				Name:   "orphans",
+				Usage:  "List files in download directories that no torrent of any instance references",
+				Action: orphansCommand,
+				Flags: []cli.Flag{
+					&cli.StringSliceFlag{
+						Name:  "root",
+						Usage: "Directory to scan (repeatable, default: orphans.roots in the config file or the default save path of each instance)",
+					},
+					&cli.StringSliceFlag{
+						Name:  "exclude",
+						Usage: "Skip files or directories whose name or path relative to the root matches this pattern (repeatable)",
+					},
+					&cli.BoolFlag{
+						Name:  "move-to-trash",
+						Usage: "Move the orphaned files into the trash directory, keeping their full path",
+					},
+					&cli.StringFlag{
+						Name:  "trash",
+						Usage: "Trash directory on the same filesystem as the roots (default: orphans.trash in the config file)",
+					},
+				},
+			},
 			{
 				Name:  "service",
 				Usage: "Manage system service",
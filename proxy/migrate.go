@@ -0,0 +1,379 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/leganck/fn-qb-proxy/qbapi"
+	"github.com/sirupsen/logrus"
+	"github.com/urfave/cli/v2"
+)
+
+// migratingTag 迁移期间为源种子添加的标签，重复种子检测会忽略带有该标签的种子
+const migratingTag = "migrating"
+
+// migrateOptions 迁移选项
+type migrateOptions struct {
+	paths        pathMapper
+	removeSource bool          // 校验通过后从源实例删除种子（保留文件）
+	timeout      time.Duration // 等待目标实例校验完成的最长时间
+}
+
+// migrateTorrent 将种子从 src 迁移到 dst：
+// 暂停源种子，以相同（或映射后）的保存路径添加到目标实例并重新校验，校验通过后才删除源种子；
+// 任一步骤失败时撤销目标实例中的种子并恢复源种子
+func migrateTorrent(ctx context.Context, src, dst *qbapi.Client, hash string, opts migrateOptions) error {
+	list, err := src.Torrents(ctx, url.Values{"hashes": {hash}})
+	if err != nil {
+		return err
+	}
+	if len(list) == 0 {
+		return fmt.Errorf("torrent not found in source instance")
+	}
+	t := list[0]
+	if existing, err := dst.Torrents(ctx, url.Values{"hashes": {hash}}); err != nil {
+		return err
+	} else if len(existing) > 0 {
+		return fmt.Errorf("torrent already exists in target instance")
+	}
+	data, err := src.Export(ctx, hash)
+	if err != nil {
+		return fmt.Errorf("export: %w", err)
+	}
+	tb := backupTorrent(ctx, src, t)
+	tb.File = hash + ".torrent"
+
+	if tb.Category != "" {
+		if err := copyCategory(ctx, src, dst, tb.Category, opts.paths); err != nil {
+			return err
+		}
+	}
+
+	// 暂停源种子，避免两个实例同时写入同一份数据
+	wasRunning := !t.IsPaused()
+	hashes := []string{hash}
+	if err := src.AddTags(ctx, hashes, []string{migratingTag}); err != nil {
+		return fmt.Errorf("tag source: %w", err)
+	}
+	if err := src.Stop(ctx, hashes); err != nil {
+		src.RemoveTags(ctx, hashes, []string{migratingTag})
+		return fmt.Errorf("stop source: %w", err)
+	}
+	restoreSource := func() {
+		src.RemoveTags(ctx, hashes, []string{migratingTag})
+		if wasRunning {
+			src.Start(ctx, hashes)
+		}
+	}
+
+	// 先以暂停状态添加，校验通过后再按源种子的状态启动
+	status, err := restoreTorrent(ctx, dst, &tb, map[string][]byte{tb.File: data}, restoreOptions{paths: opts.paths, paused: true})
+	if err != nil {
+		restoreSource()
+		return fmt.Errorf("add to target: %w", err)
+	}
+	if status != "added" {
+		restoreSource()
+		return fmt.Errorf("target instance: %s", status)
+	}
+
+	if err := dst.Recheck(ctx, hashes); err != nil {
+		logrus.Warnf("Migrate: failed to start recheck of %s: %v", hash, err)
+	}
+	if err := waitForRecheck(ctx, dst, hash, t.Progress, opts.timeout); err != nil {
+		dst.Delete(context.WithoutCancel(ctx), hashes, false)
+		restoreSource()
+		return err
+	}
+	if wasRunning {
+		if err := dst.Start(ctx, hashes); err != nil {
+			logrus.Warnf("Migrate: failed to start %s in target instance: %v", hash, err)
+		}
+	}
+
+	if opts.removeSource {
+		if err := src.Delete(ctx, hashes, false); err != nil {
+			return fmt.Errorf("remove source: %w", err)
+		}
+		return nil
+	}
+	// 保留源种子时只在已完成时继续做种，未完成的种子继续下载会与目标实例写入同一份数据
+	src.RemoveTags(ctx, hashes, []string{migratingTag})
+	if wasRunning && t.IsFinished() {
+		src.Start(ctx, hashes)
+	}
+	return nil
+}
+
+// copyCategory 目标实例没有该分类时按源实例的保存路径（映射后）创建
+func copyCategory(ctx context.Context, src, dst *qbapi.Client, name string, paths pathMapper) error {
+	existing, err := dst.Categories(ctx)
+	if err != nil {
+		return fmt.Errorf("get target categories: %w", err)
+	}
+	if _, ok := existing[name]; ok {
+		return nil
+	}
+	categories, err := src.Categories(ctx)
+	if err != nil {
+		return fmt.Errorf("get source categories: %w", err)
+	}
+	err = dst.CreateCategory(ctx, name, paths.apply(categories[name].SavePath))
+	var se *qbapi.StatusError
+	if err != nil && !(errors.As(err, &se) && se.Code == http.StatusConflict) {
+		return fmt.Errorf("create category: %w", err)
+	}
+	return nil
+}
+
+// isCheckingState 判断种子是否正在校验或准备数据
+func isCheckingState(state string) bool {
+	switch state {
+	case "checkingUP", "checkingDL", "checkingResumeData", "allocating", "moving":
+		return true
+	}
+	return false
+}
+
+// waitForRecheck 等待校验结束，并确认目标实例中的进度不低于源实例
+func waitForRecheck(ctx context.Context, client *qbapi.Client, hash string, want float64, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	checked := false
+	for {
+		list, err := client.Torrents(ctx, url.Values{"hashes": {hash}})
+		if err == nil && len(list) > 0 {
+			t := list[0]
+			switch {
+			case isCheckingState(t.State):
+				checked = true
+			case t.IsErrored():
+				// 保存路径不存在或无权限时校验会立即失败，可能观察不到校验状态
+				return fmt.Errorf("recheck failed with state %s, check the save path and permissions", t.State)
+			case t.Progress >= want-0.0001:
+				return nil
+			case checked:
+				return fmt.Errorf("recheck found only %.1f%% of the data (source had %.1f%%), check the save path and permissions",
+					t.Progress*100, want*100)
+			}
+		}
+
+		select {
+		case <-time.After(2 * time.Second):
+		case <-ctx.Done():
+			return fmt.Errorf("timed out waiting for recheck")
+		}
+	}
+}
+
+// migrateCommand 命令行迁移，通过正在运行的 fn-qb-proxy 的代理 Socket 访问两个实例
+func migrateCommand(c *cli.Context) error {
+	from, to := c.String("from"), c.String("to")
+	if from == "" || to == "" || from == to {
+		return fmt.Errorf("--from and --to must name two different users")
+	}
+	hashes := c.StringSlice("hash")
+	if len(hashes) == 0 {
+		return fmt.Errorf("missing --hash")
+	}
+	clients, err := proxyClients(c.String(PROXY_SOCKET_DIR), []string{from, to})
+	if err != nil {
+		return err
+	}
+	paths, err := parsePathMap(c.StringSlice("map-path"))
+	if err != nil {
+		return err
+	}
+	opts := migrateOptions{paths: paths, removeSource: c.Bool("remove-source"), timeout: c.Duration("timeout")}
+
+	failed := 0
+	for i, hash := range hashes {
+		logrus.Infof("[%d/%d] Migrating %s from %s to %s", i+1, len(hashes), hash, from, to)
+		if err := migrateTorrent(c.Context, clients[from], clients[to], hash, opts); err != nil {
+			logrus.Errorf("[%d/%d] %s: %v", i+1, len(hashes), hash, err)
+			failed++
+			continue
+		}
+		logrus.Infof("[%d/%d] %s: migrated", i+1, len(hashes), hash)
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d torrents failed to migrate", failed, len(hashes))
+	}
+	return nil
+}
+
+func init() {
+	adminMux.HandleFunc("POST /admin/migrate", handleAdminMigrate)
+	adminMux.HandleFunc("GET /admin/migrate", handleAdminMigrations)
+	adminMux.HandleFunc("GET /admin/migrate/{id}", handleAdminMigration)
+}
+
+// migrationJob 管理接口发起的迁移任务，校验可能需要很长时间，因此在后台执行
+type migrationJob struct {
+	ID      int               `json:"id"`
+	From    string            `json:"from"`
+	To      string            `json:"to"`
+	Created time.Time         `json:"created"`
+	Done    bool              `json:"done"`
+	Results map[string]string `json:"results"` // hash -> pending、running、migrated 或错误信息
+}
+
+// maxMigrationJobs 保留的任务数量，同时也是同时运行的任务数量上限
+const maxMigrationJobs = 50
+
+var (
+	migrationJobs   []*migrationJob
+	migrationNextID = 1
+	migrationMu     sync.Mutex
+)
+
+// migrateRequest POST /admin/migrate 的请求体
+type migrateRequest struct {
+	From         string            `json:"from"`
+	To           string            `json:"to"`
+	Hashes       []string          `json:"hashes"`
+	MapPath      map[string]string `json:"map_path"`
+	RemoveSource bool              `json:"remove_source"`
+	Timeout      Duration          `json:"timeout"` // 默认 1h
+}
+
+func handleAdminMigrate(w http.ResponseWriter, r *http.Request) {
+	var req migrateRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	src, dst := getInstance(req.From), getInstance(req.To)
+	if src == nil || dst == nil || src == dst {
+		http.Error(w, "from and to must name two different running instances", http.StatusBadRequest)
+		return
+	}
+	if len(req.Hashes) == 0 {
+		http.Error(w, "No hashes given", http.StatusBadRequest)
+		return
+	}
+	paths, err := pathMapperFromMap(req.MapPath)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	opts := migrateOptions{paths: paths, removeSource: req.RemoveSource, timeout: req.Timeout.Or(time.Hour)}
+
+	job := &migrationJob{From: req.From, To: req.To, Created: time.Now(), Results: make(map[string]string)}
+	for _, hash := range req.Hashes {
+		job.Results[hash] = "pending"
+	}
+	if err := addMigrationJob(job); err != nil {
+		http.Error(w, err.Error(), http.StatusTooManyRequests)
+		return
+	}
+
+	// 后台任务不随请求结束而取消；直接使用原生 Socket，避免代理上的检查拦截迁移
+	go func() {
+		ctx := context.Background()
+		for _, hash := range req.Hashes {
+			setMigrationResult(job, hash, "running")
+			result := "migrated"
+			if err := migrateTorrent(ctx, src.client, dst.client, hash, opts); err != nil {
+				result = err.Error()
+				logrus.Errorf("Migrate: %s from %s to %s failed: %v", hash, req.From, req.To, err)
+			} else {
+				logrus.Infof("Migrate: %s moved from %s to %s", hash, req.From, req.To)
+			}
+			setMigrationResult(job, hash, result)
+		}
+		migrationMu.Lock()
+		job.Done = true
+		migrationMu.Unlock()
+	}()
+
+	w.WriteHeader(http.StatusAccepted)
+	writeMigrationJob(w, job)
+}
+
+// pathMapperFromMap 转换 JSON 中的映射，apply 按最长前缀匹配，对象的键没有顺序也不影响结果
+func pathMapperFromMap(m map[string]string) (pathMapper, error) {
+	var paths pathMapper
+	for from, to := range m {
+		if from == "" {
+			return nil, fmt.Errorf("invalid path mapping %q=%q", from, to)
+		}
+		paths = append(paths, [2]string{strings.TrimSuffix(from, "/"), strings.TrimSuffix(to, "/")})
+	}
+	return paths, nil
+}
+
+// addMigrationJob 登记任务并分配 ID，超出数量时删除最早的已完成任务；
+// 运行中的任务不会被删除，因此同时运行的任务数量也有上限
+func addMigrationJob(job *migrationJob) error {
+	migrationMu.Lock()
+	defer migrationMu.Unlock()
+	running := 0
+	for _, j := range migrationJobs {
+		if !j.Done {
+			running++
+		}
+	}
+	if running >= maxMigrationJobs {
+		return fmt.Errorf("%d migrations are already running", running)
+	}
+	job.ID = migrationNextID
+	migrationNextID++
+	migrationJobs = append(migrationJobs, job)
+	for len(migrationJobs) > maxMigrationJobs {
+		i := slices.IndexFunc(migrationJobs, func(j *migrationJob) bool { return j.Done })
+		if i < 0 {
+			break
+		}
+		migrationJobs = slices.Delete(migrationJobs, i, i+1)
+	}
+	return nil
+}
+
+func setMigrationResult(job *migrationJob, hash, result string) {
+	migrationMu.Lock()
+	job.Results[hash] = result
+	migrationMu.Unlock()
+}
+
+func writeMigrationJob(w http.ResponseWriter, job *migrationJob) {
+	migrationMu.Lock()
+	data, _ := json.Marshal(job)
+	migrationMu.Unlock()
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(data)
+}
+
+func handleAdminMigrations(w http.ResponseWriter, r *http.Request) {
+	migrationMu.Lock()
+	data, _ := json.Marshal(append([]*migrationJob{}, migrationJobs...))
+	migrationMu.Unlock()
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(data)
+}
+
+func handleAdminMigration(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.Atoi(r.PathValue("id"))
+	migrationMu.Lock()
+	var job *migrationJob
+	for _, j := range migrationJobs {
+		if j.ID == id {
+			job = j
+		}
+	}
+	migrationMu.Unlock()
+	if job == nil {
+		http.Error(w, "Not found", http.StatusNotFound)
+		return
+	}
+	writeMigrationJob(w, job)
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestPathMapperFromMap(t *testing.T) {
+	paths, err := pathMapperFromMap(map[string]string{
+		"/vol1/1000/":          "/vol2/1000",
+		"/vol1/1000/downloads": "/vol3/downloads",
+		"/vol1":                "/vol4",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 多次运行以覆盖 map 的随机遍历顺序
+	for range 10 {
+		tests := map[string]string{
+			"/vol1/1000/downloads/a.mkv": "/vol3/downloads/a.mkv",
+			"/vol1/1000/other":           "/vol2/1000/other",
+			"/vol1/1001":                 "/vol4/1001",
+		}
+		for path, want := range tests {
+			if got := paths.apply(path); got != want {
+				t.Errorf("apply(%q) = %q, want %q", path, got, want)
+			}
+		}
+	}
+	if _, err := pathMapperFromMap(map[string]string{"": "/x"}); err == nil {
+		t.Error("empty prefix was accepted")
+	}
+}
+
+func TestWaitForRecheck(t *testing.T) {
+	tests := []struct {
+		name     string
+		state    string
+		progress float64
+		wantErr  bool
+	}{
+		{name: "complete", state: "stoppedUP", progress: 1},
+		{name: "missing files", state: "missingFiles", progress: 0, wantErr: true},
+		{name: "error", state: "error", progress: 0.5, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := newFakeQB(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				fmt.Fprintf(w, `[{"hash":"abc","state":%q,"progress":%g}]`, tt.state, tt.progress)
+			}))
+			start := time.Now()
+			err := waitForRecheck(context.Background(), client, "abc", 1, time.Minute)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("waitForRecheck() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			// 失败应立即返回，而不是等到超时
+			if time.Since(start) > 10*time.Second {
+				t.Errorf("waitForRecheck() took %v", time.Since(start))
+			}
+		})
+	}
+}
+
+func TestAddMigrationJob(t *testing.T) {
+	migrationMu.Lock()
+	saved := migrationJobs
+	migrationJobs = nil
+	migrationMu.Unlock()
+	defer func() {
+		migrationMu.Lock()
+		migrationJobs = saved
+		migrationMu.Unlock()
+	}()
+
+	// 最早的任务一直没有完成，其余任务都已完成
+	stuck := &migrationJob{}
+	if err := addMigrationJob(stuck); err != nil {
+		t.Fatal(err)
+	}
+	for range maxMigrationJobs + 5 {
+		job := &migrationJob{}
+		if err := addMigrationJob(job); err != nil {
+			t.Fatal(err)
+		}
+		migrationMu.Lock()
+		job.Done = true
+		migrationMu.Unlock()
+	}
+	migrationMu.Lock()
+	if len(migrationJobs) != maxMigrationJobs || migrationJobs[0] != stuck {
+		t.Errorf("kept %d jobs, first %d, want %d jobs starting with the running one", len(migrationJobs), migrationJobs[0].ID, maxMigrationJobs)
+	}
+	migrationJobs = nil
+	migrationMu.Unlock()
+
+	// 运行中的任务达到上限时拒绝新任务
+	for range maxMigrationJobs {
+		if err := addMigrationJob(&migrationJob{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := addMigrationJob(&migrationJob{}); err == nil {
+		t.Error("job beyond the running limit was accepted")
+	}
+}
@@ -0,0 +1,298 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/smtp"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// 默认通知模板
+const (
+	defaultNotifyTitle   = "[{user}] {event}: {name}"
+	defaultNotifyMessage = "{name}\n大小: {size_human}\n分类: {category}\n路径: {content_path}"
+)
+
+// NotifyConfig 种子事件通知配置
+type NotifyConfig struct {
+	Title     string           `json:"title"`   // 默认标题模板，占位符与钩子相同
+	Message   string           `json:"message"` // 默认正文模板
+	Notifiers []NotifierConfig `json:"notifiers"`
+}
+
+// NotifierConfig 单个通知渠道，type 可以为 webhook、telegram、bark、ntfy、smtp、serverchan
+// base_url 默认为各服务的官方地址，可以指向自建服务或本地测试服务
+type NotifierConfig struct {
+	Name    string   `json:"name"`
+	Type    string   `json:"type"`
+	Events  []string `json:"events"` // 为空表示 completed 与 errored
+	Users   []string `json:"users"`  // 为空表示所有用户
+	Title   string   `json:"title"`
+	Message string   `json:"message"`
+	Timeout Duration `json:"timeout"` // 默认 15s
+
+	BaseURL string            `json:"base_url"`
+	Token   string            `json:"token"`   // telegram 的 bot token、ntfy 的访问令牌
+	Key     string            `json:"key"`     // bark 设备 key、serverchan SendKey
+	ChatID  string            `json:"chat_id"` // telegram
+	Topic   string            `json:"topic"`   // ntfy
+	Headers map[string]string `json:"headers"` // webhook 额外请求头
+
+	// smtp
+	Host     string   `json:"host"`
+	Port     int      `json:"port"`
+	Username string   `json:"username"`
+	Password string   `json:"password"`
+	From     string   `json:"from"`
+	To       []string `json:"to"`
+	TLS      string   `json:"tls"` // starttls（默认，服务器支持时启用）、tls（隐式 TLS）、none
+}
+
+// notification 渲染后的通知内容
+type notification struct {
+	Title   string       `json:"title"`
+	Message string       `json:"message"`
+	Event   torrentEvent `json:"event"`
+}
+
+// notifier 通知渠道
+type notifier interface {
+	send(ctx context.Context, n notification) error
+}
+
+type notifierFunc func(ctx context.Context, n notification) error
+
+func (f notifierFunc) send(ctx context.Context, n notification) error { return f(ctx, n) }
+
+func (c *NotifierConfig) label() string {
+	if c.Name != "" {
+		return c.Name
+	}
+	return c.Type
+}
+
+func (c *NotifierConfig) matches(ev torrentEvent) bool {
+	events := c.Events
+	if len(events) == 0 {
+		events = []string{torrentCompleted, torrentErrored}
+	}
+	if !slices.Contains(events, ev.Type) {
+		return false
+	}
+	return len(c.Users) == 0 || slices.Contains(c.Users, ev.User)
+}
+
+// newNotifier 根据配置创建通知渠道
+func newNotifier(c NotifierConfig) (notifier, error) {
+	switch c.Type {
+	case "webhook":
+		if c.BaseURL == "" {
+			return nil, fmt.Errorf("webhook notifier requires base_url")
+		}
+		return notifierFunc(func(ctx context.Context, n notification) error {
+			return postJSON(ctx, c.BaseURL, c.Headers, n)
+		}), nil
+	case "telegram":
+		base := baseURLOr(c.BaseURL, "https://api.telegram.org")
+		return notifierFunc(func(ctx context.Context, n notification) error {
+			return postJSON(ctx, base+"/bot"+c.Token+"/sendMessage", nil, map[string]any{
+				"chat_id": c.ChatID,
+				"text":    n.Title + "\n\n" + n.Message,
+			})
+		}), nil
+	case "bark":
+		base := baseURLOr(c.BaseURL, "https://api.day.app")
+		return notifierFunc(func(ctx context.Context, n notification) error {
+			return postJSON(ctx, base+"/push", nil, map[string]any{
+				"device_key": c.Key,
+				"title":      n.Title,
+				"body":       n.Message,
+				"group":      "fn-qb-proxy",
+			})
+		}), nil
+	case "ntfy":
+		base := baseURLOr(c.BaseURL, "https://ntfy.sh")
+		return notifierFunc(func(ctx context.Context, n notification) error {
+			// HTTP 头不能直接携带非 ASCII 字符，ntfy 支持 RFC 2047 编码
+			headers := map[string]string{"Title": mime.BEncoding.Encode("UTF-8", n.Title)}
+			if c.Token != "" {
+				headers["Authorization"] = "Bearer " + c.Token
+			}
+			return postBody(ctx, base+"/"+url.PathEscape(c.Topic), "text/plain; charset=utf-8", headers, strings.NewReader(n.Message))
+		}), nil
+	case "serverchan":
+		base := baseURLOr(c.BaseURL, "https://sctapi.ftqq.com")
+		return notifierFunc(func(ctx context.Context, n notification) error {
+			form := url.Values{"title": {n.Title}, "desp": {n.Message}}
+			return postBody(ctx, base+"/"+url.PathEscape(c.Key)+".send", "application/x-www-form-urlencoded", nil, strings.NewReader(form.Encode()))
+		}), nil
+	case "smtp":
+		if c.Host == "" || len(c.To) == 0 {
+			return nil, fmt.Errorf("smtp notifier requires host and to")
+		}
+		return notifierFunc(func(ctx context.Context, n notification) error {
+			return sendMail(ctx, c, n)
+		}), nil
+	}
+	return nil, fmt.Errorf("unknown notifier type %q", c.Type)
+}
+
+func baseURLOr(base, def string) string {
+	if base == "" {
+		return def
+	}
+	return strings.TrimRight(base, "/")
+}
+
+// handleNotifyEvent 为匹配的通知渠道异步发送通知
+func handleNotifyEvent(ev torrentEvent) {
+	cfg := currentConfig().Notify
+	if cfg == nil {
+		return
+	}
+	vars := eventVars(ev)
+	for _, nc := range cfg.Notifiers {
+		if !nc.matches(ev) {
+			continue
+		}
+		n, err := newNotifier(nc)
+		if err != nil {
+			logrus.Errorf("Notifier %s: %v", nc.label(), err)
+			continue
+		}
+		msg := notification{
+			Title:   expandTemplate(firstNonEmpty(nc.Title, cfg.Title, defaultNotifyTitle), vars),
+			Message: expandTemplate(firstNonEmpty(nc.Message, cfg.Message, defaultNotifyMessage), vars),
+			Event:   ev,
+		}
+		go func(nc NotifierConfig) {
+			ctx, cancel := context.WithTimeout(context.Background(), nc.Timeout.Or(15*time.Second))
+			defer cancel()
+			if err := n.send(ctx, msg); err != nil {
+				logrus.Warnf("Notifier %s failed for %s event of torrent %s: %v", nc.label(), ev.Type, ev.Torrent.Hash, err)
+				return
+			}
+			logrus.Debugf("Notifier %s sent %s event of torrent %s", nc.label(), ev.Type, ev.Torrent.Hash)
+		}(nc)
+	}
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+func postJSON(ctx context.Context, target string, headers map[string]string, payload any) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	return postBody(ctx, target, "application/json", headers, bytes.NewReader(body))
+}
+
+// postBody 发送 POST 请求，非 2xx 响应视为失败
+func postBody(ctx context.Context, target, contentType string, headers map[string]string, body io.Reader) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, target, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", contentType)
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		detail, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("%s: %s", resp.Status, bytes.TrimSpace(detail))
+	}
+	return nil
+}
+
+// sendMail 通过 SMTP 发送纯文本邮件
+func sendMail(ctx context.Context, c NotifierConfig, n notification) error {
+	port := c.Port
+	if port == 0 {
+		port = 587
+		if c.TLS == "tls" {
+			port = 465
+		}
+	}
+	addr := net.JoinHostPort(c.Host, strconv.Itoa(port))
+
+	dialer := &net.Dialer{}
+	var conn net.Conn
+	var err error
+	if c.TLS == "tls" {
+		conn, err = (&tls.Dialer{NetDialer: dialer, Config: &tls.Config{ServerName: c.Host}}).DialContext(ctx, "tcp", addr)
+	} else {
+		conn, err = dialer.DialContext(ctx, "tcp", addr)
+	}
+	if err != nil {
+		return err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	client, err := smtp.NewClient(conn, c.Host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer client.Close()
+
+	if c.TLS != "tls" && c.TLS != "none" {
+		if ok, _ := client.Extension("STARTTLS"); ok {
+			if err := client.StartTLS(&tls.Config{ServerName: c.Host}); err != nil {
+				return err
+			}
+		}
+	}
+	if c.Username != "" {
+		if err := client.Auth(smtp.PlainAuth("", c.Username, c.Password, c.Host)); err != nil {
+			return err
+		}
+	}
+
+	from := firstNonEmpty(c.From, c.Username)
+	if err := client.Mail(from); err != nil {
+		return err
+	}
+	for _, to := range c.To {
+		if err := client.Rcpt(to); err != nil {
+			return err
+		}
+	}
+	w, err := client.Data()
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(w, "From: %s\r\nTo: %s\r\nSubject: %s\r\nDate: %s\r\nMIME-Version: 1.0\r\nContent-Type: text/plain; charset=UTF-8\r\nContent-Transfer-Encoding: 8bit\r\n\r\n%s\r\n",
+		from, strings.Join(c.To, ", "), mime.BEncoding.Encode("UTF-8", n.Title), time.Now().Format(time.RFC1123Z),
+		strings.ReplaceAll(n.Message, "\n", "\r\n"))
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return client.Quit()
+}
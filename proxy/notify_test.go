@@ -0,0 +1,153 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"mime"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/leganck/fn-qb-proxy/qbapi"
+)
+
+func TestNotifierConfigMatches(t *testing.T) {
+	tests := []struct {
+		name string
+		c    NotifierConfig
+		ev   torrentEvent
+		want bool
+	}{
+		{"default completed", NotifierConfig{}, torrentEvent{Type: torrentCompleted}, true},
+		{"default errored", NotifierConfig{}, torrentEvent{Type: torrentErrored}, true},
+		{"default added", NotifierConfig{}, torrentEvent{Type: torrentAdded}, false},
+		{"default stalled", NotifierConfig{}, torrentEvent{Type: torrentStalled}, false},
+		{"explicit added", NotifierConfig{Events: []string{torrentAdded}}, torrentEvent{Type: torrentAdded}, true},
+		{"explicit excludes completed", NotifierConfig{Events: []string{torrentAdded}}, torrentEvent{Type: torrentCompleted}, false},
+		{"user", NotifierConfig{Users: []string{"alice"}}, torrentEvent{Type: torrentCompleted, User: "alice"}, true},
+		{"other user", NotifierConfig{Users: []string{"alice"}}, torrentEvent{Type: torrentCompleted, User: "bob"}, false},
+	}
+	for _, tt := range tests {
+		if got := tt.c.matches(tt.ev); got != tt.want {
+			t.Errorf("%s: matches = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+// notifyRequest 测试服务收到的通知请求
+type notifyRequest struct {
+	path    string
+	header  http.Header
+	body    string
+	payload map[string]any
+}
+
+func newNotifyServer(t *testing.T) (string, <-chan notifyRequest) {
+	ch := make(chan notifyRequest, 10)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		req := notifyRequest{path: r.URL.Path, header: r.Header, body: string(body)}
+		json.Unmarshal(body, &req.payload)
+		ch <- req
+	}))
+	t.Cleanup(srv.Close)
+	return srv.URL, ch
+}
+
+func receive(t *testing.T, ch <-chan notifyRequest) notifyRequest {
+	t.Helper()
+	select {
+	case req := <-ch:
+		return req
+	case <-time.After(5 * time.Second):
+		t.Fatal("no notification received")
+		return notifyRequest{}
+	}
+}
+
+func TestHandleNotifyEvent(t *testing.T) {
+	base, ch := newNotifyServer(t)
+	old := config.Load()
+	config.Store(&Config{Notify: &NotifyConfig{
+		Message: "{name} ({size_human})",
+		Notifiers: []NotifierConfig{
+			{Name: "default", Type: "webhook", BaseURL: base + "/default"},
+			{Name: "custom", Type: "webhook", BaseURL: base + "/custom", Title: "完成: {name}", Users: []string{"alice"}},
+			{Name: "added", Type: "webhook", BaseURL: base + "/added", Events: []string{torrentAdded}},
+		},
+	}})
+	defer config.Store(old)
+
+	ev := torrentEvent{Type: torrentCompleted, User: "alice", Torrent: qbapi.Torrent{Hash: "aaa", Name: "Show", Size: 1536}}
+	handleNotifyEvent(ev)
+	got := make(map[string]notifyRequest)
+	for range 2 {
+		req := receive(t, ch)
+		got[req.path] = req
+	}
+	// 渠道的模板优先，其次是全局模板，最后是默认模板
+	if req := got["/default"]; req.payload["title"] != "[alice] completed: Show" || req.payload["message"] != "Show (1.5 KiB)" {
+		t.Errorf("default notification = %v", req.payload)
+	}
+	if req := got["/custom"]; req.payload["title"] != "完成: Show" || req.payload["message"] != "Show (1.5 KiB)" {
+		t.Errorf("custom notification = %v", req.payload)
+	}
+	if event, _ := got["/default"].payload["event"].(map[string]any); event["event"] != torrentCompleted || event["user"] != "alice" {
+		t.Errorf("notification event = %v", event)
+	}
+
+	// bob 不匹配 custom，completed 不匹配 added
+	ev.User = "bob"
+	handleNotifyEvent(ev)
+	if req := receive(t, ch); req.path != "/default" {
+		t.Errorf("bob's notification went to %s", req.path)
+	}
+	select {
+	case req := <-ch:
+		t.Errorf("unexpected notification to %s", req.path)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestNotifierRequests(t *testing.T) {
+	base, ch := newNotifyServer(t)
+	n := notification{Title: "标题", Message: "正文"}
+	tests := []struct {
+		c     NotifierConfig
+		check func(req notifyRequest) bool
+	}{
+		{NotifierConfig{Type: "telegram", BaseURL: base + "/", Token: "bot-token", ChatID: "42"}, func(req notifyRequest) bool {
+			return req.path == "/botbot-token/sendMessage" && req.payload["chat_id"] == "42" && req.payload["text"] == "标题\n\n正文"
+		}},
+		{NotifierConfig{Type: "bark", BaseURL: base, Key: "device"}, func(req notifyRequest) bool {
+			return req.path == "/push" && req.payload["device_key"] == "device" && req.payload["title"] == "标题" && req.payload["body"] == "正文"
+		}},
+		{NotifierConfig{Type: "ntfy", BaseURL: base, Topic: "downloads", Token: "tk"}, func(req notifyRequest) bool {
+			title, _ := new(mime.WordDecoder).DecodeHeader(req.header.Get("Title"))
+			return req.path == "/downloads" && title == "标题" && req.header.Get("Authorization") == "Bearer tk" && req.body == "正文"
+		}},
+		{NotifierConfig{Type: "serverchan", BaseURL: base, Key: "SCT1"}, func(req notifyRequest) bool {
+			return req.path == "/SCT1.send" && req.body == "desp=%E6%AD%A3%E6%96%87&title=%E6%A0%87%E9%A2%98"
+		}},
+	}
+	for _, tt := range tests {
+		nf, err := newNotifier(tt.c)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.c.Type, err)
+		}
+		if err := nf.send(context.Background(), n); err != nil {
+			t.Fatalf("%s: %v", tt.c.Type, err)
+		}
+		if req := receive(t, ch); !tt.check(req) {
+			t.Errorf("%s: unexpected request %s %v %q", tt.c.Type, req.path, req.header, req.body)
+		}
+	}
+
+	for _, c := range []NotifierConfig{{Type: "webhook"}, {Type: "smtp", Host: "mail"}, {Type: "pigeon"}} {
+		if _, err := newNotifier(c); err == nil {
+			t.Errorf("newNotifier(%+v) succeeded", c)
+		}
+	}
+}
@@ -0,0 +1,297 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+
+	"github.com/leganck/fn-qb-proxy/qbapi"
+	"github.com/sirupsen/logrus"
+	"github.com/urfave/cli/v2"
+)
+
+// OrphansConfig orphans 命令的默认参数，命令行参数优先
+type OrphansConfig struct {
+	Roots   []string `json:"roots"`   // 要检查的下载目录，为空时使用各实例的默认保存路径
+	Exclude []string `json:"exclude"` // 排除的文件或目录，按文件名或相对路径匹配（filepath.Match 语法）
+	Trash   string   `json:"trash"`   // --move-to-trash 时使用的回收站目录，应与下载目录在同一文件系统
+}
+
+func (c *OrphansConfig) validate() error {
+	return validateExcludes(c.Exclude)
+}
+
+func validateExcludes(patterns []string) error {
+	for _, p := range patterns {
+		if _, err := filepath.Match(p, ""); err != nil {
+			return fmt.Errorf("invalid exclude pattern %q: %w", p, err)
+		}
+	}
+	return nil
+}
+
+// torrentRefs 所有实例中种子引用的文件，路径中的符号链接均已解析
+type torrentRefs struct {
+	files    map[string]bool
+	trees    []string // 无法获取文件列表的种子（如尚未获取元数据）按整个 content_path 处理
+	saveDirs []string // 运行中实例的默认保存路径以及种子的保存路径与临时目录
+	defaults []string // 各实例的默认保存路径，未指定根目录时检查这些目录
+}
+
+// covers 判断根目录是否位于某个运行中实例的保存路径之内；
+// 其它位置可能存放未运行实例的数据，这些文件会全部被当成孤立文件
+func (r *torrentRefs) covers(root string) bool {
+	for _, dir := range r.saveDirs {
+		if isWithin(root, dir) {
+			return true
+		}
+	}
+	return false
+}
+
+// resolvePath 解析路径中的符号链接，路径不存在时只做清理；
+// qBittorrent 报告的路径与根目录可能经由不同的符号链接指向同一位置
+func resolvePath(path string) string {
+	if real, err := filepath.EvalSymlinks(path); err == nil {
+		return real
+	}
+	return filepath.Clean(path)
+}
+
+func (r *torrentRefs) contains(path string) bool {
+	if r.files[path] {
+		return true
+	}
+	for _, tree := range r.trees {
+		if path == tree || strings.HasPrefix(path, tree+string(filepath.Separator)) {
+			return true
+		}
+	}
+	return false
+}
+
+// collectTorrentRefs 收集种子引用的文件，任一实例失败时返回错误，否则它的文件都会被误判为孤立文件
+func collectTorrentRefs(ctx context.Context, clients map[string]*qbapi.Client) (*torrentRefs, error) {
+	refs := &torrentRefs{files: make(map[string]bool)}
+	// 大多数种子共用几个保存路径，缓存解析结果
+	resolved := make(map[string]string)
+	resolveDir := func(dir string) string {
+		real, ok := resolved[dir]
+		if !ok {
+			real = resolvePath(dir)
+			resolved[dir] = real
+			refs.saveDirs = append(refs.saveDirs, real)
+		}
+		return real
+	}
+	for username, client := range clients {
+		path, err := client.DefaultSavePath(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("get default save path of user %s: %w", username, err)
+		}
+		refs.defaults = append(refs.defaults, resolveDir(path))
+		torrents, err := client.Torrents(ctx, nil)
+		if err != nil {
+			return nil, fmt.Errorf("list torrents of user %s: %w", username, err)
+		}
+		for _, t := range torrents {
+			content := ""
+			if t.ContentPath != "" {
+				content = resolvePath(t.ContentPath)
+				refs.files[content] = true
+			}
+			files, err := client.Files(ctx, t.Hash)
+			if err != nil || len(files) == 0 {
+				if content == "" {
+					return nil, fmt.Errorf("no files or content path for torrent %s of user %s", t.Hash, username)
+				}
+				refs.trees = append(refs.trees, content)
+				continue
+			}
+			// 未完成的种子可能在临时目录中，文件名可能带有 .!qB 后缀
+			bases := []string{resolveDir(t.SavePath)}
+			if t.DownloadPath != "" {
+				bases = append(bases, resolveDir(t.DownloadPath))
+			}
+			for _, base := range bases {
+				for _, f := range files {
+					path := filepath.Join(base, f.Name)
+					refs.files[path] = true
+					refs.files[path+".!qB"] = true
+				}
+			}
+		}
+		logrus.Debugf("Orphans: collected %d torrents of user %s", len(torrents), username)
+	}
+	return refs, nil
+}
+
+// orphanFile 未被任何种子引用的文件
+type orphanFile struct {
+	path string
+	size int64
+}
+
+// excluded 按文件名或相对于根目录的路径匹配排除规则
+func excluded(patterns []string, root, path string) bool {
+	rel, _ := filepath.Rel(root, path)
+	for _, p := range patterns {
+		if ok, _ := filepath.Match(p, filepath.Base(path)); ok {
+			return true
+		}
+		if ok, _ := filepath.Match(p, rel); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// findOrphans 遍历根目录，返回未被引用的普通文件，skip 中的目录（如回收站）不会遍历
+func findOrphans(root string, refs *torrentRefs, patterns []string, skip string) ([]orphanFile, error) {
+	var orphans []orphanFile
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			logrus.Warnf("Orphans: %v", err)
+			if d != nil && d.IsDir() {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		if (path != root && excluded(patterns, root, path)) || (skip != "" && path == skip) {
+			if d.IsDir() {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		if !d.Type().IsRegular() || refs.contains(path) {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return nil
+		}
+		orphans = append(orphans, orphanFile{path: path, size: info.Size()})
+		return nil
+	})
+	return orphans, err
+}
+
+// moveToTrash 将文件移到回收站中相同的路径下，并删除因此变空的目录（根目录除外）
+func moveToTrash(trash, root, path string) error {
+	dst := filepath.Join(trash, path)
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+	if err := os.Rename(path, dst); err != nil {
+		if errors.Is(err, syscall.EXDEV) {
+			return fmt.Errorf("trash %s is not on the same filesystem as %s", trash, path)
+		}
+		return err
+	}
+	removeEmptyDirs(filepath.Dir(path), root)
+	return nil
+}
+
+// orphansCommand 列出下载目录中未被任何实例的种子引用的文件，可选移到回收站
+func orphansCommand(c *cli.Context) error {
+	cfg := &OrphansConfig{}
+	if path := c.String(CONFIG); path != "" {
+		full, err := loadConfig(path)
+		if err != nil {
+			return err
+		}
+		if full.Orphans != nil {
+			cfg = full.Orphans
+		}
+	}
+	roots := c.StringSlice("root")
+	if len(roots) == 0 {
+		roots = cfg.Roots
+	}
+	patterns := append(append([]string{}, cfg.Exclude...), c.StringSlice("exclude")...)
+	if err := validateExcludes(patterns); err != nil {
+		return err
+	}
+	trash := ""
+	if c.Bool("move-to-trash") {
+		trash = c.String("trash")
+		if trash == "" {
+			trash = cfg.Trash
+		}
+		if trash == "" {
+			return fmt.Errorf("--move-to-trash requires --trash (or orphans.trash in the config file)")
+		}
+		abs, err := filepath.Abs(trash)
+		if err != nil {
+			return err
+		}
+		trash = resolvePath(abs)
+	}
+
+	clients, err := proxyClients(c.String(PROXY_SOCKET_DIR), nil)
+	if err != nil {
+		return err
+	}
+	refs, err := collectTorrentRefs(c.Context, clients)
+	if err != nil {
+		return err
+	}
+	if len(roots) == 0 {
+		roots = refs.defaults
+	}
+	for i, root := range roots {
+		abs, err := filepath.Abs(root)
+		if err != nil {
+			return err
+		}
+		roots[i] = resolvePath(abs)
+		if trash != "" && !refs.covers(roots[i]) {
+			return fmt.Errorf("%s is not within the save path of any running instance, refusing to move files to trash", root)
+		}
+	}
+
+	seen := make(map[string]bool)
+	var total, moved int64
+	count := 0
+	for _, root := range roots {
+		orphans, err := findOrphans(root, refs, patterns, trash)
+		if err != nil {
+			logrus.Warnf("Orphans: skipped %s: %v", root, err)
+			continue
+		}
+		sort.Slice(orphans, func(i, j int) bool { return orphans[i].path < orphans[j].path })
+		var size int64
+		n := 0
+		for _, o := range orphans {
+			// 根目录可能互相包含
+			if seen[o.path] {
+				continue
+			}
+			seen[o.path] = true
+			fmt.Fprintf(c.App.Writer, "%10s  %s\n", humanSize(o.size), o.path)
+			size += o.size
+			n++
+			if trash != "" {
+				if err := moveToTrash(trash, root, o.path); err != nil {
+					logrus.Errorf("Orphans: failed to move %s to trash: %v", o.path, err)
+					continue
+				}
+				moved += o.size
+			}
+		}
+		logrus.Infof("%s: %d orphaned files, %s", root, n, humanSize(size))
+		total += size
+		count += n
+	}
+	logrus.Infof("Total: %d orphaned files, %s", count, humanSize(total))
+	if trash != "" {
+		logrus.Infof("Moved %s to %s", humanSize(moved), trash)
+	}
+	return nil
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/leganck/fn-qb-proxy/qbapi"
+)
+
+func TestOrphansThroughSymlinks(t *testing.T) {
+	base := resolvePath(t.TempDir())
+	real := filepath.Join(base, "vol1", "downloads")
+	os.MkdirAll(filepath.Join(real, "show"), 0755)
+	os.MkdirAll(filepath.Join(base, "vol2"), 0755)
+	os.WriteFile(filepath.Join(real, "show", "e01.mkv"), []byte("e01"), 0644)
+	os.WriteFile(filepath.Join(real, "orphan.mkv"), []byte("orphan"), 0644)
+	// qBittorrent 通过符号链接访问下载目录
+	link := filepath.Join(base, "downloads")
+	if err := os.Symlink(real, link); err != nil {
+		t.Fatal(err)
+	}
+
+	client := newFakeQB(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case qbapi.DefaultSavePathPath:
+			w.Write([]byte(link))
+		case qbapi.TorrentsInfoPath:
+			json.NewEncoder(w).Encode([]map[string]string{{
+				"hash": "abc", "save_path": link, "content_path": filepath.Join(link, "show"),
+			}})
+		case qbapi.FilesPath:
+			w.Write([]byte(`[{"name":"show/e01.mkv"}]`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	refs, err := collectTorrentRefs(context.Background(), map[string]*qbapi.Client{"alice": client})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	root := resolvePath(link)
+	if root != real {
+		t.Fatalf("resolvePath(%q) = %q, want %q", link, root, real)
+	}
+	orphans, err := findOrphans(root, refs, nil, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(orphans) != 1 || orphans[0].path != filepath.Join(real, "orphan.mkv") {
+		t.Errorf("findOrphans() = %v, want only orphan.mkv", orphans)
+	}
+
+	if len(refs.defaults) != 1 || refs.defaults[0] != real {
+		t.Errorf("defaults = %v, want [%s]", refs.defaults, real)
+	}
+	if !refs.covers(filepath.Join(real, "show")) {
+		t.Error("directory within the save path is not covered")
+	}
+	for _, dir := range []string{filepath.Join(base, "vol2"), filepath.Join(base, "vol1")} {
+		if refs.covers(dir) {
+			t.Errorf("%s outside the save paths is covered", dir)
+		}
+	}
+}
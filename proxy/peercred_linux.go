@@ -0,0 +1,22 @@
+package main
+
+import (
+	"net"
+	"syscall"
+)
+
+// peerUID 通过 SO_PEERCRED 获取 Unix Socket 对端进程的 uid
+func peerUID(c *net.UnixConn) (uint32, bool) {
+	raw, err := c.SyscallConn()
+	if err != nil {
+		return 0, false
+	}
+	var uid uint32
+	ok := false
+	raw.Control(func(fd uintptr) {
+		if cred, err := syscall.GetsockoptUcred(int(fd), syscall.SOL_SOCKET, syscall.SO_PEERCRED); err == nil {
+			uid, ok = cred.Uid, true
+		}
+	})
+	return uid, ok
+}
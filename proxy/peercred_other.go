@@ -0,0 +1,10 @@
+//go:build !linux
+
+package main
+
+import "net"
+
+// peerUID 非 Linux 平台不支持，客户端身份统一为 unknown
+func peerUID(c *net.UnixConn) (uint32, bool) {
+	return 0, false
+}
@@ -14,6 +14,7 @@ import (
 	"sync"
 	"time"
 
+	"github.com/leganck/fn-qb-proxy/qbapi"
 	"github.com/sirupsen/logrus"
 )
 
@@ -140,6 +141,17 @@ func createProxyHandler(username string, proxy *httputil.ReverseProxy) http.Hand
 			r.ContentLength = int64(len(body))
 		}
 
+		// 拦截添加种子请求：记录来源客户端并执行添加前检查
+		if strings.Contains(path, qbapi.TorrentsAddPath) && r.Method == http.MethodPost {
+			if !checkAddRequest(username, w, r) {
+				return
+			}
+		}
+
+		if inst := getInstance(username); inst != nil {
+			serveCached(inst, w, r, proxy)
+			return
+		}
 		proxy.ServeHTTP(w, r)
 	})
 }
@@ -229,12 +241,14 @@ func createUserProxy(username string) {
 		return
 	}
 
-	// 创建反向代理
+	// 创建反向代理及实例状态
 	proxy := createProxy(username)
+	addInstance(username, cred)
 
 	// 启动服务器，使用拦截器包装
 	server := &http.Server{
-		Handler: createProxyHandler(username, proxy),
+		Handler:     withRateLimit(username, createProxyHandler(username, proxy)),
+		ConnContext: peerConnContext,
 	}
 
 	// 保存服务器引用
@@ -249,7 +263,9 @@ func createUserProxy(username string) {
 
 		// 服务器关闭后清理
 		serverMutex.Lock()
-		delete(userServers, user)
+		if userServers[user] == srv {
+			delete(userServers, user)
+		}
 		serverMutex.Unlock()
 		os.Remove(sockPath)
 	}(username, newSocketPath, server, listener)
@@ -283,6 +299,7 @@ func removeUserProxy(username string) {
 	}
 
 	delete(userServers, username)
+	removeInstance(username)
 	logrus.Infof("Proxy server removed for user %s", username)
 }
 
@@ -305,6 +322,7 @@ func cleanupAllProxies() {
 		}
 
 		delete(userServers, username)
+		removeInstance(username)
 	}
 	credsMutex.RUnlock()
 }
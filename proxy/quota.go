@@ -0,0 +1,254 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// 超出配额的种子的处理方式
+const (
+	quotaPause  = "pause"
+	quotaRemove = "remove"
+)
+
+// QuotaConfig 按用户的存储配额
+type QuotaConfig struct {
+	Default QuotaLimit            `json:"default"`
+	Users   map[string]QuotaLimit `json:"users"`  // 按用户单独设置，整体替换 default
+	Action  string                `json:"action"` // 获取元数据后才知道大小的种子超出配额时的处理：pause（默认）或 remove
+	Tag     string                `json:"tag"`    // 因超出配额暂停的种子添加的标签，默认 over-quota
+}
+
+// QuotaLimit 配额限制，0 表示不限制
+type QuotaLimit struct {
+	MaxBytes    ByteSize `json:"max_bytes"`    // 所有种子选中文件的总大小
+	MaxTorrents int      `json:"max_torrents"` // 种子数量
+}
+
+func (c *QuotaConfig) validate() error {
+	switch c.Action {
+	case "", quotaPause, quotaRemove:
+		return nil
+	}
+	return fmt.Errorf("unknown action %q", c.Action)
+}
+
+func (c *QuotaConfig) limitFor(username string) QuotaLimit {
+	if l, ok := c.Users[username]; ok {
+		return l
+	}
+	return c.Default
+}
+
+func (c *QuotaConfig) tag() string {
+	if c.Tag != "" {
+		return c.Tag
+	}
+	return "over-quota"
+}
+
+// quotaUsage 用户当前的占用
+type quotaUsage struct {
+	Torrents int   `json:"torrents"`
+	Bytes    int64 `json:"bytes"`
+	hashes   map[string]bool
+}
+
+// usageOf 统计实例中所有种子的数量与大小，未获取元数据的磁力链接大小为 0
+func usageOf(state *syncState) quotaUsage {
+	u := quotaUsage{hashes: make(map[string]bool)}
+	for _, t := range state.torrentList() {
+		u.Torrents++
+		u.hashes[t.Hash] = true
+		if t.Size > 0 {
+			u.Bytes += t.Size
+		} else {
+			u.Bytes += t.TotalSize
+		}
+	}
+	return u
+}
+
+// exceeds 返回超出的限制，未超出时返回空字符串
+func (l QuotaLimit) exceeds(torrents int, bytes int64) string {
+	if l.MaxTorrents > 0 && torrents > l.MaxTorrents {
+		return fmt.Sprintf("%d torrents > %d", torrents, l.MaxTorrents)
+	}
+	if l.MaxBytes > 0 && bytes > int64(l.MaxBytes) {
+		return fmt.Sprintf("%s > %s", humanSize(bytes), humanSize(int64(l.MaxBytes)))
+	}
+	return ""
+}
+
+// admittedAdds 添加时已按大小检查过的种子，出现后不再重复检查，key 为 用户名/infohash
+var (
+	admittedAdds   = make(map[string]time.Time)
+	admittedAddsMu sync.Mutex
+)
+
+// checkQuotaForAdd 添加请求会超出配额时拒绝，返回 false 表示已拒绝
+// 磁力链接与 http(s) 链接在这里只计入数量，大小等获取元数据后再检查；
+// ctx 为请求的上下文，客户端断开后不再等待实例的状态
+func checkQuotaForAdd(ctx context.Context, username string, add *addRequest, w http.ResponseWriter) bool {
+	cfg := currentConfig().Quotas
+	if cfg == nil {
+		return true
+	}
+	limit := cfg.limitFor(username)
+	if limit == (QuotaLimit{}) {
+		return true
+	}
+	inst := getInstance(username)
+	if inst == nil {
+		return true
+	}
+	state, err := inst.hub.Snapshot(ctx, syncInterval)
+	if err != nil {
+		logrus.Warnf("Quota: failed to get usage for user %s: %v", username, err)
+		return true
+	}
+	usage := usageOf(state)
+
+	// 已存在的种子不会重复添加，不计入
+	torrents, bytes := usage.Torrents+len(add.URLs), usage.Bytes
+	var sized []string
+	for _, t := range add.Torrents {
+		if usage.hashes[t.Hash()] {
+			continue
+		}
+		torrents++
+		bytes += t.TotalSize
+		if t.TotalSize > 0 {
+			sized = append(sized, t.Hash())
+		}
+	}
+	if reason := limit.exceeds(torrents, bytes); reason != "" {
+		logrus.Warnf("Quota: rejected add request for user %s: %s", username, reason)
+		http.Error(w, "Quota exceeded: "+reason, http.StatusInsufficientStorage)
+		return false
+	}
+
+	admittedAddsMu.Lock()
+	now := time.Now()
+	for key, at := range admittedAdds {
+		if now.Sub(at) > addClientTTL {
+			delete(admittedAdds, key)
+		}
+	}
+	for _, hash := range sized {
+		admittedAdds[username+"/"+hash] = now
+	}
+	admittedAddsMu.Unlock()
+	return true
+}
+
+// handleQuotaEvent 大小未知的种子获取元数据后检查配额
+func handleQuotaEvent(ev torrentEvent) {
+	cfg := currentConfig().Quotas
+	if cfg == nil {
+		return
+	}
+	switch ev.Type {
+	case torrentAdded:
+		if isMetaState(ev.Torrent.State) {
+			return
+		}
+	case torrentMetadata:
+	default:
+		return
+	}
+
+	key := ev.User + "/" + ev.Torrent.Hash
+	admittedAddsMu.Lock()
+	_, admitted := admittedAdds[key]
+	delete(admittedAdds, key)
+	admittedAddsMu.Unlock()
+	if admitted || cfg.limitFor(ev.User).MaxBytes <= 0 {
+		return
+	}
+	go enforceQuota(cfg, ev)
+}
+
+func enforceQuota(cfg *QuotaConfig, ev torrentEvent) {
+	inst := getInstance(ev.User)
+	if inst == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	state, err := inst.hub.Snapshot(ctx, syncInterval)
+	if err != nil {
+		logrus.Warnf("Quota: failed to get usage for user %s: %v", ev.User, err)
+		return
+	}
+	usage := usageOf(state)
+	// 只比较大小，数量已在添加时检查
+	reason := cfg.limitFor(ev.User).exceeds(0, usage.Bytes)
+	if reason == "" {
+		return
+	}
+
+	hashes := []string{ev.Torrent.Hash}
+	action := quotaPause
+	if cfg.Action == quotaRemove {
+		// 刚获取到元数据，删除已下载的少量数据以免留下残缺文件
+		action = quotaRemove
+		err = inst.client.Delete(ctx, hashes, true)
+	} else if err = inst.client.AddTags(ctx, hashes, []string{cfg.tag()}); err == nil {
+		err = inst.client.Stop(ctx, hashes)
+	}
+	if err != nil {
+		logrus.Errorf("Quota: failed to %s torrent %s for user %s: %v", action, ev.Torrent.Hash, ev.User, err)
+		return
+	}
+	logrus.Warnf("Quota: %s torrent %s (%s) for user %s: %s", action, ev.Torrent.Hash, ev.Torrent.Name, ev.User, reason)
+}
+
+func init() {
+	adminMux.HandleFunc("GET /admin/quotas", handleAdminQuotas)
+}
+
+// quotaReport 管理接口返回的每个实例的配额使用情况
+type quotaReport struct {
+	Instance string `json:"instance"`
+	quotaUsage
+	MaxTorrents int    `json:"max_torrents"`
+	MaxBytes    int64  `json:"max_bytes"`
+	Exceeded    string `json:"exceeded,omitempty"`
+}
+
+func handleAdminQuotas(w http.ResponseWriter, r *http.Request) {
+	var cfg QuotaConfig
+	if c := currentConfig().Quotas; c != nil {
+		cfg = *c
+	}
+	list := adminInstances(r)
+	reports, failed := fanOut(r.Context(), list, func(ctx context.Context, inst *qbInstance) (quotaReport, error) {
+		state, err := inst.hub.Snapshot(ctx, syncInterval)
+		if err != nil {
+			return quotaReport{}, err
+		}
+		usage := usageOf(state)
+		limit := cfg.limitFor(inst.username)
+		return quotaReport{
+			Instance:    inst.username,
+			quotaUsage:  usage,
+			MaxTorrents: limit.MaxTorrents,
+			MaxBytes:    int64(limit.MaxBytes),
+			Exceeded:    limit.exceeds(usage.Torrents, usage.Bytes),
+		}, nil
+	})
+	if !reportFailed(w, failed, len(list)) {
+		return
+	}
+	if reports == nil {
+		reports = []quotaReport{}
+	}
+	writeAdminJSON(w, reports)
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestQuotaLimitExceeds(t *testing.T) {
+	tests := []struct {
+		limit    QuotaLimit
+		torrents int
+		bytes    int64
+		want     string
+	}{
+		{limit: QuotaLimit{}, torrents: 1000, bytes: 1 << 40, want: ""},
+		{limit: QuotaLimit{MaxTorrents: 2}, torrents: 2, want: ""},
+		{limit: QuotaLimit{MaxTorrents: 2}, torrents: 3, want: "3 torrents > 2"},
+		{limit: QuotaLimit{MaxBytes: 1024}, bytes: 1024, want: ""},
+		{limit: QuotaLimit{MaxBytes: 1024}, bytes: 2048, want: "2.0 KiB > 1.0 KiB"},
+		// 数量先于大小检查
+		{limit: QuotaLimit{MaxTorrents: 1, MaxBytes: 1}, torrents: 2, bytes: 2, want: "2 torrents > 1"},
+	}
+	for _, tt := range tests {
+		if got := tt.limit.exceeds(tt.torrents, tt.bytes); got != tt.want {
+			t.Errorf("%+v.exceeds(%d, %d) = %q, want %q", tt.limit, tt.torrents, tt.bytes, got, tt.want)
+		}
+	}
+}
+
+func TestUsageOf(t *testing.T) {
+	state := newSyncState()
+	state.Torrents["aaa"] = map[string]any{"size": 100.0, "total_size": 300.0}
+	// 未选择文件大小时使用总大小，磁力链接为 0
+	state.Torrents["bbb"] = map[string]any{"total_size": 50.0}
+	state.Torrents["ccc"] = map[string]any{}
+	u := usageOf(state)
+	if u.Torrents != 3 || u.Bytes != 150 || !u.hashes["ccc"] {
+		t.Errorf("usageOf() = %+v, want 3 torrents and 150 bytes", u)
+	}
+}
+
+// maindataHandler 返回只包含给定种子的完整 maindata
+func maindataHandler(torrents string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `{"rid":1,"full_update":true,"torrents":{%s}}`, torrents)
+	})
+}
+
+// addRequestFor 构造上传 .torrent 文件与磁力链接的添加请求
+func addRequestFor(files [][]byte, magnets ...string) *http.Request {
+	var buf bytes.Buffer
+	mw := multipart.NewWriter(&buf)
+	mw.WriteField("urls", strings.Join(magnets, "\n"))
+	for i, data := range files {
+		fw, _ := mw.CreateFormFile("torrents", fmt.Sprintf("%d.torrent", i))
+		fw.Write(data)
+	}
+	mw.Close()
+	r := httptest.NewRequest(http.MethodPost, "/api/v2/torrents/add", &buf)
+	r.Header.Set("Content-Type", mw.FormDataContentType())
+	return r
+}
+
+func TestCheckAddRequestQuota(t *testing.T) {
+	existing, existingHash := testTorrent("existing", 600)
+	small, _ := testTorrent("small", 300)
+	big, _ := testTorrent("big", 500)
+	bobs, bobsHash := testTorrent("bobs", 100)
+	magnet := func(c string) string { return "magnet:?xt=urn:btih:" + strings.Repeat(c, 40) }
+
+	addFakeInstance(t, "quota-alice", maindataHandler(fmt.Sprintf(`%q:{"name":"existing","size":600}`, existingHash)))
+	addFakeInstance(t, "quota-bob", maindataHandler(fmt.Sprintf(`%q:{"name":"bobs","size":100}`, bobsHash)))
+	old := config.Load()
+	config.Store(&Config{
+		Quotas:     &QuotaConfig{Users: map[string]QuotaLimit{"quota-alice": {MaxTorrents: 2, MaxBytes: 1000}}},
+		Duplicates: &DuplicatesConfig{Action: duplicateReject},
+	})
+	defer config.Store(old)
+
+	tests := []struct {
+		name    string
+		files   [][]byte
+		magnets []string
+		want    int
+	}{
+		{name: "within quota", files: [][]byte{small}, want: http.StatusOK},
+		{name: "too large", files: [][]byte{big}, want: http.StatusInsufficientStorage},
+		{name: "too many", magnets: []string{magnet("a"), magnet("b")}, want: http.StatusInsufficientStorage},
+		// 实例中已有的种子不计入
+		{name: "already in own instance", files: [][]byte{existing, small}, want: http.StatusOK},
+		// 其它用户已有的种子先按重复拒绝
+		{name: "duplicate", files: [][]byte{bobs, big}, want: http.StatusConflict},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		ok := checkAddRequest("quota-alice", w, addRequestFor(tt.files, tt.magnets...))
+		if ok != (tt.want == http.StatusOK) || w.Code != tt.want {
+			t.Errorf("%s: checkAddRequest() = %v, status %d, want %d", tt.name, ok, w.Code, tt.want)
+		}
+	}
+}
+
+func TestCheckQuotaForAddCanceled(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+	addFakeInstance(t, "quota-slow", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}))
+	old := config.Load()
+	config.Store(&Config{Quotas: &QuotaConfig{Default: QuotaLimit{MaxTorrents: 1}}})
+	defer config.Store(old)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	add := &addRequest{URLs: []string{(&url.URL{Scheme: "https", Host: "example.org"}).String()}}
+	// 无法得到占用时放行，但不应等待到上游超时
+	if !checkQuotaForAdd(ctx, "quota-slow", add, httptest.NewRecorder()) {
+		t.Error("request was rejected without usage")
+	}
+	if d := time.Since(start); d > 5*time.Second {
+		t.Errorf("checkQuotaForAdd() took %v after the request was canceled", d)
+	}
+}
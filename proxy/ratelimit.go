@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"os/user"
+	"strconv"
+	"strings"
+
+	"github.com/leganck/fn-qb-proxy/ratelimit"
+)
+
+// 客户端维度与 qBittorrent 实例维度的限流器，未配置时为 nil
+var (
+	clientLimiter   *ratelimit.Limiter
+	upstreamLimiter *ratelimit.Limiter
+)
+
+type peerKeyCtx struct{}
+
+// trustedForwarders 可信的 HTTP 网关（如 fn-qb-http）的对端身份，只采用它们转发的 X-Forwarded-For
+var trustedForwarders = make(map[string]bool)
+
+// initTrustedForwarders 解析以逗号分隔的 uid 或用户名
+func initTrustedForwarders(spec string) error {
+	for _, v := range strings.FieldsFunc(spec, func(r rune) bool { return r == ',' || r == ' ' }) {
+		uid := v
+		if _, err := strconv.Atoi(v); err != nil {
+			u, err := user.Lookup(v)
+			if err != nil {
+				return fmt.Errorf("trusted forwarder %q: %w", v, err)
+			}
+			uid = u.Uid
+		}
+		trustedForwarders["uid:"+uid] = true
+	}
+	return nil
+}
+
+// initRateLimiters 根据命令行参数初始化限流器
+func initRateLimiters(clientSpec, upstreamSpec string) error {
+	clientLimits, err := ratelimit.Parse(clientSpec)
+	if err != nil {
+		return fmt.Errorf("client rate limit: %w", err)
+	}
+	upstreamLimits, err := ratelimit.Parse(upstreamSpec)
+	if err != nil {
+		return fmt.Errorf("upstream rate limit: %w", err)
+	}
+	clientLimiter = ratelimit.New(clientLimits)
+	upstreamLimiter = ratelimit.New(upstreamLimits)
+	return nil
+}
+
+// peerConnContext 在连接建立时记录 Unix Socket 对端的 uid，作为客户端身份
+func peerConnContext(ctx context.Context, c net.Conn) context.Context {
+	key := "unknown"
+	if uc, ok := c.(*net.UnixConn); ok {
+		if uid, ok := peerUID(uc); ok {
+			key = fmt.Sprintf("uid:%d", uid)
+		}
+	}
+	return context.WithValue(ctx, peerKeyCtx{}, key)
+}
+
+// forwardedFor 返回可信网关转发的客户端地址；其它对端可以随意伪造 X-Forwarded-For，忽略
+func forwardedFor(r *http.Request) string {
+	peer, _ := r.Context().Value(peerKeyCtx{}).(string)
+	if !trustedForwarders[peer] {
+		return ""
+	}
+	// 网关把自己看到的客户端地址追加在最后，之前的值来自客户端本身
+	xff := strings.Join(r.Header.Values("X-Forwarded-For"), ",")
+	return strings.TrimSpace(xff[strings.LastIndexByte(xff, ',')+1:])
+}
+
+// clientKey 提取客户端身份：优先使用可信 HTTP 网关转发的客户端地址，其次为对端 uid
+func clientKey(r *http.Request) string {
+	peer, _ := r.Context().Value(peerKeyCtx{}).(string)
+	if ip := forwardedFor(r); ip != "" {
+		return peer + "/" + ip
+	}
+	return peer
+}
+
+// withRateLimit 为用户代理包装客户端与实例两个维度的限流
+func withRateLimit(username string, next http.Handler) http.Handler {
+	return ratelimit.Middleware(next,
+		ratelimit.Scope{Name: "client", Limiter: clientLimiter, Key: clientKey},
+		ratelimit.Scope{Name: "upstream", Limiter: upstreamLimiter, Key: func(*http.Request) string { return username }},
+	)
+}
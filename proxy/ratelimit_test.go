@@ -0,0 +1,40 @@
+package main
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestForwardedFor(t *testing.T) {
+	if err := initTrustedForwarders("1000, 1001"); err != nil {
+		t.Fatal(err)
+	}
+	defer func() { trustedForwarders = make(map[string]bool) }()
+
+	tests := []struct {
+		peer string
+		xff  []string
+		want string
+	}{
+		{peer: "uid:1000", xff: []string{"192.168.1.20"}, want: "192.168.1.20"},
+		{peer: "uid:1001", xff: []string{"10.0.0.1, 192.168.1.20"}, want: "192.168.1.20"},
+		{peer: "uid:1000", xff: []string{"10.0.0.1", "192.168.1.20"}, want: "192.168.1.20"},
+		{peer: "uid:1000", want: ""},
+		{peer: "uid:0", xff: []string{"192.168.1.20"}, want: ""},
+		{peer: "unknown", xff: []string{"192.168.1.20"}, want: ""},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", "/api/v2/app/version", nil)
+		r = r.WithContext(context.WithValue(r.Context(), peerKeyCtx{}, tt.peer))
+		for _, v := range tt.xff {
+			r.Header.Add("X-Forwarded-For", v)
+		}
+		if got := forwardedFor(r); got != tt.want {
+			t.Errorf("forwardedFor(%s, %v) = %q, want %q", tt.peer, tt.xff, got, tt.want)
+		}
+	}
+	if err := initTrustedForwarders("no-such-user-xyz"); err == nil {
+		t.Error("unknown user was accepted")
+	}
+}
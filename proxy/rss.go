@@ -0,0 +1,723 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/leganck/fn-qb-proxy/metainfo"
+	"github.com/leganck/fn-qb-proxy/qbapi"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// rssMaxFeedSize 订阅内容与 .torrent 文件允许的最大大小
+	rssMaxFeedSize = 10 << 20
+	// rssStateRetention 去重记录的保留时间，订阅源一般不会保留更早的条目
+	rssStateRetention = 90 * 24 * time.Hour
+)
+
+// rssClient 用于获取订阅与下载 .torrent 文件，跳转到磁力链接时不再跟随
+var rssClient = &http.Client{
+	Timeout: time.Minute,
+	CheckRedirect: func(req *http.Request, via []*http.Request) error {
+		if req.URL.Scheme == "magnet" {
+			return http.ErrUseLastResponse
+		}
+		if len(via) >= 10 {
+			return errors.New("stopped after 10 redirects")
+		}
+		// 跳转到其它主机时不再携带订阅的自定义请求头
+		if !sameHost(req.URL, via[0].URL) {
+			req.Header = make(http.Header)
+		}
+		return nil
+	},
+}
+
+// RSSConfig 内置 RSS 自动下载，fnOS 隐藏了 qBittorrent 的 RSS 设置
+type RSSConfig struct {
+	Interval Duration  `json:"interval"` // 轮询间隔，默认 15m
+	State    string    `json:"state"`    // 去重记录文件，默认为配置文件目录下的 rss-state.json
+	Feeds    []RSSFeed `json:"feeds"`
+}
+
+// RSSFeed 一个订阅源，匹配的条目添加到 user 的实例
+type RSSFeed struct {
+	Name    string            `json:"name"`
+	URL     string            `json:"url"`
+	User    string            `json:"user"`
+	Headers map[string]string `json:"headers"` // 如私有站点的 Cookie，下载同一主机上的 .torrent 文件时同样使用
+	Rules   []RSSRule         `json:"rules"`   // 按顺序使用第一条匹配的规则
+}
+
+// RSSRule 下载规则，正则表达式均不区分大小写
+type RSSRule struct {
+	Name     string   `json:"name"`
+	Include  string   `json:"include"`  // 标题需要匹配的正则表达式，为空表示全部
+	Exclude  string   `json:"exclude"`  // 标题匹配时跳过
+	Episodes string   `json:"episodes"` // 剧集过滤，格式同 qBittorrent，如 1x1-10;2x5-;
+	MinSize  ByteSize `json:"min_size"`
+	MaxSize  ByteSize `json:"max_size"`
+	Category string   `json:"category"`
+	SavePath string   `json:"save_path"`
+	Tags     []string `json:"tags"`
+	Paused   bool     `json:"paused"`
+
+	include  *regexp.Regexp
+	exclude  *regexp.Regexp
+	episodes []episodeRange
+}
+
+// episodeRange 某一季的剧集范围，last 为 -1 表示该集之后以及之后所有季
+type episodeRange struct {
+	season, first, last int
+}
+
+func (c *RSSConfig) validate() error {
+	for i := range c.Feeds {
+		f := &c.Feeds[i]
+		if f.URL == "" {
+			return fmt.Errorf("feed %s: url is required", f.label(i))
+		}
+		if u, err := url.Parse(f.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+			return fmt.Errorf("feed %s: invalid url %q", f.label(i), f.URL)
+		}
+		if f.User == "" {
+			return fmt.Errorf("feed %s: user is required", f.label(i))
+		}
+		for j := range f.Rules {
+			if err := f.Rules[j].compile(); err != nil {
+				return fmt.Errorf("feed %s: rule %s: %w", f.label(i), f.Rules[j].label(j), err)
+			}
+		}
+	}
+	return nil
+}
+
+func (c *RSSConfig) statePath() string {
+	if c.State != "" {
+		return c.State
+	}
+	return filepath.Join(filepath.Dir(configPath), "rss-state.json")
+}
+
+func (f *RSSFeed) label(i int) string {
+	if f.Name != "" {
+		return f.Name
+	}
+	return fmt.Sprintf("#%d", i)
+}
+
+func (r *RSSRule) label(i int) string {
+	if r.Name != "" {
+		return r.Name
+	}
+	return fmt.Sprintf("#%d", i)
+}
+
+func (r *RSSRule) compile() error {
+	var err error
+	if r.Include != "" {
+		if r.include, err = regexp.Compile("(?i)" + r.Include); err != nil {
+			return fmt.Errorf("include: %w", err)
+		}
+	}
+	if r.Exclude != "" {
+		if r.exclude, err = regexp.Compile("(?i)" + r.Exclude); err != nil {
+			return fmt.Errorf("exclude: %w", err)
+		}
+	}
+	if r.episodes, err = parseEpisodeFilter(r.Episodes); err != nil {
+		return fmt.Errorf("episodes: %w", err)
+	}
+	if r.MaxSize > 0 && r.MinSize > r.MaxSize {
+		return errors.New("min_size is larger than max_size")
+	}
+	return nil
+}
+
+// parseEpisodeFilter 解析 qBittorrent 格式的剧集过滤：以 ; 分隔，
+// 每段为 季x集（1x5）、季x集-集（1x1-10）或 季x集-（1x25-，包括之后所有季）
+func parseEpisodeFilter(s string) ([]episodeRange, error) {
+	var ranges []episodeRange
+	for _, part := range strings.Split(s, ";") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		season, eps, ok := strings.Cut(strings.ToLower(part), "x")
+		if !ok {
+			return nil, fmt.Errorf("invalid range %q", part)
+		}
+		var r episodeRange
+		var err error
+		if r.season, err = strconv.Atoi(season); err != nil {
+			return nil, fmt.Errorf("invalid range %q", part)
+		}
+		first, last, isRange := strings.Cut(eps, "-")
+		if r.first, err = strconv.Atoi(first); err != nil {
+			return nil, fmt.Errorf("invalid range %q", part)
+		}
+		switch {
+		case !isRange:
+			r.last = r.first
+		case last == "":
+			r.last = -1
+		default:
+			if r.last, err = strconv.Atoi(last); err != nil || r.last < r.first {
+				return nil, fmt.Errorf("invalid range %q", part)
+			}
+		}
+		ranges = append(ranges, r)
+	}
+	return ranges, nil
+}
+
+// episodePatterns 从标题中识别季与集，如 S01E05、1x05
+var episodePatterns = []*regexp.Regexp{
+	regexp.MustCompile(`(?i)\bS(\d{1,2})[ ._-]?E(\d{1,4})`),
+	regexp.MustCompile(`\b(\d{1,2})x(\d{1,4})\b`),
+}
+
+func parseEpisode(title string) (season, episode int, ok bool) {
+	for _, re := range episodePatterns {
+		if m := re.FindStringSubmatch(title); m != nil {
+			season, _ = strconv.Atoi(m[1])
+			episode, _ = strconv.Atoi(m[2])
+			return season, episode, true
+		}
+	}
+	return 0, 0, false
+}
+
+func (r *RSSRule) matchesEpisode(title string) bool {
+	if len(r.episodes) == 0 {
+		return true
+	}
+	season, episode, ok := parseEpisode(title)
+	if !ok {
+		return false
+	}
+	for _, e := range r.episodes {
+		switch {
+		case e.last < 0 && (season > e.season || season == e.season && episode >= e.first):
+			return true
+		case season == e.season && episode >= e.first && episode <= e.last:
+			return true
+		}
+	}
+	return false
+}
+
+// matchesTitle 按标题判断是否匹配
+func (r *RSSRule) matchesTitle(title string) bool {
+	if r.include != nil && !r.include.MatchString(title) {
+		return false
+	}
+	if r.exclude != nil && r.exclude.MatchString(title) {
+		return false
+	}
+	return r.matchesEpisode(title)
+}
+
+// matchesSize 大小未知（为 0）时只有没有设置大小限制的规则匹配
+func (r *RSSRule) matchesSize(size int64) bool {
+	if r.MinSize <= 0 && r.MaxSize <= 0 {
+		return true
+	}
+	if size <= 0 {
+		return false
+	}
+	return (r.MinSize <= 0 || size >= int64(r.MinSize)) && (r.MaxSize <= 0 || size <= int64(r.MaxSize))
+}
+
+// feedItem 订阅中的一个条目
+type feedItem struct {
+	GUID  string
+	Title string
+	URL   string // .torrent 下载地址或磁力链接
+	Hash  string // 订阅提供或从磁力链接中解析的 infohash
+	Size  int64  // 订阅提供的大小，未知时为 0
+}
+
+// feedDocument 同时解析 RSS 2.0（包括 Torznab、Nyaa、Mikan 的扩展字段）与 Atom
+type feedDocument struct {
+	Items   []rssItemXML   `xml:"channel>item"`
+	Entries []atomEntryXML `xml:"entry"`
+}
+
+type rssItemXML struct {
+	Title     string `xml:"title"`
+	Link      string `xml:"link"`
+	GUID      string `xml:"guid"`
+	Enclosure struct {
+		URL    string `xml:"url,attr"`
+		Length int64  `xml:"length,attr"`
+	} `xml:"enclosure"`
+	Attrs []struct {
+		Name  string `xml:"name,attr"`
+		Value string `xml:"value,attr"`
+	} `xml:"attr"` // torznab:attr
+	InfoHash      string `xml:"infoHash"`              // nyaa:infoHash
+	ContentLength int64  `xml:"torrent>contentLength"` // Mikan
+}
+
+type atomEntryXML struct {
+	Title string `xml:"title"`
+	ID    string `xml:"id"`
+	Links []struct {
+		Href   string `xml:"href,attr"`
+		Rel    string `xml:"rel,attr"`
+		Type   string `xml:"type,attr"`
+		Length int64  `xml:"length,attr"`
+	} `xml:"link"`
+}
+
+// parseFeed 解析订阅内容，没有下载地址的条目会被忽略
+func parseFeed(data []byte) ([]feedItem, error) {
+	var doc feedDocument
+	if err := xml.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("parse feed: %w", err)
+	}
+	var items []feedItem
+	for _, x := range doc.Items {
+		item := feedItem{Title: strings.TrimSpace(x.Title), GUID: strings.TrimSpace(x.GUID), Hash: x.InfoHash, Size: x.ContentLength}
+		item.URL = x.Enclosure.URL
+		if item.URL == "" || strings.HasPrefix(x.Link, "magnet:") {
+			item.URL = x.Link
+		}
+		for _, a := range x.Attrs {
+			switch a.Name {
+			case "infohash":
+				item.Hash = a.Value
+			case "size":
+				item.Size, _ = strconv.ParseInt(a.Value, 10, 64)
+			case "magneturl":
+				if item.URL == "" {
+					item.URL = a.Value
+				}
+			}
+		}
+		// enclosure 的长度在部分站点是 .torrent 文件本身的大小，只在没有其他来源时使用
+		if item.Size == 0 && x.Enclosure.Length > 1<<20 {
+			item.Size = x.Enclosure.Length
+		}
+		items = append(items, item)
+	}
+	for _, x := range doc.Entries {
+		item := feedItem{Title: strings.TrimSpace(x.Title), GUID: strings.TrimSpace(x.ID)}
+		for _, l := range x.Links {
+			if l.Rel == "enclosure" || l.Type == "application/x-bittorrent" || strings.HasPrefix(l.Href, "magnet:") {
+				item.URL, item.Size = l.Href, l.Length
+				break
+			}
+		}
+		if item.URL == "" && len(x.Links) > 0 {
+			item.URL = x.Links[0].Href
+		}
+		items = append(items, item)
+	}
+
+	valid := items[:0]
+	for _, item := range items {
+		if item.URL == "" {
+			continue
+		}
+		if item.GUID == "" {
+			item.GUID = item.URL
+		}
+		if item.Hash == "" && strings.HasPrefix(item.URL, "magnet:") {
+			if t, err := metainfo.ParseMagnet(item.URL); err == nil {
+				item.Hash = t.Hash()
+				item.Size = max(item.Size, t.TotalSize)
+			}
+		}
+		item.Hash = strings.ToLower(item.Hash)
+		valid = append(valid, item)
+	}
+	return valid, nil
+}
+
+// rssUserState 一个用户已添加的条目，值为记录时间
+type rssUserState struct {
+	GUIDs  map[string]time.Time `json:"guids"`
+	Hashes map[string]time.Time `json:"hashes"`
+}
+
+func (s *rssUserState) seen(item *feedItem) bool {
+	_, ok := s.GUIDs[item.GUID]
+	return ok || s.seenHash(item.Hash)
+}
+
+func (s *rssUserState) seenHash(hash string) bool {
+	_, ok := s.Hashes[hash]
+	return hash != "" && ok
+}
+
+func (s *rssUserState) record(item *feedItem, now time.Time) {
+	s.GUIDs[item.GUID] = now
+	if item.Hash != "" {
+		s.Hashes[item.Hash] = now
+	}
+}
+
+// loadRSSState 读取去重记录，键为用户名，并清理过期的记录
+func loadRSSState(path string) (map[string]*rssUserState, error) {
+	state := make(map[string]*rssUserState)
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return state, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &state); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", path, err)
+	}
+	cutoff := time.Now().Add(-rssStateRetention)
+	for user, s := range state {
+		// 手工编辑或旧版本的记录中可能缺少字段，record 不能写入 nil map
+		if s == nil {
+			s = &rssUserState{}
+			state[user] = s
+		}
+		if s.GUIDs == nil {
+			s.GUIDs = make(map[string]time.Time)
+		}
+		if s.Hashes == nil {
+			s.Hashes = make(map[string]time.Time)
+		}
+		for _, m := range []map[string]time.Time{s.GUIDs, s.Hashes} {
+			for k, t := range m {
+				if t.Before(cutoff) {
+					delete(m, k)
+				}
+			}
+		}
+	}
+	return state, nil
+}
+
+func saveRSSState(path string, state map[string]*rssUserState) error {
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(path, data)
+}
+
+// rssFeedStatus 管理接口返回的订阅状态
+type rssFeedStatus struct {
+	Name     string    `json:"name"`
+	URL      string    `json:"url"`
+	User     string    `json:"user"`
+	LastPoll time.Time `json:"last_poll"`
+	Error    string    `json:"error,omitempty"`
+	Items    int       `json:"items"`
+	Added    int       `json:"added"` // 最近一次轮询添加的种子数
+}
+
+var (
+	// rssMu 保证同一时间只有一次轮询，避免重复添加
+	rssMu sync.Mutex
+	// rssStatus 用户/订阅序号 -> 最近一次轮询的状态，多个用户可能订阅同一个地址
+	rssStatus = make(map[string]*rssFeedStatus)
+	// rssSizes 订阅未提供大小时从 .torrent 文件得到的大小，避免每次轮询重复下载
+	rssSizes = make(map[string]int64)
+)
+
+// runRSS 定期轮询所有订阅
+func runRSS(ctx context.Context) {
+	for {
+		cfg := currentConfig().RSS
+		interval := 15 * time.Minute
+		if cfg != nil {
+			interval = cfg.Interval.Or(interval)
+			if !pollRSS(ctx, cfg) {
+				// 启动后尚未发现部分用户的 qBittorrent 实例，稍后再试
+				interval = min(interval, time.Minute)
+			}
+		}
+
+		select {
+		case <-time.After(interval):
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// pollRSS 轮询所有订阅并添加匹配的条目，去重记录在全部订阅处理完后写入
+// 有订阅的用户实例不存在时返回 false
+func pollRSS(ctx context.Context, cfg *RSSConfig) bool {
+	rssMu.Lock()
+	defer rssMu.Unlock()
+
+	path := cfg.statePath()
+	state, err := loadRSSState(path)
+	if err != nil {
+		logrus.Errorf("RSS: %v", err)
+		return true
+	}
+	ready := true
+	current := make(map[string]bool)
+	guids := make(map[string]bool)
+	for i := range cfg.Feeds {
+		feed := &cfg.Feeds[i]
+		status := &rssFeedStatus{Name: feed.label(i), URL: feed.URL, User: feed.User, LastPoll: time.Now()}
+		key := feed.User + "/" + strconv.Itoa(i)
+		rssStatus[key] = status
+		current[key] = true
+		if getInstance(feed.User) == nil {
+			status.Error = "qBittorrent of this user is not running"
+			ready = false
+			continue
+		}
+
+		items, err := pollFeed(ctx, feed, state, status)
+		if err != nil {
+			logrus.Warnf("RSS: feed %s of user %s: %v", status.Name, feed.User, err)
+			status.Error = err.Error()
+		}
+		status.Items = len(items)
+		for _, item := range items {
+			guids[item.GUID] = true
+		}
+	}
+	for key := range rssStatus {
+		if !current[key] {
+			delete(rssStatus, key)
+		}
+	}
+	for guid := range rssSizes {
+		if !guids[guid] {
+			delete(rssSizes, guid)
+		}
+	}
+	if err := saveRSSState(path, state); err != nil {
+		logrus.Errorf("RSS: failed to save %s: %v", path, err)
+	}
+	return ready
+}
+
+// pollFeed 获取一个订阅并添加匹配的条目，返回订阅中的条目
+func pollFeed(ctx context.Context, feed *RSSFeed, state map[string]*rssUserState, status *rssFeedStatus) ([]feedItem, error) {
+	inst := getInstance(feed.User)
+	data, _, err := fetchRSS(ctx, feed, feed.URL)
+	if err != nil {
+		return nil, err
+	}
+	items, err := parseFeed(data)
+	if err != nil {
+		return nil, err
+	}
+
+	s := state[feed.User]
+	if s == nil {
+		s = &rssUserState{GUIDs: make(map[string]time.Time), Hashes: make(map[string]time.Time)}
+		state[feed.User] = s
+	}
+	// 已经在 qBittorrent 中的种子（如手动添加的）同样视为已添加
+	existing := make(map[string]bool)
+	if snapshot, err := inst.hub.Snapshot(ctx, syncInterval); err == nil {
+		for _, t := range snapshot.torrentList() {
+			existing[t.Hash] = true
+		}
+	}
+
+	now := time.Now()
+	for i := range items {
+		item := &items[i]
+		if s.seen(item) {
+			continue
+		}
+		if existing[item.Hash] {
+			s.record(item, now)
+			continue
+		}
+		rule := -1
+		for j := range feed.Rules {
+			if feed.Rules[j].matchesTitle(item.Title) {
+				rule = j
+				break
+			}
+		}
+		if rule < 0 {
+			continue
+		}
+		r := &feed.Rules[rule]
+		if item.Size == 0 {
+			item.Size = rssSizes[item.GUID]
+		}
+		if item.Size > 0 && !r.matchesSize(item.Size) {
+			continue
+		}
+
+		added, err := addFeedItem(ctx, inst, feed, r, item, existing, s)
+		if errors.Is(err, errSizeMismatch) {
+			continue
+		}
+		if err != nil {
+			// 未记录的条目在下次轮询时重试
+			logrus.Warnf("RSS: failed to add %s for user %s: %v", item.Title, feed.User, err)
+			continue
+		}
+		s.record(item, now)
+		if added {
+			status.Added++
+			logrus.Infof("RSS: added %s for user %s (feed %s, rule %s)", item.Title, feed.User, status.Name, r.label(rule))
+		}
+	}
+	return items, nil
+}
+
+// addFeedItem 下载 .torrent 文件（磁力链接直接提交）并通过用户的代理 Socket 添加，
+// 下载后才得知种子已存在时返回 false
+func addFeedItem(ctx context.Context, inst *qbInstance, feed *RSSFeed, r *RSSRule, item *feedItem, existing map[string]bool, s *rssUserState) (bool, error) {
+	opts := qbapi.AddOptions{
+		SavePath: r.SavePath,
+		Category: r.Category,
+		Tags:     r.Tags,
+		Paused:   r.Paused,
+	}
+	link := item.URL
+	if !strings.HasPrefix(link, "magnet:") {
+		data, magnet, err := fetchRSS(ctx, feed, link)
+		if err != nil {
+			return false, err
+		}
+		if magnet != "" {
+			link = magnet
+			if t, err := metainfo.ParseMagnet(magnet); err == nil && item.Hash == "" {
+				item.Hash = t.Hash()
+			}
+		} else {
+			t, err := metainfo.Parse(data)
+			if err != nil {
+				return false, fmt.Errorf("invalid torrent file: %w", err)
+			}
+			item.Hash = t.Hash()
+			if item.Size == 0 {
+				item.Size = t.TotalSize
+				rssSizes[item.GUID] = t.TotalSize
+			}
+			name := path.Base(strings.SplitN(link, "?", 2)[0])
+			if !strings.HasSuffix(name, ".torrent") {
+				name = item.Hash + ".torrent"
+			}
+			opts.Files = []qbapi.TorrentFile{{Name: name, Data: data}}
+		}
+	}
+	if opts.Files == nil {
+		opts.URLs = []string{link}
+	}
+
+	// 得到 infohash 后再次去重，不同订阅中的同一种子只添加一次
+	if existing[item.Hash] || s.seenHash(item.Hash) {
+		return false, nil
+	}
+	if !r.matchesSize(item.Size) {
+		logrus.Debugf("RSS: skipped %s for user %s, size %s does not match", item.Title, feed.User, humanSize(item.Size))
+		return false, errSizeMismatch
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, time.Minute)
+	defer cancel()
+	if err := inst.proxyClient.AddTorrent(ctx, opts); err != nil {
+		return false, err
+	}
+	if item.Hash != "" {
+		existing[item.Hash] = true
+	}
+	return true, nil
+}
+
+// errSizeMismatch 下载 .torrent 文件后才得知大小不符合规则
+var errSizeMismatch = errors.New("size does not match the rule")
+
+// fetchRSS 获取订阅或 .torrent 文件，地址跳转到磁力链接时返回该链接
+func fetchRSS(ctx context.Context, feed *RSSFeed, link string) ([]byte, string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, link, nil)
+	if err != nil {
+		return nil, "", err
+	}
+	// 自定义请求头（如 Cookie、API Key）只发送给订阅所在的主机，条目可以指向任意地址
+	if feedURL, err := url.Parse(feed.URL); err == nil && sameHost(req.URL, feedURL) {
+		for k, v := range feed.Headers {
+			req.Header.Set(k, v)
+		}
+	}
+	resp, err := rssClient.Do(req)
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+	if loc := resp.Header.Get("Location"); strings.HasPrefix(loc, "magnet:") {
+		return nil, loc, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, "", fmt.Errorf("GET %s: %s", link, resp.Status)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, rssMaxFeedSize+1))
+	if err != nil {
+		return nil, "", err
+	}
+	if len(data) > rssMaxFeedSize {
+		return nil, "", fmt.Errorf("GET %s: response too large", link)
+	}
+	return data, "", nil
+}
+
+// sameHost 判断两个地址的主机名是否相同
+func sameHost(a, b *url.URL) bool {
+	return a.Hostname() != "" && strings.EqualFold(a.Hostname(), b.Hostname())
+}
+
+func init() {
+	adminMux.HandleFunc("GET /admin/rss", handleAdminRSS)
+	adminMux.HandleFunc("POST /admin/rss/refresh", handleAdminRSSRefresh)
+}
+
+// handleAdminRSS 返回各订阅最近一次轮询的状态
+func handleAdminRSS(w http.ResponseWriter, r *http.Request) {
+	users := make(map[string]bool)
+	for _, inst := range adminInstances(r) {
+		users[inst.username] = true
+	}
+	list := []rssFeedStatus{}
+	rssMu.Lock()
+	for _, s := range rssStatus {
+		if users[s.User] {
+			list = append(list, *s)
+		}
+	}
+	rssMu.Unlock()
+	slices.SortFunc(list, func(a, b rssFeedStatus) int { return strings.Compare(a.User+"/"+a.Name, b.User+"/"+b.Name) })
+	writeAdminJSON(w, list)
+}
+
+// handleAdminRSSRefresh 立即轮询所有订阅
+func handleAdminRSSRefresh(w http.ResponseWriter, r *http.Request) {
+	cfg := currentConfig().RSS
+	if cfg == nil {
+		http.Error(w, "RSS is not configured", http.StatusNotFound)
+		return
+	}
+	pollRSS(r.Context(), cfg)
+	handleAdminRSS(w, r)
+}
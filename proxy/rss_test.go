@@ -0,0 +1,385 @@
+package main
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testTorrent 生成单文件种子，返回文件内容与 infohash
+func testTorrent(name string, size int64) ([]byte, string) {
+	info := fmt.Sprintf("d6:lengthi%de4:name%d:%s12:piece lengthi16384e6:pieces20:aaaaaaaaaaaaaaaaaaaae", size, len(name), name)
+	sum := sha1.Sum([]byte(info))
+	return []byte("d4:info" + info + "e"), hex.EncodeToString(sum[:])
+}
+
+func TestParseFeed(t *testing.T) {
+	magnet := "magnet:?xt=urn:btih:CCCCCCCCCCCCCCCCCCCCCCCCCCCCCCCCCCCCCCCC&amp;dn=x"
+	tests := []struct {
+		name string
+		data string
+		want []feedItem
+	}{
+		{
+			name: "rss enclosure",
+			data: `<rss><channel><item><title> A </title><guid>g1</guid><link>http://site/details/1</link>
+				<enclosure url="http://site/1.torrent" length="2000000000"/></item></channel></rss>`,
+			want: []feedItem{{GUID: "g1", Title: "A", URL: "http://site/1.torrent", Size: 2000000000}},
+		},
+		{
+			name: "small enclosure length is the torrent file size",
+			data: `<rss><channel><item><title>A</title><enclosure url="http://site/1.torrent" length="1234"/></item></channel></rss>`,
+			want: []feedItem{{GUID: "http://site/1.torrent", Title: "A", URL: "http://site/1.torrent"}},
+		},
+		{
+			name: "magnet link preferred over enclosure",
+			data: `<rss><channel><item><title>A</title><guid>g1</guid><link>` + magnet + `</link>
+				<enclosure url="http://site/1.torrent"/></item></channel></rss>`,
+			want: []feedItem{{GUID: "g1", Title: "A", URL: strings.ReplaceAll(magnet, "&amp;", "&"), Hash: strings.Repeat("c", 40)}},
+		},
+		{
+			name: "torznab attributes",
+			data: `<rss xmlns:torznab="http://torznab.com/schemas/2015/feed"><channel><item><title>A</title><guid>g1</guid>
+				<torznab:attr name="size" value="3000"/><torznab:attr name="infohash" value="ABCDEF"/>
+				<torznab:attr name="magneturl" value="magnet:?xt=urn:btih:abcdef"/></item></channel></rss>`,
+			want: []feedItem{{GUID: "g1", Title: "A", URL: "magnet:?xt=urn:btih:abcdef", Hash: "abcdef", Size: 3000}},
+		},
+		{
+			name: "nyaa and mikan extensions",
+			data: `<rss xmlns:nyaa="https://nyaa.si/xmlns/nyaa"><channel>
+				<item><title>A</title><link>http://nyaa/1.torrent</link><nyaa:infoHash>ABC</nyaa:infoHash></item>
+				<item><title>B</title><enclosure url="http://mikan/2.torrent"/><torrent><contentLength>5000</contentLength></torrent></item>
+				</channel></rss>`,
+			want: []feedItem{
+				{GUID: "http://nyaa/1.torrent", Title: "A", URL: "http://nyaa/1.torrent", Hash: "abc"},
+				{GUID: "http://mikan/2.torrent", Title: "B", URL: "http://mikan/2.torrent", Size: 5000},
+			},
+		},
+		{
+			name: "atom",
+			data: `<feed xmlns="http://www.w3.org/2005/Atom">
+				<entry><title>A</title><id>e1</id><link href="http://site/page"/><link rel="enclosure" href="http://site/1.torrent" length="7000"/></entry>
+				<entry><title>B</title><id>e2</id><link href="http://site/2.torrent" type="application/x-bittorrent"/></entry>
+				<entry><title>C</title><id>e3</id><link href="http://site/3"/></entry>
+				</feed>`,
+			want: []feedItem{
+				{GUID: "e1", Title: "A", URL: "http://site/1.torrent", Size: 7000},
+				{GUID: "e2", Title: "B", URL: "http://site/2.torrent"},
+				{GUID: "e3", Title: "C", URL: "http://site/3"},
+			},
+		},
+		{
+			name: "items without a link are skipped",
+			data: `<rss><channel><item><title>A</title><guid>g1</guid></item></channel></rss>`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseFeed([]byte(tt.data))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("parseFeed() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+	if _, err := parseFeed([]byte("<rss><channel>")); err == nil {
+		t.Error("truncated feed was accepted")
+	}
+}
+
+func TestParseEpisodeFilter(t *testing.T) {
+	tests := []struct {
+		filter  string
+		want    []episodeRange
+		wantErr bool
+	}{
+		{filter: "", want: nil},
+		{filter: "1x5", want: []episodeRange{{1, 5, 5}}},
+		{filter: "1x1-10; 2X5-;", want: []episodeRange{{1, 1, 10}, {2, 5, -1}}},
+		{filter: "1x10-5", wantErr: true},
+		{filter: "1", wantErr: true},
+		{filter: "ax1", wantErr: true},
+		{filter: "1x", wantErr: true},
+		{filter: "1x1-b", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := parseEpisodeFilter(tt.filter)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("parseEpisodeFilter(%q) = %v, want error", tt.filter, got)
+			}
+			continue
+		}
+		if err != nil || !slices.Equal(got, tt.want) {
+			t.Errorf("parseEpisodeFilter(%q) = %v, %v, want %v", tt.filter, got, err, tt.want)
+		}
+	}
+
+	r := &RSSRule{Episodes: "1x1-10;2x5-"}
+	if err := r.compile(); err != nil {
+		t.Fatal(err)
+	}
+	for title, want := range map[string]bool{
+		"Show.S01E01.1080p":  true,
+		"Show S01E10":        true,
+		"Show.S01E11":        false,
+		"Show.S02E04":        false,
+		"Show.S02E05":        true,
+		"Show.S03E01":        true,
+		"Show 1x07 HDTV":     true,
+		"Show.Complete.1080": false,
+	} {
+		if got := r.matchesEpisode(title); got != want {
+			t.Errorf("matchesEpisode(%q) = %v, want %v", title, got, want)
+		}
+	}
+}
+
+// fakeRSSQB 记录添加的种子的模拟 qBittorrent
+type fakeRSSQB struct {
+	mu       sync.Mutex
+	existing string
+	urls     []string
+	files    []string
+}
+
+func (q *fakeRSSQB) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	switch r.URL.Path {
+	case "/api/v2/sync/maindata":
+		fmt.Fprintf(w, `{"rid":1,"full_update":true,"torrents":{%q:{"name":"existing"}}}`, q.existing)
+	case "/api/v2/torrents/add":
+		if err := r.ParseMultipartForm(32 << 20); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if v := r.FormValue("urls"); v != "" {
+			q.urls = append(q.urls, v)
+		}
+		for _, fh := range r.MultipartForm.File["torrents"] {
+			q.files = append(q.files, fh.Filename)
+		}
+		w.Write([]byte("Ok."))
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func TestPollFeed(t *testing.T) {
+	small, smallHash := testTorrent("Show.S01E01", 1000)
+	large, _ := testTorrent("Show.S01E02", 4<<30)
+	other, _ := testTorrent("Show.S01E03", 2000)
+	redirected, redirectedHash := testTorrent("Show.S01E04", 3000)
+	existingMagnet := "magnet:?xt=urn:btih:" + strings.Repeat("e", 40)
+
+	// 另一主机上的下载地址不能收到订阅的请求头
+	var leaked []string
+	var mu sync.Mutex
+	otherSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Api-Key") != "" {
+			mu.Lock()
+			leaked = append(leaked, r.URL.Path)
+			mu.Unlock()
+		}
+		switch r.URL.Path {
+		case "/other.torrent":
+			w.Write(other)
+		case "/redirected.torrent":
+			w.Write(redirected)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer otherSrv.Close()
+	otherURL := strings.Replace(otherSrv.URL, "127.0.0.1", "localhost", 1)
+
+	var site *httptest.Server
+	site = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Api-Key") != "secret" {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		switch r.URL.Path {
+		case "/feed":
+			fmt.Fprintf(w, `<rss xmlns:torznab="http://torznab.com/schemas/2015/feed"><channel>
+<item><title>Show.S01E01.1080p</title><guid>g1</guid><enclosure url="%[1]s/small.torrent"/></item>
+<item><title>Show.S01E01.1080p.REPACK</title><guid>g1-dup</guid><link>magnet:?xt=urn:btih:%[3]s</link></item>
+<item><title>Show.S01E02.1080p</title><guid>g2</guid><enclosure url="%[1]s/large.torrent"/></item>
+<item><title>Show.S01E02.2160p</title><guid>g2-big</guid><enclosure url="%[1]s/missing.torrent"/><torznab:attr name="size" value="5000000000"/></item>
+<item><title>Show.S01E03.1080p</title><guid>g3</guid><enclosure url="%[2]s/other.torrent"/></item>
+<item><title>Show.S01E04.1080p</title><guid>g4</guid><link>%[1]s/cross</link></item>
+<item><title>Show.S01E05.1080p</title><guid>g5</guid><link>%[1]s/redir</link></item>
+<item><title>Show.S01E06.1080p</title><guid>g6</guid><link>%[4]s</link></item>
+<item><title>Other.S01E01.1080p</title><guid>g7</guid><link>magnet:?xt=urn:btih:%[5]s</link></item>
+</channel></rss>`, site.URL, otherURL, smallHash, existingMagnet, strings.Repeat("f", 40))
+		case "/small.torrent":
+			w.Write(small)
+		case "/large.torrent":
+			w.Write(large)
+		case "/cross":
+			http.Redirect(w, r, otherURL+"/redirected.torrent", http.StatusFound)
+		case "/redir":
+			http.Redirect(w, r, "magnet:?xt=urn:btih:"+strings.Repeat("a", 40), http.StatusFound)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer site.Close()
+
+	qb := &fakeRSSQB{existing: strings.Repeat("e", 40)}
+	client := newFakeQB(t, qb)
+	instanceMutex.Lock()
+	instances["rss-test"] = &qbInstance{username: "rss-test", client: client, proxyClient: client, hub: newSyncHub("rss-test", client)}
+	instanceMutex.Unlock()
+	defer func() {
+		instanceMutex.Lock()
+		delete(instances, "rss-test")
+		instanceMutex.Unlock()
+	}()
+
+	feed := &RSSFeed{
+		URL:     site.URL + "/feed",
+		User:    "rss-test",
+		Headers: map[string]string{"X-Api-Key": "secret"},
+		Rules: []RSSRule{
+			// 大小未知的磁力链接不匹配设置了大小限制的规则
+			{Include: `S01E05`},
+			{Include: `^Show\.`, MaxSize: 1 << 30},
+		},
+	}
+	if err := (&RSSConfig{Feeds: []RSSFeed{*feed}}).validate(); err != nil {
+		t.Fatal(err)
+	}
+	for i := range feed.Rules {
+		if err := feed.Rules[i].compile(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	state := make(map[string]*rssUserState)
+	status := &rssFeedStatus{}
+	items, err := pollFeed(context.Background(), feed, state, status)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(items) != 9 {
+		t.Errorf("pollFeed() returned %d items, want 9", len(items))
+	}
+	// 地址中没有 .torrent 文件名时以 infohash 命名
+	want := []string{"other.torrent", redirectedHash + ".torrent", "small.torrent"}
+	slices.Sort(qb.files)
+	slices.Sort(want)
+	if !slices.Equal(qb.files, want) {
+		t.Errorf("added files = %v, want %v", qb.files, want)
+	}
+	if want := []string{"magnet:?xt=urn:btih:" + strings.Repeat("a", 40)}; !slices.Equal(qb.urls, want) {
+		t.Errorf("added urls = %v, want %v", qb.urls, want)
+	}
+	if status.Added != 4 {
+		t.Errorf("status.Added = %d, want 4", status.Added)
+	}
+	if len(leaked) > 0 {
+		t.Errorf("feed headers were sent to another host: %v", leaked)
+	}
+
+	s := state["rss-test"]
+	for guid, want := range map[string]bool{
+		"g1": true, "g3": true, "g4": true, "g5": true,
+		"g1-dup": false,                  // infohash 已记录，直接跳过
+		"g6":     true,                   // 已在 qBittorrent 中
+		"g2":     false, "g2-big": false, // 大小不符合，以后不再下载但也不记录
+		"g7": false, // 没有匹配的规则
+	} {
+		if _, ok := s.GUIDs[guid]; ok != want {
+			t.Errorf("GUID %s recorded = %v, want %v", guid, ok, want)
+		}
+	}
+	if rssSizes["g2"] != 4<<30 {
+		t.Errorf("size of g2 was not cached: %d", rssSizes["g2"])
+	}
+
+	// 再次轮询不会重复添加
+	status = &rssFeedStatus{}
+	if _, err := pollFeed(context.Background(), feed, state, status); err != nil {
+		t.Fatal(err)
+	}
+	if status.Added != 0 || len(qb.files) != 3 || len(qb.urls) != 1 {
+		t.Errorf("second poll added %d torrents (files %v, urls %v)", status.Added, qb.files, qb.urls)
+	}
+
+	// 请求头只发送给订阅所在主机，缺少时订阅本身无法获取
+	feed.URL = otherURL + "/feed"
+	if _, _, err := fetchRSS(context.Background(), feed, site.URL+"/small.torrent"); err == nil {
+		t.Error("headers were sent to a host other than the feed's")
+	}
+}
+
+func TestFetchRSSTooLarge(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.CopyN(w, strings.NewReader(strings.Repeat("x", rssMaxFeedSize+1)), rssMaxFeedSize+1)
+	}))
+	defer srv.Close()
+	if _, _, err := fetchRSS(context.Background(), &RSSFeed{URL: srv.URL}, srv.URL); err == nil {
+		t.Error("oversized response was accepted")
+	}
+}
+
+func TestLoadRSSStateMissingMaps(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rss-state.json")
+	os.WriteFile(path, []byte(`{"alice":{"guids":null},"bob":{},"carol":null}`), 0600)
+	state, err := loadRSSState(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, user := range []string{"alice", "bob", "carol"} {
+		s := state[user]
+		if s == nil {
+			t.Fatalf("state of %s is nil", user)
+		}
+		// 不应 panic
+		s.record(&feedItem{GUID: "g", Hash: "h"}, time.Now())
+		if !s.seenHash("h") {
+			t.Errorf("%s: recorded hash not seen", user)
+		}
+	}
+}
+
+func TestPollRSSStatusPerUser(t *testing.T) {
+	rssMu.Lock()
+	clear(rssStatus)
+	rssMu.Unlock()
+	// 两个用户订阅同一个公开订阅，实例均未运行，不会发起请求
+	cfg := &RSSConfig{
+		State: filepath.Join(t.TempDir(), "rss-state.json"),
+		Feeds: []RSSFeed{
+			{URL: "https://example.org/rss", User: "rss-alice"},
+			{URL: "https://example.org/rss", User: "rss-bob"},
+		},
+	}
+	if pollRSS(context.Background(), cfg) {
+		t.Error("pollRSS() = true with no running instances")
+	}
+	rssMu.Lock()
+	defer rssMu.Unlock()
+	users := map[string]bool{}
+	for _, s := range rssStatus {
+		users[s.User] = true
+	}
+	if len(rssStatus) != 2 || !users["rss-alice"] || !users["rss-bob"] {
+		t.Errorf("rssStatus = %v, want one entry per user", rssStatus)
+	}
+}
@@ -0,0 +1,409 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/leganck/fn-qb-proxy/metainfo"
+	"github.com/leganck/fn-qb-proxy/qbapi"
+	"github.com/sirupsen/logrus"
+)
+
+// TorrentRule 新种子的自动分类规则，设置的条件全部满足时生效
+// 多条规则匹配时按顺序合并：标签累加，其余设置以后面的规则为准
+type TorrentRule struct {
+	Name       string   `json:"name"`
+	Users      []string `json:"users"`
+	Trackers   []string `json:"trackers"`   // Tracker 域名，同时匹配其子域名
+	NameRegex  string   `json:"name_regex"` // 匹配种子名称的正则表达式
+	MinSize    ByteSize `json:"min_size"`
+	MaxSize    ByteSize `json:"max_size"`
+	Extensions []string `json:"extensions"` // 任一文件扩展名匹配即可，如 .mkv
+	Clients    []string `json:"clients"`    // 通配符，匹配添加请求的对端 uid、客户端地址或 User-Agent
+
+	Category         string    `json:"category"`
+	Tags             []string  `json:"tags"`
+	SavePath         string    `json:"save_path"`
+	DlLimit          ByteSize  `json:"dl_limit"` // 每秒字节数
+	UpLimit          ByteSize  `json:"up_limit"`
+	RatioLimit       *float64  `json:"ratio_limit"`        // -1 表示不限制
+	SeedingTimeLimit *Duration `json:"seeding_time_limit"` // 负数表示不限制
+	Stop             bool      `json:"stop"`               // 匹配后不再评估后续规则
+
+	nameRe    *regexp.Regexp
+	clientRes []*regexp.Regexp
+}
+
+func (r *TorrentRule) compile() error {
+	r.nameRe, r.clientRes = nil, nil
+	if r.NameRegex != "" {
+		re, err := regexp.Compile(r.NameRegex)
+		if err != nil {
+			return fmt.Errorf("name_regex: %w", err)
+		}
+		r.nameRe = re
+	}
+	for _, pattern := range r.Clients {
+		re, err := compileGlob(pattern)
+		if err != nil {
+			return fmt.Errorf("clients: %w", err)
+		}
+		r.clientRes = append(r.clientRes, re)
+	}
+	return nil
+}
+
+func (r *TorrentRule) label(i int) string {
+	if r.Name != "" {
+		return r.Name
+	}
+	return fmt.Sprintf("#%d", i)
+}
+
+// ruleSubject 规则评估的对象，Tracker 与文件列表在需要时才向 qBittorrent 查询
+type ruleSubject struct {
+	inst    *qbInstance
+	torrent qbapi.Torrent
+	client  *addClient
+	meta    *metainfo.Torrent
+
+	trackers []string
+	files    []qbapi.FileInfo
+	fetched  map[string]bool
+}
+
+func (s *ruleSubject) size() int64 {
+	if s.torrent.TotalSize > 0 {
+		return s.torrent.TotalSize
+	}
+	if s.torrent.Size > 0 {
+		return s.torrent.Size
+	}
+	if s.meta != nil {
+		return s.meta.TotalSize
+	}
+	return 0
+}
+
+// trackerHosts 返回种子所有 Tracker 的域名
+func (s *ruleSubject) trackerHosts(ctx context.Context) []string {
+	if !s.fetched["trackers"] {
+		s.fetched["trackers"] = true
+		urls := []string{s.torrent.Tracker}
+		if s.meta != nil {
+			urls = append(urls, s.meta.Trackers...)
+		}
+		if list, err := s.inst.client.Trackers(ctx, s.torrent.Hash); err == nil {
+			for _, t := range list {
+				if !t.IsPseudo() {
+					urls = append(urls, t.URL)
+				}
+			}
+		}
+		for _, raw := range urls {
+			if u, err := url.Parse(raw); err == nil && u.Hostname() != "" {
+				s.trackers = append(s.trackers, strings.ToLower(u.Hostname()))
+			}
+		}
+	}
+	return s.trackers
+}
+
+func (s *ruleSubject) fileList(ctx context.Context) []qbapi.FileInfo {
+	if !s.fetched["files"] {
+		s.fetched["files"] = true
+		files, err := s.inst.client.Files(ctx, s.torrent.Hash)
+		if err != nil {
+			logrus.Debugf("Failed to get files of torrent %s: %v", s.torrent.Hash, err)
+		}
+		s.files = files
+	}
+	return s.files
+}
+
+// matches 判断规则的所有条件是否满足，开销较大的条件放在最后
+func (r *TorrentRule) matches(ctx context.Context, s *ruleSubject) bool {
+	if len(r.Users) > 0 && !slices.Contains(r.Users, s.inst.username) {
+		return false
+	}
+	if r.nameRe != nil && !r.nameRe.MatchString(s.torrent.Name) {
+		return false
+	}
+	if r.MinSize > 0 && s.size() < int64(r.MinSize) {
+		return false
+	}
+	if r.MaxSize > 0 && s.size() > int64(r.MaxSize) {
+		return false
+	}
+	if len(r.Clients) > 0 && !r.matchClient(s.client) {
+		return false
+	}
+	if len(r.Trackers) > 0 && !trackerHostMatch(r.Trackers, s.trackerHosts(ctx)) {
+		return false
+	}
+	if len(r.Extensions) > 0 && !r.matchExtension(s.fileList(ctx)) {
+		return false
+	}
+	return true
+}
+
+// matchClient 只有经过代理添加的种子才知道来源客户端
+func (r *TorrentRule) matchClient(c *addClient) bool {
+	if c == nil {
+		return false
+	}
+	for _, re := range r.clientRes {
+		for _, v := range []string{c.Peer, c.clientIP(), c.UserAgent} {
+			if v != "" && re.MatchString(v) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// compileGlob 将通配符转换为不区分大小写的正则表达式，* 可以匹配包括 / 在内的任意字符（User-Agent 中通常带有 /）
+func compileGlob(pattern string) (*regexp.Regexp, error) {
+	expr := regexp.QuoteMeta(pattern)
+	expr = strings.ReplaceAll(expr, `\*`, ".*")
+	expr = strings.ReplaceAll(expr, `\?`, ".")
+	return regexp.Compile("(?i)^" + expr + "$")
+}
+
+// trackerHostMatch 判断域名列表中是否有与 patterns 相同或为其子域名的
+func trackerHostMatch(patterns, hosts []string) bool {
+	for _, want := range patterns {
+		want = strings.ToLower(want)
+		for _, host := range hosts {
+			if host == want || strings.HasSuffix(host, "."+want) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (r *TorrentRule) matchExtension(files []qbapi.FileInfo) bool {
+	for _, f := range files {
+		ext := strings.ToLower(filepath.Ext(f.Name))
+		for _, want := range r.Extensions {
+			if ext == "."+strings.TrimPrefix(strings.ToLower(want), ".") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// ruleActions 合并后的规则动作
+type ruleActions struct {
+	matched          []string
+	category         string
+	tags             []string
+	savePath         string
+	dlLimit          int64
+	upLimit          int64
+	ratioLimit       *float64
+	seedingTimeLimit *Duration
+}
+
+func (a *ruleActions) merge(r *TorrentRule) {
+	if r.Category != "" {
+		a.category = r.Category
+	}
+	for _, tag := range r.Tags {
+		if !slices.Contains(a.tags, tag) {
+			a.tags = append(a.tags, tag)
+		}
+	}
+	if r.SavePath != "" {
+		a.savePath = r.SavePath
+	}
+	if r.DlLimit > 0 {
+		a.dlLimit = int64(r.DlLimit)
+	}
+	if r.UpLimit > 0 {
+		a.upLimit = int64(r.UpLimit)
+	}
+	if r.RatioLimit != nil {
+		a.ratioLimit = r.RatioLimit
+	}
+	if r.SeedingTimeLimit != nil {
+		a.seedingTimeLimit = r.SeedingTimeLimit
+	}
+}
+
+// apply 通过 qBittorrent API 执行动作，单个动作失败不影响其它动作
+func (a *ruleActions) apply(ctx context.Context, client *qbapi.Client, hash string) error {
+	hashes := []string{hash}
+	var errs []error
+	if a.category != "" {
+		if err := ensureCategory(ctx, client, a.category); err != nil {
+			errs = append(errs, err)
+		} else if err := client.SetCategory(ctx, hashes, a.category); err != nil {
+			errs = append(errs, fmt.Errorf("set category: %w", err))
+		}
+	}
+	if len(a.tags) > 0 {
+		if err := client.AddTags(ctx, hashes, a.tags); err != nil {
+			errs = append(errs, fmt.Errorf("add tags: %w", err))
+		}
+	}
+	if a.savePath != "" {
+		if err := client.SetLocation(ctx, hashes, a.savePath); err != nil {
+			errs = append(errs, fmt.Errorf("set location: %w", err))
+		}
+	}
+	if a.dlLimit > 0 {
+		if err := client.SetDownloadLimit(ctx, hashes, a.dlLimit); err != nil {
+			errs = append(errs, fmt.Errorf("set download limit: %w", err))
+		}
+	}
+	if a.upLimit > 0 {
+		if err := client.SetUploadLimit(ctx, hashes, a.upLimit); err != nil {
+			errs = append(errs, fmt.Errorf("set upload limit: %w", err))
+		}
+	}
+	if a.ratioLimit != nil || a.seedingTimeLimit != nil {
+		ratio, minutes := -2.0, int64(-2)
+		if a.ratioLimit != nil {
+			ratio = *a.ratioLimit
+		}
+		if a.seedingTimeLimit != nil {
+			minutes = int64(time.Duration(*a.seedingTimeLimit) / time.Minute)
+			if *a.seedingTimeLimit < 0 {
+				minutes = -1
+			}
+		}
+		if err := client.SetShareLimits(ctx, hashes, ratio, minutes); err != nil {
+			errs = append(errs, fmt.Errorf("set share limits: %w", err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// ensureCategory 分类不存在时创建
+func ensureCategory(ctx context.Context, client *qbapi.Client, name string) error {
+	categories, err := client.Categories(ctx)
+	if err != nil {
+		return fmt.Errorf("get categories: %w", err)
+	}
+	if _, ok := categories[name]; ok {
+		return nil
+	}
+	err = client.CreateCategory(ctx, name, "")
+	var se *qbapi.StatusError
+	if errors.As(err, &se) && se.Code == http.StatusConflict {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("create category: %w", err)
+	}
+	return nil
+}
+
+// awaitingMetaMaxAge 磁力链接等待元数据的最长时间，之后不再评估规则
+const awaitingMetaMaxAge = 24 * time.Hour
+
+// awaitingSubject 等待元数据的种子及其加入时间
+type awaitingSubject struct {
+	subject *ruleSubject
+	since   time.Time
+}
+
+// awaitingMeta 等待获取元数据后再评估规则的磁力链接，key 为 用户名/hash
+var (
+	awaitingMeta   = make(map[string]awaitingSubject)
+	awaitingMetaMu sync.Mutex
+)
+
+// pruneAwaitingMeta 删除等待过久的记录，一直获取不到元数据的磁力链接可能不会被删除
+func pruneAwaitingMeta(now time.Time) {
+	awaitingMetaMu.Lock()
+	defer awaitingMetaMu.Unlock()
+	for key, a := range awaitingMeta {
+		if now.Sub(a.since) > awaitingMetaMaxAge {
+			delete(awaitingMeta, key)
+		}
+	}
+}
+
+// handleRuleEvent 新种子出现时评估规则，磁力链接等到获取元数据之后
+func handleRuleEvent(ev torrentEvent) {
+	key := ev.User + "/" + ev.Torrent.Hash
+	// 清理不依赖规则，规则被清空后等待中的记录同样需要删除
+	pruneAwaitingMeta(time.Now())
+	if ev.Type == torrentRemoved {
+		awaitingMetaMu.Lock()
+		delete(awaitingMeta, key)
+		awaitingMetaMu.Unlock()
+		return
+	}
+	rules := currentConfig().Rules
+	if len(rules) == 0 {
+		return
+	}
+
+	switch ev.Type {
+	case torrentAdded:
+		inst := getInstance(ev.User)
+		if inst == nil {
+			return
+		}
+		s := &ruleSubject{inst: inst, torrent: ev.Torrent, fetched: make(map[string]bool)}
+		if p, ok := takePendingAdd(ev.User, ev.Torrent.Hash); ok {
+			s.client, s.meta = &p.client, p.torrent
+		}
+		if isMetaState(ev.Torrent.State) {
+			awaitingMetaMu.Lock()
+			awaitingMeta[key] = awaitingSubject{subject: s, since: time.Now()}
+			awaitingMetaMu.Unlock()
+			return
+		}
+		go evaluateRules(rules, s)
+	case torrentMetadata:
+		awaitingMetaMu.Lock()
+		a, ok := awaitingMeta[key]
+		delete(awaitingMeta, key)
+		awaitingMetaMu.Unlock()
+		if ok {
+			a.subject.torrent = ev.Torrent
+			go evaluateRules(rules, a.subject)
+		}
+	}
+}
+
+func evaluateRules(rules []TorrentRule, s *ruleSubject) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	var actions ruleActions
+	for i := range rules {
+		if !rules[i].matches(ctx, s) {
+			continue
+		}
+		actions.matched = append(actions.matched, rules[i].label(i))
+		actions.merge(&rules[i])
+		if rules[i].Stop {
+			break
+		}
+	}
+	if len(actions.matched) == 0 {
+		return
+	}
+
+	logrus.Infof("Rules %s matched torrent %s (%s) for user %s",
+		strings.Join(actions.matched, ", "), s.torrent.Hash, s.torrent.Name, s.inst.username)
+	if err := actions.apply(ctx, s.inst.client, s.torrent.Hash); err != nil {
+		logrus.Warnf("Failed to apply rules to torrent %s: %v", s.torrent.Hash, err)
+	}
+}
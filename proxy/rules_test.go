@@ -0,0 +1,163 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/leganck/fn-qb-proxy/qbapi"
+)
+
+func TestCompileGlob(t *testing.T) {
+	tests := []struct {
+		pattern string
+		s       string
+		want    bool
+	}{
+		{"Sonarr*", "Sonarr/4.0.1 (ubuntu 22.04)", true},
+		{"sonarr*", "Sonarr/4.0.1", true},
+		{"*radarr*", "Mozilla/5.0 Radarr/5.2", true},
+		{"Sonarr*", "Radarr/5.2", false},
+		{"uid:1000", "uid:1000", true},
+		{"uid:100", "uid:1000", false},
+		{"uid:10?0", "uid:1000", true},
+		{"192.168.1.*", "192.168.1.20", true},
+		{"192.168.1.*", "192.168.10.20", false},
+		// 正则元字符按字面匹配
+		{"a.b", "axb", false},
+		{"(x)+", "(x)+", true},
+		{"[abc]", "a", false},
+		{"", "", true},
+		{"", "x", false},
+		{"*", "", true},
+	}
+	for _, tt := range tests {
+		re, err := compileGlob(tt.pattern)
+		if err != nil {
+			t.Errorf("compileGlob(%q) error = %v", tt.pattern, err)
+			continue
+		}
+		if got := re.MatchString(tt.s); got != tt.want {
+			t.Errorf("compileGlob(%q).MatchString(%q) = %v, want %v", tt.pattern, tt.s, got, tt.want)
+		}
+	}
+}
+
+// fakeRulesQB 记录规则动作的 qBittorrent
+type fakeRulesQB struct {
+	mu      sync.Mutex
+	actions map[string]string // 路径 -> 表单
+	done    chan struct{}
+}
+
+func (q *fakeRulesQB) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.URL.Path {
+	case qbapi.TrackersPath:
+		fmt.Fprint(w, `[{"url":"** [DHT] **","status":2},{"url":"https://a.tracker.org/announce?passkey=x","status":2}]`)
+	case qbapi.FilesPath:
+		fmt.Fprint(w, `[{"name":"Show/e01.mkv"},{"name":"Show/e01.nfo"}]`)
+	case qbapi.CategoriesPath:
+		fmt.Fprint(w, `{"movies":{"name":"movies"}}`)
+	default:
+		r.ParseForm()
+		q.mu.Lock()
+		q.actions[r.URL.Path] = r.Form.Encode()
+		q.mu.Unlock()
+		if r.URL.Path == "/api/v2/torrents/addTags" {
+			close(q.done)
+		}
+	}
+}
+
+func TestEvaluateRules(t *testing.T) {
+	qb := &fakeRulesQB{actions: map[string]string{}, done: make(chan struct{})}
+	client := newFakeQB(t, qb)
+	rules := []TorrentRule{
+		{Clients: []string{"Sonarr*"}, Category: "tv", Tags: []string{"sonarr"}},
+		{Trackers: []string{"tracker.org"}, Tags: []string{"pt"}},
+		{Extensions: []string{"iso"}, Category: "iso"},
+		{Users: []string{"bob"}, Tags: []string{"bob"}},
+		{NameRegex: `S01E\d+`, MaxSize: 1 << 30, Tags: []string{"s01"}, Stop: true},
+		{Tags: []string{"after-stop"}},
+	}
+	for i := range rules {
+		if err := rules[i].compile(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	s := &ruleSubject{
+		inst:    &qbInstance{username: "alice", client: client},
+		torrent: qbapi.Torrent{Hash: "abc", Name: "Show.S01E01.1080p", TotalSize: 1 << 20},
+		client:  &addClient{Peer: "uid:1000", UserAgent: "Sonarr/4.0.1"},
+		fetched: make(map[string]bool),
+	}
+	evaluateRules(rules, s)
+
+	want := map[string]string{
+		"/api/v2/torrents/createCategory": "category=tv&savePath=",
+		"/api/v2/torrents/setCategory":    "category=tv&hashes=abc",
+		"/api/v2/torrents/addTags":        "hashes=abc&tags=sonarr%2Cpt%2Cs01",
+	}
+	for path, form := range want {
+		if got := qb.actions[path]; got != form {
+			t.Errorf("%s = %q, want %q", path, got, form)
+		}
+	}
+	if len(qb.actions) != len(want) {
+		t.Errorf("actions = %v, want %v", qb.actions, want)
+	}
+}
+
+func TestHandleRuleEventAwaitingMeta(t *testing.T) {
+	qb := &fakeRulesQB{actions: map[string]string{}, done: make(chan struct{})}
+	client := newFakeQB(t, qb)
+	instanceMutex.Lock()
+	instances["rules-test"] = &qbInstance{username: "rules-test", client: client, proxyClient: client}
+	instanceMutex.Unlock()
+	old := config.Load()
+	config.Store(&Config{Rules: []TorrentRule{{Tags: []string{"all"}}}})
+	defer func() {
+		config.Store(old)
+		instanceMutex.Lock()
+		delete(instances, "rules-test")
+		instanceMutex.Unlock()
+	}()
+
+	magnet := qbapi.Torrent{Hash: "abc", Name: "abc", State: "metaDL"}
+	handleRuleEvent(torrentEvent{Type: torrentAdded, User: "rules-test", Torrent: magnet})
+	awaitingMetaMu.Lock()
+	_, waiting := awaitingMeta["rules-test/abc"]
+	awaitingMetaMu.Unlock()
+	if !waiting {
+		t.Fatal("magnet without metadata is not waiting")
+	}
+	if len(qb.actions) != 0 {
+		t.Fatalf("rules were applied before metadata: %v", qb.actions)
+	}
+
+	handleRuleEvent(torrentEvent{Type: torrentMetadata, User: "rules-test", Torrent: qbapi.Torrent{Hash: "abc", Name: "Show", State: "stalledDL"}})
+	select {
+	case <-qb.done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("rules were not applied after metadata")
+	}
+
+	// 规则被清空后删除事件与过期清理仍然生效
+	config.Store(&Config{})
+	awaitingMetaMu.Lock()
+	awaitingMeta["rules-test/removed"] = awaitingSubject{since: time.Now()}
+	awaitingMeta["rules-test/stale"] = awaitingSubject{since: time.Now().Add(-awaitingMetaMaxAge - time.Minute)}
+	awaitingMeta["rules-test/fresh"] = awaitingSubject{since: time.Now()}
+	awaitingMetaMu.Unlock()
+	handleRuleEvent(torrentEvent{Type: torrentRemoved, User: "rules-test", Torrent: qbapi.Torrent{Hash: "removed"}})
+	awaitingMetaMu.Lock()
+	defer awaitingMetaMu.Unlock()
+	for key, want := range map[string]bool{"rules-test/removed": false, "rules-test/stale": false, "rules-test/fresh": true} {
+		if _, ok := awaitingMeta[key]; ok != want {
+			t.Errorf("%s present = %v, want %v", key, ok, want)
+		}
+	}
+	delete(awaitingMeta, "rules-test/fresh")
+}
@@ -0,0 +1,324 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/leganck/fn-qb-proxy/qbapi"
+	"github.com/sirupsen/logrus"
+)
+
+// 做种策略动作
+const (
+	seedingPause       = "pause"
+	seedingRemove      = "remove"
+	seedingRemoveFiles = "remove_files"
+	seedingTag         = "tag"
+)
+
+// SeedingConfig 做种策略配置
+type SeedingConfig struct {
+	Interval Duration        `json:"interval"` // 评估间隔，默认 10m
+	DryRun   bool            `json:"dry_run"`  // 只记录将要执行的动作，不实际执行
+	Report   string          `json:"report"`   // 每次评估后写入报告（JSON）的文件
+	Policies []SeedingPolicy `json:"policies"`
+}
+
+// SeedingPolicy 做种策略，按顺序为每个已完成的种子选择第一条匹配的策略
+type SeedingPolicy struct {
+	Name       string   `json:"name"`
+	Users      []string `json:"users"`
+	Categories []string `json:"categories"`
+	Trackers   []string `json:"trackers"` // Tracker 域名，同时匹配其子域名
+	Tags       []string `json:"tags"`     // 带有任一标签即匹配
+
+	// 保种要求，未满足前不执行动作；磁盘空间不足时可以忽略
+	MinSeedTime Duration `json:"min_seed_time"`
+	MinRatio    float64  `json:"min_ratio"`
+	// H&R 保护，做种时间与分享率满足其一之前始终不执行动作，磁盘空间不足时同样生效
+	HnRSeedTime Duration `json:"hnr_seed_time"`
+	HnRRatio    float64  `json:"hnr_ratio"`
+
+	// 触发条件，满足任一即执行动作；均未设置时满足保种要求即执行
+	MaxSeedTime Duration `json:"max_seed_time"`
+	MaxRatio    float64  `json:"max_ratio"`
+	MaxAge      Duration `json:"max_age"` // 自添加起的时间
+
+	// 种子所在磁盘可用空间低于该值时视为空间不足
+	LowSpace        ByteSize `json:"low_space"`
+	LowSpacePercent float64  `json:"low_space_percent"`
+
+	Action string `json:"action"` // pause、remove、remove_files、tag
+	Tag    string `json:"tag"`    // action 为 tag 时添加的标签，默认 seeding-done
+}
+
+// seedingDecision 对单个种子的评估结果，同时作为报告条目
+type seedingDecision struct {
+	User     string `json:"user"`
+	Hash     string `json:"hash"`
+	Name     string `json:"name"`
+	Policy   string `json:"policy"`
+	Action   string `json:"action"`
+	Reason   string `json:"reason"`
+	Executed bool   `json:"executed"`
+	Error    string `json:"error,omitempty"`
+}
+
+func (c *SeedingConfig) validate() error {
+	for i, p := range c.Policies {
+		switch p.Action {
+		case seedingPause, seedingRemove, seedingRemoveFiles, seedingTag:
+		default:
+			return fmt.Errorf("policy %s: unknown action %q", p.label(i), p.Action)
+		}
+	}
+	return nil
+}
+
+func (p *SeedingPolicy) label(i int) string {
+	if p.Name != "" {
+		return p.Name
+	}
+	return fmt.Sprintf("#%d", i)
+}
+
+func (p *SeedingPolicy) tag() string {
+	if p.Tag != "" {
+		return p.Tag
+	}
+	return "seeding-done"
+}
+
+// matches 判断策略是否适用于种子，trackers 只在需要时获取种子全部 Tracker 的域名
+func (p *SeedingPolicy) matches(username string, t *qbapi.Torrent, trackers func() ([]string, error)) (bool, error) {
+	if len(p.Users) > 0 && !slices.Contains(p.Users, username) {
+		return false, nil
+	}
+	if len(p.Categories) > 0 && !slices.Contains(p.Categories, t.Category) {
+		return false, nil
+	}
+	if len(p.Tags) > 0 && !slices.ContainsFunc(p.Tags, t.HasTag) {
+		return false, nil
+	}
+	if len(p.Trackers) > 0 {
+		hosts, err := trackers()
+		if err != nil {
+			return false, err
+		}
+		if !trackerHostMatch(p.Trackers, hosts) {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// torrentTrackerHosts 返回种子所有 Tracker 的域名；当前工作的 Tracker 只是其中之一，可能属于其它站点
+func torrentTrackerHosts(ctx context.Context, client *qbapi.Client, hash string) ([]string, error) {
+	list, err := client.Trackers(ctx, hash)
+	if err != nil {
+		return nil, err
+	}
+	var hosts []string
+	for _, t := range list {
+		if t.IsPseudo() {
+			continue
+		}
+		if u, err := url.Parse(t.URL); err == nil && u.Hostname() != "" {
+			hosts = append(hosts, strings.ToLower(u.Hostname()))
+		}
+	}
+	return hosts, nil
+}
+
+// done 动作已执行过的种子不再重复处理
+func (p *SeedingPolicy) done(t *qbapi.Torrent) bool {
+	switch p.Action {
+	case seedingPause:
+		return t.IsPaused()
+	case seedingTag:
+		return t.HasTag(p.tag())
+	}
+	return false
+}
+
+// evaluate 判断是否需要对种子执行动作，返回原因
+func (p *SeedingPolicy) evaluate(t *qbapi.Torrent, lowSpace bool, now time.Time) (string, bool) {
+	seedTime := time.Duration(t.SeedingTime) * time.Second
+	if p.HnRSeedTime > 0 || p.HnRRatio > 0 {
+		hnrMet := p.HnRSeedTime > 0 && seedTime >= time.Duration(p.HnRSeedTime) ||
+			p.HnRRatio > 0 && t.Ratio >= p.HnRRatio
+		if !hnrMet {
+			return "", false
+		}
+	}
+	if !lowSpace && (seedTime < time.Duration(p.MinSeedTime) || t.Ratio < p.MinRatio) {
+		return "", false
+	}
+
+	var reasons []string
+	if lowSpace {
+		reasons = append(reasons, "low disk space")
+	}
+	if p.MaxSeedTime > 0 && seedTime >= time.Duration(p.MaxSeedTime) {
+		reasons = append(reasons, fmt.Sprintf("seeding time %s >= %s", seedTime.Round(time.Minute), time.Duration(p.MaxSeedTime)))
+	}
+	if p.MaxRatio > 0 && t.Ratio >= p.MaxRatio {
+		reasons = append(reasons, fmt.Sprintf("ratio %.2f >= %.2f", t.Ratio, p.MaxRatio))
+	}
+	if age := now.Sub(time.Unix(t.AddedOn, 0)); p.MaxAge > 0 && age >= time.Duration(p.MaxAge) {
+		reasons = append(reasons, fmt.Sprintf("age %s >= %s", age.Round(time.Minute), time.Duration(p.MaxAge)))
+	}
+	if len(reasons) == 0 {
+		if p.MaxSeedTime > 0 || p.MaxRatio > 0 || p.MaxAge > 0 {
+			return "", false
+		}
+		reasons = append(reasons, "seeding requirements met")
+	}
+	return strings.Join(reasons, ", "), true
+}
+
+// lowSpace 判断保存路径所在磁盘空间是否不足，statfs 不可用时使用 qBittorrent 报告的可用空间
+func (p *SeedingPolicy) lowSpace(savePath string, reported int64, hasReported bool) bool {
+	if p.LowSpace <= 0 && p.LowSpacePercent <= 0 {
+		return false
+	}
+	free, total, err := diskUsage(savePath)
+	if err != nil {
+		if !hasReported {
+			return false
+		}
+		free, total = uint64(reported), 0
+	}
+	if p.LowSpace > 0 && free < uint64(p.LowSpace) {
+		return true
+	}
+	return p.LowSpacePercent > 0 && total > 0 && float64(free)/float64(total)*100 < p.LowSpacePercent
+}
+
+// runSeedingPolicies 定期评估做种策略
+func runSeedingPolicies(ctx context.Context) {
+	for {
+		cfg := currentConfig().Seeding
+		interval := 10 * time.Minute
+		if cfg != nil {
+			interval = cfg.Interval.Or(interval)
+			evaluateSeedingPolicies(ctx, cfg)
+		}
+
+		select {
+		case <-time.After(interval):
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func evaluateSeedingPolicies(ctx context.Context, cfg *SeedingConfig) {
+	var report []seedingDecision
+	now := time.Now()
+	for _, inst := range listInstances() {
+		state, err := inst.hub.Snapshot(ctx, syncInterval)
+		if err != nil {
+			logrus.Warnf("Seeding policy: failed to get torrents for user %s: %v", inst.username, err)
+			continue
+		}
+		reported, hasReported := state.freeSpace()
+
+	torrents:
+		for _, t := range state.torrentList() {
+			if !t.IsFinished() {
+				continue
+			}
+			var hosts []string
+			var hostsErr error
+			fetched := false
+			trackers := func() ([]string, error) {
+				if !fetched {
+					fetched = true
+					hosts, hostsErr = torrentTrackerHosts(ctx, inst.client, t.Hash)
+				}
+				return hosts, hostsErr
+			}
+			for i := range cfg.Policies {
+				p := &cfg.Policies[i]
+				ok, err := p.matches(inst.username, &t, trackers)
+				if err != nil {
+					// 无法确定匹配哪条策略时不处理，避免落到后面更激进的策略
+					logrus.Warnf("Seeding policy: failed to get trackers of torrent %s for user %s: %v", t.Hash, inst.username, err)
+					continue torrents
+				}
+				if !ok {
+					continue
+				}
+				// 只使用第一条匹配的策略
+				if reason, ok := p.evaluate(&t, p.lowSpace(t.SavePath, reported, hasReported), now); ok && !p.done(&t) {
+					d := seedingDecision{User: inst.username, Hash: t.Hash, Name: t.Name, Policy: p.label(i), Action: p.Action, Reason: reason}
+					applySeedingAction(ctx, inst, p, &d, cfg.DryRun)
+					report = append(report, d)
+				}
+				break
+			}
+		}
+	}
+	if cfg.Report != "" {
+		writeSeedingReport(cfg.Report, now, cfg.DryRun, report)
+	}
+}
+
+func applySeedingAction(ctx context.Context, inst *qbInstance, p *SeedingPolicy, d *seedingDecision, dryRun bool) {
+	if dryRun {
+		logrus.Infof("[dry-run] Seeding policy %s would %s torrent %s (%s) for user %s: %s", d.Policy, d.Action, d.Hash, d.Name, d.User, d.Reason)
+		return
+	}
+
+	hashes := []string{d.Hash}
+	var err error
+	switch p.Action {
+	case seedingPause:
+		err = inst.client.Stop(ctx, hashes)
+	case seedingRemove:
+		err = inst.client.Delete(ctx, hashes, false)
+	case seedingRemoveFiles:
+		err = inst.client.Delete(ctx, hashes, true)
+	case seedingTag:
+		err = inst.client.AddTags(ctx, hashes, []string{p.tag()})
+	default:
+		err = fmt.Errorf("unknown action %q", p.Action)
+	}
+	if err != nil {
+		d.Error = err.Error()
+		logrus.Warnf("Seeding policy %s failed to %s torrent %s: %v", d.Policy, d.Action, d.Hash, err)
+		return
+	}
+	d.Executed = true
+	logrus.Infof("Seeding policy %s: %s torrent %s (%s) for user %s: %s", d.Policy, d.Action, d.Hash, d.Name, d.User, d.Reason)
+}
+
+// writeSeedingReport 写入本次评估的报告，先写临时文件再重命名，避免读到不完整的内容
+func writeSeedingReport(path string, at time.Time, dryRun bool, decisions []seedingDecision) {
+	if decisions == nil {
+		decisions = []seedingDecision{}
+	}
+	data, err := json.MarshalIndent(map[string]any{
+		"time":      at,
+		"dry_run":   dryRun,
+		"decisions": decisions,
+	}, "", "  ")
+	if err != nil {
+		return
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		logrus.Errorf("Failed to write seeding report %s: %v", path, err)
+		return
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		logrus.Errorf("Failed to write seeding report %s: %v", path, err)
+	}
+}
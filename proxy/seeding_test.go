@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"slices"
+	"testing"
+
+	"github.com/leganck/fn-qb-proxy/qbapi"
+)
+
+func TestSeedingPolicyMatches(t *testing.T) {
+	torrent := &qbapi.Torrent{Hash: "aaa", Category: "tv", Tracker: "https://tracker.other.org/announce", Tags: "hd"}
+	hosts := func() ([]string, error) { return []string{"tracker.other.org", "tracker.site.org"}, nil }
+	failing := func() ([]string, error) { return nil, errors.New("connection refused") }
+
+	tests := []struct {
+		name     string
+		policy   SeedingPolicy
+		trackers func() ([]string, error)
+		want     bool
+		wantErr  bool
+	}{
+		{name: "no conditions", policy: SeedingPolicy{}, trackers: failing, want: true},
+		{name: "user", policy: SeedingPolicy{Users: []string{"bob"}}, trackers: failing, want: false},
+		{name: "category", policy: SeedingPolicy{Categories: []string{"tv"}}, trackers: failing, want: true},
+		{name: "tag", policy: SeedingPolicy{Tags: []string{"sd"}}, trackers: failing, want: false},
+		{name: "tracker that is not the current one", policy: SeedingPolicy{Trackers: []string{"site.org"}}, trackers: hosts, want: true},
+		{name: "tracker not in list", policy: SeedingPolicy{Trackers: []string{"another.org"}}, trackers: hosts, want: false},
+		{name: "tracker list unavailable", policy: SeedingPolicy{Trackers: []string{"site.org"}}, trackers: failing, wantErr: true},
+		// 其它条件不满足时不需要获取 Tracker 列表
+		{name: "tracker list not needed", policy: SeedingPolicy{Categories: []string{"movies"}, Trackers: []string{"site.org"}}, trackers: failing, want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.policy.matches("alice", torrent, tt.trackers)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("matches() = %v, want error", got)
+				}
+				return
+			}
+			if err != nil || got != tt.want {
+				t.Errorf("matches() = %v, %v, want %v", got, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestTorrentTrackerHosts(t *testing.T) {
+	client := newFakeQB(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != qbapi.TrackersPath || r.URL.Query().Get("hash") != "aaa" {
+			http.NotFound(w, r)
+			return
+		}
+		json.NewEncoder(w).Encode([]qbapi.Tracker{
+			{URL: "** [DHT] **"},
+			{URL: "https://Tracker.Site.org:443/announce?passkey=x"},
+			{URL: "udp://open.tracker.net:6969"},
+		})
+	}))
+
+	hosts, err := torrentTrackerHosts(context.Background(), client, "aaa")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"tracker.site.org", "open.tracker.net"}; !slices.Equal(hosts, want) {
+		t.Errorf("torrentTrackerHosts() = %v, want %v", hosts, want)
+	}
+	if _, err := torrentTrackerHosts(context.Background(), client, "bbb"); err == nil {
+		t.Error("failed request returned no error")
+	}
+}
+
+func TestSeedingConfigValidate(t *testing.T) {
+	for _, action := range []string{seedingPause, seedingRemove, seedingRemoveFiles, seedingTag} {
+		cfg := &SeedingConfig{Policies: []SeedingPolicy{{Action: action}}}
+		if err := cfg.validate(); err != nil {
+			t.Errorf("action %q: %v", action, err)
+		}
+	}
+	for _, action := range []string{"", "delete"} {
+		cfg := &SeedingConfig{Policies: []SeedingPolicy{{Action: seedingPause}, {Name: "bad", Action: action}}}
+		if err := cfg.validate(); err == nil {
+			t.Errorf("action %q was accepted", action)
+		}
+	}
+}
@@ -0,0 +1,357 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"maps"
+	"math/rand/v2"
+	"reflect"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/leganck/fn-qb-proxy/qbapi"
+	"github.com/sirupsen/logrus"
+)
+
+// syncHistorySize 保留的历史版本数量，客户端 rid 早于该范围时返回全量数据
+const syncHistorySize = 32
+
+// syncInterval 两次向上游拉取 sync/maindata 的最小间隔，0 表示不合并轮询
+var syncInterval = time.Second
+
+// syncState qBittorrent sync/maindata 的完整状态
+type syncState struct {
+	Torrents    map[string]map[string]any
+	Categories  map[string]any
+	Tags        []string
+	ServerState map[string]any
+	Trackers    map[string]any
+}
+
+func newSyncState() *syncState {
+	return &syncState{
+		Torrents:    make(map[string]map[string]any),
+		Categories:  make(map[string]any),
+		ServerState: make(map[string]any),
+		Trackers:    make(map[string]any),
+	}
+}
+
+// clone 复制状态，单个种子的字段表整体复制，字段值在合并时只会被替换不会被修改
+func (s *syncState) clone() *syncState {
+	c := &syncState{
+		Torrents:    make(map[string]map[string]any, len(s.Torrents)),
+		Categories:  maps.Clone(s.Categories),
+		Tags:        slices.Clone(s.Tags),
+		ServerState: maps.Clone(s.ServerState),
+		Trackers:    maps.Clone(s.Trackers),
+	}
+	for hash, t := range s.Torrents {
+		c.Torrents[hash] = maps.Clone(t)
+	}
+	return c
+}
+
+// apply 合并一次上游 maindata 响应
+func (s *syncState) apply(data map[string]any) {
+	if full, _ := data["full_update"].(bool); full {
+		*s = *newSyncState()
+	}
+
+	if torrents, ok := data["torrents"].(map[string]any); ok {
+		for hash, v := range torrents {
+			fields, _ := v.(map[string]any)
+			t, exists := s.Torrents[hash]
+			if !exists {
+				t = make(map[string]any, len(fields))
+				s.Torrents[hash] = t
+			}
+			for k, fv := range fields {
+				t[k] = fv
+			}
+		}
+	}
+	for _, hash := range stringList(data["torrents_removed"]) {
+		delete(s.Torrents, hash)
+	}
+
+	if categories, ok := data["categories"].(map[string]any); ok {
+		for name, v := range categories {
+			if old, ok := s.Categories[name].(map[string]any); ok {
+				merged := maps.Clone(old)
+				if fields, ok := v.(map[string]any); ok {
+					maps.Copy(merged, fields)
+				}
+				s.Categories[name] = merged
+			} else {
+				s.Categories[name] = v
+			}
+		}
+	}
+	for _, name := range stringList(data["categories_removed"]) {
+		delete(s.Categories, name)
+	}
+
+	for _, tag := range stringList(data["tags"]) {
+		if !slices.Contains(s.Tags, tag) {
+			s.Tags = append(s.Tags, tag)
+		}
+	}
+	if removed := stringList(data["tags_removed"]); len(removed) > 0 {
+		s.Tags = slices.DeleteFunc(s.Tags, func(tag string) bool { return slices.Contains(removed, tag) })
+	}
+
+	if state, ok := data["server_state"].(map[string]any); ok {
+		maps.Copy(s.ServerState, state)
+	}
+
+	if trackers, ok := data["trackers"].(map[string]any); ok {
+		maps.Copy(s.Trackers, trackers)
+	}
+	for _, tracker := range stringList(data["trackers_removed"]) {
+		delete(s.Trackers, tracker)
+	}
+}
+
+// full 生成全量响应
+func (s *syncState) full(rid int64) map[string]any {
+	torrents := make(map[string]any, len(s.Torrents))
+	for hash, t := range s.Torrents {
+		torrents[hash] = t
+	}
+	tags := s.Tags
+	if tags == nil {
+		tags = []string{}
+	}
+	return map[string]any{
+		"rid":          rid,
+		"full_update":  true,
+		"torrents":     torrents,
+		"categories":   s.Categories,
+		"tags":         tags,
+		"server_state": s.ServerState,
+		"trackers":     s.Trackers,
+	}
+}
+
+// diff 生成从 old 到 s 的增量响应，格式与 qBittorrent 一致
+func (s *syncState) diff(old *syncState, rid int64) map[string]any {
+	resp := map[string]any{"rid": rid}
+
+	torrents := make(map[string]any)
+	for hash, t := range s.Torrents {
+		prev, exists := old.Torrents[hash]
+		if !exists {
+			torrents[hash] = t
+			continue
+		}
+		changed := make(map[string]any)
+		for k, v := range t {
+			if pv, ok := prev[k]; !ok || !reflect.DeepEqual(pv, v) {
+				changed[k] = v
+			}
+		}
+		if len(changed) > 0 {
+			torrents[hash] = changed
+		}
+	}
+	if len(torrents) > 0 {
+		resp["torrents"] = torrents
+	}
+	if removed := removedKeys(old.Torrents, s.Torrents); len(removed) > 0 {
+		resp["torrents_removed"] = removed
+	}
+
+	if changed := changedEntries(old.Categories, s.Categories); len(changed) > 0 {
+		resp["categories"] = changed
+	}
+	if removed := removedKeys(old.Categories, s.Categories); len(removed) > 0 {
+		resp["categories_removed"] = removed
+	}
+
+	var added, removedTags []string
+	for _, tag := range s.Tags {
+		if !slices.Contains(old.Tags, tag) {
+			added = append(added, tag)
+		}
+	}
+	for _, tag := range old.Tags {
+		if !slices.Contains(s.Tags, tag) {
+			removedTags = append(removedTags, tag)
+		}
+	}
+	if len(added) > 0 {
+		resp["tags"] = added
+	}
+	if len(removedTags) > 0 {
+		resp["tags_removed"] = removedTags
+	}
+
+	if changed := changedEntries(old.ServerState, s.ServerState); len(changed) > 0 {
+		resp["server_state"] = changed
+	}
+
+	if changed := changedEntries(old.Trackers, s.Trackers); len(changed) > 0 {
+		resp["trackers"] = changed
+	}
+	if removed := removedKeys(old.Trackers, s.Trackers); len(removed) > 0 {
+		resp["trackers_removed"] = removed
+	}
+	return resp
+}
+
+func changedEntries(old, cur map[string]any) map[string]any {
+	changed := make(map[string]any)
+	for k, v := range cur {
+		if pv, ok := old[k]; !ok || !reflect.DeepEqual(pv, v) {
+			changed[k] = v
+		}
+	}
+	return changed
+}
+
+func removedKeys[V any](old, cur map[string]V) []string {
+	var removed []string
+	for k := range old {
+		if _, ok := cur[k]; !ok {
+			removed = append(removed, k)
+		}
+	}
+	return removed
+}
+
+func stringList(v any) []string {
+	items, _ := v.([]any)
+	list := make([]string, 0, len(items))
+	for _, item := range items {
+		if s, ok := item.(string); ok {
+			list = append(list, s)
+		}
+	}
+	return list
+}
+
+// torrentList 将状态中的种子转换为结构体列表
+func (s *syncState) torrentList() []qbapi.Torrent {
+	list := make([]qbapi.Torrent, 0, len(s.Torrents))
+	for hash, fields := range s.Torrents {
+		list = append(list, torrentFromMap(hash, fields))
+	}
+	return list
+}
+
+// freeSpace 返回 qBittorrent 报告的默认保存路径所在磁盘的可用空间
+func (s *syncState) freeSpace() (int64, bool) {
+	v, ok := s.ServerState["free_space_on_disk"].(float64)
+	return int64(v), ok
+}
+
+// syncSnapshot 某个版本的状态
+type syncSnapshot struct {
+	rid   int64
+	state *syncState
+}
+
+// syncHub 每个 qBittorrent 实例共享的 sync/maindata 轮询
+// 所有客户端的请求合并为一路上游轮询，按各自的 rid 返回增量数据
+type syncHub struct {
+	username string
+	client   *qbapi.Client
+
+	mu        sync.Mutex
+	upRid     int64 // 上游 rid
+	rid       int64 // 本地版本号，作为返回给客户端的 rid
+	state     *syncState
+	history   []syncSnapshot
+	lastFetch time.Time
+}
+
+func newSyncHub(username string, client *qbapi.Client) *syncHub {
+	return &syncHub{
+		username: username,
+		client:   client,
+		// 本地版本号从随机值开始：代理或实例重启后，客户端持有的旧 rid 不会落入新的历史范围而得到错误的增量
+		rid:   rand.Int64N(1 << 40),
+		state: newSyncState(),
+	}
+}
+
+// invalidate 使下一次请求立即向上游拉取，在修改类请求之后调用
+func (h *syncHub) invalidate() {
+	h.mu.Lock()
+	h.lastFetch = time.Time{}
+	h.mu.Unlock()
+}
+
+// refresh 距上次拉取超过 maxAge 时向上游拉取增量并合并，调用方需持有锁
+func (h *syncHub) refresh(ctx context.Context, maxAge time.Duration) error {
+	if !h.lastFetch.IsZero() && time.Since(h.lastFetch) < maxAge {
+		return nil
+	}
+
+	data, err := h.client.MainData(ctx, h.upRid)
+	if err != nil {
+		return err
+	}
+	h.lastFetch = time.Now()
+	if rid, ok := data["rid"].(float64); ok {
+		h.upRid = int64(rid)
+	}
+
+	prev := h.state.clone()
+	h.state.apply(data)
+	// 首次拉取的是已有种子，不产生事件；没有变化时也需要检查，停滞事件在持续一段时间后才产生
+	if len(h.history) > 0 {
+		detectEvents(h.username, prev, h.state)
+	}
+	if len(h.history) > 0 && len(h.state.diff(prev, 0)) == 1 {
+		// 无变化，不产生新版本
+		return nil
+	}
+
+	h.rid++
+	h.history = append(h.history, syncSnapshot{rid: h.rid, state: h.state.clone()})
+	if len(h.history) > syncHistorySize {
+		h.history = h.history[len(h.history)-syncHistorySize:]
+	}
+	logrus.Debugf("Sync hub for user %s advanced to rid %d (upstream rid %d)", h.username, h.rid, h.upRid)
+	return nil
+}
+
+// Poll 没有客户端请求时由代理主动拉取，用于发现种子状态变化
+func (h *syncHub) Poll(ctx context.Context, maxAge time.Duration) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.refresh(ctx, maxAge)
+}
+
+// Snapshot 返回当前状态的副本，距上次拉取超过 maxAge 时先向上游拉取
+func (h *syncHub) Snapshot(ctx context.Context, maxAge time.Duration) (*syncState, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if err := h.refresh(ctx, maxAge); err != nil {
+		return nil, err
+	}
+	return h.state.clone(), nil
+}
+
+// MainData 返回客户端 rid 之后的增量数据（已编码为 JSON）
+// 状态在锁外可能被修改，因此在持有锁时完成编码
+func (h *syncHub) MainData(ctx context.Context, clientRid int64) ([]byte, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if err := h.refresh(ctx, syncInterval); err != nil {
+		return nil, err
+	}
+
+	if clientRid > 0 {
+		for _, snap := range h.history {
+			if snap.rid == clientRid {
+				return json.Marshal(h.state.diff(snap.state, h.rid))
+			}
+		}
+	}
+	return json.Marshal(h.state.full(h.rid))
+}
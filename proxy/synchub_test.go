@@ -0,0 +1,221 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"testing"
+
+	"github.com/leganck/fn-qb-proxy/qbapi"
+)
+
+// newFakeQB 在临时 Unix Socket 上启动模拟的 qBittorrent，登录总是成功
+func newFakeQB(t *testing.T, handler http.Handler) *qbapi.Client {
+	t.Helper()
+	sock := filepath.Join(t.TempDir(), "qb.sock")
+	l, err := net.Listen("unix", sock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v2/auth/login", func(w http.ResponseWriter, r *http.Request) {
+		http.SetCookie(w, &http.Cookie{Name: "SID", Value: "test"})
+		w.Write([]byte("Ok."))
+	})
+	mux.Handle("/", handler)
+	srv := &http.Server{Handler: mux}
+	go srv.Serve(l)
+	t.Cleanup(func() { srv.Close() })
+	return qbapi.New(sock, "")
+}
+
+// addFakeInstance 注册使用模拟 qBittorrent 的实例，测试结束时移除
+func addFakeInstance(t *testing.T, username string, handler http.Handler) *qbInstance {
+	t.Helper()
+	client := newFakeQB(t, handler)
+	inst := &qbInstance{username: username, client: client, proxyClient: client, hub: newSyncHub(username, client)}
+	instanceMutex.Lock()
+	instances[username] = inst
+	instanceMutex.Unlock()
+	t.Cleanup(func() {
+		instanceMutex.Lock()
+		delete(instances, username)
+		instanceMutex.Unlock()
+	})
+	return inst
+}
+
+func TestSyncStateDiff(t *testing.T) {
+	base := map[string]any{
+		"full_update": true,
+		"torrents": map[string]any{
+			"aaa": map[string]any{"name": "a", "progress": 0.5, "state": "downloading"},
+			"bbb": map[string]any{"name": "b", "progress": 1.0, "state": "uploading"},
+		},
+		"categories":   map[string]any{"tv": map[string]any{"name": "tv", "savePath": "/tv"}},
+		"tags":         []any{"x", "y"},
+		"server_state": map[string]any{"dl_info_speed": 100.0, "free_space_on_disk": 1000.0},
+		"trackers":     map[string]any{"http://t/a": []any{"aaa"}},
+	}
+	tests := []struct {
+		name   string
+		update map[string]any
+		want   map[string]any
+	}{
+		{
+			name:   "no change",
+			update: map[string]any{},
+			want:   map[string]any{},
+		},
+		{
+			name:   "changed torrent fields only",
+			update: map[string]any{"torrents": map[string]any{"aaa": map[string]any{"progress": 0.75, "state": "downloading"}}},
+			want:   map[string]any{"torrents": map[string]any{"aaa": map[string]any{"progress": 0.75}}},
+		},
+		{
+			name: "added and removed torrents",
+			update: map[string]any{
+				"torrents":         map[string]any{"ccc": map[string]any{"name": "c"}},
+				"torrents_removed": []any{"bbb"},
+			},
+			want: map[string]any{
+				"torrents":         map[string]any{"ccc": map[string]any{"name": "c"}},
+				"torrents_removed": []string{"bbb"},
+			},
+		},
+		{
+			name:   "category fields are merged",
+			update: map[string]any{"categories": map[string]any{"tv": map[string]any{"savePath": "/tv2"}}},
+			want:   map[string]any{"categories": map[string]any{"tv": map[string]any{"name": "tv", "savePath": "/tv2"}}},
+		},
+		{
+			name:   "removed category",
+			update: map[string]any{"categories_removed": []any{"tv"}},
+			want:   map[string]any{"categories_removed": []string{"tv"}},
+		},
+		{
+			name:   "tags",
+			update: map[string]any{"tags": []any{"z", "x"}, "tags_removed": []any{"y"}},
+			want:   map[string]any{"tags": []string{"z"}, "tags_removed": []string{"y"}},
+		},
+		{
+			name:   "server state",
+			update: map[string]any{"server_state": map[string]any{"dl_info_speed": 200.0, "free_space_on_disk": 1000.0}},
+			want:   map[string]any{"server_state": map[string]any{"dl_info_speed": 200.0}},
+		},
+		{
+			name:   "trackers",
+			update: map[string]any{"trackers": map[string]any{"http://t/b": []any{"aaa"}}, "trackers_removed": []any{"http://t/a"}},
+			want: map[string]any{
+				"trackers":         map[string]any{"http://t/b": []any{"aaa"}},
+				"trackers_removed": []string{"http://t/a"},
+			},
+		},
+		{
+			name: "full update replaces state",
+			update: map[string]any{
+				"full_update":  true,
+				"torrents":     map[string]any{"aaa": map[string]any{"name": "a", "progress": 0.5, "state": "downloading"}},
+				"server_state": map[string]any{"dl_info_speed": 100.0, "free_space_on_disk": 1000.0},
+			},
+			want: map[string]any{
+				"torrents_removed":   []string{"bbb"},
+				"categories_removed": []string{"tv"},
+				"tags_removed":       []string{"x", "y"},
+				"trackers_removed":   []string{"http://t/a"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			old := newSyncState()
+			old.apply(base)
+			cur := old.clone()
+			cur.apply(tt.update)
+
+			got := cur.diff(old, 7)
+			if got["rid"] != int64(7) {
+				t.Errorf("rid = %v, want 7", got["rid"])
+			}
+			delete(got, "rid")
+			for _, v := range got {
+				if list, ok := v.([]string); ok {
+					slices.Sort(list)
+				}
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("diff() = %v, want %v", got, tt.want)
+			}
+
+			// 在旧状态上应用增量后应与新状态一致
+			replay := old.clone()
+			replay.apply(toJSONValue(t, got))
+			if len(replay.diff(cur, 0)) != 1 || len(cur.diff(replay, 0)) != 1 {
+				t.Errorf("applying the diff does not reproduce the state: %v", cur.diff(replay, 0))
+			}
+		})
+	}
+}
+
+// toJSONValue 将增量经过一次 JSON 编解码，模拟客户端收到的数据
+func toJSONValue(t *testing.T, v map[string]any) map[string]any {
+	t.Helper()
+	data, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out map[string]any
+	if err := json.Unmarshal(data, &out); err != nil {
+		t.Fatal(err)
+	}
+	return out
+}
+
+func TestSyncHubRid(t *testing.T) {
+	progress := 0.0
+	client := newFakeQB(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		progress += 0.1
+		json.NewEncoder(w).Encode(map[string]any{
+			"rid":         1,
+			"full_update": true,
+			"torrents":    map[string]any{"aaa": map[string]any{"name": "a", "progress": progress}},
+		})
+	}))
+	ctx := context.Background()
+	mainData := func(h *syncHub, rid int64) map[string]any {
+		t.Helper()
+		h.invalidate()
+		data, err := h.MainData(ctx, rid)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var resp map[string]any
+		if err := json.Unmarshal(data, &resp); err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+
+	hub := newSyncHub("alice", client)
+	first := mainData(hub, 0)
+	rid := int64(first["rid"].(float64))
+	next := mainData(hub, rid)
+	if next["full_update"] == true {
+		t.Errorf("known rid %d got a full update", rid)
+	}
+	if int64(next["rid"].(float64)) <= rid {
+		t.Errorf("rid did not advance: %v -> %v", rid, next["rid"])
+	}
+
+	// 新的 hub（代理或实例重启）不能把旧客户端的 rid 当作自己的版本
+	restarted := newSyncHub("alice", client)
+	mainData(restarted, 0)
+	mainData(restarted, 0)
+	if resp := mainData(restarted, rid); resp["full_update"] != true {
+		t.Errorf("stale rid %d from a previous hub got an incremental update: %v", rid, resp)
+	}
+}
@@ -0,0 +1,302 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/leganck/fn-qb-proxy/qbapi"
+	"github.com/sirupsen/logrus"
+)
+
+// Tracker 状态码，见 /api/v2/torrents/trackers
+const (
+	trackerNotContacted = 1
+	trackerWorking      = 2
+	trackerNotWorking   = 4
+)
+
+// 种子的 Tracker 健康状况
+const (
+	trackerHealthy      = "ok"
+	trackerError        = "error"
+	trackerUnregistered = "unregistered"
+)
+
+// defaultUnregisteredMessages 各站点表示种子已被删除或不存在的常见 Tracker 消息
+var defaultUnregisteredMessages = []string{
+	"unregistered",
+	"not registered",
+	"torrent not found",
+	"unknown torrent",
+	"torrent does not exist",
+	"torrent has been deleted",
+	"infohash not found",
+}
+
+// TrackersConfig Tracker 健康检查与地址替换
+type TrackersConfig struct {
+	Interval        Duration         `json:"interval"`         // 检查间隔，默认 30m
+	Users           []string         `json:"users"`            // 为空表示所有实例
+	ErrorTag        string           `json:"error_tag"`        // 所有 Tracker 都不工作的种子添加的标签，默认 tracker-error
+	UnregisteredTag string           `json:"unregistered_tag"` // Tracker 报告种子未注册时添加的标签，默认 unregistered
+	Unregistered    []string         `json:"unregistered"`     // 额外的未注册消息关键字，不区分大小写
+	Rewrites        []TrackerRewrite `json:"rewrites"`
+	DryRun          bool             `json:"dry_run"` // 只记录将要替换的地址
+}
+
+// TrackerRewrite Tracker 地址替换规则，如更换 passkey 或站点域名
+type TrackerRewrite struct {
+	Name    string   `json:"name"`
+	Users   []string `json:"users"`   // 为空表示所有用户
+	Match   string   `json:"match"`   // 匹配 Tracker 地址的正则表达式
+	Replace string   `json:"replace"` // 替换内容，可以使用 $1 等分组
+
+	re *regexp.Regexp
+}
+
+func (c *TrackersConfig) validate() error {
+	for i := range c.Rewrites {
+		r := &c.Rewrites[i]
+		if r.Match == "" {
+			return fmt.Errorf("rewrite %s: match is required", r.label(i))
+		}
+		re, err := regexp.Compile(r.Match)
+		if err != nil {
+			return fmt.Errorf("rewrite %s: %w", r.label(i), err)
+		}
+		r.re = re
+	}
+	return nil
+}
+
+func (c *TrackersConfig) errorTag() string {
+	if c.ErrorTag != "" {
+		return c.ErrorTag
+	}
+	return "tracker-error"
+}
+
+func (c *TrackersConfig) unregisteredTag() string {
+	if c.UnregisteredTag != "" {
+		return c.UnregisteredTag
+	}
+	return "unregistered"
+}
+
+func (r *TrackerRewrite) label(i int) string {
+	if r.Name != "" {
+		return r.Name
+	}
+	return fmt.Sprintf("#%d", i)
+}
+
+// rewrite 返回按第一条匹配规则替换后的地址
+func (c *TrackersConfig) rewrite(username, u string) (string, bool) {
+	for _, r := range c.Rewrites {
+		if len(r.Users) > 0 && !slices.Contains(r.Users, username) {
+			continue
+		}
+		if r.re.MatchString(u) {
+			if v := r.re.ReplaceAllString(u, r.Replace); v != u {
+				return v, true
+			}
+		}
+	}
+	return "", false
+}
+
+// isUnregistered 判断 Tracker 消息是否表示种子未注册
+func (c *TrackersConfig) isUnregistered(msg string) bool {
+	msg = strings.ToLower(msg)
+	for _, list := range [][]string{defaultUnregisteredMessages, c.Unregistered} {
+		for _, m := range list {
+			if m != "" && strings.Contains(msg, strings.ToLower(m)) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// classifyTrackers 根据 Tracker 列表判断种子的健康状况，还没有联系过 Tracker（如暂停的种子）时返回空
+// 任一 Tracker 报告未注册即为 unregistered，所有 Tracker 都不工作时为 error
+func (c *TrackersConfig) classifyTrackers(trackers []qbapi.Tracker) (health, msg string) {
+	working, failing := 0, 0
+	for _, tr := range trackers {
+		if tr.IsPseudo() {
+			continue
+		}
+		switch tr.Status {
+		case trackerWorking:
+			working++
+		case trackerNotWorking:
+			if c.isUnregistered(tr.Msg) {
+				return trackerUnregistered, tr.URL + ": " + tr.Msg
+			}
+			failing++
+			if msg == "" {
+				msg = tr.URL + ": " + tr.Msg
+			}
+		}
+	}
+	switch {
+	case working > 0:
+		return trackerHealthy, ""
+	case failing > 0:
+		return trackerError, msg
+	}
+	return "", ""
+}
+
+// trackerProblem 管理接口返回的有问题的种子
+type trackerProblem struct {
+	Instance string    `json:"instance"`
+	Hash     string    `json:"hash"`
+	Name     string    `json:"name"`
+	Health   string    `json:"health"`
+	Message  string    `json:"message"`
+	Checked  time.Time `json:"checked"`
+}
+
+var (
+	trackerProblems   = make(map[string][]trackerProblem) // 用户 -> 最近一次检查发现的问题
+	trackerProblemsMu sync.Mutex
+)
+
+// runTrackerMonitor 定期检查所有实例的 Tracker
+func runTrackerMonitor(ctx context.Context) {
+	for {
+		cfg := currentConfig().Trackers
+		interval := 30 * time.Minute
+		if cfg != nil {
+			interval = cfg.Interval.Or(interval)
+			for _, inst := range listInstances() {
+				if len(cfg.Users) > 0 && !slices.Contains(cfg.Users, inst.username) {
+					continue
+				}
+				if err := checkInstanceTrackers(ctx, cfg, inst); err != nil {
+					logrus.Warnf("Trackers: failed to check user %s: %v", inst.username, err)
+				}
+			}
+		}
+
+		select {
+		case <-time.After(interval):
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// checkInstanceTrackers 为种子添加或移除健康状况标签，并按规则替换 Tracker 地址；
+// 有可用 Tracker 的种子同样需要查询，其它 Tracker 可能报告未注册
+func checkInstanceTrackers(ctx context.Context, cfg *TrackersConfig, inst *qbInstance) error {
+	state, err := inst.hub.Snapshot(ctx, syncInterval)
+	if err != nil {
+		return err
+	}
+	errorTag, unregTag := cfg.errorTag(), cfg.unregisteredTag()
+	tagged := map[string][]string{}   // 标签 -> 需要添加的种子
+	untagged := map[string][]string{} // 标签 -> 需要移除的种子
+	var problems []trackerProblem
+	now := time.Now()
+
+	for _, t := range state.torrentList() {
+		hasError, hasUnreg := t.HasTag(errorTag), t.HasTag(unregTag)
+		if isMetaState(t.State) {
+			continue
+		}
+		trackers, err := inst.client.Trackers(ctx, t.Hash)
+		if err != nil {
+			logrus.Debugf("Trackers: failed to get trackers of %s for user %s: %v", t.Hash, inst.username, err)
+			continue
+		}
+		if len(cfg.Rewrites) > 0 {
+			rewriteTrackers(ctx, cfg, inst, &t, trackers)
+		}
+
+		health, msg := cfg.classifyTrackers(trackers)
+		if health == "" {
+			continue
+		}
+		want := map[string]bool{errorTag: health == trackerError, unregTag: health == trackerUnregistered}
+		for tag, on := range want {
+			switch has := t.HasTag(tag); {
+			case on && !has:
+				tagged[tag] = append(tagged[tag], t.Hash)
+			case !on && has:
+				untagged[tag] = append(untagged[tag], t.Hash)
+			}
+		}
+		if health != trackerHealthy {
+			if !hasError && !hasUnreg {
+				logrus.Infof("Trackers: %s of user %s is %s: %s", t.Name, inst.username, health, msg)
+			}
+			problems = append(problems, trackerProblem{Instance: inst.username, Hash: t.Hash, Name: t.Name, Health: health, Message: msg, Checked: now})
+		}
+	}
+
+	for tag, hashes := range tagged {
+		if err := inst.proxyClient.AddTags(ctx, hashes, []string{tag}); err != nil {
+			logrus.Errorf("Trackers: failed to add tag %s for user %s: %v", tag, inst.username, err)
+		}
+	}
+	for tag, hashes := range untagged {
+		logrus.Infof("Trackers: removed tag %s from %d torrents of user %s", tag, len(hashes), inst.username)
+		if err := inst.proxyClient.RemoveTags(ctx, hashes, []string{tag}); err != nil {
+			logrus.Errorf("Trackers: failed to remove tag %s for user %s: %v", tag, inst.username, err)
+		}
+	}
+
+	trackerProblemsMu.Lock()
+	trackerProblems[inst.username] = problems
+	trackerProblemsMu.Unlock()
+	return nil
+}
+
+// rewriteTrackers 按规则替换种子的 Tracker 地址，替换成功后同步更新 trackers 中的地址
+func rewriteTrackers(ctx context.Context, cfg *TrackersConfig, inst *qbInstance, t *qbapi.Torrent, trackers []qbapi.Tracker) {
+	for i, tr := range trackers {
+		if tr.IsPseudo() {
+			continue
+		}
+		newURL, ok := cfg.rewrite(inst.username, tr.URL)
+		if !ok {
+			continue
+		}
+		if cfg.DryRun {
+			logrus.Infof("Trackers: [dry run] would rewrite tracker of %s for user %s: %s -> %s", t.Name, inst.username, tr.URL, newURL)
+			continue
+		}
+		if err := inst.client.EditTracker(ctx, t.Hash, tr.URL, newURL); err != nil {
+			logrus.Errorf("Trackers: failed to rewrite tracker of %s for user %s: %v", t.Name, inst.username, err)
+			continue
+		}
+		logrus.Infof("Trackers: rewrote tracker of %s for user %s: %s -> %s", t.Name, inst.username, tr.URL, newURL)
+		// 新地址还没有联系过，不能沿用旧地址的状态
+		trackers[i].URL = newURL
+		trackers[i].Status = trackerNotContacted
+		trackers[i].Msg = ""
+	}
+}
+
+func init() {
+	adminMux.HandleFunc("GET /admin/trackers", handleAdminTrackers)
+}
+
+// handleAdminTrackers 返回最近一次检查中 Tracker 有问题的种子
+func handleAdminTrackers(w http.ResponseWriter, r *http.Request) {
+	list := []trackerProblem{}
+	trackerProblemsMu.Lock()
+	for _, inst := range adminInstances(r) {
+		list = append(list, trackerProblems[inst.username]...)
+	}
+	trackerProblemsMu.Unlock()
+	writeAdminJSON(w, list)
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"testing"
+
+	"github.com/leganck/fn-qb-proxy/qbapi"
+)
+
+func TestClassifyTrackers(t *testing.T) {
+	dht := qbapi.Tracker{URL: "** [DHT] **", Status: trackerWorking}
+	working := qbapi.Tracker{URL: "https://a/announce", Status: trackerWorking}
+	timeout := qbapi.Tracker{URL: "https://b/announce", Status: trackerNotWorking, Msg: "timed out"}
+	refused := qbapi.Tracker{URL: "https://c/announce", Status: trackerNotWorking, Msg: "connection refused"}
+	unregistered := qbapi.Tracker{URL: "https://d/announce", Status: trackerNotWorking, Msg: "Torrent Not Registered with this tracker"}
+	custom := qbapi.Tracker{URL: "https://e/announce", Status: trackerNotWorking, Msg: "种子已被删除"}
+	notContacted := qbapi.Tracker{URL: "https://f/announce", Status: trackerNotContacted}
+
+	cfg := &TrackersConfig{Unregistered: []string{"已被删除"}}
+	tests := []struct {
+		name     string
+		trackers []qbapi.Tracker
+		health   string
+		msg      string
+	}{
+		{name: "no trackers", trackers: nil, health: ""},
+		{name: "only DHT", trackers: []qbapi.Tracker{dht}, health: ""},
+		{name: "not contacted", trackers: []qbapi.Tracker{notContacted}, health: ""},
+		{name: "working", trackers: []qbapi.Tracker{dht, working}, health: trackerHealthy},
+		{name: "one of several failing", trackers: []qbapi.Tracker{timeout, working}, health: trackerHealthy},
+		{name: "all failing", trackers: []qbapi.Tracker{dht, timeout, refused}, health: trackerError, msg: "https://b/announce: timed out"},
+		{name: "failing and not contacted", trackers: []qbapi.Tracker{notContacted, refused}, health: trackerError, msg: "https://c/announce: connection refused"},
+		// 未注册优先于其它 Tracker 的状态
+		{name: "unregistered", trackers: []qbapi.Tracker{working, unregistered}, health: trackerUnregistered, msg: "https://d/announce: Torrent Not Registered with this tracker"},
+		{name: "configured message", trackers: []qbapi.Tracker{custom}, health: trackerUnregistered, msg: "https://e/announce: 种子已被删除"},
+	}
+	for _, tt := range tests {
+		health, msg := cfg.classifyTrackers(tt.trackers)
+		if health != tt.health || msg != tt.msg {
+			t.Errorf("%s: classifyTrackers() = %q, %q, want %q, %q", tt.name, health, msg, tt.health, tt.msg)
+		}
+	}
+
+	// 未配置时只使用内置的消息
+	if health, _ := (&TrackersConfig{}).classifyTrackers([]qbapi.Tracker{custom}); health != trackerError {
+		t.Errorf("custom message without configuration: health = %q, want %q", health, trackerError)
+	}
+}
+
+func TestTrackerRewrite(t *testing.T) {
+	cfg := &TrackersConfig{Rewrites: []TrackerRewrite{
+		{Users: []string{"bob"}, Match: `passkey=\w+`, Replace: "passkey=bobkey"},
+		{Match: `^https?://old\.site\.org/`, Replace: "https://new.site.org/"},
+	}}
+	if err := cfg.validate(); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		user, url, want string
+		ok              bool
+	}{
+		{"alice", "http://old.site.org/announce?passkey=abc", "https://new.site.org/announce?passkey=abc", true},
+		{"bob", "http://old.site.org/announce?passkey=abc", "http://old.site.org/announce?passkey=bobkey", true},
+		{"bob", "https://new.site.org/announce?passkey=bobkey", "", false},
+		{"alice", "udp://other.org:6969", "", false},
+	}
+	for _, tt := range tests {
+		got, ok := cfg.rewrite(tt.user, tt.url)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("rewrite(%s, %s) = %q, %v, want %q, %v", tt.user, tt.url, got, ok, tt.want, tt.ok)
+		}
+	}
+	if err := (&TrackersConfig{Rewrites: []TrackerRewrite{{Match: "("}}}).validate(); err == nil {
+		t.Error("invalid pattern was accepted")
+	}
+}
+
+func TestCheckInstanceTrackers(t *testing.T) {
+	client := newFakeQB(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/v2/sync/maindata":
+			// 两个种子的当前 Tracker 都在工作，只有查询列表才能发现未注册
+			fmt.Fprint(w, `{"rid":1,"full_update":true,"torrents":{
+				"aaa":{"name":"a","state":"uploading","tracker":"https://a/announce"},
+				"bbb":{"name":"b","state":"uploading","tracker":"https://a/announce","tags":"unregistered"}}}`)
+		case "/api/v2/torrents/trackers":
+			if r.URL.Query().Get("hash") == "aaa" {
+				fmt.Fprint(w, `[{"url":"https://a/announce","status":2},{"url":"https://d/announce","status":4,"msg":"unregistered torrent"}]`)
+			} else {
+				fmt.Fprint(w, `[{"url":"https://a/announce","status":2}]`)
+			}
+		default:
+			http.Error(w, "unexpected request to the native socket", http.StatusForbidden)
+		}
+	}))
+	var mu sync.Mutex
+	calls := map[string]string{}
+	proxy := newFakeQB(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		mu.Lock()
+		calls[r.URL.Path] = r.FormValue("hashes") + " " + r.FormValue("tags")
+		mu.Unlock()
+	}))
+	inst := &qbInstance{username: "alice", client: client, proxyClient: proxy, hub: newSyncHub("alice", client)}
+
+	if err := checkInstanceTrackers(context.Background(), &TrackersConfig{}, inst); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{
+		"/api/v2/torrents/addTags":    "aaa unregistered",
+		"/api/v2/torrents/removeTags": "bbb unregistered",
+	}
+	for path, v := range want {
+		if calls[path] != v {
+			t.Errorf("%s = %q, want %q", path, calls[path], v)
+		}
+	}
+}
@@ -0,0 +1,312 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/leganck/fn-qb-proxy/qbapi"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	watchDoneDir   = "done"
+	watchFailedDir = "failed"
+	// watchErrorSuffix 失败文件旁边的错误说明文件后缀
+	watchErrorSuffix = ".error.txt"
+	// watchMaxFileSize 读取的 .torrent 或磁力链接文件的最大大小
+	watchMaxFileSize = 64 << 20
+)
+
+// WatchConfig 监视目录导入配置
+type WatchConfig struct {
+	Dir        string            `json:"dir"`         // 监视目录，{user} 会替换为 fnOS 用户名
+	Users      map[string]string `json:"users"`       // 按用户单独指定的监视目录，优先于 dir
+	Interval   Duration          `json:"interval"`    // 轮询间隔，默认 30s；inotify 可用时变化会立即触发扫描
+	SettleTime Duration          `json:"settle_time"` // 文件最后修改后等待的时间，避免读到未写完的文件，默认 2s
+	TextFiles  bool              `json:"text_files"`  // 同时从 .txt 文件中读取磁力链接，默认只处理 .torrent 与 .magnet
+	Rules      []WatchRule       `json:"rules"`
+}
+
+// WatchRule 按子目录指定添加参数，匹配最长的子目录路径
+type WatchRule struct {
+	Path     string   `json:"path"` // 相对监视目录的子目录，空字符串表示根目录
+	Category string   `json:"category"`
+	Tags     []string `json:"tags"`
+	SavePath string   `json:"save_path"`
+	Paused   bool     `json:"paused"`
+}
+
+// userDir 返回用户的监视目录，未配置时返回空
+func (c *WatchConfig) userDir(username string) string {
+	if dir, ok := c.Users[username]; ok {
+		return dir
+	}
+	if c.Dir == "" {
+		return ""
+	}
+	return strings.ReplaceAll(c.Dir, "{user}", username)
+}
+
+// ruleFor 返回与文件所在子目录匹配的规则
+func (c *WatchConfig) ruleFor(relDir string) WatchRule {
+	relDir = filepath.ToSlash(relDir)
+	if relDir == "." {
+		relDir = ""
+	}
+	best, bestLen := WatchRule{}, -1
+	for _, rule := range c.Rules {
+		p := strings.Trim(filepath.ToSlash(rule.Path), "/")
+		if p != "" && relDir != p && !strings.HasPrefix(relDir, p+"/") {
+			continue
+		}
+		if len(p) > bestLen {
+			best, bestLen = rule, len(p)
+		}
+	}
+	return best
+}
+
+// runWatcher 定时（以及 inotify 通知时）扫描每个用户的监视目录
+func runWatcher(ctx context.Context) {
+	notifier, err := newDirNotifier()
+	if err != nil {
+		logrus.Warnf("Watch folder falls back to polling: %v", err)
+	} else {
+		defer notifier.Close()
+	}
+
+	interval := watchInterval(currentConfig().Watch)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		cfg := currentConfig().Watch
+		if cfg != nil {
+			scanWatchDirs(ctx, cfg, notifier)
+		}
+
+		// 配置重新加载后间隔可能变化
+		if want := watchInterval(cfg); want != interval {
+			interval = want
+			ticker.Reset(interval)
+		}
+
+		var events <-chan struct{}
+		if notifier != nil {
+			events = notifier.Events()
+		}
+		select {
+		case <-ticker.C:
+		case <-events:
+			// 合并短时间内的连续变化
+			time.Sleep(500 * time.Millisecond)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func watchInterval(cfg *WatchConfig) time.Duration {
+	if cfg == nil {
+		return 30 * time.Second
+	}
+	return cfg.Interval.Or(30 * time.Second)
+}
+
+func scanWatchDirs(ctx context.Context, cfg *WatchConfig, notifier *dirNotifier) {
+	for _, inst := range listInstances() {
+		root := cfg.userDir(inst.username)
+		if root == "" {
+			continue
+		}
+		if _, err := os.Stat(root); err != nil {
+			logrus.Debugf("Watch dir %s for user %s not available: %v", root, inst.username, err)
+			continue
+		}
+		scanWatchDir(ctx, cfg, inst, root, notifier)
+	}
+}
+
+func scanWatchDir(ctx context.Context, cfg *WatchConfig, inst *qbInstance, root string, notifier *dirNotifier) {
+	settle := cfg.SettleTime.Or(2 * time.Second)
+	filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			logrus.Debugf("Watch dir walk error at %s: %v", path, err)
+			return nil
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		rel, _ := filepath.Rel(root, path)
+		if d.IsDir() {
+			if rel == watchDoneDir || rel == watchFailedDir || strings.HasPrefix(d.Name(), ".") && rel != "." {
+				return filepath.SkipDir
+			}
+			if notifier != nil {
+				notifier.Watch(path)
+			}
+			return nil
+		}
+
+		// 监视目录属于用户，代理以 root 运行，不跟随用户放置的符号链接
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		kind := watchFileKind(d.Name(), cfg.TextFiles)
+		if kind == "" {
+			return nil
+		}
+		// 导入后无法移走的文件会在每次扫描时重复导入，先确认 done/ 与 failed/ 可用
+		for _, p := range []string{path, filepath.Join(root, watchDoneDir, rel), filepath.Join(root, watchFailedDir, rel)} {
+			if err := checkNoSymlinks(root, filepath.Dir(p)); err != nil {
+				logrus.Warnf("Skipped %s for user %s: %v", path, inst.username, err)
+				return nil
+			}
+		}
+		info, err := d.Info()
+		if err != nil || time.Since(info.ModTime()) < settle {
+			return nil
+		}
+
+		rule := cfg.ruleFor(filepath.Dir(rel))
+		if err := importWatchFile(ctx, inst, path, kind, rule); err != nil {
+			logrus.Warnf("Failed to import %s for user %s: %v", path, inst.username, err)
+			moveWatchFile(root, rel, watchFailedDir, err)
+		} else {
+			logrus.Infof("Imported %s for user %s", path, inst.username)
+			moveWatchFile(root, rel, watchDoneDir, nil)
+		}
+		return nil
+	})
+}
+
+// watchFileKind 根据扩展名判断文件类型，不支持的文件返回空；.txt 只在 textFiles 开启时处理
+func watchFileKind(name string, textFiles bool) string {
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".torrent":
+		return "torrent"
+	case ".magnet":
+		return "magnet"
+	case ".txt":
+		if !textFiles || strings.HasSuffix(name, watchErrorSuffix) {
+			return ""
+		}
+		return "magnet"
+	}
+	return ""
+}
+
+// readWatchFile 以 O_NOFOLLOW 读取普通文件，检查之后被替换为符号链接时同样拒绝，
+// O_NONBLOCK 避免被替换为 FIFO 时阻塞
+func readWatchFile(path string) ([]byte, error) {
+	f, err := os.OpenFile(path, os.O_RDONLY|openNoFollow|openNonBlock, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if !info.Mode().IsRegular() {
+		return nil, errors.New("not a regular file")
+	}
+	return io.ReadAll(io.LimitReader(f, watchMaxFileSize))
+}
+
+// importWatchFile 通过用户的代理 Socket 提交种子，使代理上的添加检查同样生效
+func importWatchFile(ctx context.Context, inst *qbInstance, path, kind string, rule WatchRule) error {
+	data, err := readWatchFile(path)
+	if err != nil {
+		return err
+	}
+
+	opts := qbapi.AddOptions{
+		SavePath: rule.SavePath,
+		Category: rule.Category,
+		Tags:     rule.Tags,
+		Paused:   rule.Paused,
+	}
+	if kind == "torrent" {
+		opts.Files = []qbapi.TorrentFile{{Name: filepath.Base(path), Data: data}}
+	} else {
+		for _, field := range strings.Fields(string(data)) {
+			if strings.HasPrefix(field, "magnet:?") {
+				opts.URLs = append(opts.URLs, field)
+			}
+		}
+		if len(opts.URLs) == 0 {
+			return errors.New("no magnet links found in file")
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, time.Minute)
+	defer cancel()
+	return inst.proxyClient.AddTorrent(ctx, opts)
+}
+
+// moveWatchFile 将处理过的文件移动到 done/ 或 failed/ 下的相同相对路径，失败时写入错误说明
+// 目标路径中任何一级是符号链接（如用户把 done/ 替换为指向其它位置的链接）时不移动
+func moveWatchFile(root, rel, target string, cause error) {
+	dst := filepath.Join(root, target, rel)
+	if err := checkNoSymlinks(root, filepath.Dir(dst)); err != nil {
+		logrus.Errorf("Refused to move %s: %v", rel, err)
+		return
+	}
+	if err := mkdirAllLike(filepath.Dir(dst), root); err != nil {
+		logrus.Errorf("Failed to create %s: %v", filepath.Dir(dst), err)
+		return
+	}
+	if err := checkNoSymlinks(root, filepath.Dir(dst)); err != nil {
+		logrus.Errorf("Refused to move %s: %v", rel, err)
+		return
+	}
+	// 目标已存在时追加时间戳，避免覆盖之前的记录
+	if _, err := os.Lstat(dst); err == nil {
+		ext := filepath.Ext(dst)
+		dst = fmt.Sprintf("%s.%d%s", strings.TrimSuffix(dst, ext), time.Now().Unix(), ext)
+	}
+	if err := os.Rename(filepath.Join(root, rel), dst); err != nil {
+		logrus.Errorf("Failed to move %s to %s: %v", rel, dst, err)
+		return
+	}
+
+	if cause != nil {
+		sidecar := dst + watchErrorSuffix
+		msg := fmt.Sprintf("%s\n%v\n", time.Now().Format(time.RFC3339), cause)
+		os.Remove(sidecar)
+		f, err := os.OpenFile(sidecar, os.O_WRONLY|os.O_CREATE|os.O_EXCL|openNoFollow, 0644)
+		if err == nil {
+			_, err = f.WriteString(msg)
+			if closeErr := f.Close(); err == nil {
+				err = closeErr
+			}
+		}
+		if err != nil {
+			logrus.Errorf("Failed to write %s: %v", sidecar, err)
+			return
+		}
+		chownLike(sidecar, root)
+	}
+}
+
+// mkdirAllLike 创建目录，并将新建目录的属主设置为与 ref 相同
+func mkdirAllLike(dir, ref string) error {
+	if _, err := os.Stat(dir); err == nil {
+		return nil
+	}
+	if err := mkdirAllLike(filepath.Dir(dir), ref); err != nil {
+		return err
+	}
+	if err := os.Mkdir(dir, 0755); err != nil && !os.IsExist(err) {
+		return err
+	}
+	chownLike(dir, ref)
+	return nil
+}
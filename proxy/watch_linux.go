@@ -0,0 +1,22 @@
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+const (
+	openNoFollow = syscall.O_NOFOLLOW
+	openNonBlock = syscall.O_NONBLOCK
+)
+
+// chownLike 代理以 root 运行，新建的文件需归还给监视目录的属主
+func chownLike(path, ref string) {
+	info, err := os.Stat(ref)
+	if err != nil {
+		return
+	}
+	if st, ok := info.Sys().(*syscall.Stat_t); ok {
+		os.Lchown(path, int(st.Uid), int(st.Gid))
+	}
+}
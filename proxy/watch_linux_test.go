@@ -0,0 +1,33 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+)
+
+func TestReadWatchFileSpecial(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "a.magnet")
+	if err := os.WriteFile(file, []byte("magnet:?xt=urn:btih:x"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// 符号链接可能指向监视目录之外的文件
+	link := filepath.Join(dir, "link.magnet")
+	if err := os.Symlink(file, link); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := readWatchFile(link); err == nil {
+		t.Error("symlink was followed")
+	}
+	// 没有写入方的 FIFO 不能阻塞扫描
+	fifo := filepath.Join(dir, "fifo.magnet")
+	if err := syscall.Mkfifo(fifo, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := readWatchFile(fifo); err == nil {
+		t.Error("fifo was read")
+	}
+}
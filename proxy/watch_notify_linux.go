@@ -0,0 +1,98 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"os"
+	"sync"
+	"syscall"
+
+	"github.com/sirupsen/logrus"
+)
+
+// dirNotifier 基于 inotify 的目录变更通知，只关心"有变化"，具体变化由调用方重新扫描得到
+type dirNotifier struct {
+	fd     int // 直接保存 fd，调用 os.File.Fd() 会把文件切换回阻塞模式
+	file   *os.File
+	events chan struct{}
+
+	mu      sync.Mutex
+	watched map[string]int32
+	dirs    map[int32]string
+}
+
+func newDirNotifier() (*dirNotifier, error) {
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC | syscall.IN_NONBLOCK)
+	if err != nil {
+		return nil, fmt.Errorf("inotify init: %w", err)
+	}
+	n := &dirNotifier{
+		// 非阻塞 fd 交给 os.File 后由运行时轮询，Close 可以打断阻塞中的 Read
+		fd:      fd,
+		file:    os.NewFile(uintptr(fd), "inotify"),
+		events:  make(chan struct{}, 1),
+		watched: make(map[string]int32),
+		dirs:    make(map[int32]string),
+	}
+	go n.readLoop()
+	return n, nil
+}
+
+// Watch 监视目录（不递归），重复调用无副作用
+func (n *dirNotifier) Watch(dir string) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if _, ok := n.watched[dir]; ok {
+		return
+	}
+	wd, err := syscall.InotifyAddWatch(n.fd, dir,
+		syscall.IN_CLOSE_WRITE|syscall.IN_MOVED_TO|syscall.IN_CREATE)
+	if err != nil {
+		logrus.Debugf("Failed to watch %s: %v", dir, err)
+		return
+	}
+	n.watched[dir] = int32(wd)
+	n.dirs[int32(wd)] = dir
+}
+
+// Events 目录发生变化时收到通知，多次变化会合并为一次
+func (n *dirNotifier) Events() <-chan struct{} {
+	return n.events
+}
+
+func (n *dirNotifier) Close() {
+	n.file.Close()
+}
+
+func (n *dirNotifier) readLoop() {
+	buf := make([]byte, 64*1024)
+	for {
+		count, err := n.file.Read(buf)
+		if err != nil {
+			return
+		}
+		changed := false
+		for offset := 0; offset+syscall.SizeofInotifyEvent <= count; {
+			wd := int32(binary.LittleEndian.Uint32(buf[offset:]))
+			mask := binary.LittleEndian.Uint32(buf[offset+4:])
+			nameLen := int(binary.LittleEndian.Uint32(buf[offset+12:]))
+			offset += syscall.SizeofInotifyEvent + nameLen
+
+			if mask&syscall.IN_IGNORED != 0 {
+				// 目录被删除后 inotify 自动移除监视，下次扫描时重新添加
+				n.mu.Lock()
+				delete(n.watched, n.dirs[wd])
+				delete(n.dirs, wd)
+				n.mu.Unlock()
+				continue
+			}
+			changed = true
+		}
+		if changed {
+			select {
+			case n.events <- struct{}{}:
+			default:
+			}
+		}
+	}
+}
@@ -0,0 +1,18 @@
+//go:build !linux
+
+package main
+
+import "errors"
+
+// dirNotifier 非 Linux 平台不支持 inotify，调用方回退为定时轮询
+type dirNotifier struct{}
+
+func newDirNotifier() (*dirNotifier, error) {
+	return nil, errors.New("inotify is not supported on this platform")
+}
+
+func (n *dirNotifier) Watch(dir string) {}
+
+func (n *dirNotifier) Events() <-chan struct{} { return nil }
+
+func (n *dirNotifier) Close() {}
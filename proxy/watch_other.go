@@ -0,0 +1,12 @@
+//go:build !linux
+
+package main
+
+// 非 Linux 平台不使用 O_NOFOLLOW 与 O_NONBLOCK，只依赖打开前的符号链接检查
+const (
+	openNoFollow = 0
+	openNonBlock = 0
+)
+
+// chownLike 非 Linux 平台不调整属主
+func chownLike(path, ref string) {}
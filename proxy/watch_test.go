@@ -0,0 +1,83 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWatchRuleFor(t *testing.T) {
+	cfg := &WatchConfig{Rules: []WatchRule{
+		{Path: "tv/anime", Category: "anime"},
+		{Path: "", Category: "default"},
+		{Path: "/tv/", Category: "tv"},
+		{Path: "movies", Category: "movies"},
+	}}
+	tests := []struct{ dir, want string }{
+		{".", "default"},
+		{"", "default"},
+		{"tv", "tv"},
+		{"tv/shows", "tv"},
+		// 最长的匹配优先，与规则顺序无关
+		{"tv/anime", "anime"},
+		{"tv/anime/2024", "anime"},
+		// 只按完整的目录名匹配
+		{"tv2", "default"},
+		{"tv/anime-old", "tv"},
+		{"music", "default"},
+		{filepath.Join("movies", "4k"), "movies"},
+	}
+	for _, tt := range tests {
+		if got := cfg.ruleFor(tt.dir).Category; got != tt.want {
+			t.Errorf("ruleFor(%q) = %q, want %q", tt.dir, got, tt.want)
+		}
+	}
+
+	// 没有根目录规则时不匹配的子目录使用空规则
+	cfg.Rules = cfg.Rules[2:]
+	if got := cfg.ruleFor("music"); got.Category != "" {
+		t.Errorf("ruleFor(music) = %q without root rule", got.Category)
+	}
+	if got := (&WatchConfig{}).ruleFor("tv"); got.Category != "" {
+		t.Errorf("ruleFor without rules = %+v", got)
+	}
+}
+
+func TestWatchFileKind(t *testing.T) {
+	tests := []struct {
+		name      string
+		textFiles bool
+		want      string
+	}{
+		{"a.torrent", false, "torrent"},
+		{"A.TORRENT", false, "torrent"},
+		{"a.magnet", false, "magnet"},
+		{"a.txt", false, ""},
+		{"a.txt", true, "magnet"},
+		{"A.TXT", true, "magnet"},
+		// 处理失败时写入的错误说明不能再次被当作磁力链接文件
+		{"a.torrent" + watchErrorSuffix, true, ""},
+		{"a.torrent.part", false, ""},
+		{"torrent", false, ""},
+		{".magnet", false, "magnet"},
+	}
+	for _, tt := range tests {
+		if got := watchFileKind(tt.name, tt.textFiles); got != tt.want {
+			t.Errorf("watchFileKind(%q, %v) = %q, want %q", tt.name, tt.textFiles, got, tt.want)
+		}
+	}
+}
+
+func TestReadWatchFile(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "a.magnet")
+	if err := os.WriteFile(file, []byte("magnet:?xt=urn:btih:x"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if data, err := readWatchFile(file); err != nil || string(data) != "magnet:?xt=urn:btih:x" {
+		t.Fatalf("readWatchFile = %q, %v", data, err)
+	}
+	if _, err := readWatchFile(dir); err == nil {
+		t.Error("directory was read")
+	}
+}
@@ -0,0 +1,343 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/leganck/fn-qb-proxy/qbapi"
+	"github.com/sirupsen/logrus"
+)
+
+// 停滞处理动作
+const (
+	watchdogReannounce  = "reannounce"
+	watchdogAddTrackers = "add_trackers"
+	watchdogTag         = "tag"
+	watchdogNotify      = "notify"
+	watchdogRemove      = "remove"
+	watchdogRemoveFiles = "remove_files"
+)
+
+// WatchdogConfig 停滞下载检测，种子持续没有下载进度时按步骤逐级处理
+type WatchdogConfig struct {
+	Interval       Duration         `json:"interval"`        // 检查间隔，默认 5m
+	PublicTrackers string           `json:"public_trackers"` // add_trackers 使用的公共 Tracker 列表文件，每行一个地址
+	Tag            string           `json:"tag"`             // tag 动作添加的标签，默认 stuck，恢复下载后自动移除
+	DryRun         bool             `json:"dry_run"`         // 只记录将要执行的动作
+	Policies       []WatchdogPolicy `json:"policies"`
+}
+
+// WatchdogPolicy 停滞处理策略，按顺序为每个种子选择第一条匹配的策略
+type WatchdogPolicy struct {
+	Name       string         `json:"name"`
+	Users      []string       `json:"users"`
+	Categories []string       `json:"categories"`
+	Tags       []string       `json:"tags"`    // 带有任一标签即匹配
+	MinAge     Duration       `json:"min_age"` // remove、remove_files 只处理添加时间超过该值的种子，使用 remove_files 时必须设置
+	Steps      []WatchdogStep `json:"steps"`
+}
+
+// WatchdogStep 没有进度的时间达到 after 时执行一次 action，步骤按 after 从小到大排列
+type WatchdogStep struct {
+	After  Duration `json:"after"`
+	Action string   `json:"action"` // reannounce、add_trackers、tag、notify、remove、remove_files
+}
+
+func (c *WatchdogConfig) validate() error {
+	for i, p := range c.Policies {
+		for j, s := range p.Steps {
+			switch s.Action {
+			case watchdogReannounce, watchdogTag, watchdogNotify, watchdogRemove:
+			case watchdogRemoveFiles:
+				if p.MinAge <= 0 {
+					return fmt.Errorf("policy %s: remove_files requires min_age", p.label(i))
+				}
+			case watchdogAddTrackers:
+				if c.PublicTrackers == "" {
+					return fmt.Errorf("policy %s: add_trackers requires public_trackers", p.label(i))
+				}
+			default:
+				return fmt.Errorf("policy %s: unknown action %q", p.label(i), s.Action)
+			}
+			if s.After <= 0 {
+				return fmt.Errorf("policy %s: step %s requires after", p.label(i), s.Action)
+			}
+			if j > 0 && s.After < p.Steps[j-1].After {
+				return fmt.Errorf("policy %s: steps must be ordered by after", p.label(i))
+			}
+		}
+	}
+	return nil
+}
+
+func (c *WatchdogConfig) tag() string {
+	if c.Tag != "" {
+		return c.Tag
+	}
+	return "stuck"
+}
+
+func (p *WatchdogPolicy) label(i int) string {
+	if p.Name != "" {
+		return p.Name
+	}
+	return fmt.Sprintf("#%d", i)
+}
+
+func (p *WatchdogPolicy) matches(username string, t *qbapi.Torrent) bool {
+	if len(p.Users) > 0 && !slices.Contains(p.Users, username) {
+		return false
+	}
+	if len(p.Categories) > 0 && !slices.Contains(p.Categories, t.Category) {
+		return false
+	}
+	if len(p.Tags) > 0 && !slices.ContainsFunc(p.Tags, t.HasTag) {
+		return false
+	}
+	return true
+}
+
+// stallState 种子最近一次有进度的时间及已执行的步骤
+type stallState struct {
+	User      string    `json:"user"`
+	Hash      string    `json:"hash"`
+	Name      string    `json:"name"`
+	Policy    string    `json:"policy"`
+	Completed int64     `json:"completed"`
+	Since     time.Time `json:"since"`
+	Done      []string  `json:"done"` // 已执行的动作
+	steps     int       // 已执行的步骤数
+	tagged    bool
+}
+
+var (
+	stallStates   = make(map[string]*stallState) // 用户/hash -> 状态
+	stallStatesMu sync.Mutex
+)
+
+// isWaitingDownload 是否为应当有下载进度的种子：未完成、未暂停、不在排队、校验或出错
+func isWaitingDownload(t *qbapi.Torrent) bool {
+	if t.IsFinished() || t.IsPaused() || t.IsErrored() {
+		return false
+	}
+	switch t.State {
+	case "queuedDL", "checkingDL", "checkingResumeData", "allocating", "moving":
+		return false
+	}
+	return true
+}
+
+// runWatchdog 定期检查停滞的下载
+func runWatchdog(ctx context.Context) {
+	for {
+		cfg := currentConfig().Watchdog
+		interval := 5 * time.Minute
+		if cfg != nil {
+			interval = cfg.Interval.Or(interval)
+			checkStalledDownloads(ctx, cfg)
+		}
+
+		select {
+		case <-time.After(interval):
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func checkStalledDownloads(ctx context.Context, cfg *WatchdogConfig) {
+	now := time.Now()
+	seen := make(map[string]bool)
+	for _, inst := range listInstances() {
+		state, err := inst.hub.Snapshot(ctx, syncInterval)
+		if err != nil {
+			logrus.Warnf("Watchdog: failed to get torrents for user %s: %v", inst.username, err)
+			continue
+		}
+		for _, t := range state.torrentList() {
+			key := inst.username + "/" + t.Hash
+			if !isWaitingDownload(&t) {
+				// 在两次检查之间下载完成的种子同样移除停滞标签
+				if t.IsFinished() && t.HasTag(cfg.tag()) {
+					untagStalled(ctx, cfg, inst, t)
+				}
+				continue
+			}
+			policy := -1
+			for i := range cfg.Policies {
+				if cfg.Policies[i].matches(inst.username, &t) {
+					policy = i
+					break
+				}
+			}
+			if policy < 0 {
+				continue
+			}
+			seen[key] = true
+			p := &cfg.Policies[policy]
+
+			stallStatesMu.Lock()
+			s := stallStates[key]
+			resumed := false
+			switch {
+			case s == nil:
+				// 从当前时间开始计时：刚从暂停或排队中恢复的种子，最近活动时间可能是几天前
+				s = &stallState{User: inst.username, Hash: t.Hash, Completed: t.Completed, Since: now, tagged: t.HasTag(cfg.tag())}
+				stallStates[key] = s
+			case t.Completed > s.Completed:
+				resumed = s.tagged
+				*s = stallState{User: inst.username, Hash: t.Hash, Completed: t.Completed, Since: now}
+			}
+			s.Name, s.Policy = t.Name, p.label(policy)
+			stalled := now.Sub(s.Since)
+			// 每次检查最多执行一个步骤，让前面的动作（如重新汇报）有时间生效
+			action := ""
+			if s.steps < len(p.Steps) && stalled >= time.Duration(p.Steps[s.steps].After) {
+				action = p.Steps[s.steps].Action
+				// 添加时间不足 min_age 的种子不删除，等待之后的检查
+				if (action == watchdogRemove || action == watchdogRemoveFiles) && now.Sub(time.Unix(t.AddedOn, 0)) < time.Duration(p.MinAge) {
+					action = ""
+				} else {
+					s.Done = append(s.Done, action)
+					s.steps++
+				}
+			}
+			stallStatesMu.Unlock()
+
+			if resumed {
+				untagStalled(ctx, cfg, inst, t)
+			}
+			if action != "" {
+				runWatchdogAction(ctx, cfg, inst, t, action, stalled)
+			}
+		}
+	}
+
+	// 已完成、暂停或删除的种子不再跟踪
+	stallStatesMu.Lock()
+	for key := range stallStates {
+		if !seen[key] {
+			delete(stallStates, key)
+		}
+	}
+	stallStatesMu.Unlock()
+}
+
+// runWatchdogAction 通过代理 Socket 执行动作，代理上的拦截与检查同样生效
+func runWatchdogAction(ctx context.Context, cfg *WatchdogConfig, inst *qbInstance, t qbapi.Torrent, action string, stalled time.Duration) {
+	prefix := ""
+	if cfg.DryRun {
+		prefix = "[dry run] "
+	}
+	logrus.Infof("Watchdog: %s%s %s of user %s (no progress for %s)", prefix, action, t.Name, inst.username, stalled.Round(time.Second))
+	if cfg.DryRun {
+		return
+	}
+
+	client := inst.proxyClient
+	hashes := []string{t.Hash}
+	var err error
+	switch action {
+	case watchdogReannounce:
+		err = client.Reannounce(ctx, hashes)
+	case watchdogAddTrackers:
+		err = addPublicTrackers(ctx, client, cfg.PublicTrackers, t.Hash)
+	case watchdogTag:
+		err = client.AddTags(ctx, hashes, []string{cfg.tag()})
+		if err == nil {
+			stallStatesMu.Lock()
+			if s := stallStates[inst.username+"/"+t.Hash]; s != nil {
+				s.tagged = true
+			}
+			stallStatesMu.Unlock()
+		}
+	case watchdogNotify:
+		publishEvent(torrentEvent{Type: torrentStuck, User: inst.username, Time: time.Now(), Torrent: t})
+	case watchdogRemove, watchdogRemoveFiles:
+		if err = client.Delete(ctx, hashes, action == watchdogRemoveFiles); err == nil {
+			stallStatesMu.Lock()
+			delete(stallStates, inst.username+"/"+t.Hash)
+			stallStatesMu.Unlock()
+		}
+	}
+	if err != nil {
+		logrus.Errorf("Watchdog: %s failed for %s of user %s: %v", action, t.Name, inst.username, err)
+	}
+}
+
+// untagStalled 种子恢复下载后移除停滞标签
+func untagStalled(ctx context.Context, cfg *WatchdogConfig, inst *qbInstance, t qbapi.Torrent) {
+	logrus.Infof("Watchdog: %s of user %s is no longer stuck", t.Name, inst.username)
+	if cfg.DryRun {
+		return
+	}
+	if err := inst.proxyClient.RemoveTags(ctx, []string{t.Hash}, []string{cfg.tag()}); err != nil {
+		logrus.Warnf("Watchdog: failed to remove tag from %s: %v", t.Name, err)
+	}
+}
+
+// addPublicTrackers 添加列表文件中种子还没有的 Tracker，# 开头的行为注释
+func addPublicTrackers(ctx context.Context, client *qbapi.Client, path, hash string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	current, err := client.Trackers(ctx, hash)
+	if err != nil {
+		return err
+	}
+	var missing []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		u := strings.TrimSpace(scanner.Text())
+		if u == "" || strings.HasPrefix(u, "#") {
+			continue
+		}
+		if !slices.ContainsFunc(current, func(tr qbapi.Tracker) bool { return tr.URL == u }) && !slices.Contains(missing, u) {
+			missing = append(missing, u)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	if len(missing) == 0 {
+		return nil
+	}
+	return client.AddTrackers(ctx, hash, missing)
+}
+
+func init() {
+	adminMux.HandleFunc("GET /admin/stalled", handleAdminStalled)
+}
+
+// handleAdminStalled 返回正在跟踪的停滞下载，停滞时间最长的在前
+func handleAdminStalled(w http.ResponseWriter, r *http.Request) {
+	users := make(map[string]bool)
+	for _, inst := range adminInstances(r) {
+		users[inst.username] = true
+	}
+	now := time.Now()
+	type entry struct {
+		stallState
+		Stalled string `json:"stalled"`
+	}
+	list := []entry{}
+	stallStatesMu.Lock()
+	for _, s := range stallStates {
+		if users[s.User] && len(s.Done) > 0 {
+			list = append(list, entry{stallState: *s, Stalled: now.Sub(s.Since).Round(time.Second).String()})
+		}
+	}
+	stallStatesMu.Unlock()
+	sort.Slice(list, func(i, j int) bool { return list[i].Since.Before(list[j].Since) })
+	writeAdminJSON(w, list)
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/leganck/fn-qb-proxy/qbapi"
+)
+
+// fakeStalledQB 只有一个下载中种子的 qBittorrent，记录代理上执行的动作
+type fakeStalledQB struct {
+	mu        sync.Mutex
+	completed int64
+	progress  float64
+	addedOn   int64
+	tags      string
+	calls     []string
+}
+
+func (f *fakeStalledQB) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if r.URL.Path == qbapi.MainDataPath {
+		json.NewEncoder(w).Encode(map[string]any{
+			"rid":         1,
+			"full_update": true,
+			"torrents": map[string]any{
+				"aaa": map[string]any{
+					"name":      "slow",
+					"state":     "stalledDL",
+					"progress":  f.progress,
+					"completed": f.completed,
+					"added_on":  f.addedOn,
+					"tags":      f.tags,
+				},
+			},
+		})
+		return
+	}
+	r.ParseForm()
+	call := r.URL.Path
+	if v := r.PostForm.Get("deleteFiles"); v != "" {
+		call += " deleteFiles=" + v
+	}
+	f.calls = append(f.calls, call)
+}
+
+func (f *fakeStalledQB) set(fn func()) {
+	f.mu.Lock()
+	fn()
+	f.mu.Unlock()
+}
+
+// takeCalls 返回并清空上次调用以来执行的动作
+func (f *fakeStalledQB) takeCalls() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	calls := f.calls
+	f.calls = nil
+	return calls
+}
+
+func TestCheckStalledDownloads(t *testing.T) {
+	resetStallStates := func() {
+		stallStatesMu.Lock()
+		clear(stallStates)
+		stallStatesMu.Unlock()
+	}
+	resetStallStates()
+	t.Cleanup(resetStallStates)
+
+	qb := &fakeStalledQB{completed: 100, progress: 0.5, addedOn: time.Now().Unix()}
+	inst := addFakeInstance(t, "watchdog-alice", qb)
+	cfg := &WatchdogConfig{Policies: []WatchdogPolicy{{
+		Name:   "default",
+		MinAge: Duration(24 * time.Hour),
+		Steps: []WatchdogStep{
+			{After: Duration(time.Hour), Action: watchdogReannounce},
+			{After: Duration(2 * time.Hour), Action: watchdogTag},
+			{After: Duration(3 * time.Hour), Action: watchdogRemoveFiles},
+		},
+	}}}
+	if err := cfg.validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	const key = "watchdog-alice/aaa"
+	// stalledFor 把最近一次有进度的时间往前移，模拟经过的时间
+	stalledFor := func(d time.Duration) {
+		stallStatesMu.Lock()
+		stallStates[key].Since = time.Now().Add(-d)
+		stallStatesMu.Unlock()
+	}
+	state := func() stallState {
+		stallStatesMu.Lock()
+		defer stallStatesMu.Unlock()
+		s := stallStates[key]
+		if s == nil {
+			t.Fatalf("%s is not tracked", key)
+		}
+		return *s
+	}
+	check := func(want ...string) {
+		t.Helper()
+		inst.hub.invalidate()
+		checkStalledDownloads(context.Background(), cfg)
+		if got := qb.takeCalls(); !slices.Equal(got, want) {
+			t.Fatalf("calls = %q, want %q", got, want)
+		}
+	}
+
+	// 第一次看到的种子从当前时间开始计时
+	check()
+	if s := state(); time.Since(s.Since) > time.Minute || s.steps != 0 {
+		t.Fatalf("new state = %+v", s)
+	}
+
+	// 有了进度后重新计时，不执行任何步骤
+	stalledFor(10 * time.Hour)
+	qb.set(func() { qb.completed = 200 })
+	check()
+	if s := state(); time.Since(s.Since) > time.Minute || s.steps != 0 || s.Completed != 200 {
+		t.Fatalf("state after progress = %+v", s)
+	}
+
+	// 所有步骤都已到期，每次检查也只执行一个
+	stalledFor(10 * time.Hour)
+	check("/api/v2/torrents/reannounce")
+	check("/api/v2/torrents/addTags")
+	if s := state(); s.steps != 2 || !s.tagged {
+		t.Fatalf("state after tag = %+v", s)
+	}
+
+	// 添加时间不足 min_age，不删除也不消耗步骤
+	check()
+	check()
+	if s := state(); s.steps != 2 {
+		t.Fatalf("steps after min_age = %d, want 2", s.steps)
+	}
+
+	// 恢复下载后移除停滞标签并重新计时
+	qb.set(func() { qb.completed, qb.tags = 300, "stuck" })
+	check("/api/v2/torrents/removeTags")
+	if s := state(); s.steps != 0 || s.tagged {
+		t.Fatalf("state after resume = %+v", s)
+	}
+
+	// 超过 min_age 后按步骤删除种子与文件
+	qb.set(func() { qb.addedOn = time.Now().Add(-48 * time.Hour).Unix() })
+	stalledFor(10 * time.Hour)
+	check("/api/v2/torrents/reannounce")
+	check("/api/v2/torrents/addTags")
+	check("/api/v2/torrents/delete deleteFiles=true")
+	stallStatesMu.Lock()
+	_, tracked := stallStates[key]
+	stallStatesMu.Unlock()
+	if tracked {
+		t.Fatal("removed torrent is still tracked")
+	}
+}
+
+func TestCheckStalledDownloadsUntagFinished(t *testing.T) {
+	qb := &fakeStalledQB{completed: 100, progress: 1, tags: "stuck"}
+	inst := addFakeInstance(t, "watchdog-bob", qb)
+	cfg := &WatchdogConfig{Policies: []WatchdogPolicy{{Steps: []WatchdogStep{{After: Duration(time.Hour), Action: watchdogTag}}}}}
+
+	inst.hub.invalidate()
+	checkStalledDownloads(context.Background(), cfg)
+	if got, want := qb.takeCalls(), []string{"/api/v2/torrents/removeTags"}; !slices.Equal(got, want) {
+		t.Fatalf("calls = %q, want %q", got, want)
+	}
+}
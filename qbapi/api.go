@@ -0,0 +1,332 @@
+package qbapi
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// API 路径
+const (
+	MainDataPath        = "/api/v2/sync/maindata"
+	TorrentsInfoPath    = "/api/v2/torrents/info"
+	TorrentsAddPath     = "/api/v2/torrents/add"
+	TorrentsDeletePath  = "/api/v2/torrents/delete"
+	TransferInfoPath    = "/api/v2/transfer/info"
+	DefaultSavePathPath = "/api/v2/app/defaultSavePath"
+	VersionPath         = "/api/v2/app/version"
+	CategoriesPath      = "/api/v2/torrents/categories"
+	FilesPath           = "/api/v2/torrents/files"
+	TrackersPath        = "/api/v2/torrents/trackers"
+	ExportPath          = "/api/v2/torrents/export"
+)
+
+// GetJSON 发送 GET 请求并将响应解析到 v
+func (c *Client) GetJSON(ctx context.Context, path string, query url.Values, v any) error {
+	data, err := c.Get(ctx, path, query)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return fmt.Errorf("decode %s: %w", path, err)
+	}
+	return nil
+}
+
+// MainData 获取 sync/maindata 的原始 JSON 对象
+func (c *Client) MainData(ctx context.Context, rid int64) (map[string]any, error) {
+	var data map[string]any
+	err := c.GetJSON(ctx, MainDataPath, url.Values{"rid": {strconv.FormatInt(rid, 10)}}, &data)
+	return data, err
+}
+
+// Torrents 获取种子列表，query 为 torrents/info 支持的过滤参数
+func (c *Client) Torrents(ctx context.Context, query url.Values) ([]Torrent, error) {
+	var list []Torrent
+	err := c.GetJSON(ctx, TorrentsInfoPath, query, &list)
+	return list, err
+}
+
+// TransferInfo 获取全局传输信息
+func (c *Client) TransferInfo(ctx context.Context) (*TransferInfo, error) {
+	var info TransferInfo
+	if err := c.GetJSON(ctx, TransferInfoPath, nil, &info); err != nil {
+		return nil, err
+	}
+	return &info, nil
+}
+
+// DefaultSavePath 获取默认保存路径
+func (c *Client) DefaultSavePath(ctx context.Context) (string, error) {
+	data, err := c.Get(ctx, DefaultSavePathPath, nil)
+	return strings.TrimSpace(string(data)), err
+}
+
+// Version 获取 qBittorrent 版本
+func (c *Client) Version(ctx context.Context) (string, error) {
+	data, err := c.Get(ctx, VersionPath, nil)
+	return strings.TrimSpace(string(data)), err
+}
+
+// AddTorrent 添加种子（链接或 .torrent 文件）
+func (c *Client) AddTorrent(ctx context.Context, opts AddOptions) error {
+	var buf bytes.Buffer
+	mw := multipart.NewWriter(&buf)
+
+	if len(opts.URLs) > 0 {
+		mw.WriteField("urls", strings.Join(opts.URLs, "\n"))
+	}
+	for _, f := range opts.Files {
+		name := f.Name
+		if name == "" {
+			name = "upload.torrent"
+		}
+		fw, err := mw.CreateFormFile("torrents", name)
+		if err != nil {
+			return err
+		}
+		fw.Write(f.Data)
+	}
+	if opts.SavePath != "" {
+		mw.WriteField("savepath", opts.SavePath)
+	}
+	if opts.Category != "" {
+		mw.WriteField("category", opts.Category)
+	}
+	if len(opts.Tags) > 0 {
+		mw.WriteField("tags", strings.Join(opts.Tags, ","))
+	}
+	if opts.Paused {
+		// v4 使用 paused，v5 改名为 stopped
+		mw.WriteField("paused", "true")
+		mw.WriteField("stopped", "true")
+	}
+	if opts.SkipChecking {
+		mw.WriteField("skip_checking", "true")
+	}
+	if opts.Rename != "" {
+		mw.WriteField("rename", opts.Rename)
+	}
+	if err := mw.Close(); err != nil {
+		return err
+	}
+
+	data, err := c.PostRaw(ctx, TorrentsAddPath, mw.FormDataContentType(), buf.Bytes())
+	if err != nil {
+		return err
+	}
+	if strings.TrimSpace(string(data)) == "Fails." {
+		return fmt.Errorf("qBittorrent rejected the torrent")
+	}
+	return nil
+}
+
+// Delete 删除种子，deleteFiles 为 true 时同时删除已下载的文件
+func (c *Client) Delete(ctx context.Context, hashes []string, deleteFiles bool) error {
+	_, err := c.PostForm(ctx, TorrentsDeletePath, url.Values{
+		"hashes":      {strings.Join(hashes, "|")},
+		"deleteFiles": {strconv.FormatBool(deleteFiles)},
+	})
+	return err
+}
+
+// Stop 暂停种子，兼容 v5 的 stop 与 v4 的 pause
+func (c *Client) Stop(ctx context.Context, hashes []string) error {
+	return c.postCompat(ctx, "/api/v2/torrents/stop", "/api/v2/torrents/pause",
+		url.Values{"hashes": {strings.Join(hashes, "|")}})
+}
+
+// Start 继续种子，兼容 v5 的 start 与 v4 的 resume
+func (c *Client) Start(ctx context.Context, hashes []string) error {
+	return c.postCompat(ctx, "/api/v2/torrents/start", "/api/v2/torrents/resume",
+		url.Values{"hashes": {strings.Join(hashes, "|")}})
+}
+
+// Recheck 重新校验种子数据
+func (c *Client) Recheck(ctx context.Context, hashes []string) error {
+	_, err := c.PostForm(ctx, "/api/v2/torrents/recheck", url.Values{"hashes": {strings.Join(hashes, "|")}})
+	return err
+}
+
+// Reannounce 立即向所有 Tracker 重新汇报
+func (c *Client) Reannounce(ctx context.Context, hashes []string) error {
+	_, err := c.PostForm(ctx, "/api/v2/torrents/reannounce", url.Values{"hashes": {strings.Join(hashes, "|")}})
+	return err
+}
+
+// Categories 获取所有分类
+func (c *Client) Categories(ctx context.Context) (map[string]Category, error) {
+	categories := make(map[string]Category)
+	err := c.GetJSON(ctx, CategoriesPath, nil, &categories)
+	return categories, err
+}
+
+// CreateCategory 创建分类，已存在时返回 409 错误
+func (c *Client) CreateCategory(ctx context.Context, name, savePath string) error {
+	_, err := c.PostForm(ctx, "/api/v2/torrents/createCategory", url.Values{"category": {name}, "savePath": {savePath}})
+	return err
+}
+
+// RemoveCategories 删除分类
+func (c *Client) RemoveCategories(ctx context.Context, names []string) error {
+	_, err := c.PostForm(ctx, "/api/v2/torrents/removeCategories", url.Values{"categories": {strings.Join(names, "\n")}})
+	return err
+}
+
+// SetCategory 设置种子分类，category 为空表示清除分类
+func (c *Client) SetCategory(ctx context.Context, hashes []string, category string) error {
+	_, err := c.PostForm(ctx, "/api/v2/torrents/setCategory", url.Values{
+		"hashes":   {strings.Join(hashes, "|")},
+		"category": {category},
+	})
+	return err
+}
+
+// AddTags 为种子添加标签，不存在的标签会自动创建
+func (c *Client) AddTags(ctx context.Context, hashes, tags []string) error {
+	_, err := c.PostForm(ctx, "/api/v2/torrents/addTags", url.Values{
+		"hashes": {strings.Join(hashes, "|")},
+		"tags":   {strings.Join(tags, ",")},
+	})
+	return err
+}
+
+// RemoveTags 移除种子的标签，标签本身仍然保留
+func (c *Client) RemoveTags(ctx context.Context, hashes, tags []string) error {
+	_, err := c.PostForm(ctx, "/api/v2/torrents/removeTags", url.Values{
+		"hashes": {strings.Join(hashes, "|")},
+		"tags":   {strings.Join(tags, ",")},
+	})
+	return err
+}
+
+// SetLocation 修改种子保存路径，qBittorrent 会移动已下载的文件
+func (c *Client) SetLocation(ctx context.Context, hashes []string, location string) error {
+	_, err := c.PostForm(ctx, "/api/v2/torrents/setLocation", url.Values{
+		"hashes":   {strings.Join(hashes, "|")},
+		"location": {location},
+	})
+	return err
+}
+
+// SetDownloadLimit 设置种子下载限速（字节/秒），0 表示不限速
+func (c *Client) SetDownloadLimit(ctx context.Context, hashes []string, limit int64) error {
+	_, err := c.PostForm(ctx, "/api/v2/torrents/setDownloadLimit", url.Values{
+		"hashes": {strings.Join(hashes, "|")},
+		"limit":  {strconv.FormatInt(limit, 10)},
+	})
+	return err
+}
+
+// SetUploadLimit 设置种子上传限速（字节/秒），0 表示不限速
+func (c *Client) SetUploadLimit(ctx context.Context, hashes []string, limit int64) error {
+	_, err := c.PostForm(ctx, "/api/v2/torrents/setUploadLimit", url.Values{
+		"hashes": {strings.Join(hashes, "|")},
+		"limit":  {strconv.FormatInt(limit, 10)},
+	})
+	return err
+}
+
+// SetShareLimits 设置种子分享限制，ratio 与 seedingMinutes 为 -2 表示使用全局设置、-1 表示不限制
+func (c *Client) SetShareLimits(ctx context.Context, hashes []string, ratio float64, seedingMinutes int64) error {
+	_, err := c.PostForm(ctx, "/api/v2/torrents/setShareLimits", url.Values{
+		"hashes":                   {strings.Join(hashes, "|")},
+		"ratioLimit":               {strconv.FormatFloat(ratio, 'f', -1, 64)},
+		"seedingTimeLimit":         {strconv.FormatInt(seedingMinutes, 10)},
+		"inactiveSeedingTimeLimit": {"-2"},
+	})
+	return err
+}
+
+// Files 获取种子的文件列表，元数据尚未获取时为空
+func (c *Client) Files(ctx context.Context, hash string) ([]FileInfo, error) {
+	var files []FileInfo
+	err := c.GetJSON(ctx, FilesPath, url.Values{"hash": {hash}}, &files)
+	return files, err
+}
+
+// Trackers 获取种子的 Tracker 列表（包含 DHT/PeX/LSD 伪条目）
+func (c *Client) Trackers(ctx context.Context, hash string) ([]Tracker, error) {
+	var trackers []Tracker
+	err := c.GetJSON(ctx, TrackersPath, url.Values{"hash": {hash}}, &trackers)
+	return trackers, err
+}
+
+// Export 导出种子的 .torrent 文件（qBittorrent 4.5 及以上），元数据尚未获取时失败
+func (c *Client) Export(ctx context.Context, hash string) ([]byte, error) {
+	return c.Get(ctx, ExportPath, url.Values{"hash": {hash}})
+}
+
+// AddTrackers 为种子添加 Tracker
+func (c *Client) AddTrackers(ctx context.Context, hash string, urls []string) error {
+	_, err := c.PostForm(ctx, "/api/v2/torrents/addTrackers", url.Values{
+		"hash": {hash},
+		"urls": {strings.Join(urls, "\n")},
+	})
+	return err
+}
+
+// EditTracker 替换种子的 Tracker 地址
+func (c *Client) EditTracker(ctx context.Context, hash, origURL, newURL string) error {
+	_, err := c.PostForm(ctx, "/api/v2/torrents/editTracker", url.Values{
+		"hash":    {hash},
+		"origUrl": {origURL},
+		"newUrl":  {newURL},
+	})
+	return err
+}
+
+// SetPreferences 修改 qBittorrent 设置，只需包含要修改的字段
+func (c *Client) SetPreferences(ctx context.Context, prefs map[string]any) error {
+	data, err := json.Marshal(prefs)
+	if err != nil {
+		return err
+	}
+	_, err = c.PostForm(ctx, "/api/v2/app/setPreferences", url.Values{"json": {string(data)}})
+	return err
+}
+
+// SetGlobalDownloadLimit 设置全局下载限速（字节/秒），0 表示不限速
+func (c *Client) SetGlobalDownloadLimit(ctx context.Context, limit int64) error {
+	_, err := c.PostForm(ctx, "/api/v2/transfer/setDownloadLimit", url.Values{"limit": {strconv.FormatInt(limit, 10)}})
+	return err
+}
+
+// SetGlobalUploadLimit 设置全局上传限速（字节/秒），0 表示不限速
+func (c *Client) SetGlobalUploadLimit(ctx context.Context, limit int64) error {
+	_, err := c.PostForm(ctx, "/api/v2/transfer/setUploadLimit", url.Values{"limit": {strconv.FormatInt(limit, 10)}})
+	return err
+}
+
+// AltSpeedEnabled 判断是否启用了备用限速
+func (c *Client) AltSpeedEnabled(ctx context.Context) (bool, error) {
+	data, err := c.Get(ctx, "/api/v2/transfer/speedLimitsMode", nil)
+	return strings.TrimSpace(string(data)) == "1", err
+}
+
+// SetAltSpeed 启用或关闭备用限速，qBittorrent 只提供切换接口，因此先查询当前状态
+func (c *Client) SetAltSpeed(ctx context.Context, enabled bool) error {
+	current, err := c.AltSpeedEnabled(ctx)
+	if err != nil || current == enabled {
+		return err
+	}
+	_, err = c.PostForm(ctx, "/api/v2/transfer/toggleSpeedLimitsMode", nil)
+	return err
+}
+
+// postCompat 优先调用新版接口，返回 404 时回退到旧版接口
+func (c *Client) postCompat(ctx context.Context, path, fallback string, form url.Values) error {
+	_, err := c.PostForm(ctx, path, form)
+	var se *StatusError
+	if errors.As(err, &se) && se.Code == http.StatusNotFound {
+		_, err = c.PostForm(ctx, fallback, form)
+	}
+	return err
+}
@@ -0,0 +1,174 @@
+package qbapi
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const loginAPIPath = "/api/v2/auth/login"
+
+// StatusError qBittorrent 返回非 200 状态码时的错误
+type StatusError struct {
+	Path string
+	Code int
+	Body string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("%s: unexpected status %d: %s", e.Path, e.Code, strings.TrimSpace(e.Body))
+}
+
+// Client 通过 Unix Socket 访问 qBittorrent Web API 的客户端
+// 自动登录并保存 SID，会话失效（403）时重新登录一次
+type Client struct {
+	sockPath string
+	password string
+	http     *http.Client
+
+	mu  sync.Mutex
+	sid string
+}
+
+// New 创建客户端，password 为 qBittorrent 的 WebUI 密码
+// 通过 fn-qb-proxy 的代理 Socket 访问时密码由代理注入，可为空
+func New(sockPath, password string) *Client {
+	return &Client{
+		sockPath: sockPath,
+		password: password,
+		http: &http.Client{
+			Timeout: 60 * time.Second,
+			Transport: &http.Transport{
+				DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+					var d net.Dialer
+					return d.DialContext(ctx, "unix", sockPath)
+				},
+			},
+		},
+	}
+}
+
+// SockPath 返回客户端连接的 Socket 路径
+func (c *Client) SockPath() string {
+	return c.sockPath
+}
+
+// Get 发送 GET 请求
+func (c *Client) Get(ctx context.Context, path string, query url.Values) ([]byte, error) {
+	if len(query) > 0 {
+		path += "?" + query.Encode()
+	}
+	return c.do(ctx, http.MethodGet, path, "", func() io.Reader { return nil })
+}
+
+// PostForm 发送表单 POST 请求
+func (c *Client) PostForm(ctx context.Context, path string, form url.Values) ([]byte, error) {
+	encoded := form.Encode()
+	return c.do(ctx, http.MethodPost, path, "application/x-www-form-urlencoded",
+		func() io.Reader { return strings.NewReader(encoded) })
+}
+
+// PostRaw 以指定 Content-Type 发送原始请求体，用于 multipart 等场景
+func (c *Client) PostRaw(ctx context.Context, path, contentType string, body []byte) ([]byte, error) {
+	return c.do(ctx, http.MethodPost, path, contentType,
+		func() io.Reader { return bytes.NewReader(body) })
+}
+
+func (c *Client) do(ctx context.Context, method, path, contentType string, body func() io.Reader) ([]byte, error) {
+	sid, err := c.session(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	data, code, err := c.send(ctx, method, path, contentType, sid, body())
+	if err != nil {
+		return nil, err
+	}
+	if code == http.StatusForbidden {
+		// 会话可能已过期（例如 qBittorrent 重启），重新登录后重试一次
+		c.mu.Lock()
+		if c.sid == sid {
+			c.sid = ""
+		}
+		c.mu.Unlock()
+		if sid, err = c.session(ctx); err != nil {
+			return nil, err
+		}
+		if data, code, err = c.send(ctx, method, path, contentType, sid, body()); err != nil {
+			return nil, err
+		}
+	}
+	if code != http.StatusOK {
+		return nil, &StatusError{Path: path, Code: code, Body: string(data)}
+	}
+	return data, nil
+}
+
+func (c *Client) send(ctx context.Context, method, path, contentType, sid string, body io.Reader) ([]byte, int, error) {
+	req, err := http.NewRequestWithContext(ctx, method, "http://localhost"+path, body)
+	if err != nil {
+		return nil, 0, err
+	}
+	req.Host = fmt.Sprintf("unix://%s", c.sockPath)
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	if sid != "" {
+		req.AddCookie(&http.Cookie{Name: "SID", Value: sid})
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, 0, fmt.Errorf("%s %s: %w", method, path, err)
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, 0, fmt.Errorf("read response of %s: %w", path, err)
+	}
+	return data, resp.StatusCode, nil
+}
+
+// session 返回当前 SID，没有时登录获取
+func (c *Client) session(ctx context.Context) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.sid != "" {
+		return c.sid, nil
+	}
+
+	form := url.Values{"username": {"admin"}, "password": {c.password}}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, "http://localhost"+loginAPIPath,
+		strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Host = fmt.Sprintf("unix://%s", c.sockPath)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("login: %w", err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+
+	if resp.StatusCode != http.StatusOK || strings.TrimSpace(string(body)) != "Ok." {
+		return "", fmt.Errorf("login failed: status %d: %s", resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+	for _, cookie := range resp.Cookies() {
+		if cookie.Name == "SID" {
+			c.sid = cookie.Value
+			return c.sid, nil
+		}
+	}
+	return "", fmt.Errorf("login succeeded but no SID cookie returned")
+}
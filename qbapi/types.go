@@ -0,0 +1,133 @@
+package qbapi
+
+import "strings"
+
+// Torrent /api/v2/torrents/info 返回的种子信息
+type Torrent struct {
+	Hash             string  `json:"hash"`
+	InfohashV1       string  `json:"infohash_v1"`
+	InfohashV2       string  `json:"infohash_v2"`
+	Name             string  `json:"name"`
+	Size             int64   `json:"size"`
+	TotalSize        int64   `json:"total_size"`
+	Progress         float64 `json:"progress"`
+	DlSpeed          int64   `json:"dlspeed"`
+	UpSpeed          int64   `json:"upspeed"`
+	DlLimit          int64   `json:"dl_limit"`
+	UpLimit          int64   `json:"up_limit"`
+	State            string  `json:"state"`
+	Category         string  `json:"category"`
+	Tags             string  `json:"tags"`
+	SavePath         string  `json:"save_path"`
+	ContentPath      string  `json:"content_path"`
+	DownloadPath     string  `json:"download_path"`
+	MagnetURI        string  `json:"magnet_uri"`
+	Tracker          string  `json:"tracker"`
+	AddedOn          int64   `json:"added_on"`
+	CompletionOn     int64   `json:"completion_on"`
+	LastActivity     int64   `json:"last_activity"`
+	Ratio            float64 `json:"ratio"`
+	RatioLimit       float64 `json:"ratio_limit"`
+	SeedingTime      int64   `json:"seeding_time"`
+	SeedingTimeLimit int64   `json:"seeding_time_limit"`
+	TimeActive       int64   `json:"time_active"`
+	Eta              int64   `json:"eta"`
+	NumSeeds         int64   `json:"num_seeds"`
+	NumLeechs        int64   `json:"num_leechs"`
+	Downloaded       int64   `json:"downloaded"`
+	Uploaded         int64   `json:"uploaded"`
+	AmountLeft       int64   `json:"amount_left"`
+	Completed        int64   `json:"completed"`
+	Priority         int64   `json:"priority"`
+}
+
+// TransferInfo /api/v2/transfer/info 返回的全局传输信息
+type TransferInfo struct {
+	DlInfoSpeed      int64  `json:"dl_info_speed"`
+	DlInfoData       int64  `json:"dl_info_data"`
+	UpInfoSpeed      int64  `json:"up_info_speed"`
+	UpInfoData       int64  `json:"up_info_data"`
+	DlRateLimit      int64  `json:"dl_rate_limit"`
+	UpRateLimit      int64  `json:"up_rate_limit"`
+	DHTNodes         int64  `json:"dht_nodes"`
+	ConnectionStatus string `json:"connection_status"`
+}
+
+// Category /api/v2/torrents/categories 返回的分类信息
+type Category struct {
+	Name     string `json:"name"`
+	SavePath string `json:"savePath"`
+}
+
+// FileInfo /api/v2/torrents/files 返回的文件信息
+type FileInfo struct {
+	Index    int     `json:"index"`
+	Name     string  `json:"name"`
+	Size     int64   `json:"size"`
+	Progress float64 `json:"progress"`
+	Priority int     `json:"priority"`
+}
+
+// Tracker /api/v2/torrents/trackers 返回的 Tracker 信息
+type Tracker struct {
+	URL           string `json:"url"`
+	Status        int    `json:"status"` // 0 禁用、1 未联系、2 工作中、3 更新中、4 未工作
+	Tier          int    `json:"tier"`
+	NumPeers      int    `json:"num_peers"`
+	NumSeeds      int    `json:"num_seeds"`
+	NumLeeches    int    `json:"num_leeches"`
+	NumDownloaded int    `json:"num_downloaded"`
+	Msg           string `json:"msg"`
+}
+
+// IsPseudo 判断是否为 DHT、PeX、LSD 等非真实 Tracker 条目
+func (t *Tracker) IsPseudo() bool {
+	return strings.HasPrefix(t.URL, "** [")
+}
+
+// TorrentFile 添加种子时上传的 .torrent 文件
+type TorrentFile struct {
+	Name string
+	Data []byte
+}
+
+// AddOptions /api/v2/torrents/add 的参数，零值字段不会发送
+type AddOptions struct {
+	URLs         []string
+	Files        []TorrentFile
+	SavePath     string
+	Category     string
+	Tags         []string
+	Paused       bool
+	SkipChecking bool
+	Rename       string
+}
+
+// IsFinished 判断种子是否已下载完成
+func (t *Torrent) IsFinished() bool {
+	return t.Progress >= 1
+}
+
+// IsPaused 判断种子是否处于暂停（v5 中称为停止）状态
+func (t *Torrent) IsPaused() bool {
+	switch t.State {
+	case "pausedDL", "pausedUP", "stoppedDL", "stoppedUP":
+		return true
+	}
+	return false
+}
+
+// HasTag 判断种子是否带有指定标签
+func (t *Torrent) HasTag(tag string) bool {
+	for _, v := range strings.Split(t.Tags, ",") {
+		if strings.TrimSpace(v) == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// IsErrored 判断种子是否处于错误状态
+func (t *Torrent) IsErrored() bool {
+	return t.State == "error" || t.State == "missingFiles"
+}
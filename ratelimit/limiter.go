@@ -0,0 +1,193 @@
+package ratelimit
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Limit 单个端点类别的限制：令牌桶速率、桶容量与最大并发请求数
+// Rate 或 MaxInFlight 为 0 表示对应维度不限制
+type Limit struct {
+	Rate        float64 // 每秒补充的令牌数
+	Burst       int     // 令牌桶容量
+	MaxInFlight int     // 同时处理中的最大请求数
+}
+
+// bucket 某个身份在某个端点类别下的状态
+type bucket struct {
+	tokens   float64
+	last     time.Time
+	inFlight int
+}
+
+// Limiter 按 (身份, 端点类别) 维护令牌桶与并发计数
+type Limiter struct {
+	limits  map[string]Limit
+	buckets map[string]*bucket
+	mu      sync.Mutex
+	pruned  time.Time
+}
+
+// idleTTL 超过该时间未使用的桶会被清理，避免身份过多时内存持续增长
+const idleTTL = 10 * time.Minute
+
+// New 创建限流器，limits 为空时返回 nil（nil Limiter 不做任何限制）
+func New(limits map[string]Limit) *Limiter {
+	if len(limits) == 0 {
+		return nil
+	}
+	return &Limiter{
+		limits:  limits,
+		buckets: make(map[string]*bucket),
+		pruned:  time.Now(),
+	}
+}
+
+// limitFor 获取类别对应的限制，未单独配置的类别使用 default
+func (l *Limiter) limitFor(class string) (Limit, bool) {
+	if lim, ok := l.limits[class]; ok {
+		return lim, true
+	}
+	lim, ok := l.limits[ClassDefault]
+	return lim, ok
+}
+
+// Acquire 为 key 在 class 下申请一次请求配额
+// 成功时返回 release，调用方需在请求结束后调用；失败时返回建议的重试等待时间
+func (l *Limiter) Acquire(key, class string) (release func(), retryAfter time.Duration, ok bool) {
+	release, _, retryAfter, ok = l.acquire(key, class)
+	return release, retryAfter, ok
+}
+
+// acquire 与 Acquire 相同，另外返回 cancel：撤销本次申请并退还令牌，
+// 用于后续维度拒绝时不计入前面维度的配额。release 与 cancel 只有第一次调用生效
+func (l *Limiter) acquire(key, class string) (release, cancel func(), retryAfter time.Duration, ok bool) {
+	noop := func() {}
+	if l == nil {
+		return noop, noop, 0, true
+	}
+	lim, exists := l.limitFor(class)
+	if !exists {
+		return noop, noop, 0, true
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	l.prune(now)
+
+	id := class + "\x00" + key
+	b, exists := l.buckets[id]
+	if !exists {
+		b = &bucket{tokens: float64(lim.Burst), last: now}
+		l.buckets[id] = b
+	}
+
+	if lim.MaxInFlight > 0 && b.inFlight >= lim.MaxInFlight {
+		return nil, nil, time.Second, false
+	}
+
+	if lim.Rate > 0 {
+		b.tokens = math.Min(float64(lim.Burst), b.tokens+now.Sub(b.last).Seconds()*lim.Rate)
+		b.last = now
+		if b.tokens < 1 {
+			wait := time.Duration((1 - b.tokens) / lim.Rate * float64(time.Second))
+			return nil, nil, wait, false
+		}
+		b.tokens--
+	}
+	b.last = now
+
+	b.inFlight++
+	var once sync.Once
+	release = func() {
+		once.Do(func() {
+			l.mu.Lock()
+			b.inFlight--
+			l.mu.Unlock()
+		})
+	}
+	cancel = func() {
+		once.Do(func() {
+			l.mu.Lock()
+			b.inFlight--
+			if lim.Rate > 0 {
+				b.tokens = math.Min(float64(lim.Burst), b.tokens+1)
+			}
+			l.mu.Unlock()
+		})
+	}
+	return release, cancel, 0, true
+}
+
+// prune 清理长时间空闲的桶，调用方需持有锁
+func (l *Limiter) prune(now time.Time) {
+	if now.Sub(l.pruned) < idleTTL {
+		return
+	}
+	l.pruned = now
+	for id, b := range l.buckets {
+		if b.inFlight == 0 && now.Sub(b.last) > idleTTL {
+			delete(l.buckets, id)
+		}
+	}
+}
+
+// Parse 解析限流配置字符串
+// 格式：class=rate:burst:inflight[,class=...]，例如 "sync=2:5:1,default=20:40:8"
+// rate 可为小数；burst 省略时取 rate 向上取整；inflight 省略或为 0 表示不限并发
+func Parse(spec string) (map[string]Limit, error) {
+	limits := make(map[string]Limit)
+	spec = strings.TrimSpace(spec)
+	if spec == "" {
+		return limits, nil
+	}
+
+	for _, item := range strings.Split(spec, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		class, value, found := strings.Cut(item, "=")
+		if !found {
+			return nil, fmt.Errorf("invalid rate limit %q: missing '='", item)
+		}
+		class = strings.TrimSpace(class)
+		if !isKnownClass(class) {
+			return nil, fmt.Errorf("invalid rate limit %q: unknown endpoint class %q", item, class)
+		}
+
+		parts := strings.Split(value, ":")
+		if len(parts) > 3 {
+			return nil, fmt.Errorf("invalid rate limit %q: too many fields", item)
+		}
+
+		var lim Limit
+		rate, err := strconv.ParseFloat(strings.TrimSpace(parts[0]), 64)
+		if err != nil || rate < 0 || math.IsNaN(rate) || math.IsInf(rate, 0) {
+			return nil, fmt.Errorf("invalid rate limit %q: bad rate", item)
+		}
+		lim.Rate = rate
+		lim.Burst = int(math.Ceil(rate))
+		if len(parts) > 1 && strings.TrimSpace(parts[1]) != "" {
+			if lim.Burst, err = strconv.Atoi(strings.TrimSpace(parts[1])); err != nil || lim.Burst < 0 {
+				return nil, fmt.Errorf("invalid rate limit %q: bad burst", item)
+			}
+		}
+		if lim.Rate > 0 && lim.Burst < 1 {
+			lim.Burst = 1
+		}
+		if len(parts) > 2 && strings.TrimSpace(parts[2]) != "" {
+			if lim.MaxInFlight, err = strconv.Atoi(strings.TrimSpace(parts[2])); err != nil || lim.MaxInFlight < 0 {
+				return nil, fmt.Errorf("invalid rate limit %q: bad inflight", item)
+			}
+		}
+		limits[class] = lim
+	}
+	return limits, nil
+}
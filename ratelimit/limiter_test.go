@@ -0,0 +1,137 @@
+package ratelimit
+
+import (
+	"maps"
+	"testing"
+	"time"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		spec    string
+		want    map[string]Limit
+		wantErr bool
+	}{
+		{spec: "", want: map[string]Limit{}},
+		{spec: "sync=2:5:1", want: map[string]Limit{ClassSync: {Rate: 2, Burst: 5, MaxInFlight: 1}}},
+		{spec: " sync=2:5:1 , default=20:40:8 ,", want: map[string]Limit{
+			ClassSync:    {Rate: 2, Burst: 5, MaxInFlight: 1},
+			ClassDefault: {Rate: 20, Burst: 40, MaxInFlight: 8},
+		}},
+		{spec: "read=0.5", want: map[string]Limit{ClassRead: {Rate: 0.5, Burst: 1}}},
+		{spec: "add=2.5", want: map[string]Limit{ClassAdd: {Rate: 2.5, Burst: 3}}},
+		{spec: "write=1::4", want: map[string]Limit{ClassWrite: {Rate: 1, Burst: 1, MaxInFlight: 4}}},
+		{spec: "write=1:0", want: map[string]Limit{ClassWrite: {Rate: 1, Burst: 1}}},
+		{spec: "sync=0:0:2", want: map[string]Limit{ClassSync: {MaxInFlight: 2}}},
+		{spec: "sync", wantErr: true},
+		{spec: "upload=1", wantErr: true},
+		{spec: "sync=x", wantErr: true},
+		{spec: "sync=-1", wantErr: true},
+		{spec: "sync=NaN", wantErr: true},
+		{spec: "sync=Inf", wantErr: true},
+		{spec: "sync=1:-1", wantErr: true},
+		{spec: "sync=1:1:-1", wantErr: true},
+		{spec: "sync=1:1:1:1", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := Parse(tt.spec)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("Parse(%q) = %v, want error", tt.spec, got)
+			}
+			continue
+		}
+		if err != nil || !maps.Equal(got, tt.want) {
+			t.Errorf("Parse(%q) = %v, %v, want %v", tt.spec, got, err, tt.want)
+		}
+	}
+}
+
+func TestAcquire(t *testing.T) {
+	var nilLimiter *Limiter
+	if _, _, ok := nilLimiter.Acquire("a", ClassSync); !ok {
+		t.Error("nil limiter rejected a request")
+	}
+	if New(nil) != nil {
+		t.Error("New(nil) is not nil")
+	}
+
+	l := New(map[string]Limit{
+		ClassSync:    {Rate: 1, Burst: 2},
+		ClassDefault: {MaxInFlight: 1},
+	})
+
+	// 令牌桶：突发 2 次后拒绝，按身份与类别分别计数
+	for i := range 2 {
+		if _, _, ok := l.Acquire("a", ClassSync); !ok {
+			t.Fatalf("request %d within burst was rejected", i)
+		}
+	}
+	_, retryAfter, ok := l.Acquire("a", ClassSync)
+	if ok {
+		t.Error("request over burst was accepted")
+	}
+	if retryAfter <= 0 || retryAfter > time.Second {
+		t.Errorf("retryAfter = %v, want (0, 1s]", retryAfter)
+	}
+	if _, _, ok := l.Acquire("b", ClassSync); !ok {
+		t.Error("another key shares the bucket")
+	}
+
+	// 并发数：释放后才能再次获取，重复释放不影响计数
+	release, _, ok := l.Acquire("a", ClassRead)
+	if !ok {
+		t.Fatal("first in-flight request was rejected")
+	}
+	if _, _, ok := l.Acquire("a", ClassRead); ok {
+		t.Error("second in-flight request was accepted")
+	}
+	// 使用 default 的各类别分别计数
+	if _, _, ok := l.Acquire("a", ClassWrite); !ok {
+		t.Error("another class falling back to default shares the slot")
+	}
+	release()
+	release()
+	release2, _, ok := l.Acquire("a", ClassRead)
+	if !ok {
+		t.Fatal("request after release was rejected")
+	}
+	if _, _, ok := l.Acquire("a", ClassRead); ok {
+		t.Error("double release freed two slots")
+	}
+	release2()
+
+	// cancel 退还令牌与并发数，release 之后再 cancel 不生效
+	l = New(map[string]Limit{ClassDefault: {Rate: 0.001, Burst: 1, MaxInFlight: 1}})
+	_, cancel, _, ok := l.acquire("a", ClassWrite)
+	if !ok {
+		t.Fatal("first request was rejected")
+	}
+	cancel()
+	cancel()
+	release, cancel, _, ok = l.acquire("a", ClassWrite)
+	if !ok {
+		t.Fatal("request after cancel was rejected")
+	}
+	release()
+	cancel()
+	if _, _, ok := l.Acquire("a", ClassWrite); ok {
+		t.Error("cancel after release refunded the token")
+	}
+
+	// 没有 default 时未配置的类别不限制
+	l = New(map[string]Limit{ClassSync: {Rate: 1, Burst: 1}})
+	for range 3 {
+		if _, _, ok := l.Acquire("a", ClassWrite); !ok {
+			t.Error("unconfigured class was limited")
+		}
+	}
+}
+
+func TestRetryAfterSeconds(t *testing.T) {
+	for d, want := range map[time.Duration]int{0: 1, 10 * time.Millisecond: 1, time.Second: 1, 1500 * time.Millisecond: 2} {
+		if got := RetryAfterSeconds(d); got != want {
+			t.Errorf("RetryAfterSeconds(%v) = %d, want %d", d, got, want)
+		}
+	}
+}
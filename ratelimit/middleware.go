@@ -0,0 +1,176 @@
+package ratelimit
+
+import (
+	"bufio"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// 端点类别
+const (
+	ClassSync    = "sync"    // /api/v2/sync/*，客户端高频轮询
+	ClassRead    = "read"    // 只读查询接口
+	ClassAdd     = "add"     // 添加种子
+	ClassWrite   = "write"   // 其余修改类接口
+	ClassDefault = "default" // 未单独配置的类别使用该限制
+)
+
+const syncPathPrefix = "/api/v2/sync/"
+const addPath = "/api/v2/torrents/add"
+
+// readOnlyPaths qBittorrent Web API 中的只读接口
+var readOnlyPaths = map[string]bool{
+	"/api/v2/app/version":              true,
+	"/api/v2/app/webapiVersion":        true,
+	"/api/v2/app/buildInfo":            true,
+	"/api/v2/app/preferences":          true,
+	"/api/v2/app/defaultSavePath":      true,
+	"/api/v2/log/main":                 true,
+	"/api/v2/log/peers":                true,
+	"/api/v2/transfer/info":            true,
+	"/api/v2/transfer/speedLimitsMode": true,
+	"/api/v2/transfer/downloadLimit":   true,
+	"/api/v2/transfer/uploadLimit":     true,
+	"/api/v2/torrents/info":            true,
+	"/api/v2/torrents/count":           true,
+	"/api/v2/torrents/properties":      true,
+	"/api/v2/torrents/trackers":        true,
+	"/api/v2/torrents/webseeds":        true,
+	"/api/v2/torrents/files":           true,
+	"/api/v2/torrents/pieceStates":     true,
+	"/api/v2/torrents/pieceHashes":     true,
+	"/api/v2/torrents/categories":      true,
+	"/api/v2/torrents/tags":            true,
+	"/api/v2/torrents/export":          true,
+	"/api/v2/rss/items":                true,
+	"/api/v2/rss/rules":                true,
+	"/api/v2/search/status":            true,
+	"/api/v2/search/results":           true,
+	"/api/v2/search/plugins":           true,
+}
+
+// IsReadOnly 判断路径是否为只读接口
+func IsReadOnly(path string) bool {
+	return readOnlyPaths[strings.TrimSuffix(path, "/")]
+}
+
+// Classify 根据请求路径确定端点类别
+func Classify(r *http.Request) string {
+	path := r.URL.Path
+	switch {
+	case strings.HasPrefix(path, syncPathPrefix):
+		return ClassSync
+	case strings.HasPrefix(path, addPath):
+		return ClassAdd
+	case IsReadOnly(path):
+		return ClassRead
+	default:
+		return ClassWrite
+	}
+}
+
+func isKnownClass(class string) bool {
+	switch class {
+	case ClassSync, ClassRead, ClassAdd, ClassWrite, ClassDefault:
+		return true
+	}
+	return false
+}
+
+// Scope 一个限流维度：限流器及从请求中提取身份的方法
+type Scope struct {
+	Name    string // 用于日志，例如 "client" / "upstream"
+	Limiter *Limiter
+	Key     func(r *http.Request) string
+}
+
+// Middleware 依次在各维度申请配额，任一维度超限时撤销已在前面维度申请的配额，
+// 返回 429 并带上 Retry-After，被拒绝的请求不消耗任何维度的配额
+func Middleware(next http.Handler, scopes ...Scope) http.Handler {
+	active := make([]Scope, 0, len(scopes))
+	for _, s := range scopes {
+		if s.Limiter != nil {
+			active = append(active, s)
+		}
+	}
+	if len(active) == 0 {
+		return next
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		class := Classify(r)
+		releases := make([]func(), 0, len(active))
+		cancels := make([]func(), 0, len(active))
+		// release 可重复调用，连接被接管时提前释放
+		releaseAll := func() {
+			for _, release := range releases {
+				release()
+			}
+		}
+		defer releaseAll()
+
+		for _, s := range active {
+			key := s.Key(r)
+			release, cancel, retryAfter, ok := s.Limiter.acquire(key, class)
+			if !ok {
+				for _, cancel := range cancels {
+					cancel()
+				}
+				seconds := RetryAfterSeconds(retryAfter)
+				logrus.Debugf("Rate limited %s %s (%s=%s, class=%s, retry after %ds)",
+					r.Method, r.URL.Path, s.Name, key, class, seconds)
+				w.Header().Set("Retry-After", fmt.Sprintf("%d", seconds))
+				http.Error(w, "Too many requests", http.StatusTooManyRequests)
+				return
+			}
+			releases = append(releases, release)
+			cancels = append(cancels, cancel)
+		}
+
+		next.ServeHTTP(&hijackReleaser{ResponseWriter: w, release: releaseAll}, r)
+	})
+}
+
+// hijackReleaser 连接被接管（如 WebSocket）后立即释放并发配额，长连接不应一直占用
+type hijackReleaser struct {
+	http.ResponseWriter
+	release func()
+}
+
+func (w *hijackReleaser) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, http.ErrNotSupported
+	}
+	conn, rw, err := hijacker.Hijack()
+	if err == nil {
+		w.release()
+	}
+	return conn, rw, err
+}
+
+func (w *hijackReleaser) Flush() {
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Unwrap 供 http.ResponseController 访问底层的 ResponseWriter
+func (w *hijackReleaser) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// RetryAfterSeconds 将等待时间转换为 Retry-After 使用的整数秒
+func RetryAfterSeconds(d time.Duration) int {
+	seconds := int(math.Ceil(d.Seconds()))
+	if seconds < 1 {
+		seconds = 1
+	}
+	return seconds
+}
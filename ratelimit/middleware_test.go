@@ -0,0 +1,81 @@
+package ratelimit
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestMiddlewareReleasesHijackedConnections(t *testing.T) {
+	limiter := New(map[string]Limit{ClassDefault: {MaxInFlight: 1}})
+	hijacked := make(chan struct{})
+	done := make(chan struct{})
+	handler := Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/ws" {
+			return
+		}
+		conn, _, err := http.NewResponseController(w).Hijack()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer conn.Close()
+		close(hijacked)
+		<-done
+	}), Scope{Name: "client", Limiter: limiter, Key: func(*http.Request) string { return "c" }})
+	srv := httptest.NewServer(handler)
+	defer srv.Close()
+	defer close(done)
+
+	conn, err := net.Dial("tcp", srv.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.Write([]byte("GET /ws HTTP/1.1\r\nHost: x\r\n\r\n"))
+	<-hijacked
+
+	resp, err := http.Get(srv.URL + "/other")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("request while a hijacked connection is open: status %d, want 200", resp.StatusCode)
+	}
+}
+
+func TestMiddlewareRefundsEarlierScopes(t *testing.T) {
+	client := New(map[string]Limit{ClassDefault: {Rate: 0.001, Burst: 1, MaxInFlight: 1}})
+	upstream := New(map[string]Limit{ClassDefault: {Rate: 0.001, Burst: 1}})
+	handler := Middleware(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}),
+		Scope{Name: "client", Limiter: client, Key: func(r *http.Request) string { return r.Header.Get("X-Client") }},
+		Scope{Name: "upstream", Limiter: upstream, Key: func(*http.Request) string { return "u" }})
+	serve := func(c string) int {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodPost, "/api/v2/torrents/stop", nil)
+		r.Header.Set("X-Client", c)
+		handler.ServeHTTP(w, r)
+		return w.Code
+	}
+
+	if code := serve("a"); code != http.StatusOK {
+		t.Fatalf("first request: status %d, want 200", code)
+	}
+	// 上游的令牌已用完，b 的请求被拒绝
+	if code := serve("b"); code != http.StatusTooManyRequests {
+		t.Fatalf("request over upstream limit: status %d, want 429", code)
+	}
+	// 被上游拒绝的请求不能消耗 b 自己的令牌与并发数
+	release, _, ok := client.Acquire("b", ClassWrite)
+	if !ok {
+		t.Fatal("client budget was consumed by a request the upstream rejected")
+	}
+	release()
+	if _, _, ok := client.Acquire("b", ClassWrite); ok {
+		t.Error("refund restored more than one token")
+	}
+}
+
+var _ http.Hijacker = (*hijackReleaser)(nil)